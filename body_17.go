@@ -0,0 +1,11 @@
+//go:build !go1.8
+// +build !go1.8
+
+package httptreemux
+
+import "io"
+
+// emptyBody returns true if the request body is known to be empty.
+func emptyBody(body io.ReadCloser) bool {
+	return body == nil
+}
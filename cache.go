@@ -0,0 +1,253 @@
+package httptreemux
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy configures the response cache of a route, given with WithResponseCache.
+type CachePolicy struct {
+	// TTL is how long a response is fresh. Fresh responses are served from the cache without
+	// calling the handler.
+	TTL time.Duration
+	// StaleWhileRevalidate is how long a response can still be served once it is no longer
+	// fresh. The first request in this time gets the stale response right away and starts a
+	// refresh in the background, and later requests get the stale response until the refresh
+	// is done. After this time, a request waits for the handler, as for a response which was
+	// never cached.
+	StaleWhileRevalidate time.Duration
+	// MaxEntries limits the number of responses kept for the route. It defaults to 1000.
+	MaxEntries int
+}
+
+// defaultMaxCacheEntries is the limit of cached responses for a route without
+// CachePolicy.MaxEntries.
+const defaultMaxCacheEntries = 1000
+
+// WithResponseCache caches the responses of a route to GET requests, so that read-heavy
+// endpoints don't call their handler for every request. Responses are kept for each host and
+// request URI, including the query, and only 200 responses are stored, unless they set a
+// cookie or have a Cache-Control header with no-store or private. The handler is only called
+// once for concurrent requests which miss the cache for the same URI; the others wait for its
+// response. Times are measured with TreeMux.Clock.
+//
+// Requests with an Authorization header are never served from the cache. Other request
+// headers are not part of the key, so a handler whose response depends on them, such as on
+// Accept for content negotiation or on a session cookie, must name them in a Vary header. A
+// response with Vary is only served to requests with the same values of those headers, and
+// each URI keeps one such variant at a time; a response with Vary: * is not stored.
+//
+// A refresh in the background runs with a copy of the request whose context is not canceled
+// when the request finishes, in Go 1.7 and later. If it fails or panics, the stale response
+// is kept, and the next request tries again.
+//
+// Cached responses are served without calling the route's handler, including the middleware
+// of its group, so routes which authenticate or authorize their requests in middleware should
+// not be cached. Responses are also buffered before they are sent.
+//
+//	api.With(httptreemux.WithResponseCache(httptreemux.CachePolicy{
+//	    TTL:                  time.Minute,
+//	    StaleWhileRevalidate: 10 * time.Minute,
+//	})).GET("/catalog/:id", getCatalog)
+func WithResponseCache(policy CachePolicy) RouteOption {
+	if policy.MaxEntries <= 0 {
+		policy.MaxEntries = defaultMaxCacheEntries
+	}
+	return func(info *routeInfo) {
+		info.cache = &responseCache{
+			policy:  policy,
+			entries: make(map[string]*cachedResponse),
+			pending: make(map[string]*cacheFill),
+		}
+	}
+}
+
+// responseCache holds the cached responses of a route.
+type responseCache struct {
+	policy  CachePolicy
+	mutex   sync.Mutex
+	entries map[string]*cachedResponse
+	// The requests calling the handler for responses which are missing or expired, by key.
+	pending map[string]*cacheFill
+}
+
+type cachedResponse struct {
+	header http.Header
+	code   int
+	body   []byte
+	stored time.Time
+	// The headers named by the response's Vary header, and the values they had in the
+	// request.
+	vary       []string
+	varyValues []string
+	// True while the response is refreshed in the background.
+	refreshing bool
+}
+
+// cacheFill lets requests for a missing response wait for the one calling the handler.
+type cacheFill struct {
+	done chan struct{}
+	// The response, or nil if it could not be stored.
+	response *cachedResponse
+}
+
+// matches returns true if the response can be served for the request, according to the
+// response's Vary header.
+func (cr *cachedResponse) matches(r *http.Request) bool {
+	for i, name := range cr.vary {
+		if strings.Join(r.Header[name], ", ") != cr.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// write sends the cached response to w. The header values are copied, so that middleware
+// which appends to them does not change the cached response.
+func (cr *cachedResponse) write(w http.ResponseWriter) {
+	dst := w.Header()
+	for name, values := range cr.header {
+		dst[name] = append([]string(nil), values...)
+	}
+	w.WriteHeader(cr.code)
+	w.Write(cr.body)
+}
+
+// newCachedResponse returns the response to r buffered in tw, or nil if it can not be cached.
+func newCachedResponse(r *http.Request, tw *timeoutWriter, outcome handlerOutcome, stored time.Time) *cachedResponse {
+	if outcome != handlerServed || (tw.code != http.StatusOK && tw.code != 0) || len(tw.header["Set-Cookie"]) != 0 {
+		return nil
+	}
+	for _, value := range tw.header["Cache-Control"] {
+		value = strings.ToLower(value)
+		if strings.Contains(value, "no-store") || strings.Contains(value, "private") {
+			return nil
+		}
+	}
+
+	response := &cachedResponse{
+		header: cloneHeader(tw.header),
+		code:   http.StatusOK,
+		body:   append([]byte(nil), tw.body.Bytes()...),
+		stored: stored,
+	}
+	for _, value := range tw.header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			} else if name != "" {
+				name = http.CanonicalHeaderKey(name)
+				response.vary = append(response.vary, name)
+				response.varyValues = append(response.varyValues, strings.Join(r.Header[name], ", "))
+			}
+		}
+	}
+	return response
+}
+
+// store adds a response to the cache, removing expired responses to make room for it if
+// necessary. The caller must hold the cache's lock.
+func (c *responseCache) store(key string, response *cachedResponse, now time.Time) {
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.policy.MaxEntries {
+		for k, entry := range c.entries {
+			if now.Sub(entry.stored) >= c.policy.TTL+c.policy.StaleWhileRevalidate {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.policy.MaxEntries {
+			return
+		}
+	}
+	c.entries[key] = response
+}
+
+// serveHandler calls the handler of a lookup result for a registered route, with the route's
+// timeout if it has one.
+func (t *TreeMux) serveHandler(w http.ResponseWriter, r *http.Request, lr LookupResult) handlerOutcome {
+	if lr.timeout > 0 {
+		return t.serveWithTimeout(w, r, lr)
+	}
+	lr.callHandler(w, r)
+	return handlerServed
+}
+
+// serveCached serves a request for a route added with WithResponseCache, from the cache if it
+// can.
+func (t *TreeMux) serveCached(w http.ResponseWriter, r *http.Request, lr LookupResult) handlerOutcome {
+	if r.Method != "GET" || len(r.Header["Authorization"]) != 0 {
+		return t.serveHandler(w, r, lr)
+	}
+
+	c := lr.cache
+	key := r.Host + r.URL.RequestURI()
+	now := t.now()
+
+	c.mutex.Lock()
+	if entry := c.entries[key]; entry != nil && entry.matches(r) {
+		age := now.Sub(entry.stored)
+		if age < c.policy.TTL+c.policy.StaleWhileRevalidate {
+			if age >= c.policy.TTL && !entry.refreshing {
+				entry.refreshing = true
+				go t.refreshCached(detachRequest(r), lr, key, entry)
+			}
+			c.mutex.Unlock()
+			entry.write(w)
+			return handlerServed
+		}
+	}
+
+	if fill := c.pending[key]; fill != nil {
+		c.mutex.Unlock()
+		<-fill.done
+		if fill.response != nil && fill.response.matches(r) {
+			fill.response.write(w)
+			return handlerServed
+		}
+		return t.serveHandler(w, r, lr)
+	}
+	fill := &cacheFill{done: make(chan struct{})}
+	c.pending[key] = fill
+	c.mutex.Unlock()
+
+	// Let the waiting requests call the handler themselves if this one panics.
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, key)
+		c.mutex.Unlock()
+		close(fill.done)
+	}()
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	outcome := t.serveHandler(tw, r, lr)
+	tw.flush(w)
+	if response := newCachedResponse(r, tw, outcome, t.now()); response != nil {
+		c.mutex.Lock()
+		c.store(key, response, now)
+		c.mutex.Unlock()
+		fill.response = response
+	}
+	return outcome
+}
+
+// refreshCached calls the handler in the background to replace a stale response.
+func (t *TreeMux) refreshCached(r *http.Request, lr LookupResult, key string, stale *cachedResponse) {
+	c := lr.cache
+	var response *cachedResponse
+	defer func() {
+		// A panic leaves the stale response in place.
+		recover()
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if response != nil && c.entries[key] == stale {
+			c.entries[key] = response
+		} else {
+			stale.refreshing = false
+		}
+	}()
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	response = newCachedResponse(r, tw, t.serveHandler(tw, r, lr), t.now())
+}
@@ -0,0 +1,205 @@
+package httptreemux
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	clock := &fakeClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	var mutex sync.Mutex
+	calls := 0
+	var release chan struct{}
+	started := make(chan struct{}, 1)
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		mutex.Lock()
+		calls++
+		n, wait := calls, release
+		mutex.Unlock()
+		if wait != nil {
+			started <- struct{}{}
+			<-wait
+		}
+		w.Header().Set("X-Call", fmt.Sprint(n))
+		fmt.Fprintf(w, "%s %d", params["id"], n)
+	}
+	callCount := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls
+	}
+
+	router := New()
+	router.Clock = clock
+	cached := router.With(WithResponseCache(CachePolicy{TTL: time.Minute, StaleWhileRevalidate: time.Hour}))
+	cached.GET("/items/:id", handler)
+	cached.POST("/items/:id", handler)
+	cached.GET("/private", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls++
+		w.Header().Set("Cache-Control", "Private, max-age=60")
+	})
+	cached.GET("/missing", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	get := func(method, path string) string {
+		r, _ := newRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	expect := func(method, path, body string, expectedCalls int) {
+		if seen := get(method, path); seen != body {
+			t.Errorf("%s %s: expected body %q, saw %q", method, path, body, seen)
+		}
+		if seen := callCount(); seen != expectedCalls {
+			t.Errorf("%s %s: expected %d calls of the handler, saw %d", method, path, expectedCalls, seen)
+		}
+	}
+
+	expect("GET", "/items/a", "a 1", 1)
+	expect("GET", "/items/a", "a 1", 1)
+	expect("GET", "/items/a?full=1", "a 2", 2)
+	expect("GET", "/items/b", "b 3", 3)
+	expect("POST", "/items/a", "a 4", 4)
+	expect("POST", "/items/a", "a 5", 5)
+	expect("GET", "/private", "", 6)
+	expect("GET", "/private", "", 7)
+	expect("GET", "/missing", "", 8)
+	expect("GET", "/missing", "", 9)
+
+	// A stale response is served while a single refresh runs in the background.
+	clock.time = clock.time.Add(2 * time.Minute)
+	mutex.Lock()
+	release = make(chan struct{})
+	mutex.Unlock()
+	if body := get("GET", "/items/a"); body != "a 1" {
+		t.Errorf("Expected the stale response, saw %q", body)
+	}
+	<-started
+	expect("GET", "/items/a", "a 1", 10)
+	expect("GET", "/items/a", "a 1", 10)
+	mutex.Lock()
+	close(release)
+	release = nil
+	mutex.Unlock()
+	for deadline := time.Now().Add(5 * time.Second); get("GET", "/items/a") != "a 10"; {
+		if time.Now().After(deadline) {
+			t.Fatal("The stale response was not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expect("GET", "/items/a", "a 10", 10)
+
+	// Once the stale time has passed, the request waits for the handler.
+	clock.time = clock.time.Add(2 * time.Hour)
+	expect("GET", "/items/a", "a 11", 11)
+	expect("GET", "/items/a", "a 11", 11)
+}
+
+func TestResponseCacheSingleFill(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+	started, release := make(chan struct{}, 3), make(chan struct{})
+	router := New()
+	router.With(WithResponseCache(CachePolicy{TTL: time.Hour})).GET("/slow", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	serve := func(i int) {
+		defer wg.Done()
+		r, _ := newRequest("GET", "/slow", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		bodies[i] = w.Body.String()
+	}
+	wg.Add(3)
+	go serve(0)
+	<-started
+	go serve(1)
+	go serve(2)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected the handler to be called once, saw %d calls", calls)
+	}
+	for i, body := range bodies {
+		if body != "done" {
+			t.Errorf("Request %d: expected body %q, saw %q", i, "done", body)
+		}
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	calls := 0
+	router := New()
+	cached := router.With(WithResponseCache(CachePolicy{TTL: time.Hour}))
+	cached.GET("/negotiated", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls++
+		w.Header().Set("Vary", "Accept-Language, accept")
+		w.Header().Set("X-Call", fmt.Sprint(calls))
+		fmt.Fprintf(w, "%s %d", r.Header.Get("Accept"), calls)
+	})
+	cached.GET("/any", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls++
+		w.Header().Set("Vary", "*")
+	})
+	cached.GET("/user", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls++
+		fmt.Fprintf(w, "%s %d", r.Header.Get("Authorization"), calls)
+	})
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r, _ := newRequest("GET", path, nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	expect := func(path string, header http.Header, body string, expectedCalls int) {
+		if seen := get(path, header).Body.String(); seen != body {
+			t.Errorf("%s %v: expected body %q, saw %q", path, header, body, seen)
+		}
+		if calls != expectedCalls {
+			t.Errorf("%s %v: expected %d calls of the handler, saw %d", path, header, expectedCalls, calls)
+		}
+	}
+
+	jsonAccept := http.Header{"Accept": {"application/json"}}
+	xmlAccept := http.Header{"Accept": {"application/xml"}}
+	expect("/negotiated", jsonAccept, "application/json 1", 1)
+	expect("/negotiated", jsonAccept, "application/json 1", 1)
+	expect("/negotiated", xmlAccept, "application/xml 2", 2)
+	expect("/negotiated", xmlAccept, "application/xml 2", 2)
+	expect("/negotiated", jsonAccept, "application/json 3", 3)
+	expect("/negotiated", http.Header{"Accept": {"application/json"}, "Accept-Language": {"de"}}, "application/json 4", 4)
+
+	// Changing the headers of a cached response does not change the cache entry.
+	w := get("/negotiated", http.Header{"Accept": {"application/json"}, "Accept-Language": {"de"}})
+	w.Header()["X-Call"][0] = "changed"
+	if seen := get("/negotiated", http.Header{"Accept": {"application/json"}, "Accept-Language": {"de"}}).Header().Get("X-Call"); seen != "4" {
+		t.Errorf("Expected the cached X-Call header to be unchanged, saw %q", seen)
+	}
+
+	expect("/any", nil, "", 5)
+	expect("/any", nil, "", 6)
+
+	expect("/user", http.Header{"Authorization": {"Bearer a"}}, "Bearer a 7", 7)
+	expect("/user", http.Header{"Authorization": {"Bearer b"}}, "Bearer b 8", 8)
+	expect("/user", nil, " 9", 9)
+	expect("/user", http.Header{"Authorization": {"Bearer a"}}, "Bearer a 10", 10)
+	expect("/user", nil, " 9", 10)
+}
@@ -0,0 +1,28 @@
+package httptreemux
+
+// WithCanary makes a route the canary release with the given name for its path and method.
+// It serves the requests which any of the match options accept, such as a header or cookie
+// set by the gateway, and the others go to the route added without match options, as with
+// MatchAny. Unlike WithExperiment, the choice depends only on the request, so a client sees
+// the same release for as long as it sends the same header or cookie.
+//
+// The name of the release is recorded in LookupResult.Canary, which the instrumentation hooks
+// receive, and in the Canary of the request's ContextData, so that metrics and logs can tell
+// the releases apart. It panics if no match options are given.
+//
+//	router.With(httptreemux.WithCanary("orders-v2",
+//	    httptreemux.MatchHeader("X-Canary", "true"),
+//	    httptreemux.MatchCookie("canary", "orders-v2"),
+//	)).GET("/orders", ordersV2)
+//	router.GET("/orders", orders)
+func WithCanary(name string, match ...RouteOption) RouteOption {
+	if len(match) == 0 {
+		panic("httptreemux: canary " + name + " has no match options")
+	}
+	description, matches := anyPredicate(match)
+	option := matchOption("canary "+name+": "+description, matches)
+	return func(info *routeInfo) {
+		info.canary = name
+		option(info)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCanary(t *testing.T) {
+	router := NewContextMux()
+	served := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + ContextData(r.Context()).Canary()))
+		}
+	}
+	router.With(WithCanary("orders-v2",
+		MatchHeader("X-Canary", "true"),
+		MatchCookie("canary", "orders-v2"),
+	)).GET("/orders", served("v2"))
+	router.GET("/orders", served("v1"))
+
+	var matched []string
+	router.OnRouteMatched = func(r *http.Request, lr LookupResult) {
+		matched = append(matched, lr.Canary)
+	}
+
+	for _, test := range []struct {
+		header http.Header
+		body   string
+	}{
+		{nil, "v1 "},
+		{http.Header{"X-Canary": {"true"}}, "v2 orders-v2"},
+		{http.Header{"X-Canary": {"false"}}, "v1 "},
+		{http.Header{"Cookie": {"session=abc; canary=orders-v2"}}, "v2 orders-v2"},
+		{http.Header{"Cookie": {"canary=orders-v3"}}, "v1 "},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", "/orders", nil)
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != test.body {
+			t.Errorf("%v: expected %q, saw %d %q", test.header, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	expected := []string{"", "orders-v2", "", "orders-v2", ""}
+	if !reflect.DeepEqual(matched, expected) {
+		t.Errorf("Expected hooks to see canaries %q, saw %q", expected, matched)
+	}
+
+	routes := router.Routes()
+	if len(routes) != 2 || !reflect.DeepEqual(routes[1].Predicates,
+		[]string{"canary orders-v2: header X-Canary=true or cookie canary=orders-v2"}) {
+		t.Errorf("Unexpected routes %v", routes)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected a panic for a canary without match options")
+			}
+		}()
+		WithCanary("empty")
+	}()
+}
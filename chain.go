@@ -0,0 +1,98 @@
+package httptreemux
+
+import "net/http"
+
+// Chain is a list of middleware which can be built separately from any group, and then added
+// to several groups with UseChain. Each middleware in the chain may be given a name, so that
+// other middleware can be inserted before it. The middleware runs in the order in which it
+// appears in the chain, with the first one outermost, just as if it had been added to a group
+// with Use.
+//
+//	base := httptreemux.NewChain().
+//	    Append("logging", logRequests).
+//	    Append("auth", requireAuth)
+//	internal := base.Clone().InsertBefore("auth", "trace", traceRequests)
+//
+//	router.NewGroup("/api").UseChain(base)
+//	router.NewGroup("/internal").UseChain(internal)
+type Chain struct {
+	entries []chainEntry
+}
+
+type chainEntry struct {
+	name string
+	middleware
+}
+
+// NewChain returns an empty chain.
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Append adds middleware to the end of the chain, with the given name, which may be empty.
+// It returns the chain so that calls can be chained.
+func (c *Chain) Append(name string, fn MiddlewareFunc) *Chain {
+	c.entries = append(c.entries, chainEntry{name: name, middleware: middleware{fn: fn}})
+	return c
+}
+
+// AppendWithRoute is like Append, but adds route-aware middleware. See Group.UseWithRoute.
+func (c *Chain) AppendWithRoute(name string, fn MiddlewareWithRouteFunc) *Chain {
+	c.entries = append(c.entries, chainEntry{name: name, middleware: middleware{withRoute: fn}})
+	return c
+}
+
+// AppendHandler is like Append, but adds http.Handler middleware.
+func (c *Chain) AppendHandler(name string, fn func(http.Handler) http.Handler) *Chain {
+	return c.Append(name, handlerMiddleware(fn))
+}
+
+// InsertBefore adds middleware to the chain just before the first middleware with the name
+// before, so that it runs before it. It panics if the chain has no middleware with that name.
+func (c *Chain) InsertBefore(before, name string, fn MiddlewareFunc) *Chain {
+	for i, entry := range c.entries {
+		if entry.name == before {
+			entries := make([]chainEntry, 0, len(c.entries)+1)
+			entries = append(entries, c.entries[:i]...)
+			entries = append(entries, chainEntry{name: name, middleware: middleware{fn: fn}})
+			c.entries = append(entries, c.entries[i:]...)
+			return c
+		}
+	}
+	panic("Middleware chain has no middleware named " + before)
+}
+
+// Clone returns a copy of the chain, which can be changed without affecting the original.
+func (c *Chain) Clone() *Chain {
+	return &Chain{entries: append([]chainEntry(nil), c.entries...)}
+}
+
+// Names returns the names of the middleware in the chain, in order.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.entries))
+	for i, entry := range c.entries {
+		names[i] = entry.name
+	}
+	return names
+}
+
+// Then returns handler wrapped by the middleware in the chain, for use outside of a router.
+// Route-aware middleware is given an empty LookupResult.
+func (c *Chain) Then(handler HandlerFunc) HandlerFunc {
+	return handlerWithMiddlewares(handler, c.stack(), LookupResult{})
+}
+
+// stack returns the middleware in the chain in the form of a group's middleware stack.
+func (c *Chain) stack() []middleware {
+	stack := make([]middleware, len(c.entries))
+	for i, entry := range c.entries {
+		stack[i] = entry.middleware
+	}
+	return stack
+}
+
+// UseChain appends the middleware in the chain to the group's middleware stack. Later changes
+// to the chain do not affect the group.
+func (g *Group) UseChain(c *Chain) {
+	g.stack = append(g.stack, c.stack()...)
+}
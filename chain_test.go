@@ -0,0 +1,82 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) MiddlewareFunc {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				calls = append(calls, name)
+				next(w, r, params)
+			}
+		}
+	}
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		calls = append(calls, "handler")
+	}
+
+	base := NewChain().Append("logging", record("logging")).Append("auth", record("auth"))
+	base.AppendWithRoute("route", func(next HandlerFunc, route LookupResult) HandlerFunc {
+		return record("route " + route.Route)(next)
+	})
+	internal := base.Clone().InsertBefore("auth", "trace", record("trace"))
+	base.AppendHandler("", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "handler middleware")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	if names := internal.Names(); !reflect.DeepEqual(names, []string{"logging", "trace", "auth", "route"}) {
+		t.Errorf("Unexpected names in the cloned chain: %v", names)
+	}
+
+	router := New()
+	router.Use(record("router"))
+	api := router.NewGroup("/api")
+	api.UseChain(base)
+	api.GET("/users", handler)
+
+	// The group takes a copy of the chain.
+	base.Append("late", record("late"))
+
+	tools := router.NewGroup("/tools")
+	tools.UseChain(internal)
+	tools.GET("/run", handler)
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/api/users", []string{"router", "logging", "auth", "route /api/users", "handler middleware", "handler"}},
+		{"/tools/run", []string{"router", "logging", "trace", "auth", "route /tools/run", "handler"}},
+	} {
+		calls = nil
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(calls, test.expected) {
+			t.Errorf("%s: expected calls %v, saw %v", test.path, test.expected, calls)
+		}
+	}
+
+	calls = nil
+	r, _ := newRequest("GET", "/", nil)
+	internal.Then(handler)(httptest.NewRecorder(), r, nil)
+	expected := []string{"logging", "trace", "auth", "route ", "handler"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v from Then, saw %v", expected, calls)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic when inserting before a missing name")
+		}
+	}()
+	NewChain().InsertBefore("missing", "x", record("x"))
+}
@@ -0,0 +1,57 @@
+package httptreemux
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock provides the current time to the features of the router which depend on it. Setting
+// TreeMux.Clock to a fake implementation makes the behavior of these features deterministic
+// in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock is a Clock which also runs timers. When TreeMux.Clock implements it, the
+// timeouts of routes added with WithTimeout fire from its timers, so that a fake clock
+// controls when they fire as well as the times it reports.
+type TimerClock interface {
+	Clock
+	// AfterFunc calls f in its own goroutine once d has passed on the clock. The returned
+	// function stops the timer, and returns false if it already fired.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// RandomSource provides random numbers to the features of the router which make random
+// choices, such as sampling. A *rand.Rand created with a fixed seed satisfies it, which makes
+// these choices repeatable in tests. Implementations must be safe for concurrent use;
+// note that a *rand.Rand is not, unlike the functions of the math/rand package.
+type RandomSource interface {
+	// Float64 returns a number in the half-open interval [0.0, 1.0).
+	Float64() float64
+}
+
+// now returns the current time from the router's Clock.
+func (t *TreeMux) now() time.Time {
+	if t.Clock != nil {
+		return t.Clock.Now()
+	}
+	return time.Now()
+}
+
+// afterFunc calls f once d has passed on the router's Clock, using the system clock's timers
+// if it is not a TimerClock.
+func (t *TreeMux) afterFunc(d time.Duration, f func()) func() bool {
+	if clock, ok := t.Clock.(TimerClock); ok {
+		return clock.AfterFunc(d, f)
+	}
+	return time.AfterFunc(d, f).Stop
+}
+
+// random returns a random number in [0.0, 1.0) from the router's RandomSource.
+func (t *TreeMux) random() float64 {
+	if t.Random != nil {
+		return t.Random.Float64()
+	}
+	return rand.Float64()
+}
@@ -0,0 +1,97 @@
+package httptreemux
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	time   time.Time
+	mutex  sync.Mutex
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Time
+	f    func()
+	done bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.time
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &fakeTimer{at: c.time.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		stopped := !timer.done
+		timer.done = true
+		return stopped
+	}
+}
+
+// advance moves the clock forward and fires the timers which are due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	c.time = c.time.Add(d)
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		switch {
+		case timer.done:
+		case timer.at.After(c.time):
+			pending = append(pending, timer)
+		default:
+			timer.done = true
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mutex.Unlock()
+
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+type fakeRandom []float64
+
+func (r *fakeRandom) Float64() float64 {
+	value := (*r)[0]
+	*r = (*r)[1:]
+	return value
+}
+
+func TestClockAndRandom(t *testing.T) {
+	router := New()
+
+	before := time.Now()
+	if now := router.now(); now.Before(before) || now.After(time.Now()) {
+		t.Errorf("Expected the default clock to return the current time, saw %v", now)
+	}
+	if n := router.random(); n < 0 || n >= 1 {
+		t.Errorf("Expected a default random number in [0, 1), saw %v", n)
+	}
+
+	fixed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	router.Clock = &fakeClock{time: fixed}
+	router.Random = &fakeRandom{0.25, 0.75}
+
+	if now := router.now(); !now.Equal(fixed) {
+		t.Errorf("Expected the fake time %v, saw %v", fixed, now)
+	}
+	if n := router.random(); n != 0.25 {
+		t.Errorf("Expected 0.25, saw %v", n)
+	}
+	if n := router.random(); n != 0.75 {
+		t.Errorf("Expected 0.75, saw %v", n)
+	}
+}
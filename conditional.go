@@ -0,0 +1,24 @@
+package httptreemux
+
+// When returns a group with the same path, middleware and options as g, which only registers
+// routes if cond is true. Otherwise, adding routes to the group does nothing, apart from
+// checking that their patterns are valid and recording them for SkippedRoutes. Groups created
+// from the returned group with NewGroup or With inherit this.
+//
+//	router.When(os.Getenv("ENABLE_PPROF") != "").Handler("GET", "/debug/pprof/*", pprofHandler)
+func (g *Group) When(cond bool) *Group {
+	if cond {
+		return g.With()
+	}
+	return g.With(func(info *routeInfo) {
+		info.skip = true
+	})
+}
+
+// DevOnly returns a group which only registers routes in development builds, which are
+// builds with the httptreemux_dev build tag. See DevBuild and When.
+//
+//	router.DevOnly().GET("/debug/routes", dumpRoutes)
+func (g *Group) DevOnly() *Group {
+	return g.When(DevBuild)
+}
@@ -5,7 +5,10 @@ package httptreemux
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 // ContextGroup is a wrapper around Group, with the purpose of mimicking its API, but with the use of http.HandlerFunc-based handlers.
@@ -25,6 +28,22 @@ func (cg *ContextGroup) UseHandler(middleware func(http.Handler) http.Handler) {
 	cg.group.UseHandler(middleware)
 }
 
+// UseAlways is like Use, but the middleware also wraps the responses that the router
+// generates itself. See Group.UseAlways for details.
+func (cg *ContextGroup) UseAlways(fn MiddlewareFunc) {
+	cg.group.UseAlways(fn)
+}
+
+// UseHandlerAlways is like UseAlways but accepts http.Handler middleware.
+func (cg *ContextGroup) UseHandlerAlways(middleware func(http.Handler) http.Handler) {
+	cg.group.UseHandlerAlways(middleware)
+}
+
+// UseChain appends the middleware in the chain to the group's middleware stack.
+func (cg *ContextGroup) UseChain(c *Chain) {
+	cg.group.UseChain(c)
+}
+
 // UsingContext wraps the receiver to return a new instance of a ContextGroup.
 // The returned ContextGroup is a sibling to its wrapped Group, within the parent TreeMux.
 // The choice of using a *Group as the receiver, as opposed to a function parameter, allows chaining
@@ -55,34 +74,79 @@ func (cg *ContextGroup) NewGroup(path string) *ContextGroup {
 	return cg.NewContextGroup(path)
 }
 
-func (cg *ContextGroup) wrapHandler(path string, handler HandlerFunc) HandlerFunc {
+// setHandler sets the handler of a route added to the group, and the function which wraps it
+// with the group's middleware and the context data, for Swap.
+func (cg *ContextGroup) setHandler(info *routeInfo, handler HandlerFunc) {
+	info.handler = handler
+	info.wrap = func(handler HandlerFunc) HandlerFunc {
+		return cg.wrapHandler(info, handler)
+	}
+}
+
+func (cg *ContextGroup) wrapHandler(info *routeInfo, handler HandlerFunc) HandlerFunc {
 	if len(cg.group.stack) > 0 {
-		handler = handlerWithMiddlewares(handler, cg.group.stack)
+		handler = handlerWithMiddlewares(handler, cg.group.stack, info.lookupResult())
 	}
 
 	// add the context data after adding all middleware
-	fullPath := cg.group.path + path
-	return func(writer http.ResponseWriter, request *http.Request, m map[string]string) {
+	mux := cg.group.mux
+	serve := func(writer http.ResponseWriter, request *http.Request, m map[string]string, ps Params) {
+		matched, source, _ := mux.requestPath(request)
+		if locale := mux.pathLocale(matched); locale != "" {
+			matched = stripLocale(matched, locale)
+		}
 		routeData := &contextData{
-			route:  fullPath,
-			params: m,
+			route:         info.pattern,
+			params:        m,
+			orderedParams: ps,
+			matched:       matched,
+			pathSource:    source,
+			metadata:      info.metadata,
+			canary:        info.canary,
+		}
+		if mux.Debug {
+			routeData.normalization = ContextNormalization(request.Context())
 		}
+		routeData.rawParams, _ = request.Context().Value(rawParamsKey).(Params)
+		routeData.assignment, _ = request.Context().Value(assignmentKey).(*Assignment)
+		routeData.mount = ContextMount(request.Context())
 		request = request.WithContext(AddRouteDataToContext(request.Context(), routeData))
 		handler(writer, request, m)
 	}
+
+	info.paramsHandler = func(writer http.ResponseWriter, request *http.Request, ps Params) {
+		serve(writer, request, nil, ps)
+	}
+	return func(writer http.ResponseWriter, request *http.Request, m map[string]string) {
+		serve(writer, request, m, nil)
+	}
 }
 
 // Handle allows handling HTTP requests via an http.HandlerFunc, as opposed to an httptreemux.HandlerFunc.
 // Any parameters from the request URL are stored in a map[string]string in the request's context.
 func (cg *ContextGroup) Handle(method, path string, handler http.HandlerFunc) {
+	if err := cg.TryHandle(method, path, handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryHandle is like Handle, but returns an error instead of panicking if the route can not be
+// added. See Group.TryHandle for details.
+func (cg *ContextGroup) TryHandle(method, path string, handler http.HandlerFunc) error {
 	cg.group.mux.mutex.Lock()
 	defer cg.group.mux.mutex.Unlock()
 
-	wrapped := cg.wrapHandler(path, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	path, err := cg.group.mux.translatePattern(path)
+	if err != nil {
+		return err
+	}
+
+	info := cg.group.newRouteInfo(path)
+	cg.setHandler(info, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 		handler(w, r)
 	})
 
-	cg.group.addFullStackHandler(method, path, wrapped)
+	return cg.group.addFullStackHandler(method, path, info.wrap(info.handler), info)
 }
 
 // Handler allows handling HTTP requests via an http.Handler interface, as opposed to an httptreemux.HandlerFunc.
@@ -91,11 +155,94 @@ func (cg *ContextGroup) Handler(method, path string, handler http.Handler) {
 	cg.group.mux.mutex.Lock()
 	defer cg.group.mux.mutex.Unlock()
 
-	wrapped := cg.wrapHandler(path, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	path, err := cg.group.mux.translatePattern(path)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	info := cg.group.newRouteInfo(path)
+	cg.setHandler(info, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 		handler.ServeHTTP(w, r)
 	})
 
-	cg.group.addFullStackHandler(method, path, wrapped)
+	if err = cg.group.addFullStackHandler(method, path, info.wrap(info.handler), info); err != nil {
+		panic(err.Error())
+	}
+}
+
+// UseProblemResponses makes the router respond with application/problem+json documents
+// for unmatched requests under the group's path. See Group.UseProblemResponses for details.
+func (cg *ContextGroup) UseProblemResponses() {
+	cg.group.UseProblemResponses()
+}
+
+// SetNotFoundHandler sets the handler called for requests under the group's path which do
+// not match a route. See Group.SetNotFoundHandler for details.
+func (cg *ContextGroup) SetNotFoundHandler(handler func(w http.ResponseWriter, r *http.Request)) {
+	cg.group.SetNotFoundHandler(handler)
+}
+
+// SetMethodNotAllowedHandler sets the handler called for requests under the group's path
+// which do not match the method of a route. See Group.SetMethodNotAllowedHandler for details.
+func (cg *ContextGroup) SetMethodNotAllowedHandler(handler func(w http.ResponseWriter, r *http.Request,
+	methods map[string]HandlerFunc)) {
+	cg.group.SetMethodNotAllowedHandler(handler)
+}
+
+// SetPanicHandler sets the handler called for panics in the handlers of requests under the
+// group's path. See Group.SetPanicHandler for details.
+func (cg *ContextGroup) SetPanicHandler(handler PanicHandler) {
+	cg.group.SetPanicHandler(handler)
+}
+
+// Static adds a route which responds with a precomputed response. See Group.Static for details.
+func (cg *ContextGroup) Static(method, path string, status int, header http.Header, body []byte) {
+	cg.group.Static(method, path, status, header, body)
+}
+
+// Mount forwards all requests under path to handler, with path removed from the request URL.
+// See Group.Mount for details.
+func (cg *ContextGroup) Mount(path string, handler http.Handler) {
+	cg.group.Mount(path, handler)
+}
+
+// With returns a context group which applies the given options to every route registered
+// through it. See Group.With for details.
+func (cg *ContextGroup) With(opts ...RouteOption) *ContextGroup {
+	return &ContextGroup{cg.group.With(opts...)}
+}
+
+// When returns a context group which only registers routes if cond is true. See Group.When.
+func (cg *ContextGroup) When(cond bool) *ContextGroup {
+	return &ContextGroup{cg.group.When(cond)}
+}
+
+// DevOnly returns a context group which only registers routes in development builds.
+// See Group.DevOnly.
+func (cg *ContextGroup) DevOnly() *ContextGroup {
+	return &ContextGroup{cg.group.DevOnly()}
+}
+
+// Remove deletes the handler for a method from a route. See Group.Remove for details.
+func (cg *ContextGroup) Remove(method, path string) bool {
+	return cg.group.Remove(method, path)
+}
+
+// Swap replaces the handler of a route and returns the one it replaced. See Group.Swap for
+// details.
+func (cg *ContextGroup) Swap(method, path string, handler http.HandlerFunc) (http.HandlerFunc, error) {
+	if handler == nil {
+		return nil, errors.New("Swap needs a handler")
+	}
+	old, err := cg.group.Swap(method, path, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		handler(w, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		old(w, r, ContextParams(r.Context()))
+	}, nil
 }
 
 // GET is convenience method for handling GET requests on a context group.
@@ -133,9 +280,28 @@ func (cg *ContextGroup) OPTIONS(path string, handler http.HandlerFunc) {
 	cg.Handle("OPTIONS", path, handler)
 }
 
+// Any is convenience method for handling requests of any method on a context group.
+// See Group.Any.
+func (cg *ContextGroup) Any(path string, handler http.HandlerFunc) {
+	cg.Handle(MethodAny, path, handler)
+}
+
 type contextData struct {
 	route  string
 	params map[string]string
+	// The parameters when TreeMux.PooledParams is set, in which case params
+	// is built from them on demand.
+	orderedParams Params
+	// The path that was matched against the tree, before unescaping, and where it came from.
+	matched    string
+	pathSource PathSource
+	// The escaped values of the parameters, when unescaping changed any of them.
+	rawParams     Params
+	metadata      interface{}
+	normalization *Normalization
+	assignment    *Assignment
+	canary        string
+	mount         *MountPoint
 }
 
 func (cd *contextData) Route() string {
@@ -143,18 +309,216 @@ func (cd *contextData) Route() string {
 }
 
 func (cd *contextData) Params() map[string]string {
+	if cd.params == nil && len(cd.orderedParams) != 0 {
+		cd.params = cd.orderedParams.Map()
+	}
 	if cd.params != nil {
 		return cd.params
 	}
 	return map[string]string{}
 }
 
+func (cd *contextData) OrderedParams() Params {
+	if cd.orderedParams == nil && len(cd.params) != 0 {
+		// Without PooledParams, the order of the parameters comes from the route.
+		for _, name := range patternParamNames(cd.route) {
+			if value, ok := cd.params[name]; ok {
+				cd.orderedParams = append(cd.orderedParams, Param{Key: name, Value: value})
+			}
+		}
+	}
+	return cd.orderedParams
+}
+
+// param returns the value of a parameter without building the params map.
+func (cd *contextData) param(name string) (string, bool) {
+	if cd.params == nil {
+		return cd.orderedParams.lookup(name)
+	}
+	value, ok := cd.params[name]
+	return value, ok
+}
+
+func (cd *contextData) Param(name string) string {
+	value, _ := cd.param(name)
+	return value
+}
+
+func (cd *contextData) ParamOr(name, def string) string {
+	if value, ok := cd.param(name); ok {
+		return value
+	}
+	return def
+}
+
+func (cd *contextData) ParamInt(name string) (int, error) {
+	n, err := paramLookup(cd.param).int64(name, strconv.IntSize)
+	return int(n), err
+}
+
+func (cd *contextData) ParamInt64(name string) (int64, error) {
+	return paramLookup(cd.param).int64(name, 64)
+}
+
+func (cd *contextData) ParamBool(name string) (bool, error) {
+	return paramLookup(cd.param).bool(name)
+}
+
+func (cd *contextData) ParamUUID(name string) ([16]byte, error) {
+	return paramLookup(cd.param).uuid(name)
+}
+
+func (cd *contextData) Decode(dst interface{}) error {
+	return paramLookup(cd.param).decode(dst)
+}
+
+func (cd *contextData) RawParams() map[string]string {
+	if cd.rawParams == nil {
+		return cd.Params()
+	}
+	return cd.rawParams.Map()
+}
+
+func (cd *contextData) PathSource() PathSource {
+	return cd.pathSource
+}
+
+func (cd *contextData) Metadata() interface{} {
+	return cd.metadata
+}
+
+func (cd *contextData) Assignment() *Assignment {
+	return cd.assignment
+}
+
+func (cd *contextData) Canary() string {
+	return cd.canary
+}
+
+func (cd *contextData) Mount() *MountPoint {
+	return cd.mount
+}
+
+func (cd *contextData) Normalization() *Normalization {
+	return cd.normalization
+}
+
+func (cd *contextData) WildcardSegments(name string) []string {
+	value, ok := cd.param(name)
+	if !ok {
+		return nil
+	}
+
+	patternSegments := strings.Split(cd.route, "/")
+	catchAll := -1
+	for i, segment := range patternSegments {
+		if len(segment) > 1 && segment[0] == '*' && segmentParamName(segment) == name {
+			catchAll = i
+			break
+		}
+	}
+	if catchAll == -1 {
+		// A single path segment.
+		return []string{value}
+	}
+
+	// The catch-all value was unescaped as a whole, so an escaped slash is indistinguishable
+	// from a separator. Find the raw text that it was matched from, which starts after the
+	// same number of slashes as the catch-all in the pattern and ends before the segments
+	// which follow it, and split that instead.
+	raw := cd.matched
+	for slashes := catchAll; slashes > 0 && raw != ""; slashes-- {
+		next := strings.IndexByte(raw, '/')
+		if next == -1 {
+			raw = ""
+			break
+		}
+		raw = raw[next+1:]
+	}
+	for after := len(patternSegments) - catchAll - 1; after > 0 && raw != ""; after-- {
+		last := strings.LastIndexByte(raw, '/')
+		if last == -1 {
+			raw = ""
+			break
+		}
+		raw = raw[:last]
+	}
+
+	if raw != "" {
+		segments := strings.Split(raw, "/")
+		for i, segment := range segments {
+			if unescaped, err := unescape(segment); err == nil {
+				segments[i] = unescaped
+			}
+		}
+
+		if joined := strings.Join(segments, "/"); joined == value {
+			return segments
+		} else if joined == value+"/" {
+			// The trailing slash was removed from the request before matching.
+			return segments[:len(segments)-1]
+		}
+	}
+
+	// The matched path is not available or was transformed before matching, such as by
+	// CaseInsensitive, so fall back to splitting the unescaped value.
+	return strings.Split(value, "/")
+}
+
 // ContextRouteData is the information associated with the matched path.
 // Route() returns the matched route, without expanded wildcards.
+// Param() returns the value of a wildcard, or an empty string if the route has no wildcard
+// with that name or it was an optional parameter that was not given. ParamOr() is the same,
+// but returns the given default instead of an empty string. Neither builds the params map,
+// so they are preferred over Params() for reading single parameters.
+// ParamInt(), ParamInt64(), ParamBool() and ParamUUID() convert the value of a wildcard,
+// returning a *ParamError if it is missing or invalid. ParamUUID() accepts the standard
+// hyphenated form, and its result can be converted to the UUID types of other packages.
+// Decode() sets the fields of the struct that its argument points to from the wildcards
+// named by the fields' `param` tags, leaving the fields for missing parameters unchanged.
+// String, bool, integer, float and [16]byte UUID fields are supported, as well as fields
+// which implement encoding.TextUnmarshaler.
 // Params() returns a map of the route's wildcards and their matched values.
+// RawParams() is like Params(), but returns the values as they appeared in the request URL,
+// before they were unescaped, so that a proxy can forward them upstream unchanged.
+// WildcardSegments() splits the value of a catch-all parameter into its unescaped path
+// segments. An escaped slash (%2F) in the URL stays within its segment, and empty segments
+// are kept, so "/files/a%2Fb//c" matched against "/files/*path" gives ["a/b", "", "c"]. For
+// a single-segment wildcard it returns the value as the only element, and it returns nil
+// if there is no parameter with the given name.
+// Metadata() returns the value attached to the route with WithMetadata, or nil.
+// PathSource() returns where the path that was matched came from: RequestURI or URLPath.
+// It differs from TreeMux.PathSource when PathMismatchBehavior chose the other path, or when
+// the request had no RequestURI, as for requests made with http.NewRequest.
+// Normalization() returns how the router transformed the request path before matching it,
+// or nil unless TreeMux.Debug is true.
+// Assignment() returns the experiment variant chosen for the request, or nil unless the
+// route takes part in an experiment added with WithExperiment.
+// Canary() returns the name of the release serving the request, or an empty string unless
+// the route was added with WithCanary.
+// Mount() returns where the router serving the request was mounted by another router with
+// Mount or MountWithMetadata, or nil if it was not.
+// OrderedParams() returns the route's wildcards and their matched values in the order they
+// appear in the route. It does not allocate when TreeMux.PooledParams is set.
 type ContextRouteData interface {
 	Route() string
+	Param(name string) string
+	ParamOr(name, def string) string
+	ParamInt(name string) (int, error)
+	ParamInt64(name string) (int64, error)
+	ParamBool(name string) (bool, error)
+	ParamUUID(name string) ([16]byte, error)
+	Decode(dst interface{}) error
 	Params() map[string]string
+	RawParams() map[string]string
+	WildcardSegments(name string) []string
+	Metadata() interface{}
+	PathSource() PathSource
+	Normalization() *Normalization
+	Assignment() *Assignment
+	Canary() string
+	Mount() *MountPoint
+	OrderedParams() Params
 }
 
 // ContextParams returns a map of the route's wildcards and their matched values.
@@ -165,6 +529,15 @@ func ContextParams(ctx context.Context) map[string]string {
 	return map[string]string{}
 }
 
+// ContextParam returns the value of one of the route's wildcards, or an empty string if
+// there is no such parameter. See ContextRouteData.Param.
+func ContextParam(ctx context.Context, name string) string {
+	if cd := ContextData(ctx); cd != nil {
+		return cd.Param(name)
+	}
+	return ""
+}
+
 // ContextRoute returns the matched route, without expanded wildcards.
 func ContextRoute(ctx context.Context) string {
 	if cd := ContextData(ctx); cd != nil {
@@ -173,6 +546,21 @@ func ContextRoute(ctx context.Context) string {
 	return ""
 }
 
+// ContextMount returns where the handler serving the request was mounted with Mount or
+// MountWithMetadata, or nil if it was not. When handlers are mounted within each other, it
+// returns the innermost mount point.
+func ContextMount(ctx context.Context) *MountPoint {
+	m, _ := ctx.Value(mountKey).(*MountPoint)
+	return m
+}
+
+// ContextNormalization returns how the router transformed the request path before matching it.
+// It returns nil unless TreeMux.Debug is true.
+func ContextNormalization(ctx context.Context) *Normalization {
+	n, _ := ctx.Value(normalizationKey).(*Normalization)
+	return n
+}
+
 // ContextData returns the ContextRouteData associated with the matched path
 func ContextData(ctx context.Context) ContextRouteData {
 	if p, ok := ctx.Value(contextDataKey).(ContextRouteData); ok {
@@ -204,6 +592,24 @@ func AddRouteToContext(ctx context.Context, route string) context.Context {
 
 type contextKey int
 
-// contextDataKey is used to retrieve the path's params map and matched route
-// from a request's context.
-const contextDataKey contextKey = 0
+const (
+	// contextDataKey is used to retrieve the path's params map and matched route
+	// from a request's context.
+	contextDataKey contextKey = iota
+
+	// reRouteDepthKey is used to count the number of times a request has been
+	// passed to ReRoute.
+	reRouteDepthKey
+
+	// normalizationKey is used to retrieve the Normalization recorded in debug mode.
+	normalizationKey
+
+	// rawParamsKey is used to retrieve the escaped parameter values of a request.
+	rawParamsKey
+
+	// assignmentKey is used to retrieve the experiment variant chosen for a request.
+	assignmentKey
+
+	// mountKey is used to retrieve the mount point of a request forwarded by Mount.
+	mountKey
+)
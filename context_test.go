@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -93,7 +95,7 @@ func TestContextData(t *testing.T) {
 	ctxData := ContextData(ctx)
 	pathValue := ctxData.Route()
 	if pathValue != p.route {
-		t.Errorf("expected '%s', but got '%s'", p, pathValue)
+		t.Errorf("expected '%s', but got '%s'", p.route, pathValue)
 	}
 
 	params := ctxData.Params()
@@ -335,6 +337,44 @@ func TestDefaultContext(t *testing.T) {
 	router.ServeHTTP(w, r)
 }
 
+func TestDefaultContextMerge(t *testing.T) {
+	type key string
+	router := New()
+	router.DefaultContext = context.WithValue(context.WithValue(context.Background(),
+		key("default"), "default"), key("shared"), "default")
+
+	var ctx context.Context
+	router.GET("/abc", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		ctx = r.Context()
+	})
+
+	requestCtx, cancel := context.WithCancel(context.WithValue(context.Background(), key("shared"), "request"))
+	r, _ := http.NewRequest("GET", "/abc", nil)
+	r = r.WithContext(requestCtx)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	if v := ctx.Value(key("default")); v != "default" {
+		t.Errorf("Expected value from DefaultContext, saw %v", v)
+	}
+	if v := ctx.Value(key("shared")); v != "request" {
+		t.Errorf("Expected the request's value to take precedence, saw %v", v)
+	}
+	cancel()
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("Expected canceling the request's context to cancel the handler's context")
+	}
+
+	router.DefaultContextMerge = func(request, defaults context.Context) context.Context {
+		return context.WithValue(defaults, key("merged"), true)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if ctx.Value(key("merged")) != true || ctx.Value(key("shared")) != "default" {
+		t.Error("Expected DefaultContextMerge to create the handler's context")
+	}
+}
+
 func TestContextMuxSimple(t *testing.T) {
 	router := NewContextMux()
 	ctx := context.WithValue(context.Background(), "abc", "def")
@@ -467,3 +507,418 @@ func TestContextDataWithMiddleware(t *testing.T) {
 		t.Fatalf("unexpected status code.  got %d", w.Code)
 	}
 }
+
+func TestWildcardSegments(t *testing.T) {
+	for _, scenario := range scenarios {
+		t.Run(scenario.description, func(t *testing.T) {
+			router := NewContextMux()
+
+			var segments, idSegments, missing []string
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				data := ContextData(r.Context())
+				segments = data.WildcardSegments("path")
+				idSegments = data.WildcardSegments("id")
+				missing = data.WildcardSegments("missing")
+			}
+			router.GET("/files/:id/*path", handler)
+
+			requestURIOnly := func(path string) bool {
+				r, _ := scenario.RequestCreator("GET", path, nil)
+				return r.RequestURI != ""
+			}
+
+			for _, test := range []struct {
+				path     string
+				expected []string
+				// Escaped slashes can only be told apart when matching on RequestURI.
+				needsRequestURI bool
+			}{
+				{"/files/5/a/b/c", []string{"a", "b", "c"}, false},
+				{"/files/5/a%20b/c", []string{"a b", "c"}, false},
+				{"/files/5/a//c", []string{"a", "", "c"}, false},
+				{"/files/5/a/b/", []string{"a", "b"}, false},
+				{"/files/5/a%2Fb/c", []string{"a/b", "c"}, true},
+				{"/files/5%2F6/a%2Fb", []string{"a/b"}, true},
+			} {
+				if test.needsRequestURI && !requestURIOnly(test.path) {
+					continue
+				}
+
+				segments, idSegments, missing = nil, nil, nil
+				r, _ := scenario.RequestCreator("GET", test.path, nil)
+				router.ServeHTTP(httptest.NewRecorder(), r)
+
+				if !reflect.DeepEqual(segments, test.expected) {
+					t.Errorf("%s: expected segments %q, saw %q", test.path, test.expected, segments)
+				}
+				if len(idSegments) != 1 {
+					t.Errorf("%s: expected one segment for a wildcard, saw %q", test.path, idSegments)
+				}
+				if missing != nil {
+					t.Errorf("%s: expected nil for a missing parameter, saw %q", test.path, missing)
+				}
+			}
+
+			// A catch-all in the middle of the pattern ends before the segments which follow it.
+			router.GET("/docs/*path/meta", handler)
+			for path, expected := range map[string][]string{
+				"/docs/a/b/meta":     {"a", "b"},
+				"/docs/a/b%2Fc/meta": {"a", "b/c"},
+			} {
+				if strings.Contains(path, "%2F") && !requestURIOnly(path) {
+					continue
+				}
+				segments = nil
+				r, _ := scenario.RequestCreator("GET", path, nil)
+				router.ServeHTTP(httptest.NewRecorder(), r)
+				if !reflect.DeepEqual(segments, expected) {
+					t.Errorf("%s: expected segments %q, saw %q", path, expected, segments)
+				}
+			}
+
+			// The extension set is not part of the catch-all's name.
+			router.GET("/assets/*path[.js,.css]", handler)
+			if requestURIOnly("/assets/a%2Fb/c.js") {
+				segments = nil
+				r, _ := scenario.RequestCreator("GET", "/assets/a%2Fb/c.js", nil)
+				router.ServeHTTP(httptest.NewRecorder(), r)
+				if expected := []string{"a/b", "c.js"}; !reflect.DeepEqual(segments, expected) {
+					t.Errorf("Expected segments %q for an extension catch-all, saw %q", expected, segments)
+				}
+			}
+		})
+	}
+}
+
+func TestContextMetadata(t *testing.T) {
+	router := NewContextMux()
+
+	var metadata interface{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		metadata = ContextData(r.Context()).Metadata()
+	}
+	router.With(WithMetadata("limited")).GET("/limited", handler)
+	router.GET("/unlimited", handler)
+
+	for path, expected := range map[string]interface{}{"/limited": "limited", "/unlimited": nil} {
+		metadata = "unset"
+		r, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if metadata != expected {
+			t.Errorf("%s: expected metadata %v, saw %v", path, expected, metadata)
+		}
+	}
+}
+
+func TestContextNormalization(t *testing.T) {
+	router := NewContextMux()
+	router.Debug = true
+	router.CaseInsensitive = true
+
+	var data, notFound *Normalization
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		data = ContextData(r.Context()).Normalization()
+	})
+	router.NotFoundHandler = func(w http.ResponseWriter, r *http.Request) {
+		notFound = ContextNormalization(r.Context())
+	}
+
+	r, _ := http.NewRequest("GET", "/Users/abc", nil)
+	r.RequestURI = "/Users/abc/#top"
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if data != nil {
+		t.Errorf("Expected no normalization for a redirected request, saw %+v", data)
+	}
+
+	r.RequestURI = "/Users/abc#top"
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	expected := &Normalization{
+		Original:        "/Users/abc#top",
+		Unescaped:       "/Users/abc",
+		Searched:        "/users/abc",
+		Transformations: []string{TransformStripFragment, TransformLowercase},
+		Candidates:      []string{"/users/:id"},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected normalization %+v, saw %+v", expected, data)
+	}
+
+	r, _ = http.NewRequest("GET", "/missing//page/", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	expected = &Normalization{
+		Original:        "/missing//page/",
+		Unescaped:       "/missing//page/",
+		Cleaned:         "/missing/page",
+		Searched:        "/missing/page",
+		Transformations: []string{TransformStripTrailingSlash, TransformCleanPath},
+	}
+	if !reflect.DeepEqual(notFound, expected) {
+		t.Errorf("Expected normalization %+v for a missing route, saw %+v", expected, notFound)
+	}
+
+	router.Debug = false
+	data = &Normalization{}
+	r, _ = http.NewRequest("GET", "/users/abc", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if data != nil {
+		t.Errorf("Expected no normalization without Debug, saw %+v", data)
+	}
+}
+
+func TestContextParamAccessors(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		router := NewContextMux()
+		router.PooledParams = pooled
+
+		var year, month, monthOr, missing string
+		router.GET("/archive/:year/:month?", func(w http.ResponseWriter, r *http.Request) {
+			data := ContextData(r.Context())
+			year = ContextParam(r.Context(), "year")
+			month = data.Param("month")
+			monthOr = data.ParamOr("month", "all")
+			missing = data.ParamOr("missing", "none")
+		})
+
+		r, _ := http.NewRequest("GET", "/archive/2024", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if year != "2024" || month != "" || monthOr != "all" || missing != "none" {
+			t.Errorf("Pooled %v: unexpected values %q, %q, %q, %q", pooled, year, month, monthOr, missing)
+		}
+
+		r, _ = http.NewRequest("GET", "/archive/2024/06", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if month != "06" || monthOr != "06" {
+			t.Errorf("Pooled %v: expected month 06, saw %q and %q", pooled, month, monthOr)
+		}
+	}
+
+	if v := ContextParam(context.Background(), "id"); v != "" {
+		t.Errorf("Expected an empty string without route data, saw %q", v)
+	}
+}
+
+type testSlug string
+
+func (s *testSlug) UnmarshalText(text []byte) error {
+	*s = testSlug(strings.ToUpper(string(text)))
+	return nil
+}
+
+func TestContextTypedParams(t *testing.T) {
+	router := NewContextMux()
+	var data ContextRouteData
+	router.GET("/orgs/:org/items/:id/:active/:slug/:ratio", func(w http.ResponseWriter, r *http.Request) {
+		data = ContextData(r.Context())
+	})
+
+	r, _ := http.NewRequest("GET", "/orgs/123E4567-E89B-12D3-A456-426614174000/items/42/true/red/0.5", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	if id, err := data.ParamInt("id"); id != 42 || err != nil {
+		t.Errorf("Expected id 42, saw %d, %v", id, err)
+	}
+	if id, err := data.ParamInt64("id"); id != 42 || err != nil {
+		t.Errorf("Expected id 42, saw %d, %v", id, err)
+	}
+	if active, err := data.ParamBool("active"); !active || err != nil {
+		t.Errorf("Expected active to be true, saw %v, %v", active, err)
+	}
+	expectedUUID := [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	if org, err := data.ParamUUID("org"); org != expectedUUID || err != nil {
+		t.Errorf("Expected org %x, saw %x, %v", expectedUUID, org, err)
+	}
+
+	_, err := data.ParamInt("slug")
+	if perr, ok := err.(*ParamError); !ok || perr.Name != "slug" || perr.Value != "red" || perr.Err != strconv.ErrSyntax {
+		t.Errorf("Expected a syntax error for slug, saw %v", err)
+	}
+	if _, err := data.ParamUUID("id"); err == nil {
+		t.Error("Expected an error for an invalid UUID")
+	}
+	_, err = data.ParamBool("missing")
+	if perr, ok := err.(*ParamError); !ok || perr.Err != ErrMissingParam {
+		t.Errorf("Expected a missing parameter error, saw %v", err)
+	}
+
+	type itemParams struct {
+		Org      [16]byte `param:"org"`
+		ID       uint16   `param:"id"`
+		Active   bool     `param:"active"`
+		Slug     testSlug `param:"slug"`
+		Ratio    float64  `param:"ratio"`
+		Missing  string   `param:"missing"`
+		Untagged string
+	}
+	params := itemParams{Missing: "default"}
+	if err := data.Decode(&params); err != nil {
+		t.Fatal(err)
+	}
+	expected := itemParams{Org: expectedUUID, ID: 42, Active: true, Slug: "RED", Ratio: 0.5, Missing: "default"}
+	if params != expected {
+		t.Errorf("Expected decoded params %+v, saw %+v", expected, params)
+	}
+
+	var invalid struct {
+		ID bool `param:"id"`
+	}
+	if err := data.Decode(&invalid); err == nil {
+		t.Error("Expected an error for an invalid field value")
+	}
+	if err := data.Decode(params); err == nil {
+		t.Error("Expected an error for a non-pointer destination")
+	}
+}
+
+func TestContextRawParams(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		router := NewContextMux()
+		router.PooledParams = pooled
+
+		var params, raw map[string]string
+		router.GET("/proxy/:bucket/*key", func(w http.ResponseWriter, r *http.Request) {
+			params = ContextData(r.Context()).Params()
+			raw = ContextData(r.Context()).RawParams()
+		})
+
+		for _, test := range []struct {
+			path        string
+			params, raw map[string]string
+		}{
+			{"/proxy/a%2Fb/c%2Bd/e", map[string]string{"bucket": "a/b", "key": "c+d/e"},
+				map[string]string{"bucket": "a%2Fb", "key": "c%2Bd/e"}},
+			{"/proxy/a/b+c", map[string]string{"bucket": "a", "key": "b+c"},
+				map[string]string{"bucket": "a", "key": "b+c"}},
+		} {
+			r, _ := newRequest("GET", test.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			if !reflect.DeepEqual(params, test.params) {
+				t.Errorf("Pooled %v, %s: expected params %v, saw %v", pooled, test.path, test.params, params)
+			}
+			if !reflect.DeepEqual(raw, test.raw) {
+				t.Errorf("Pooled %v, %s: expected raw params %v, saw %v", pooled, test.path, test.raw, raw)
+			}
+		}
+	}
+}
+
+func TestContextPathSource(t *testing.T) {
+	router := NewContextMux()
+	router.PathMismatchBehavior = PreferURLPath
+
+	var source PathSource
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		source = ContextData(r.Context()).PathSource()
+	})
+
+	for _, test := range []struct {
+		requestURI string
+		expected   PathSource
+	}{
+		{"/users/1", RequestURI},
+		{"/api/users/1", URLPath},
+		{"", URLPath},
+	} {
+		source = -1
+		r, _ := newRequest("GET", "/users/1", nil)
+		r.RequestURI = test.requestURI
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if source != test.expected {
+			t.Errorf("RequestURI %q: expected path source %d, saw %d", test.requestURI, test.expected, source)
+		}
+	}
+}
+
+func TestContextLocale(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		router := NewContextMux()
+		router.PooledParams = pooled
+		router.Locales = []string{"en", "de"}
+
+		var locale string
+		var segments []string
+		router.GET("/files/*path", func(w http.ResponseWriter, r *http.Request) {
+			data := ContextData(r.Context())
+			locale = data.Param(LocaleParam)
+			segments = data.WildcardSegments("path")
+		})
+
+		r, _ := newRequest("GET", "/de/files/a%2Fb/c", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if locale != "de" || !reflect.DeepEqual(segments, []string{"a/b", "c"}) {
+			t.Errorf("Pooled %v: expected locale de and segments [a/b c], saw %q and %q", pooled, locale, segments)
+		}
+	}
+}
+
+func TestContextMount(t *testing.T) {
+	var route string
+	var mount *MountPoint
+	api := NewContextMux()
+	api.GET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		route = ContextRoute(r.Context())
+		mount = ContextData(r.Context()).Mount()
+	})
+
+	router := New()
+	router.MountWithMetadata("/v1", api, "v1")
+	router.NewGroup("/api").MountWithMetadata("/v2", api, "v2")
+	router.Mount("/plain", api)
+	router.GET("/direct", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		mount = ContextMount(r.Context())
+	})
+
+	for _, test := range []struct {
+		path     string
+		expected *MountPoint
+	}{
+		{"/v1/users/1", &MountPoint{Prefix: "/v1", Metadata: "v1"}},
+		{"/api/v2/users/1", &MountPoint{Prefix: "/api/v2", Metadata: "v2"}},
+		{"/plain/users/1", &MountPoint{Prefix: "/plain"}},
+		{"/direct", nil},
+	} {
+		mount = nil
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(mount, test.expected) {
+			t.Errorf("%s: expected mount point %v, saw %v", test.path, test.expected, mount)
+		}
+	}
+	if route != "/users/:id" {
+		t.Errorf("Expected the mounted route to be reported, saw %q", route)
+	}
+}
+
+func TestContextPatternSyntaxBraces(t *testing.T) {
+	router := NewContextMux()
+	router.PatternSyntax = SyntaxBraces
+
+	var route string
+	var params map[string]string
+	router.GET("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route, params = ContextRoute(r.Context()), ContextParams(r.Context())
+	})
+	router.Handler("GET", "/files/{path...}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params = ContextRoute(r.Context()), ContextParams(r.Context())
+	}))
+
+	for _, test := range []struct {
+		path   string
+		route  string
+		params map[string]string
+	}{
+		{"/users/42", "/users/:id", map[string]string{"id": "42"}},
+		{"/files/a/b.txt", "/files/*path", map[string]string{"path": "a/b.txt"}},
+	} {
+		route, params = "", nil
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || route != test.route || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected 200 %s %v, saw %d %s %v", test.path, test.route, test.params, w.Code, route, params)
+		}
+	}
+
+	if err := router.TryHandle("GET", "/{a{b}}", func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("expected an invalid brace pattern to be rejected")
+	}
+}
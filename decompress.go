@@ -0,0 +1,107 @@
+package httptreemux
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DecompressRequestBody returns a middleware which transparently decodes request bodies sent
+// with a Content-Encoding of gzip or deflate before the handler reads them. The handler sees the
+// decompressed body, and the Content-Encoding and Content-Length headers are removed from the
+// request it receives. Requests without a Content-Encoding, or with "identity", are passed
+// through unchanged.
+//
+// maxSize limits the size of the decompressed body. Reading past the limit returns an error from
+// the body's Read method, in the same way as http.MaxBytesReader. A maxSize of zero or less
+// disables the limit, which is not recommended for endpoints open to untrusted clients.
+//
+// A body which is not valid for its declared encoding results in a 400 response, and an encoding
+// other than gzip or deflate results in a 415 response. In both cases the handler is not called.
+//
+// The middleware can be enabled for a single route by wrapping its handler, or for a whole
+// group with Use:
+//
+//	router.POST("/ingest", httptreemux.DecompressRequestBody(10<<20)(ingestHandler))
+//
+//	api := router.NewGroup("/api")
+//	api.Use(httptreemux.DecompressRequestBody(10 << 20))
+func DecompressRequestBody(maxSize int64) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" || emptyBody(r.Body) {
+				next(w, r, params)
+				return
+			}
+
+			var body io.ReadCloser
+			var err error
+			switch encoding {
+			case "gzip", "x-gzip":
+				body, err = gzip.NewReader(r.Body)
+			case "deflate":
+				body, err = newDeflateReader(r.Body)
+			default:
+				http.Error(w, "Unsupported Content-Encoding "+encoding, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			if err != nil {
+				http.Error(w, "Malformed "+encoding+" request body", http.StatusBadRequest)
+				return
+			}
+
+			if maxSize > 0 {
+				body = http.MaxBytesReader(w, body, maxSize)
+			}
+
+			decoded := new(http.Request)
+			*decoded = *r
+			decoded.Header = make(http.Header, len(r.Header))
+			for k, v := range r.Header {
+				decoded.Header[k] = v
+			}
+			decoded.Header.Del("Content-Encoding")
+			decoded.Header.Del("Content-Length")
+			decoded.ContentLength = -1
+			decoded.Body = decompressedBody{body, r.Body}
+
+			next(w, decoded, params)
+		}
+	}
+}
+
+// newDeflateReader handles both the zlib-wrapped format that RFC 9110 specifies for the
+// deflate coding, and the raw deflate stream that many clients send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	isZlib := header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+	if isZlib {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// decompressedBody closes both the decompressor and the original request body.
+type decompressedBody struct {
+	io.ReadCloser
+	original io.Closer
+}
+
+func (b decompressedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if origErr := b.original.Close(); err == nil {
+		err = origErr
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package httptreemux
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecompressRequestBody(t *testing.T) {
+	payload := strings.Repeat("abcdefgh", 100)
+
+	compress := func(encoding string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "rawdeflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		default:
+			w = nopWriteCloser{&buf}
+		}
+		w.Write([]byte(payload))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	var seenBody string
+	var seenErr error
+	var seenEncoding string
+	router := New()
+	router.POST("/ingest", DecompressRequestBody(int64(len(payload)))(
+		func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			var body []byte
+			body, seenErr = ioutil.ReadAll(r.Body)
+			seenBody = string(body)
+			seenEncoding = r.Header.Get("Content-Encoding")
+		}))
+	router.POST("/small", DecompressRequestBody(10)(
+		func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			_, seenErr = ioutil.ReadAll(r.Body)
+		}))
+
+	send := func(path, encoding string, body []byte) *httptest.ResponseRecorder {
+		seenBody, seenErr, seenEncoding = "", nil, ""
+		r, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+		if encoding != "" {
+			r.Header.Set("Content-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, test := range []struct {
+		header, format string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate", "rawdeflate"},
+	} {
+		w := send("/ingest", test.header, compress(test.format))
+		if w.Code != http.StatusOK {
+			t.Errorf("Encoding %q: expected code 200, saw %d", test.header, w.Code)
+		}
+		if seenErr != nil {
+			t.Errorf("Encoding %q: unexpected read error %v", test.header, seenErr)
+		}
+		if seenBody != payload {
+			t.Errorf("Encoding %q: body was not decoded, saw %q", test.header, seenBody)
+		}
+		if test.format != "" && seenEncoding != "" {
+			t.Errorf("Encoding %q: Content-Encoding was not removed", test.header)
+		}
+	}
+
+	if w := send("/ingest", "br", compress("")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected code 415 for unsupported encoding, saw %d", w.Code)
+	}
+
+	if w := send("/ingest", "gzip", []byte("not gzip")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected code 400 for malformed body, saw %d", w.Code)
+	}
+
+	send("/small", "gzip", compress("gzip"))
+	if seenErr == nil {
+		t.Error("Expected an error when reading past the decompressed size limit")
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
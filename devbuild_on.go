@@ -0,0 +1,8 @@
+//go:build httptreemux_dev
+// +build httptreemux_dev
+
+package httptreemux
+
+// DevBuild is true when the program was built with the httptreemux_dev build tag, which
+// enables the routes added to groups from DevOnly.
+const DevBuild = true
@@ -0,0 +1,51 @@
+package httptreemux
+
+import "net/http"
+
+// StatusTooEarly is the 425 Too Early status defined by RFC 8470, which the router returns for
+// requests in TLS early data that it is not safe to replay.
+const StatusTooEarly = 425
+
+// IsEarlyData returns true if the request was sent in TLS 1.3 early data (0-RTT), so that an
+// attacker could have replayed it. This is the case if the TLS handshake of the connection was
+// not yet complete when the request was received, or if a proxy in front of the server which
+// accepted the early data added an Early-Data header of 1, as described in RFC 8470.
+func IsEarlyData(r *http.Request) bool {
+	if r.TLS != nil && !r.TLS.HandshakeComplete {
+		return true
+	}
+	return r.Header.Get("Early-Data") == "1"
+}
+
+// WithEarlyData sets whether a route accepts requests sent in TLS early data, overriding
+// TreeMux.RejectEarlyData. With false, such requests are answered with 425 Too Early, so that
+// the client retries them once the handshake is complete. With true, they are served even
+// when the route's method is not idempotent.
+func WithEarlyData(allowed bool) RouteOption {
+	return func(info *routeInfo) {
+		info.earlyData = &allowed
+	}
+}
+
+// isIdempotentMethod returns true for the methods which RFC 9110 defines as idempotent, whose
+// requests have the same effect when they are repeated.
+func isIdempotentMethod(method string) bool {
+	return isSafeMethod(method) || method == "PUT" || method == "DELETE"
+}
+
+// rejectsEarlyData returns true if the request must be answered with 425 Too Early instead of
+// being served by the route.
+func (t *TreeMux) rejectsEarlyData(r *http.Request, info *routeInfo) bool {
+	if info != nil && info.earlyData != nil {
+		if *info.earlyData {
+			return false
+		}
+	} else if !t.RejectEarlyData || isIdempotentMethod(r.Method) {
+		return false
+	}
+	return IsEarlyData(r)
+}
+
+func tooEarlyHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	http.Error(w, "Too Early", StatusTooEarly)
+}
@@ -0,0 +1,65 @@
+package httptreemux
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectEarlyData(t *testing.T) {
+	router := New()
+	router.RejectEarlyData = true
+	router.GET("/items", simpleHandler)
+	router.POST("/items", simpleHandler)
+	router.PUT("/items/:id", simpleHandler)
+	router.With(WithEarlyData(true)).POST("/search", simpleHandler)
+	router.With(WithEarlyData(false)).GET("/session", simpleHandler)
+
+	for _, test := range []struct {
+		method, path string
+		early        string
+		tls          *tls.ConnectionState
+		code         int
+	}{
+		{"POST", "/items", "1", nil, StatusTooEarly},
+		{"POST", "/items", "", &tls.ConnectionState{HandshakeComplete: false}, StatusTooEarly},
+		{"POST", "/items", "", &tls.ConnectionState{HandshakeComplete: true}, http.StatusOK},
+		{"POST", "/items", "", nil, http.StatusOK},
+		{"GET", "/items", "1", nil, http.StatusOK},
+		{"PUT", "/items/1", "1", nil, http.StatusOK},
+		{"POST", "/search", "1", nil, http.StatusOK},
+		{"GET", "/session", "1", nil, StatusTooEarly},
+		{"GET", "/session", "", nil, http.StatusOK},
+		{"DELETE", "/items", "1", nil, http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		if test.early != "" {
+			r.Header.Set("Early-Data", test.early)
+		}
+		r.TLS = test.tls
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s (early data %q, tls %v): expected code %d, saw %d",
+				test.method, test.path, test.early, test.tls != nil, test.code, w.Code)
+		}
+	}
+
+	// Routes marked with WithEarlyData(false) reject early data without RejectEarlyData.
+	router.RejectEarlyData = false
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/session", nil)
+	r.Header.Set("Early-Data", "1")
+	router.ServeHTTP(w, r)
+	if w.Code != StatusTooEarly {
+		t.Errorf("Expected code %d for the marked route, saw %d", StatusTooEarly, w.Code)
+	}
+	w = httptest.NewRecorder()
+	r, _ = newRequest("POST", "/items", nil)
+	r.Header.Set("Early-Data", "1")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected early data to be accepted by default, saw %d", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+)
+
+// ErrHandlerFunc is a handler which returns an error instead of writing an error response
+// itself. Routes added with HandleErr pass the returned error to TreeMux.ErrorHandler.
+type ErrHandlerFunc func(http.ResponseWriter, *http.Request) error
+
+// StatusCoder can be implemented by errors returned from an ErrHandlerFunc to choose the
+// status code written by SimpleErrorHandler.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// SimpleErrorHandler is the default TreeMux.ErrorHandler. It writes the status code of the
+// error if it implements StatusCoder, or 500 otherwise, along with the standard text for
+// the status. The message of the error is not written, since it may contain internal details.
+func SimpleErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if coder, ok := err.(StatusCoder); ok {
+		status = coder.StatusCode()
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (t *TreeMux) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if t.ErrorHandler != nil {
+		t.ErrorHandler(w, r, err)
+	} else {
+		SimpleErrorHandler(w, r, err)
+	}
+}
+
+// HandleErr is like Handle, but adds a handler which returns an error. If the handler returns
+// a non-nil error, it is passed to TreeMux.ErrorHandler along with the request, so that
+// mapping errors to status codes, logging and writing the response happen in one place. The
+// handler should not have written a response when it returns an error.
+func (cg *ContextGroup) HandleErr(method, path string, handler ErrHandlerFunc) {
+	mux := cg.group.mux
+	cg.Handle(method, path, func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			mux.handleError(w, r, err)
+		}
+	})
+}
+
+// GETErr is convenience method for handling GET requests with an ErrHandlerFunc.
+func (cg *ContextGroup) GETErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("GET", path, handler)
+}
+
+// POSTErr is convenience method for handling POST requests with an ErrHandlerFunc.
+func (cg *ContextGroup) POSTErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("POST", path, handler)
+}
+
+// PUTErr is convenience method for handling PUT requests with an ErrHandlerFunc.
+func (cg *ContextGroup) PUTErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("PUT", path, handler)
+}
+
+// DELETEErr is convenience method for handling DELETE requests with an ErrHandlerFunc.
+func (cg *ContextGroup) DELETEErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("DELETE", path, handler)
+}
+
+// PATCHErr is convenience method for handling PATCH requests with an ErrHandlerFunc.
+func (cg *ContextGroup) PATCHErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("PATCH", path, handler)
+}
+
+// HEADErr is convenience method for handling HEAD requests with an ErrHandlerFunc.
+func (cg *ContextGroup) HEADErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("HEAD", path, handler)
+}
+
+// OPTIONSErr is convenience method for handling OPTIONS requests with an ErrHandlerFunc.
+func (cg *ContextGroup) OPTIONSErr(path string, handler ErrHandlerFunc) {
+	cg.HandleErr("OPTIONS", path, handler)
+}
@@ -0,0 +1,64 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type statusError int
+
+func (e statusError) Error() string   { return "status error" }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestHandleErr(t *testing.T) {
+	router := NewContextMux()
+	router.GETErr("/ok", func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("ok"))
+		return nil
+	})
+	router.GETErr("/fail", func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database is down")
+	})
+	router.NewGroup("/users").POSTErr("/:id", func(w http.ResponseWriter, r *http.Request) error {
+		return statusError(http.StatusConflict)
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("GET", "/ok"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("Expected successful handler to write its response, saw %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/fail"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 from the default error handler, saw %d", w.Code)
+	}
+	if w := serve("POST", "/users/1"); w.Code != http.StatusConflict {
+		t.Errorf("Expected the error's status code from the default error handler, saw %d", w.Code)
+	}
+
+	var handled error
+	var route string
+	router.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		route = ContextRoute(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if w := serve("GET", "/fail"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected ErrorHandler to write the response, saw %d", w.Code)
+	}
+	if handled == nil || handled.Error() != "database is down" {
+		t.Errorf("Expected ErrorHandler to receive the handler's error, saw %v", handled)
+	}
+	if route != "/fail" {
+		t.Errorf("Expected ErrorHandler to have the route data in the context, saw %q", route)
+	}
+}
@@ -0,0 +1,71 @@
+package httptreemux
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ControlVariant is the variant recorded for requests which take part in an experiment but
+// are served by the route added without WithExperiment.
+const ControlVariant = "control"
+
+// Assignment is the variant of an experiment which a traffic split chose for a request.
+type Assignment struct {
+	Experiment string
+	Variant    string
+}
+
+// experiment is the traffic split given for a route with WithExperiment.
+type experiment struct {
+	Assignment
+	weight float64
+}
+
+// WithExperiment makes a route serve a random share of the requests for its path and method,
+// as the variant of an experiment. The weight is the share, between 0 and 1. The variants of
+// an experiment are added as routes for the same path and method, and the requests which none
+// of them take are served by the route added without WithExperiment, as the ControlVariant.
+// The router draws one number for each request from TreeMux.Random to choose among them.
+//
+// The chosen variant is recorded in LookupResult.Assignment and in the Assignment of the
+// request's ContextData, and reported to TreeMux.OnExperimentAssigned, so that analytics can
+// join exposure to an experiment with the outcome of the request. Each path and method may
+// take part in only one experiment. The option can be combined with match options, which
+// restrict the variant to the requests they accept. It panics if the weight is not in (0, 1].
+//
+//	router.With(httptreemux.WithExperiment("checkout", "one-page", 0.1)).GET("/checkout", onePageCheckout)
+//	router.GET("/checkout", checkout)
+func WithExperiment(name, variant string, weight float64) RouteOption {
+	if weight <= 0 || weight > 1 {
+		panic(fmt.Sprintf("httptreemux: experiment %s has invalid weight %v", name, weight))
+	}
+	description := "experiment " + name + "=" + variant + " " + strconv.FormatFloat(weight*100, 'g', -1, 64) + "%"
+	split := &experiment{Assignment: Assignment{Experiment: name, Variant: variant}, weight: weight}
+	return func(info *routeInfo) {
+		info.experiment = split
+		// The match option makes the route a variant; the split is made when selecting it.
+		matchOption(description, func(r *http.Request) bool { return true })(info)
+	}
+}
+
+// checkExperiment returns an error if a route with the experiment could not be added to the
+// variants, because the method already takes part in another experiment or the weights of
+// its variants would exceed 1.
+func checkExperiment(variants []routeVariant, split *experiment) error {
+	total := split.weight
+	for _, v := range variants {
+		if v.info.experiment == nil {
+			continue
+		}
+		if v.info.experiment.Experiment != split.Experiment {
+			return &RouteConflictError{Existing: v.info.pattern,
+				Reason: "already in experiment " + v.info.experiment.Experiment}
+		}
+		total += v.info.experiment.weight
+	}
+	if total > 1 {
+		return &RouteConflictError{Reason: "weights of experiment " + split.Experiment + " exceed 1"}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExperiments(t *testing.T) {
+	router := NewContextMux()
+	served := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			a := ContextData(r.Context()).Assignment()
+			if a == nil {
+				w.Write([]byte(name))
+				return
+			}
+			w.Write([]byte(name + " " + a.Experiment + "/" + a.Variant))
+		}
+	}
+	router.With(WithExperiment("checkout", "one-page", 0.1)).GET("/checkout", served("one-page"))
+	router.With(WithExperiment("checkout", "express", 0.2)).GET("/checkout", served("express"))
+	router.GET("/checkout", served("classic"))
+	router.With(WithExperiment("search", "new", 0.5), WithoutHooks()).GET("/search", served("new"))
+	router.GET("/plain", served("plain"))
+
+	var assigned []string
+	router.OnExperimentAssigned = func(r *http.Request, lr LookupResult) {
+		assigned = append(assigned, lr.Route+" "+lr.Assignment.Variant)
+	}
+
+	for _, test := range []struct {
+		path string
+		draw float64
+		code int
+		body string
+	}{
+		{"/checkout", 0.05, http.StatusOK, "one-page checkout/one-page"},
+		{"/checkout", 0.1, http.StatusOK, "express checkout/express"},
+		{"/checkout", 0.29, http.StatusOK, "express checkout/express"},
+		{"/checkout", 0.31, http.StatusOK, "classic checkout/control"},
+		{"/search", 0.2, http.StatusOK, "new search/new"},
+		// There is no control route, so the rest are not found.
+		{"/search", 0.7, http.StatusNotFound, ""},
+		{"/plain", 0, http.StatusOK, "plain"},
+	} {
+		random := fakeRandom{test.draw}
+		router.Random = &random
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code || (test.code == http.StatusOK && w.Body.String() != test.body) {
+			t.Errorf("%s with draw %v: expected %d %q, saw %d %q", test.path, test.draw, test.code, test.body,
+				w.Code, w.Body.String())
+		}
+		if test.path == "/plain" && len(random) != 1 {
+			t.Error("Expected no draw for a route without an experiment")
+		}
+	}
+
+	expected := []string{"/checkout one-page", "/checkout express", "/checkout express", "/checkout control",
+		"/search new"}
+	if len(assigned) != len(expected) {
+		t.Fatalf("Expected assignments %v, saw %v", expected, assigned)
+	}
+	for i := range expected {
+		if assigned[i] != expected[i] {
+			t.Errorf("Expected assignment %q, saw %q", expected[i], assigned[i])
+		}
+	}
+
+	if err := router.TryHandle("GET", "/checkout", served("other")); err == nil {
+		t.Error("Expected a conflict for a second control route")
+	}
+	if err := router.With(WithExperiment("pricing", "b", 0.1)).TryHandle("GET", "/checkout",
+		served("pricing")); err == nil {
+		t.Error("Expected a conflict for a second experiment on the same route")
+	}
+	if err := router.With(WithExperiment("checkout", "big", 0.8)).TryHandle("GET", "/checkout",
+		served("big")); err == nil {
+		t.Error("Expected a conflict for weights above 1")
+	}
+}
@@ -0,0 +1,169 @@
+package httptreemux
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Cache-Control values for use with CacheControl.
+const (
+	// CacheImmutable lets browsers and shared caches keep a file for a year without checking
+	// for a new version. Use it only for files whose names change when their contents do.
+	CacheImmutable = "public, max-age=31536000, immutable"
+	// CacheRevalidate lets a file be cached, but makes caches check for a new version before
+	// each use.
+	CacheRevalidate = "no-cache"
+)
+
+// FileOption sets headers for the files served by ServeFiles and SPAFallback. The headers are
+// only added to successful and 304 Not Modified responses, so that a missing file is never
+// cached as if it existed.
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	cacheControl func(name string) string
+	corp         string
+	origins      []string
+}
+
+// CacheControl sets the Cache-Control header of every file to the given value.
+func CacheControl(value string) FileOption {
+	return func(o *fileOptions) {
+		o.cacheControl = func(string) string { return value }
+	}
+}
+
+// FingerprintedCacheControl gives files whose names include a content hash, such as
+// "app.3f2a9c1b.js" or "logo-8d4e2f0a1b.svg", a Cache-Control header of CacheImmutable, and all
+// other files, such as index.html, a header of CacheRevalidate. A name is taken to include a
+// hash if one of the parts of its base name, separated by dots, hyphens or underscores, is a
+// hexadecimal string of at least eight digits.
+func FingerprintedCacheControl() FileOption {
+	return func(o *fileOptions) {
+		o.cacheControl = func(name string) string {
+			if isFingerprinted(name) {
+				return CacheImmutable
+			}
+			return CacheRevalidate
+		}
+	}
+}
+
+// CrossOriginResourcePolicy sets the Cross-Origin-Resource-Policy header of every file to the
+// given policy: "same-origin", "same-site" or "cross-origin". Pages which are cross-origin
+// isolated can only embed files from other origins which are served with "cross-origin".
+func CrossOriginResourcePolicy(policy string) FileOption {
+	return func(o *fileOptions) {
+		o.corp = policy
+	}
+}
+
+// AllowOrigins lets pages from the given origins, such as "https://example.com", read the files
+// with CORS requests. The Access-Control-Allow-Origin header is set to the request's Origin if it
+// is one of them, along with Vary: Origin. The origin "*" allows every origin.
+func AllowOrigins(origins ...string) FileOption {
+	return func(o *fileOptions) {
+		o.origins = append(o.origins[:len(o.origins):len(o.origins)], origins...)
+	}
+}
+
+func newFileOptions(opts []FileOption) *fileOptions {
+	if len(opts) == 0 {
+		return nil
+	}
+	o := &fileOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// writer returns w wrapped so that the headers for the named file are added when the response
+// is successful.
+func (o *fileOptions) writer(w http.ResponseWriter, r *http.Request, name string) http.ResponseWriter {
+	if o == nil {
+		return w
+	}
+
+	header := http.Header{}
+	if o.cacheControl != nil {
+		header.Set("Cache-Control", o.cacheControl(name))
+	}
+	if o.corp != "" {
+		header.Set("Cross-Origin-Resource-Policy", o.corp)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		for _, allowed := range o.origins {
+			if allowed == "*" {
+				header.Set("Access-Control-Allow-Origin", "*")
+				break
+			}
+			if allowed == origin {
+				header.Set("Access-Control-Allow-Origin", origin)
+				header.Add("Vary", "Origin")
+				break
+			}
+		}
+	} else if len(o.origins) != 0 && o.origins[0] != "*" {
+		header.Add("Vary", "Origin")
+	}
+	if len(header) == 0 {
+		return w
+	}
+	return &fileHeaderWriter{ResponseWriter: w, header: header}
+}
+
+// fileHeaderWriter adds headers to a response when its status is known to be successful.
+type fileHeaderWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *fileHeaderWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code < 400 {
+			h := w.ResponseWriter.Header()
+			for key, values := range w.header {
+				h[key] = append(h[key], values...)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *fileHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// isFingerprinted returns true if the base name of a file includes a content hash.
+func isFingerprinted(name string) bool {
+	base := path.Base(name)
+	if dot := strings.LastIndexByte(base, '.'); dot > 0 {
+		base = base[:dot]
+	}
+	parts := strings.FieldsFunc(base, func(c rune) bool {
+		return c == '.' || c == '-' || c == '_'
+	})
+	for _, part := range parts {
+		if len(part) >= 8 && isHex(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package httptreemux
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ServeFiles serves files from the given file system. The path must end with a catch-all
+// parameter, which gives the name of the file to serve, such as "/static/*filepath". The files
+// are served with http.FileServer, so a request for a directory lists its contents unless it
+// has an index.html file. To serve files from an fs.FS, pass http.FS(fsys). The options set
+// the caching and cross-origin headers of the files which are found.
+//
+//	router.ServeFiles("/static/*filepath", http.Dir("/var/www/static"),
+//	    httptreemux.FingerprintedCacheControl(),
+//	    httptreemux.CrossOriginResourcePolicy("cross-origin"))
+func (g *Group) ServeFiles(path string, root http.FileSystem, opts ...FileOption) {
+	translated, err := g.mux.translatePattern(path)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	slash := strings.LastIndex(translated, "/")
+	if slash == -1 || len(translated) < slash+3 || translated[slash+1] != '*' {
+		panic("path must end with a catch-all parameter such as /*filepath in path '" + path + "'")
+	}
+	name := translated[slash+2:]
+
+	options := newFileOptions(opts)
+	fileServer := http.FileServer(root)
+	g.GET(path, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		fileServer.ServeHTTP(options.writer(w, r, params[name]), withURLPath(r, "/"+params[name]))
+	})
+}
+
+// SPAFallback serves a single-page application under path. Requests for files that exist in
+// root are served as they are, and all other requests under path are answered with the index
+// file, so that the application can handle its own routes on the client. The fallback is
+// registered as a catch-all, so any other route under path takes precedence over it. A GET
+// route for path itself which is added before SPAFallback is kept as well. The options are
+// applied as for ServeFiles, with the index file given the headers for its own name.
+//
+//	router.GET("/api/*rest", api)
+//	router.SPAFallback("/", http.Dir("dist"), "index.html")
+func (g *Group) SPAFallback(path string, root http.FileSystem, index string, opts ...FileOption) {
+	index = "/" + strings.TrimLeft(index, "/")
+	options := newFileOptions(opts)
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if serveFile(options.writer(w, r, params["filepath"]), r, root, params["filepath"]) {
+			return
+		}
+		if !serveFile(options.writer(w, r, index), r, root, index) {
+			http.NotFound(w, r)
+		}
+	}
+
+	path = strings.TrimRight(path, "/")
+	g.GET(path+"/*filepath", handler)
+	// The catch-all doesn't match the path itself. Keep a route which already serves it.
+	if err := g.TryHandle("GET", path+"/", handler); err != nil {
+		if _, ok := err.(*RouteConflictError); !ok {
+			panic(err.Error())
+		}
+	}
+}
+
+// serveFile serves the named file from root, and returns false without writing anything if it
+// does not exist or is a directory.
+func serveFile(w http.ResponseWriter, r *http.Request, root http.FileSystem, name string) bool {
+	// Cleaning the rooted path removes any .. elements, so the name can't escape the root.
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return false
+	}
+
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return true
+}
+
+// withURLPath returns a copy of the request with the given URL path.
+func withURLPath(r *http.Request, urlPath string) *http.Request {
+	copied := new(http.Request)
+	*copied = *r
+
+	u := *r.URL
+	u.Path = urlPath
+	u.RawPath = ""
+	copied.URL = &u
+	return copied
+}
@@ -0,0 +1,174 @@
+package httptreemux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "httptreemux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServeFiles(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"css/site.css": "body{}"})
+	defer os.RemoveAll(dir)
+
+	router := New()
+	router.ServeFiles("/static/*filepath", http.Dir(dir))
+
+	for _, test := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/static/css/site.css", http.StatusOK, "body{}"},
+		{"/static/missing.css", http.StatusNotFound, ""},
+		{"/static/../files_test.go", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: expected body %q, saw %q", test.path, test.body, w.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/static", "/static/:name", "/static/*"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic for a path without a catch-all", path)
+				}
+			}()
+			New().ServeFiles(path, http.Dir(dir))
+		}()
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html":    "index",
+		"assets/app.js": "app",
+	})
+	defer os.RemoveAll(dir)
+
+	router := New()
+	router.GET("/api/*rest", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("api"))
+	})
+	router.SPAFallback("/", http.Dir(dir), "index.html")
+
+	for _, test := range []struct {
+		path, body string
+	}{
+		{"/", "index"},
+		{"/assets/app.js", "app"},
+		{"/settings/profile", "index"},
+		{"/assets", "index"},
+		{"/../files_test.go", "index"},
+		{"/api/users", "api"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != test.body {
+			t.Errorf("%s: expected 200 %q, saw %d %q", test.path, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	// A route for the path itself is kept.
+	router = New()
+	router.GET("/app/", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("home"))
+	})
+	router.SPAFallback("/app", http.Dir(dir), "/index.html")
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/app/", nil)
+	router.ServeHTTP(w, r)
+	if w.Body.String() != "home" {
+		t.Errorf("Expected the existing route to serve /app/, saw %q", w.Body.String())
+	}
+}
+
+func TestFileOptions(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html":             "index",
+		"assets/app.3f2a9c1b.js": "app",
+		"assets/logo.svg":        "logo",
+	})
+	defer os.RemoveAll(dir)
+
+	router := New()
+	router.ServeFiles("/static/*filepath", http.Dir(dir),
+		FingerprintedCacheControl(),
+		CrossOriginResourcePolicy("cross-origin"),
+		AllowOrigins("https://example.com"))
+	router.SPAFallback("/app", http.Dir(dir), "index.html", CacheControl(CacheRevalidate))
+
+	for _, test := range []struct {
+		path, origin                          string
+		code                                  int
+		cacheControl, corp, allowOrigin, vary string
+	}{
+		{"/static/assets/app.3f2a9c1b.js", "", http.StatusOK, CacheImmutable, "cross-origin", "", "Origin"},
+		{"/static/assets/logo.svg", "https://example.com", http.StatusOK, CacheRevalidate, "cross-origin", "https://example.com", "Origin"},
+		{"/static/assets/logo.svg", "https://other.example", http.StatusOK, CacheRevalidate, "cross-origin", "", ""},
+		{"/static/assets/missing.js", "", http.StatusNotFound, "", "", "", ""},
+		{"/app/assets/app.3f2a9c1b.js", "", http.StatusOK, CacheRevalidate, "", "", ""},
+		{"/app/settings", "", http.StatusOK, CacheRevalidate, "", "", ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		for _, h := range []struct{ name, expected string }{
+			{"Cache-Control", test.cacheControl},
+			{"Cross-Origin-Resource-Policy", test.corp},
+			{"Access-Control-Allow-Origin", test.allowOrigin},
+			{"Vary", test.vary},
+		} {
+			if value := w.Header().Get(h.name); value != h.expected {
+				t.Errorf("%s %s: expected %s %q, saw %q", test.path, test.origin, h.name, h.expected, value)
+			}
+		}
+	}
+
+	for name, expected := range map[string]bool{
+		"/app.3f2a9c1b.js":     true,
+		"/logo-8D4E2F0A1B.svg": true,
+		"/chunk_0123abcd.css":  true,
+		"/index.html":          false,
+		"/facade.js":           false,
+		"/v1.2.3.js":           false,
+		"/3f2a9c1b":            true,
+	} {
+		if isFingerprinted(name) != expected {
+			t.Errorf("isFingerprinted(%q): expected %v", name, expected)
+		}
+	}
+}
@@ -1,25 +1,52 @@
 package httptreemux
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
 )
 
 type MiddlewareFunc func(next HandlerFunc) HandlerFunc
 
-func handlerWithMiddlewares(handler HandlerFunc, stack []MiddlewareFunc) HandlerFunc {
+// MiddlewareWithRouteFunc is a variant of MiddlewareFunc which also receives a LookupResult
+// describing the route that it is being applied to. It is called once for each route when the
+// route is registered, so it can inspect the route and decide whether to wrap the handler
+// at all, returning next unchanged if not. Since no request has been matched yet, the Params
+// member of the LookupResult is nil; the parameters of each request are passed to the
+// returned handler as usual.
+type MiddlewareWithRouteFunc func(next HandlerFunc, route LookupResult) HandlerFunc
+
+// middleware is a single entry in a Group's middleware stack. Exactly one of the
+// functions is set.
+type middleware struct {
+	fn        MiddlewareFunc
+	withRoute MiddlewareWithRouteFunc
+}
+
+func handlerWithMiddlewares(handler HandlerFunc, stack []middleware, route LookupResult) HandlerFunc {
 	for i := len(stack) - 1; i >= 0; i-- {
-		handler = stack[i](handler)
+		if stack[i].withRoute != nil {
+			handler = stack[i].withRoute(handler, route)
+		} else {
+			handler = stack[i].fn(handler)
+		}
 	}
 	return handler
 }
 
 type Group struct {
-	path  string
-	mux   *TreeMux
-	stack []MiddlewareFunc
+	path string
+	mux  *TreeMux
+	// The host tree to which routes are added, or nil for the default tree.
+	host  *hostTree
+	stack []middleware
+	// Options applied to each route registered on the group.
+	options []RouteOption
 }
 
 // Add a sub-group to this group
@@ -29,21 +56,41 @@ func (g *Group) NewGroup(path string) *Group {
 	}
 
 	checkPath(path)
+	path, err := g.mux.translatePattern(path)
+	if err != nil {
+		panic(err.Error())
+	}
 	path = g.path + path
 	//Don't want trailing slash as all sub-paths start with slash
 	if path[len(path)-1] == '/' {
 		path = path[:len(path)-1]
 	}
 	return &Group{
-		path:  path,
-		mux:   g.mux,
-		stack: g.stack[:len(g.stack):len(g.stack)],
+		path:    path,
+		mux:     g.mux,
+		host:    g.host,
+		stack:   g.stack[:len(g.stack):len(g.stack)],
+		options: g.options[:len(g.options):len(g.options)],
 	}
 }
 
 // Use appends a middleware handler to the Group middleware stack.
 func (g *Group) Use(fn MiddlewareFunc) {
-	g.stack = append(g.stack, fn)
+	g.stack = append(g.stack, middleware{fn: fn})
+}
+
+// UseWithRoute appends a route-aware middleware handler to the Group middleware stack.
+// It runs in the same order as middleware added with Use, but is given the details of
+// each route as it is registered. For example, to skip authentication on public routes:
+//
+//	router.UseWithRoute(func(next httptreemux.HandlerFunc, route httptreemux.LookupResult) httptreemux.HandlerFunc {
+//	    if strings.HasPrefix(route.Route, "/public/") {
+//	        return next
+//	    }
+//	    return requireAuth(next)
+//	})
+func (g *Group) UseWithRoute(fn MiddlewareWithRouteFunc) {
+	g.stack = append(g.stack, middleware{withRoute: fn})
 }
 
 type handlerWithParams struct {
@@ -57,7 +104,11 @@ func (h handlerWithParams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // UseHandler is like Use but accepts http.Handler middleware.
 func (g *Group) UseHandler(middleware func(http.Handler) http.Handler) {
-	g.stack = append(g.stack, func(next HandlerFunc) HandlerFunc {
+	g.Use(handlerMiddleware(middleware))
+}
+
+func handlerMiddleware(middleware func(http.Handler) http.Handler) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 			nextHandler := handlerWithParams{
 				handler: next,
@@ -65,16 +116,41 @@ func (g *Group) UseHandler(middleware func(http.Handler) http.Handler) {
 			}
 			middleware(nextHandler).ServeHTTP(w, r)
 		}
-	})
+	}
+}
+
+// UseAlways is like Use, but the middleware also wraps the responses that the router generates
+// itself for requests under the group's path: redirects, and the responses of NotFoundHandler,
+// MethodNotAllowedHandler and PanicHandler. This is useful for middleware such as CORS or
+// security headers, which should apply to error responses as well.
+//
+// For router-generated responses, the middleware added with UseAlways by the group and all
+// groups whose path contains it are applied, starting with the shortest path, and the params
+// map is nil. When a handler panics, the middleware runs again around PanicHandler.
+func (g *Group) UseAlways(fn MiddlewareFunc) {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	g.Use(fn)
+	scope := g.errorScope()
+	scope.middleware = append(scope.middleware, fn)
+}
+
+// UseHandlerAlways is like UseAlways but accepts http.Handler middleware.
+func (g *Group) UseHandlerAlways(middleware func(http.Handler) http.Handler) {
+	g.UseAlways(handlerMiddleware(middleware))
 }
 
 // Path elements starting with : indicate a wildcard in the path. A wildcard will only match on a
 // single path segment. That is, the pattern `/post/:postid` will match on `/post/1` or `/post/1/`,
-// but not `/post/1/2`.
+// but not `/post/1/2`. Wildcards at the end of a pattern can be made optional with a ?, so that
+// `/articles/:year/:month?` matches both `/articles/2024` and `/articles/2024/06`.
 //
 // A path element starting with * is a catch-all, whose value will be a string containing all text
 // in the URL matched by the wildcards. For example, with a pattern of `/images/*path` and a
-// requested URL `images/abc/def`, path would contain `abc/def`.
+// requested URL `images/abc/def`, path would contain `abc/def`. A catch-all may be followed by
+// more path segments, as in `/files/*path/meta`, in which case it matches as many segments as
+// it can while the rest of the pattern still matches.
 //
 // # Routing Rule Priority
 //
@@ -84,7 +160,7 @@ func (g *Group) UseHandler(middleware func(http.Handler) http.Handler) {
 //
 // 2. Wildcards take second priority. For a particular wildcard to match, that wildcard and its subtree must match the URL.
 //
-// 3. Finally, a catch-all rule will match when the earlier path segments have matched, and none of the static or wildcard conditions have matched. Catch-all rules must be at the end of a pattern.
+// 3. Finally, a catch-all rule will match when the earlier path segments have matched, and none of the static or wildcard conditions have matched. Routes which continue after the catch-all are tried before a route that ends with it.
 //
 // So with the following patterns, we'll see certain matches:
 //
@@ -132,38 +208,170 @@ func (g *Group) UseHandler(middleware func(http.Handler) http.Handler) {
 //	GET /posts/ will match normally.
 //	POST /posts will redirect to /posts/, because the GET method used a trailing slash.
 func (g *Group) Handle(method string, path string, handler HandlerFunc) {
+	if err := g.TryHandle(method, path, handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryHandle is like Handle, but returns an error instead of panicking if the route can not be
+// added. This is useful when routes come from plugins or configuration files. If the route
+// conflicts with one that is already registered, the error is a *RouteConflictError. When an
+// error is returned, the router is left as it was before the call.
+func (g *Group) TryHandle(method string, path string, handler HandlerFunc) error {
 	g.mux.mutex.Lock()
 	defer g.mux.mutex.Unlock()
 
-	if len(g.stack) > 0 {
-		handler = handlerWithMiddlewares(handler, g.stack)
+	path, err := g.mux.translatePattern(path)
+	if err != nil {
+		return err
 	}
 
-	g.addFullStackHandler(method, path, handler)
+	info := g.newRouteInfo(path)
+	info.handler = handler
+	info.wrap = g.middlewareWrapper(info)
+
+	return g.addFullStackHandler(method, path, info.wrap(handler), info)
 }
 
-func (g *Group) addFullStackHandler(method string, path string, handler HandlerFunc) {
-	addSlash := false
-	addOne := func(thePath string) {
-		if g.mux.CaseInsensitive {
-			thePath = strings.ToLower(thePath)
+// RouteConflictError is returned by TryHandle when a route can not be added because it
+// conflicts with a route that is already registered.
+type RouteConflictError struct {
+	// Method and Path are the method and full pattern of the route being added.
+	Method string
+	Path   string
+	// Existing is the pattern of the previously registered route, if known.
+	Existing string
+	// Reason describes the conflict.
+	Reason string
+}
+
+func (e *RouteConflictError) Error() string {
+	msg := ""
+	if e.Path != "" {
+		msg = e.Method + " " + e.Path + " "
+	}
+	if e.Existing != "" {
+		msg += "conflicts with " + e.Existing + ": "
+	} else if msg != "" {
+		msg += "conflicts with an existing route: "
+	}
+	return msg + e.Reason
+}
+
+func (g *Group) addFullStackHandler(method string, path string, handler HandlerFunc, info *routeInfo) error {
+	method, err := g.mux.routeMethod(method)
+	if err != nil {
+		return err
+	}
+	pattern := info.pattern
+	escape := g.mux.EscapeAddedRoutes
+	if info.escapePattern != nil {
+		escape = *info.escapePattern
+	}
+	paths, addSlash, err := g.treePaths(path, escape)
+	if err != nil {
+		return err
+	}
+	slashes := make([]bool, len(paths))
+	for i := range slashes {
+		slashes[i] = addSlash
+	}
+
+	for i, alias := range info.aliases {
+		alias, err := g.mux.translatePattern(alias)
+		if err != nil {
+			return err
+		}
+		aliasPaths, aliasSlash, err := g.treePaths(alias, escape)
+		if err != nil {
+			return err
+		}
+		for range aliasPaths {
+			slashes = append(slashes, aliasSlash)
 		}
+		paths = append(paths, aliasPaths...)
+		info.aliases[i] = g.path + alias
+	}
+
+	if info.skip {
+		g.mux.skippedRoutes = append(g.mux.skippedRoutes, Route{
+			Method:     method,
+			Pattern:    pattern,
+			Host:       g.hostPattern(),
+			Metadata:   info.metadata,
+			Aliases:    info.aliases,
+			Predicates: info.predicateDescriptions(),
+		})
+		return nil
+	}
 
-		node := g.mux.root.addPath(thePath[1:], nil, false)
-		if addSlash {
+	var changes *pathtree.Changes
+	var start time.Time
+	if g.mux.OnRouteRegistered != nil {
+		changes = &pathtree.Changes{}
+		start = g.mux.now()
+	}
+
+	nodes := make([]*node, 0, len(paths))
+	for _, thePath := range paths {
+		node, err := addPath(g.tree(), thePath[1:], changes)
+		if err == nil {
+			err = node.addRoute(method, handler, info)
+		}
+
+		if err != nil {
+			// Undo the part of the registration that succeeded, and remove the nodes that
+			// it added, so that they do not conflict with later routes.
+			for _, added := range nodes {
+				added.removeRoute(method, info, g.mux.HeadCanUseGet)
+			}
+			g.tree().Prune()
+
+			if conflict, ok := err.(*RouteConflictError); ok {
+				conflict.Method = method
+				conflict.Path = pattern
+			}
+			return err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	for i, node := range nodes {
+		if slashes[i] {
 			node.addSlash = true
 		}
-		node.setHandler(method, handler, false)
 
 		if g.mux.HeadCanUseGet && method == "GET" && node.leafHandler["HEAD"] == nil {
 			node.setHandler("HEAD", handler, true)
+			node.setRouteInfo("HEAD", info)
 		}
 	}
 
-	checkPath(path)
+	if changes != nil {
+		g.mux.OnRouteRegistered(RegistrationStats{
+			Method:     method,
+			Pattern:    pattern,
+			NodesAdded: changes.Nodes,
+			Splits:     changes.Splits,
+			Reorders:   changes.Reorders,
+			Duration:   g.mux.now().Sub(start),
+		})
+	}
+	return nil
+}
+
+// treePaths returns the paths under which a pattern registered on the group is stored
+// in the tree, and whether a trailing slash was removed from the pattern to get them. If
+// escape is true, the escaped version of the pattern is included when it differs.
+func (g *Group) treePaths(path string, escape bool) (paths []string, addSlash bool, err error) {
+	if err := validatePath(path); err != nil {
+		return nil, false, err
+	}
+
 	path = g.path + path
 	if len(path) == 0 {
-		panic("Cannot map an empty path")
+		return nil, false, errors.New("Cannot map an empty path")
 	}
 
 	if len(path) > 1 && path[len(path)-1] == '/' && g.mux.RedirectTrailingSlash {
@@ -171,20 +379,159 @@ func (g *Group) addFullStackHandler(method string, path string, handler HandlerF
 		path = path[:len(path)-1]
 	}
 
-	if g.mux.EscapeAddedRoutes {
-		u, err := url.ParseRequestURI(path)
-		if err != nil {
-			panic("URL parsing error " + err.Error() + " on url " + path)
+	expansions, err := optionalExpansions(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, path := range expansions {
+		var expansionPaths []string
+		if escape {
+			u, err := url.ParseRequestURI(path)
+			if err != nil {
+				return nil, false, errors.New("URL parsing error " + err.Error() + " on url " + path)
+			}
+			escapedPath := unescapeSpecial(u.String())
+
+			if escapedPath != path {
+				expansionPaths = append(expansionPaths, escapedPath)
+			}
 		}
-		escapedPath := unescapeSpecial(u.String())
 
-		if escapedPath != path {
-			addOne(escapedPath)
+		expansionPaths = append(expansionPaths, path)
+
+		if g.mux.CaseInsensitive {
+			for i := range expansionPaths {
+				expansionPaths[i] = strings.ToLower(expansionPaths[i])
+			}
+			if len(expansionPaths) == 2 && expansionPaths[0] == expansionPaths[1] {
+				expansionPaths = expansionPaths[1:]
+			}
 		}
+
+		paths = append(paths, expansionPaths...)
 	}
 
-	addOne(path)
+	return paths, addSlash, nil
+}
 
+// isOptionalSegment returns whether a pattern segment is an optional parameter.
+func isOptionalSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == ':' && segment[len(segment)-1] == '?'
+}
+
+// optionalExpansions returns the patterns that a pattern with optional trailing parameters,
+// marked by a ? after their names as in /articles/:year/:month?/:day?, stands for. The
+// pattern is returned as is if it has no optional parameters.
+func optionalExpansions(path string) ([]string, error) {
+	if !strings.Contains(path, "?") {
+		return []string{path}, nil
+	}
+
+	segments := strings.Split(path, "/")
+	first := -1
+	for i, segment := range segments {
+		optional := isOptionalSegment(segment)
+		if optional && first == -1 {
+			first = i
+		} else if !optional && first != -1 {
+			return nil, fmt.Errorf("Optional parameter %s in %s must only be followed by other optional parameters",
+				segments[first], path)
+		}
+		if optional {
+			segments[i] = segment[:len(segment)-1]
+		}
+	}
+
+	if first == -1 {
+		return []string{path}, nil
+	}
+
+	expansions := make([]string, 0, len(segments)-first+1)
+	for end := first; end <= len(segments); end++ {
+		expansion := strings.Join(segments[:end], "/")
+		if expansion == "" {
+			expansion = "/"
+		}
+		expansions = append(expansions, expansion)
+	}
+	return expansions, nil
+}
+
+// Remove deletes the handler for a method from a route, so that it is no longer matched.
+// The path must be the same as the one passed when the route was added to this group;
+// Remove returns false if there is no route registered with that exact pattern and method.
+// Removing a GET handler also removes the HEAD handler that was added for it when
+// HeadCanUseGet is set.
+//
+// Remove can be called while the router is serving requests, as long as
+// TreeMux.SafeAddRoutesWhileRunning is true. Like Handle, it takes the write lock on the
+// router, so requests are matched either entirely before or entirely after the removal.
+func (g *Group) Remove(method, path string) bool {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	path, err := g.mux.translatePattern(path)
+	if err != nil {
+		return false
+	}
+	if method, err = g.mux.routeMethod(method); err != nil {
+		return false
+	}
+
+	pattern := g.path + path
+	// The route may have been added with or without its escaped version, depending on
+	// WithPatternEscaping, so look for both. Only the route's own handlers are removed.
+	paths, _, err := g.treePaths(path, true)
+	if err != nil {
+		return false
+	}
+	for _, thePath := range paths {
+		node := findNode(g.tree(), thePath[1:])
+		if node == nil {
+			continue
+		}
+		if info := node.leafRoute[method]; info != nil && info.pattern == pattern {
+			for _, alias := range info.aliases {
+				aliasPaths, _, err := g.treePaths(strings.TrimPrefix(alias, g.path), true)
+				if err == nil {
+					paths = append(paths, aliasPaths...)
+				}
+			}
+			break
+		}
+	}
+
+	removed := false
+	for _, thePath := range paths {
+		node := findNode(g.tree(), thePath[1:])
+		if node == nil || !node.removeHandler(method, pattern, g.mux.HeadCanUseGet) {
+			continue
+		}
+		removed = true
+
+		if node.Kind() == pathtree.CatchAll {
+			// Detach the empty catch-all so that a catch-all with a different
+			// name can be added in its place.
+			catchAllStart := strings.LastIndex(thePath, "/*")
+			if parent := g.tree().Find(thePath[1 : catchAllStart+1]); parent != nil {
+				parent.RemoveCatchAll()
+			}
+		}
+	}
+
+	return removed
+}
+
+// MethodAny can be passed as the method when adding a route, to add a handler which serves
+// requests for any method that the route has no other handler for, including methods
+// which are not in the standard set.
+const MethodAny = "*"
+
+// Any adds a handler which serves every method of requests to the path, except those
+// with their own handlers. It is the same as Handle(MethodAny, path, handler).
+func (g *Group) Any(path string, handler HandlerFunc) {
+	g.Handle(MethodAny, path, handler)
 }
 
 // Syntactic sugar for Handle("GET", path, handler)
@@ -223,10 +570,17 @@ func (g *Group) OPTIONS(path string, handler HandlerFunc) {
 }
 
 func checkPath(path string) {
+	if err := validatePath(path); err != nil {
+		panic(err.Error())
+	}
+}
+
+func validatePath(path string) error {
 	// All non-empty paths must start with a slash
 	if len(path) > 0 && path[0] != '/' {
-		panic(fmt.Sprintf("Path %s must start with slash", path))
+		return fmt.Errorf("Path %s must start with slash", path)
 	}
+	return nil
 }
 
 func unescapeSpecial(s string) string {
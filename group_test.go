@@ -3,6 +3,8 @@ package httptreemux
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -180,3 +182,410 @@ func TestSetGetAfterHead(t *testing.T) {
 	testMethod("HEAD", "HEAD")
 	testMethod("GET", "GET")
 }
+
+func TestUseWithRoute(t *testing.T) {
+	var execLog []string
+	var seenRoutes []string
+
+	router := New()
+	router.Use(func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			execLog = append(execLog, "plain")
+			next(w, r, params)
+		}
+	})
+	router.UseWithRoute(func(next HandlerFunc, route LookupResult) HandlerFunc {
+		seenRoutes = append(seenRoutes, route.Route)
+		if route.Params != nil {
+			t.Errorf("Route %s: expected nil params at registration", route.Route)
+		}
+		if strings.HasPrefix(route.Route, "/public/") {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			execLog = append(execLog, "auth")
+			next(w, r, params)
+		}
+	})
+
+	router.GET("/public/:page", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		execLog = append(execLog, "public")
+	})
+	router.NewGroup("/private").GET("/:page", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		execLog = append(execLog, "private "+params["page"])
+	})
+
+	expectedRoutes := []string{"/public/:page", "/private/:page"}
+	if !reflect.DeepEqual(seenRoutes, expectedRoutes) {
+		t.Errorf("Expected middleware to see routes %v, saw %v", expectedRoutes, seenRoutes)
+	}
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/public/abc", []string{"plain", "public"}},
+		{"/private/abc", []string{"plain", "auth", "private abc"}},
+	} {
+		execLog = nil
+		r, _ := http.NewRequest("GET", test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(execLog, test.expected) {
+			t.Errorf("%s: expected execution %v, saw %v", test.path, test.expected, execLog)
+		}
+	}
+}
+
+func TestTryHandle(t *testing.T) {
+	router := New()
+	api := router.NewGroup("/api")
+	if err := api.TryHandle("GET", "/users/:name", simpleHandler); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	router.GET("/files/*path", simpleHandler)
+
+	for _, test := range []struct {
+		method, path, existing string
+	}{
+		{"GET", "/api/users/:name", "/api/users/:name"},
+		{"POST", "/api/users/:id", "/api/users/:name"},
+		{"GET", "/files/*rest", "/files/*path"},
+	} {
+		err := router.TryHandle(test.method, test.path, simpleHandler)
+		conflict, ok := err.(*RouteConflictError)
+		if !ok {
+			t.Errorf("%s %s: expected a RouteConflictError, saw %v", test.method, test.path, err)
+			continue
+		}
+		if conflict.Method != test.method || conflict.Path != test.path || conflict.Existing != test.existing {
+			t.Errorf("%s %s: unexpected conflict %+v", test.method, test.path, conflict)
+		}
+		if !strings.Contains(err.Error(), test.existing) {
+			t.Errorf("%s %s: error %q does not mention %s", test.method, test.path, err, test.existing)
+		}
+	}
+
+	for _, path := range []string{"", "bad"} {
+		if err := router.TryHandle("GET", path, simpleHandler); err == nil {
+			t.Errorf("Expected an error for invalid path %q", path)
+		}
+	}
+
+	// More path, including a trailing slash alone, may follow a catch-all.
+	for _, path := range []string{"/abc/*path/def", "/abc/*path/"} {
+		if err := router.TryHandle("GET", path, simpleHandler); err != nil {
+			t.Errorf("Unexpected error for path %q: %v", path, err)
+		}
+	}
+
+	// A failed registration leaves the router unchanged. With EscapeAddedRoutes, the
+	// escaped version of the path is added successfully before the conflict is found.
+	router.POST("/a b/c", simpleHandler)
+	router.EscapeAddedRoutes = true
+	if err := router.TryHandle("POST", "/a b/c", simpleHandler); err == nil {
+		t.Fatal("Expected a conflict for POST /a b/c")
+	}
+	router.RedirectCleanPath = false
+	r, _ := newRequest("POST", "/a%20b/c", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the escaped path to be rolled back, saw %d", w.Code)
+	}
+
+	cg := router.UsingContext()
+	if err := cg.TryHandle("GET", "/api/users/:name", func(w http.ResponseWriter, r *http.Request) {}); err == nil {
+		t.Error("Expected ContextGroup.TryHandle to return a conflict")
+	}
+}
+
+func TestWithMetadata(t *testing.T) {
+	type permission string
+	var middlewareSaw []interface{}
+
+	router := New()
+	router.UseWithRoute(func(next HandlerFunc, route LookupResult) HandlerFunc {
+		middlewareSaw = append(middlewareSaw, route.Metadata)
+		return next
+	})
+
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {}
+	router.GET("/public", handler)
+	admin := router.NewGroup("/admin").With(WithMetadata(permission("admin")))
+	admin.GET("/users/:id", handler)
+	admin.NewGroup("/audit").With(WithMetadata(permission("auditor"))).GET("/log", handler)
+	// With must not change the group it was called on.
+	router.GET("/other", handler)
+
+	expected := []interface{}{nil, permission("admin"), permission("auditor"), nil}
+	if !reflect.DeepEqual(middlewareSaw, expected) {
+		t.Errorf("Expected middleware to see metadata %v, saw %v", expected, middlewareSaw)
+	}
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		expected interface{}
+	}{
+		{"GET", "/public", nil},
+		{"GET", "/admin/users/1", permission("admin")},
+		{"HEAD", "/admin/users/1", permission("admin")},
+		{"GET", "/admin/audit/log", permission("auditor")},
+	} {
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		result, found := router.Lookup(nil, r)
+		if !found {
+			t.Errorf("%s %s: expected to find a route", test.method, test.path)
+			continue
+		}
+		if result.Metadata != test.expected {
+			t.Errorf("%s %s: expected metadata %v, saw %v", test.method, test.path, test.expected, result.Metadata)
+		}
+	}
+}
+
+func TestUseAlways(t *testing.T) {
+	headerMiddleware := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	router := New()
+	router.PanicHandler = SimplePanicHandler
+	router.UseHandlerAlways(headerMiddleware("root"))
+	router.UseHandler(headerMiddleware("plain"))
+	api := router.NewGroup("/api")
+	api.UseHandlerAlways(headerMiddleware("api"))
+	api.GET("/users", simpleHandler)
+	api.GET("/dir/", simpleHandler)
+	api.GET("/panic", panicHandler)
+	router.GET("/other", simpleHandler)
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		code     int
+		expected []string
+	}{
+		{"GET", "/api/users", http.StatusOK, []string{"root", "plain", "api"}},
+		{"GET", "/api/missing", http.StatusNotFound, []string{"root", "api"}},
+		{"POST", "/api/users", http.StatusMethodNotAllowed, []string{"root", "api"}},
+		{"GET", "/api/dir", http.StatusMovedPermanently, []string{"root", "api"}},
+		{"GET", "/api/panic", http.StatusInternalServerError, []string{"root", "plain", "api", "root", "api"}},
+		{"GET", "/other", http.StatusOK, []string{"root", "plain"}},
+		{"GET", "/apiv2", http.StatusNotFound, []string{"root"}},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+		}
+		if seen := w.Header()["X-Middleware"]; !reflect.DeepEqual(seen, test.expected) {
+			t.Errorf("%s %s: expected middleware %v, saw %v", test.method, test.path, test.expected, seen)
+		}
+	}
+}
+
+func TestGroupErrorHandlers(t *testing.T) {
+	handler := func(name string) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	methodHandler := func(name string) func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+		return func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+			w.Header().Set("X-Handler", name)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+
+	router := NewContextMux()
+	router.NotFoundHandler = handler("global")
+	router.MethodNotAllowedHandler = methodHandler("global")
+
+	api := router.NewGroup("/api")
+	api.SetNotFoundHandler(handler("api"))
+	api.SetMethodNotAllowedHandler(methodHandler("api"))
+	api.GET("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	// Only overrides NotFound, so MethodNotAllowed comes from /api.
+	v2 := api.NewGroup("/v2")
+	v2.SetNotFoundHandler(handler("v2"))
+	v2.GET("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	removed := router.NewGroup("/removed")
+	removed.SetNotFoundHandler(handler("removed"))
+	removed.SetNotFoundHandler(nil)
+
+	router.GET("/page", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{"GET", "/missing", "global"},
+		{"POST", "/page", "global"},
+		{"GET", "/api/missing", "api"},
+		{"POST", "/api/users", "api"},
+		{"GET", "/api/v2/missing", "v2"},
+		{"POST", "/api/v2/users", "api"},
+		{"GET", "/apiv2", "global"},
+		{"GET", "/removed/missing", "global"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if h := w.Header().Get("X-Handler"); h != test.expected {
+			t.Errorf("%s %s: expected handler %s, saw %s", test.method, test.path, test.expected, h)
+		}
+	}
+}
+
+func TestGroupErrorHandlersWithWildcards(t *testing.T) {
+	handler := func(name string) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	router := New()
+	router.NotFoundHandler = handler("global")
+	router.MethodNotAllowedHandler = func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+		w.Header().Set("X-Handler", "global")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	users := router.NewGroup("/users")
+	users.SetNotFoundHandler(handler("users"))
+	user := users.NewGroup("/:id")
+	user.SetNotFoundHandler(handler("user"))
+	user.SetMethodNotAllowedHandler(func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+		w.Header().Set("X-Handler", "user")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	user.GET("/posts", simpleHandler)
+	me := users.NewGroup("/me")
+	me.SetNotFoundHandler(handler("me"))
+	files := router.NewGroup("/files/*path/raw")
+	files.SetNotFoundHandler(handler("files"))
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{"GET", "/users/1/missing", "user"},
+		{"GET", "/users/1", "user"},
+		{"POST", "/users/1/posts", "user"},
+		{"GET", "/users/me/missing", "me"},
+		{"GET", "/users//missing", "users"},
+		{"GET", "/users", "users"},
+		{"GET", "/files/a/b/raw/missing", "files"},
+		{"GET", "/files/a/b/missing", "global"},
+		{"GET", "/usersx/1/missing", "global"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if h := w.Header().Get("X-Handler"); h != test.expected {
+			t.Errorf("%s %s: expected handler %s, saw %s", test.method, test.path, test.expected, h)
+		}
+	}
+}
+
+func TestGroupPanicHandler(t *testing.T) {
+	namedPanicHandler := func(name string) PanicHandler {
+		return func(w http.ResponseWriter, r *http.Request, err interface{}) {
+			w.Header().Set("X-Handler", name)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+
+	router := New()
+	plugins := router.NewGroup("/plugins")
+	plugins.SetPanicHandler(namedPanicHandler("plugins"))
+	plugins.GET("/crash", panicHandler)
+	plugins.With(WithPanicHandler(func(w http.ResponseWriter, r *http.Request, lr LookupResult, err interface{}) {
+		w.Header().Set("X-Handler", "route")
+		w.WriteHeader(http.StatusInternalServerError)
+	})).GET("/own", panicHandler)
+	router.GET("/core", panicHandler)
+
+	// Without a global handler, panics outside the group are not recovered.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the panic in /core to propagate")
+			}
+		}()
+		r, _ := newRequest("GET", "/core", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+
+	router.PanicHandler = namedPanicHandler("global")
+	for path, expected := range map[string]string{
+		"/plugins/crash": "plugins",
+		"/plugins/own":   "route",
+		"/core":          "global",
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		if h := w.Header().Get("X-Handler"); h != expected || w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected handler %s, saw %s with code %d", path, expected, h, w.Code)
+		}
+	}
+}
+
+func TestWhen(t *testing.T) {
+	router := New()
+	router.When(true).GET("/enabled", simpleHandler)
+	disabled := router.NewGroup("/debug").When(false)
+	disabled.GET("/vars", simpleHandler)
+	disabled.NewGroup("/pprof").With(WithMetadata("profiling")).GET("/*path", simpleHandler)
+	router.Host("admin.example.com").When(false).POST("/reset", simpleHandler)
+	router.DevOnly().GET("/dev", simpleHandler)
+
+	if err := disabled.TryHandle("GET", "missing-slash", simpleHandler); err == nil {
+		t.Error("Expected invalid patterns to be rejected on skipped groups")
+	}
+
+	for path, expected := range map[string]int{
+		"/enabled":         http.StatusOK,
+		"/debug/vars":      http.StatusNotFound,
+		"/debug/pprof/cpu": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Errorf("%s: expected code %d, saw %d", path, expected, w.Code)
+		}
+	}
+
+	expected := []Route{
+		{Method: "GET", Pattern: "/debug/pprof/*path", Metadata: "profiling"},
+		{Method: "GET", Pattern: "/debug/vars"},
+	}
+	if !DevBuild {
+		expected = append(expected, Route{Method: "GET", Pattern: "/dev"})
+	}
+	expected = append(expected, Route{Method: "POST", Pattern: "/reset", Host: "admin.example.com"})
+	if skipped := router.SkippedRoutes(); !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("Expected skipped routes %v, saw %v", expected, skipped)
+	}
+
+	for _, route := range router.Routes() {
+		if route.Pattern != "/enabled" && !(DevBuild && route.Pattern == "/dev") {
+			t.Errorf("Expected skipped route %s %s not to be registered", route.Method, route.Pattern)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package httptreemux
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerRules are the changes made to the headers of requests for a route, given with
+// WithoutRequestHeaders, WithRequestHeader and WithRouteHeader.
+type headerRules struct {
+	// Canonical header names to remove. A name ending in '*' removes every header with the
+	// rest of the name as a prefix.
+	strip []string
+	set   []headerValue
+}
+
+type headerValue struct {
+	name  string
+	value string
+	// True if the value is the route's pattern.
+	route bool
+}
+
+// WithoutRequestHeaders removes the named headers from requests for a route before they are
+// served. A name ending in '*' removes every header which starts with the rest of the name, so
+// a public group can make sure that its handlers never see headers which only trusted proxies
+// should send:
+//
+//	public := router.NewGroup("/api").With(httptreemux.WithoutRequestHeaders("X-Internal-*"))
+//
+// Names are not case sensitive. The headers are removed by the router when it calls the
+// route's handler, so this applies even if the group's middleware is misconfigured.
+func WithoutRequestHeaders(names ...string) RouteOption {
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		if strings.HasSuffix(name, "*") {
+			canonical[i] = strings.ToLower(name)
+		} else {
+			canonical[i] = http.CanonicalHeaderKey(name)
+		}
+	}
+	return func(info *routeInfo) {
+		rules := info.headerRules.clone()
+		rules.strip = append(rules.strip, canonical...)
+		info.headerRules = rules
+	}
+}
+
+// WithRequestHeader sets a header on requests for a route before they are served, replacing
+// any values that the client sent. Headers are set after those given with
+// WithoutRequestHeaders are removed.
+func WithRequestHeader(name, value string) RouteOption {
+	return withHeaderValue(headerValue{name: http.CanonicalHeaderKey(name), value: value})
+}
+
+// WithRouteHeader sets a header on requests for a route to the route's pattern before they
+// are served, replacing any values that the client sent. This tells a proxied service which
+// route the router matched:
+//
+//	proxied := router.NewGroup("/billing").With(httptreemux.WithRouteHeader("X-Route"))
+//	proxied.Any("/*path", billingProxy) // requests have X-Route: /billing/*path
+func WithRouteHeader(name string) RouteOption {
+	return withHeaderValue(headerValue{name: http.CanonicalHeaderKey(name), route: true})
+}
+
+func withHeaderValue(value headerValue) RouteOption {
+	return func(info *routeInfo) {
+		rules := info.headerRules.clone()
+		rules.set = append(rules.set, value)
+		info.headerRules = rules
+	}
+}
+
+// clone returns a copy of the rules which can be changed without affecting them. The copy of
+// nil rules is empty.
+func (rules *headerRules) clone() *headerRules {
+	if rules == nil {
+		return &headerRules{}
+	}
+	return &headerRules{
+		strip: rules.strip[:len(rules.strip):len(rules.strip)],
+		set:   rules.set[:len(rules.set):len(rules.set)],
+	}
+}
+
+// stripped returns true if the canonical header name is removed by the rules.
+func (rules *headerRules) stripped(name string) bool {
+	for _, strip := range rules.strip {
+		if prefix := strings.TrimSuffix(strip, "*"); prefix != strip {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if name == strip {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a copy of the request with the rules applied to its headers, leaving the
+// headers of the original request unchanged.
+func (rules *headerRules) apply(r *http.Request, route string) *http.Request {
+	header := cloneHeader(r.Header)
+	for name := range header {
+		if rules.stripped(name) {
+			delete(header, name)
+		}
+	}
+	for _, h := range rules.set {
+		if h.route {
+			header.Set(h.name, route)
+		} else {
+			header.Set(h.name, h.value)
+		}
+	}
+
+	rewritten := new(http.Request)
+	*rewritten = *r
+	rewritten.Header = header
+	return rewritten
+}
@@ -0,0 +1,62 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequestHeaderRules(t *testing.T) {
+	var seen http.Header
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		seen = r.Header
+	}
+
+	router := New()
+	public := router.NewGroup("/public").With(WithoutRequestHeaders("x-internal-*", "Authorization"))
+	public.GET("/items", handler)
+	public.With(WithRequestHeader("X-Internal-Caller", "public")).GET("/forced", handler)
+	proxied := router.NewGroup("/billing").With(WithRouteHeader("X-Route"))
+	proxied.GET("/invoices/:id", handler)
+	router.GET("/plain", handler)
+
+	for _, test := range []struct {
+		path     string
+		expected http.Header
+	}{
+		{"/public/items", http.Header{"Accept": {"text/plain"}, "X-Route": {"spoofed"}}},
+		{"/public/forced", http.Header{"Accept": {"text/plain"}, "X-Route": {"spoofed"}, "X-Internal-Caller": {"public"}}},
+		{"/billing/invoices/1", http.Header{
+			"Accept":            {"text/plain"},
+			"Authorization":     {"Bearer token"},
+			"X-Internal-Tenant": {"a"},
+			"X-Internal-User":   {"1"},
+			"X-Route":           {"/billing/invoices/:id"},
+		}},
+		// Without any rules, the request is passed on untouched.
+		{"/plain", http.Header{
+			"Accept":            {"text/plain"},
+			"Authorization":     {"Bearer token"},
+			"x-internal-tenant": {"a"},
+			"X-Internal-User":   {"1"},
+			"X-Route":           {"spoofed"},
+		}},
+	} {
+		seen = nil
+		r, _ := newRequest("GET", test.path, nil)
+		r.Header.Set("Accept", "text/plain")
+		r.Header.Set("Authorization", "Bearer token")
+		r.Header.Set("X-Internal-User", "1")
+		r.Header["x-internal-tenant"] = []string{"a"}
+		r.Header.Set("X-Route", "spoofed")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !reflect.DeepEqual(seen, test.expected) {
+			t.Errorf("%s: expected headers %v, saw %v", test.path, test.expected, seen)
+		}
+		if r.Header.Get("X-Internal-User") != "1" || r.Header.Get("X-Route") != "spoofed" {
+			t.Errorf("%s: the headers of the original request were changed", test.path)
+		}
+	}
+}
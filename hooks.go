@@ -0,0 +1,128 @@
+package httptreemux
+
+import (
+	"net/http"
+	"time"
+)
+
+// hookResult returns the LookupResult passed to the instrumentation hooks, with the
+// parameters as a map even if they were captured into pooled Params.
+func hookResult(lr LookupResult) LookupResult {
+	if lr.Params == nil && lr.pooledParams != nil {
+		lr.Params = lr.pooledParams.Map()
+	}
+	return lr
+}
+
+// WithHookFilter makes the OnRouteMatched and OnRouteServed hooks skip the requests to a
+// route for which filter returns false, so that noisy endpoints such as health checks don't
+// flood metrics and access logs. The filter is called for each request before the handler.
+// OnMethodNotAllowed is skipped for a path when the filters of all of its routes return false.
+//
+//	router.With(httptreemux.WithHookFilter(func(r *http.Request) bool {
+//	    return r.Header.Get("User-Agent") != "kube-probe"
+//	})).GET("/healthz", health)
+func WithHookFilter(filter func(r *http.Request) bool) RouteOption {
+	return func(info *routeInfo) {
+		info.hookFilter = filter
+	}
+}
+
+// WithoutHooks makes the instrumentation hooks skip all requests to a route. It is the same as
+// a WithHookFilter whose filter always returns false.
+func WithoutHooks() RouteOption {
+	return WithHookFilter(func(r *http.Request) bool { return false })
+}
+
+// callHooks reports whether the hooks should be called for a request with the lookup result.
+func (lr LookupResult) callHooks(r *http.Request) bool {
+	return lr.hookFilter == nil || lr.hookFilter(r)
+}
+
+// methodNotAllowedHookFilter returns the hook filter for a 405 response for a path with the
+// given routes. The hooks are skipped only if all of the routes skip the request.
+func methodNotAllowedHookFilter(routes map[string]*routeInfo) func(r *http.Request) bool {
+	if len(routes) == 0 {
+		return nil
+	}
+	for _, info := range routes {
+		if info.hookFilter == nil {
+			return nil
+		}
+	}
+
+	// Copy the filters, since the routes may change once the lookup is done.
+	filters := make([]func(r *http.Request) bool, 0, len(routes))
+	for _, info := range routes {
+		filters = append(filters, info.hookFilter)
+	}
+	return func(r *http.Request) bool {
+		for _, filter := range filters {
+			if filter(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// serveMatched calls the handler of a lookup result for a registered route, along with the
+// OnRouteMatched and OnRouteServed hooks and the route's panic handler, after making the
+// route's changes to the request's headers.
+func (t *TreeMux) serveMatched(w http.ResponseWriter, r *http.Request, lr LookupResult) {
+	if lr.headerRules != nil {
+		r = lr.headerRules.apply(r, lr.Route)
+	}
+	if lr.panicHandler != nil {
+		defer func() {
+			if err := recover(); err != nil {
+				lr.panicHandler(w, r, hookResult(lr), err)
+			}
+		}()
+	}
+
+	onRouteServed, onRouteTimeout := t.OnRouteServed, t.OnRouteTimeout
+	if (t.OnRouteMatched != nil || onRouteServed != nil || onRouteTimeout != nil) && !lr.callHooks(r) {
+		onRouteServed, onRouteTimeout = nil, nil
+	} else if t.OnRouteMatched != nil {
+		t.OnRouteMatched(r, hookResult(lr))
+	}
+	if t.OnExperimentAssigned != nil && lr.Assignment != nil {
+		// Not filtered, so that analytics see every exposure.
+		t.OnExperimentAssigned(r, hookResult(lr))
+	}
+
+	var start time.Time
+	if onRouteServed != nil {
+		start = t.now()
+	}
+
+	var outcome handlerOutcome
+	if lr.cache != nil {
+		outcome = t.serveCached(w, r, lr)
+	} else {
+		outcome = t.serveHandler(w, r, lr)
+	}
+	switch outcome {
+	case handlerTimedOut:
+		if onRouteTimeout != nil {
+			onRouteTimeout(r, hookResult(lr), lr.timeout)
+		}
+		return
+	case handlerAbandoned:
+		return
+	}
+
+	if onRouteServed != nil {
+		onRouteServed(r, hookResult(lr), t.now().Sub(start))
+	}
+}
+
+// callHandler calls the handler of a lookup result for a registered route.
+func (lr LookupResult) callHandler(w http.ResponseWriter, r *http.Request) {
+	if lr.paramsHandler != nil {
+		lr.paramsHandler(w, r, *lr.pooledParams)
+	} else {
+		lr.handler(w, r, lr.Params)
+	}
+}
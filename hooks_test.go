@@ -0,0 +1,224 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	clock := &fakeClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	router := New()
+	router.Clock = clock
+
+	var events []string
+	var matched, served LookupResult
+	var elapsed time.Duration
+	router.OnRouteMatched = func(r *http.Request, lr LookupResult) {
+		events = append(events, "matched "+lr.Route)
+		matched = lr
+	}
+	router.OnRouteServed = func(r *http.Request, lr LookupResult, d time.Duration) {
+		events = append(events, "served "+lr.Route)
+		served = lr
+		elapsed = d
+	}
+	router.OnNotFound = func(r *http.Request) {
+		events = append(events, "not found "+r.URL.Path)
+	}
+	router.OnMethodNotAllowed = func(r *http.Request, lr LookupResult) {
+		events = append(events, "method not allowed "+r.URL.Path)
+	}
+
+	router.With(WithMetadata("users")).GET("/users/:id", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		events = append(events, "handler")
+		clock.time = clock.time.Add(25 * time.Millisecond)
+	})
+	router.GETP("/posts/:slug", func(w http.ResponseWriter, r *http.Request, ps Params) {
+		events = append(events, "handler")
+	})
+
+	serve := func(method, path string) {
+		r, _ := newRequest(method, path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve("GET", "/users/5")
+	expected := []string{"matched /users/:id", "handler", "served /users/:id"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, saw %v", expected, events)
+	}
+	if matched.Metadata != "users" || matched.Params["id"] != "5" {
+		t.Errorf("Expected OnRouteMatched to receive the route's metadata and params, saw %+v", matched)
+	}
+	if served.Params["id"] != "5" || elapsed != 25*time.Millisecond {
+		t.Errorf("Expected OnRouteServed to receive the params and elapsed time, saw %v and %v", served.Params, elapsed)
+	}
+
+	// Pooled parameters are passed to the hooks as a map.
+	events = nil
+	serve("GET", "/posts/hello")
+	if matched.Params["slug"] != "hello" || served.Params["slug"] != "hello" {
+		t.Errorf("Expected pooled params to be passed to the hooks, saw %v and %v", matched.Params, served.Params)
+	}
+
+	events = nil
+	serve("GET", "/missing")
+	serve("POST", "/users/5")
+	serve("GET", "/users/5/")
+	expected = []string{"not found /missing", "method not allowed /users/5"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, saw %v", expected, events)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	router := New()
+	var labels []map[string]string
+	router.OnRouteServed = func(r *http.Request, lr LookupResult, elapsed time.Duration) {
+		labels = append(labels, lr.Labels(r))
+	}
+	router.With(WithLabels(
+		Label{Name: "tenant", Param: "tenant", MaxValues: 2},
+		Label{Name: "client", Header: "X-Client-Name"},
+	)).GET("/t/:tenant/orders", simpleHandler)
+	router.GET("/plain", simpleHandler)
+
+	for _, test := range []struct {
+		path, client string
+	}{
+		{"/t/a/orders", "web"},
+		{"/t/b/orders", ""},
+		{"/t/c/orders", "ios"},
+		{"/t/a/orders", "web"},
+		{"/plain", "web"},
+	} {
+		r, _ := newRequest("GET", test.path, nil)
+		if test.client != "" {
+			r.Header.Set("X-Client-Name", test.client)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	expected := []map[string]string{
+		{"tenant": "a", "client": "web"},
+		{"tenant": "b"},
+		{"tenant": OverflowLabelValue, "client": "ios"},
+		{"tenant": "a", "client": "web"},
+		nil,
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %v, saw %v", expected, labels)
+	}
+}
+
+func TestHookFilter(t *testing.T) {
+	router := New()
+	var matched, served, methodNotAllowed []string
+	router.OnRouteMatched = func(r *http.Request, lr LookupResult) {
+		matched = append(matched, r.URL.Path)
+	}
+	router.OnRouteServed = func(r *http.Request, lr LookupResult, elapsed time.Duration) {
+		served = append(served, r.URL.Path)
+	}
+	router.OnMethodNotAllowed = func(r *http.Request, lr LookupResult) {
+		methodNotAllowed = append(methodNotAllowed, r.URL.Path)
+	}
+
+	router.With(WithoutHooks()).GET("/healthz", simpleHandler)
+	router.With(WithHookFilter(func(r *http.Request) bool {
+		return r.Header.Get("User-Agent") != "probe"
+	})).GET("/status", simpleHandler)
+	router.GET("/users", simpleHandler)
+
+	for _, test := range []struct {
+		method, path, agent string
+	}{
+		{"GET", "/healthz", ""},
+		{"POST", "/healthz", ""},
+		{"GET", "/status", "probe"},
+		{"GET", "/status", "browser"},
+		{"POST", "/status", "probe"},
+		{"POST", "/status", "browser"},
+		{"GET", "/users", "probe"},
+		{"POST", "/users", "probe"},
+	} {
+		r, _ := newRequest(test.method, test.path, nil)
+		r.Header.Set("User-Agent", test.agent)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	expected := []string{"/status", "/users"}
+	if !reflect.DeepEqual(matched, expected) || !reflect.DeepEqual(served, expected) {
+		t.Errorf("Expected hooks for %v, saw matched %v and served %v", expected, matched, served)
+	}
+	expected = []string{"/status", "/users"}
+	if !reflect.DeepEqual(methodNotAllowed, expected) {
+		t.Errorf("Expected OnMethodNotAllowed for %v, saw %v", expected, methodNotAllowed)
+	}
+}
+
+type steppingClock struct {
+	time time.Time
+	step time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.time = c.time.Add(c.step)
+	return c.time
+}
+
+func TestOnRouteRegistered(t *testing.T) {
+	router := New()
+	router.Clock = &steppingClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), step: time.Millisecond}
+	var stats []RegistrationStats
+	router.OnRouteRegistered = func(s RegistrationStats) {
+		stats = append(stats, s)
+	}
+
+	router.GET("/users", simpleHandler)
+	router.GET("/uploads", simpleHandler)
+	router.GET("/articles", simpleHandler)
+	router.GET("/articles/:id", simpleHandler)
+	// Raises the priority of /articles above that of /u, which comes first.
+	router.GET("/articles/:id/comments", simpleHandler)
+
+	expected := []RegistrationStats{
+		{Method: "GET", Pattern: "/users", NodesAdded: 1, Duration: time.Millisecond},
+		{Method: "GET", Pattern: "/uploads", NodesAdded: 2, Splits: 1, Duration: time.Millisecond},
+		{Method: "GET", Pattern: "/articles", NodesAdded: 1, Duration: time.Millisecond},
+		{Method: "GET", Pattern: "/articles/:id", NodesAdded: 2, Duration: time.Millisecond},
+		{Method: "GET", Pattern: "/articles/:id/comments", NodesAdded: 2, Reorders: 1, Duration: time.Millisecond},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected stats\n%+v\nsaw\n%+v", expected, stats)
+	}
+}
+
+func TestMutationStats(t *testing.T) {
+	router := New()
+	router.SafeAddRoutesWhileRunning = true
+	router.Clock = &steppingClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), step: time.Millisecond}
+
+	if stats := router.MutationStats(); stats != (MutationStats{}) {
+		t.Errorf("Expected no stats for a new router, saw %+v", stats)
+	}
+
+	router.GET("/users", simpleHandler)
+	router.GET("/users/:id", simpleHandler)
+	router.Remove("GET", "/users")
+
+	stats := router.MutationStats()
+	if stats.Writes != 3 {
+		t.Errorf("Expected 3 writes, saw %d", stats.Writes)
+	}
+	// Each step of the clock is one millisecond, so each wait is one step.
+	if stats.WriterWait != 3*time.Millisecond || stats.MaxWriterWait != time.Millisecond {
+		t.Errorf("Expected writer waits of 3ms and at most 1ms, saw %s and %s", stats.WriterWait, stats.MaxWriterWait)
+	}
+	if stats.LockHeld < 3*time.Millisecond || stats.MaxLockHeld < time.Millisecond || stats.MaxLockHeld > stats.LockHeld {
+		t.Errorf("Expected the lock to be held for each write, saw %s and at most %s", stats.LockHeld, stats.MaxLockHeld)
+	}
+}
@@ -0,0 +1,170 @@
+package httptreemux
+
+import (
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
+)
+
+// hostTree is a routing tree which serves requests for a particular host pattern.
+type hostTree struct {
+	// The pattern as passed to Host.
+	pattern string
+	// The host name from the pattern, without the port.
+	name string
+	// For wildcard patterns, the part of the pattern after the "*", e.g. ".example.com".
+	// Empty for exact patterns.
+	wildcardSuffix string
+	// The port required by the pattern, or empty if any port is allowed.
+	port string
+	root *pathtree.Node
+}
+
+// Host returns a group whose routes are only matched for requests to the given host. The
+// pattern is either an exact host name, such as "api.example.com", or a wildcard for
+// subdomains, such as "*.example.com", which matches "www.example.com" and
+// "a.b.example.com", but not "example.com" itself.
+//
+// A pattern without a port matches the host on any port. A pattern with a port, such as
+// "localhost:8080", only matches requests to that port.
+//
+// Each host pattern has its own routing tree. When a request is served, the tree is selected
+// using r.Host before the path lookup is done. Exact patterns take precedence over wildcard
+// patterns, and longer wildcard patterns take precedence over shorter ones. Requests for a host
+// which does not match any pattern use the default tree, which holds the routes added directly
+// to the TreeMux and its other groups. Once a host tree has been selected, the default tree is
+// not consulted, even if no route in the host tree matches the path.
+//
+// Calling Host again with the same pattern returns a new group using the same tree.
+func (t *TreeMux) Host(pattern string) *Group {
+	if len(pattern) == 0 {
+		panic("Host pattern must not be empty")
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var tree *hostTree
+	for _, h := range t.hosts {
+		if h.pattern == pattern {
+			tree = h
+			break
+		}
+	}
+
+	if tree == nil {
+		host, port := splitHostPort(pattern)
+		tree = &hostTree{
+			pattern: pattern,
+			name:    host,
+			port:    port,
+			root:    pathtree.NewRoot(),
+		}
+		if strings.HasPrefix(host, "*.") {
+			tree.wildcardSuffix = host[1:]
+		} else if strings.Contains(host, "*") {
+			panic("Host pattern " + pattern + " may only contain a * at the start")
+		}
+		t.hosts = append(t.hosts, tree)
+	}
+
+	return &Group{
+		mux:   t,
+		host:  tree,
+		stack: t.Group.stack[:len(t.Group.stack):len(t.Group.stack)],
+	}
+}
+
+// matches reports whether the tree serves the given request host and port. For wildcard
+// patterns, it also returns the length of the suffix matched, to rank the matches.
+// If fold is true, the host has been normalized as described for TreeMux.NormalizeHost.
+func (h *hostTree) matches(host, port string, fold bool) (bool, int) {
+	name, suffix, patternPort := h.name, h.wildcardSuffix, h.port
+	if fold {
+		name, suffix = strings.ToLower(name), strings.ToLower(suffix)
+		if isDefaultPort(patternPort) {
+			patternPort = ""
+		}
+	}
+
+	if patternPort != "" && patternPort != port {
+		return false, 0
+	}
+
+	if suffix == "" {
+		return host == name, 0
+	}
+
+	// The wildcard must match at least one character of the host.
+	return len(host) > len(suffix) && strings.HasSuffix(host, suffix), len(suffix)
+}
+
+// rootForHost returns the root node of the tree which serves requests for the given host.
+func (t *TreeMux) rootForHost(requestHost string) *pathtree.Node {
+	if len(t.hosts) == 0 {
+		return t.root
+	}
+
+	host, port := splitHostPort(requestHost)
+	if t.NormalizeHost {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if isDefaultPort(port) {
+			port = ""
+		}
+	}
+
+	var best *hostTree
+	bestLen := -1
+	for _, h := range t.hosts {
+		ok, suffixLen := h.matches(host, port, t.NormalizeHost)
+		if !ok {
+			continue
+		}
+
+		if h.wildcardSuffix == "" {
+			// Exact matches always win.
+			return h.root
+		}
+
+		if suffixLen > bestLen {
+			best = h
+			bestLen = suffixLen
+		}
+	}
+
+	if best != nil {
+		return best.root
+	}
+	return t.root
+}
+
+// tree returns the root node of the tree to which the group adds its routes.
+func (g *Group) tree() *pathtree.Node {
+	if g.host != nil {
+		return g.host.root
+	}
+	return g.mux.root
+}
+
+// hostPattern returns the pattern of the host that the group adds routes to, or an empty
+// string for the default tree.
+func (g *Group) hostPattern() string {
+	if g.host != nil {
+		return g.host.pattern
+	}
+	return ""
+}
+
+// splitHostPort splits a host into its name and port. Unlike net.SplitHostPort, it accepts
+// hosts without a port, and keeps the brackets around IPv6 literals.
+func splitHostPort(hostport string) (host, port string) {
+	colon := strings.LastIndexByte(hostport, ':')
+	if colon == -1 || colon < strings.LastIndexByte(hostport, ']') {
+		return hostport, ""
+	}
+	return hostport[:colon], hostport[colon+1:]
+}
+
+func isDefaultPort(port string) bool {
+	return port == "80" || port == "443"
+}
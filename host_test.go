@@ -0,0 +1,138 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	var matched string
+	makeHandler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			matched = name
+		}
+	}
+
+	router := New()
+	router.GET("/", makeHandler("default"))
+	router.GET("/only-default", makeHandler("default only"))
+
+	api := router.Host("api.example.com")
+	api.GET("/", makeHandler("api"))
+	api.NewGroup("/v1").GET("/users/:id", makeHandler("api users"))
+
+	router.Host("*.example.com").GET("/", makeHandler("wildcard"))
+	router.Host("*.eu.example.com").GET("/", makeHandler("eu wildcard"))
+	router.Host("localhost:8080").GET("/", makeHandler("localhost 8080"))
+
+	// Adding the same pattern again uses the same tree.
+	router.Host("api.example.com").GET("/status", makeHandler("api status"))
+
+	for _, test := range []struct {
+		host, path   string
+		expectedCode int
+		expected     string
+	}{
+		{"example.com", "/", 200, "default"},
+		{"other.org", "/only-default", 200, "default only"},
+		{"api.example.com", "/", 200, "api"},
+		{"api.example.com:443", "/", 200, "api"},
+		{"api.example.com", "/v1/users/5", 200, "api users"},
+		{"api.example.com", "/status", 200, "api status"},
+		{"api.example.com", "/only-default", 404, ""},
+		{"www.example.com", "/", 200, "wildcard"},
+		{"a.b.example.com", "/", 200, "wildcard"},
+		{"www.eu.example.com", "/", 200, "eu wildcard"},
+		{"www.example.com", "/v1/users/5", 404, ""},
+		{"localhost:8080", "/", 200, "localhost 8080"},
+		{"localhost:9090", "/", 200, "default"},
+		{"localhost", "/", 200, "default"},
+	} {
+		matched = ""
+		r, _ := newRequest("GET", test.path, nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s%s: expected code %d, saw %d", test.host, test.path, test.expectedCode, w.Code)
+		}
+		if matched != test.expected {
+			t.Errorf("%s%s: expected handler %q, saw %q", test.host, test.path, test.expected, matched)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	var matched string
+	makeHandler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			matched = name
+		}
+	}
+
+	router := New()
+	router.GET("/Path", makeHandler("default"))
+	router.Host("API.example.com").GET("/Path", makeHandler("api"))
+	router.Host("*.Example.com").GET("/Path", makeHandler("wildcard"))
+	router.Host("secure.example.com:443").GET("/Path", makeHandler("secure"))
+
+	for _, normalize := range []bool{false, true} {
+		router.NormalizeHost = normalize
+		for _, test := range []struct {
+			host, path        string
+			plain, normalized string
+		}{
+			{"API.example.com", "/Path", "api", "api"},
+			{"api.EXAMPLE.com.", "/Path", "default", "api"},
+			{"WWW.example.COM:80", "/Path", "default", "wildcard"},
+			{"secure.example.com", "/Path", "default", "secure"},
+			{"secure.example.com:443", "/Path", "secure", "secure"},
+			// The path is still case-sensitive.
+			{"api.example.com", "/path", "", ""},
+		} {
+			matched = ""
+			r, _ := newRequest("GET", test.path, nil)
+			r.Host = test.host
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			expected := test.plain
+			if normalize {
+				expected = test.normalized
+			}
+			if matched != expected {
+				t.Errorf("NormalizeHost %v, %s%s: expected handler %q, saw %q", normalize, test.host, test.path, expected, matched)
+			}
+		}
+	}
+}
+
+func TestHostInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"", "api.*.example.com"} {
+		func() {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("Host pattern %q should have caused a panic", pattern)
+				}
+			}()
+			New().Host(pattern)
+		}()
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	for _, test := range []struct {
+		in, host, port string
+	}{
+		{"example.com", "example.com", ""},
+		{"example.com:80", "example.com", "80"},
+		{"[::1]", "[::1]", ""},
+		{"[::1]:8080", "[::1]", "8080"},
+	} {
+		host, port := splitHostPort(test.in)
+		if host != test.host || port != test.port {
+			t.Errorf("splitHostPort(%q) = %q, %q; expected %q, %q", test.in, host, port, test.host, test.port)
+		}
+	}
+}
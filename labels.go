@@ -0,0 +1,113 @@
+package httptreemux
+
+import (
+	"net/http"
+	"sync"
+)
+
+// OverflowLabelValue is reported for a label in place of values beyond its MaxValues.
+const OverflowLabelValue = "other"
+
+// Label declares a value which is promoted from the requests to a route into a tracing
+// attribute or metric label. The value is read from a route parameter or from a request
+// header.
+type Label struct {
+	// Name is the name of the label.
+	Name string
+	// Param is the route parameter that the value is read from.
+	Param string
+	// Header is the request header that the value is read from, when Param is empty.
+	Header string
+	// MaxValues limits the number of distinct values reported for the label on the route.
+	// Once it is reached, other values are reported as OverflowLabelValue. This keeps the
+	// cardinality of a metric bounded when the value comes from the client. Zero means
+	// no limit.
+	MaxValues int
+}
+
+// WithLabels declares labels for a route, which are read by LookupResult.Labels. This
+// lets tracing and metrics code, such as the OnRouteMatched and OnRouteServed hooks,
+// label requests the same way for every route without changes to the handlers.
+//
+//	router.OnRouteServed = func(r *http.Request, lr httptreemux.LookupResult, elapsed time.Duration) {
+//	    observe(lr.Route, lr.Labels(r), elapsed)
+//	}
+//	router.With(httptreemux.WithLabels(
+//	    httptreemux.Label{Name: "tenant", Param: "tenant", MaxValues: 100},
+//	    httptreemux.Label{Name: "client", Header: "X-Client-Name", MaxValues: 20},
+//	)).GET("/t/:tenant/orders", listOrders)
+func WithLabels(labels ...Label) RouteOption {
+	return func(info *routeInfo) {
+		var existing []Label
+		if info.labels != nil {
+			existing = info.labels.labels
+		}
+		info.labels = &labelSet{labels: append(existing[:len(existing):len(existing)], labels...)}
+	}
+}
+
+// labelSet holds the labels of a route along with the values seen for each of them.
+type labelSet struct {
+	labels []Label
+
+	mutex sync.Mutex
+	seen  []map[string]bool
+}
+
+// Labels returns the labels declared with WithLabels for the matched route, with their
+// values for the request. Labels whose value is empty are left out. It returns nil if the
+// route has no labels.
+func (lr LookupResult) Labels(r *http.Request) map[string]string {
+	if lr.labels == nil {
+		return nil
+	}
+
+	params := lr.Params
+	if params == nil && lr.pooledParams != nil {
+		params = lr.pooledParams.Map()
+	}
+	return lr.labels.values(r, params)
+}
+
+func (s *labelSet) values(r *http.Request, params map[string]string) map[string]string {
+	result := make(map[string]string, len(s.labels))
+	for i, label := range s.labels {
+		var value string
+		if label.Param != "" {
+			value = params[label.Param]
+		} else if label.Header != "" {
+			value = r.Header.Get(label.Header)
+		}
+		if value == "" {
+			continue
+		}
+
+		if label.MaxValues > 0 {
+			value = s.limit(i, label.MaxValues, value)
+		}
+		result[label.Name] = value
+	}
+	return result
+}
+
+// limit returns value if it is one of the first max distinct values of the label at
+// index i, and OverflowLabelValue otherwise.
+func (s *labelSet) limit(i, max int, value string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.seen == nil {
+		s.seen = make([]map[string]bool, len(s.labels))
+	}
+	if s.seen[i] == nil {
+		s.seen[i] = map[string]bool{}
+	}
+
+	if !s.seen[i][value] {
+		if len(s.seen[i]) >= max {
+			return OverflowLabelValue
+		}
+		s.seen[i][value] = true
+	}
+	return value
+}
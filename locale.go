@@ -0,0 +1,47 @@
+package httptreemux
+
+import "strings"
+
+// LocaleParam is the name of the parameter which holds the locale removed from the start of a
+// request's path, when TreeMux.Locales is set.
+const LocaleParam = "locale"
+
+// pathLocale returns the entry of Locales which matches the first segment of the path, or an
+// empty string if there is none.
+func (t *TreeMux) pathLocale(path string) string {
+	for _, locale := range t.Locales {
+		if hasLocale(path, locale) {
+			return locale
+		}
+	}
+	return ""
+}
+
+// hasLocale returns true if the first segment of the path is the locale, ignoring case.
+func hasLocale(path, locale string) bool {
+	end := len(locale) + 1
+	return locale != "" && len(path) >= end && strings.EqualFold(path[1:end], locale) &&
+		(len(path) == end || path[end] == '/')
+}
+
+// stripLocale removes the locale segment from the start of a path, leaving at least "/".
+func stripLocale(path, locale string) string {
+	if !hasLocale(path, locale) {
+		return path
+	}
+	if path = path[len(locale)+1:]; path == "" {
+		return "/"
+	}
+	return path
+}
+
+// addPathPrefix restores the locale prefix of a path which is redirected.
+func addPathPrefix(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "/" {
+		return prefix + "/"
+	}
+	return prefix + path
+}
@@ -0,0 +1,136 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unsafe"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
+)
+
+// MatchAll looks up each of the paths for the given method, as Lookup would for a request, and
+// returns the results in the same order. It is meant for offline analysis of large numbers of
+// URLs, such as classifying the requests in old access logs against the current routes, and
+// avoids the cost of building a request for each path.
+//
+// Each path is the request target as it would appear in the request line, starting with a
+// slash, and may include a query string. Since there is no Host header, only routes which
+// apply to all hosts are matched. A path which is empty or does not start with a slash gives
+// a result with the status http.StatusBadRequest.
+func (t *TreeMux) MatchAll(paths []string, method string) []LookupResult {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	results := make([]LookupResult, len(paths))
+	// The same request is reused for every path.
+	u := &url.URL{}
+	r := &http.Request{Method: method, URL: u, Header: http.Header{}}
+	for i, path := range paths {
+		if path == "" || path[0] != '/' {
+			results[i] = LookupResult{StatusCode: http.StatusBadRequest}
+			continue
+		}
+
+		r.RequestURI = path
+		u.RawQuery = ""
+		if query := strings.IndexByte(path, '?'); query != -1 {
+			u.RawQuery = path[query+1:]
+			path = path[:query]
+		}
+		if unescaped, err := unescape(path); err == nil {
+			u.Path = unescaped
+		} else {
+			u.Path = path
+		}
+
+		results[i], _ = t.lookup(nil, r, false)
+	}
+	return results
+}
+
+// PathMatch is the result of LookupBytes.
+type PathMatch struct {
+	// Handler is the handler of the matched route, with the middleware of its group.
+	Handler HandlerFunc
+	// Route is the pattern of the matched route, as it was registered.
+	Route string
+	// Metadata is the value attached to the route with WithMetadata, if any.
+	Metadata interface{}
+	// Params holds the route's parameters, in the order they appear in the pattern.
+	Params Params
+}
+
+// LookupBytes finds the route for a method and a path given as a byte slice, for integrations
+// which read requests from their own transports, such as HTTP/3 libraries or message buses
+// carrying pseudo-HTTP requests, and would otherwise convert every path to a string. The path
+// starts with a slash and has no query string.
+//
+// The parameters are appended to params[:0], so that a caller can pass the same slice for
+// every lookup and reuse its storage. Their values share memory with path unless they had
+// to be unescaped, so they must not be used after path is modified.
+//
+// Only the exact path is matched: there are no redirects, path cleaning or locale prefixes,
+// only routes which apply to all hosts are considered, and routes added with match options
+// are skipped since there is no request to check. It returns false if no handler was found
+// for the method.
+func (t *TreeMux) LookupBytes(method string, path []byte, params Params) (PathMatch, bool) {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	params = params[:0]
+	if len(path) == 0 || path[0] != '/' {
+		return PathMatch{Params: params}, false
+	}
+	if t.MethodPolicy != PassMethods {
+		if !validMethod(method) {
+			return PathMatch{Params: params}, false
+		}
+		if t.MethodPolicy == NormalizeMethodCase {
+			method = strings.ToUpper(method)
+		}
+	}
+
+	p := bytesToString(path)
+	if t.CaseInsensitive {
+		p = strings.ToLower(p)
+	}
+	trailingSlash := len(p) > 1 && p[len(p)-1] == '/'
+	if trailingSlash && t.RedirectTrailingSlash {
+		p = p[:len(p)-1]
+	}
+
+	n, handler, values := search(t.root, method, p[1:], nil)
+	if handler == nil {
+		return PathMatch{Params: params}, false
+	}
+	if t.RedirectTrailingSlash && (n.Kind() != pathtree.CatchAll || t.RemoveCatchAllTrailingSlash) && trailingSlash != n.addSlash {
+		// The request would be redirected.
+		return PathMatch{Params: params}, false
+	}
+	info := n.route(method)
+	if info != nil && len(info.predicates) != 0 {
+		return PathMatch{Params: params}, false
+	}
+
+	values, _ = unescapeParams(values)
+	for i, name := range n.WildcardNames()[:len(values)] {
+		params = append(params, Param{Key: name, Value: values[len(values)-i-1]})
+	}
+
+	match := PathMatch{Handler: handler, Params: params}
+	if info != nil {
+		match.Route = info.pattern
+		match.Metadata = info.metadata
+	}
+	return match, true
+}
+
+// bytesToString returns a string which shares the memory of b, without copying it.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,150 @@
+package httptreemux
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestMatchAll(t *testing.T) {
+	router := New()
+	router.GET("/users/:id", simpleHandler)
+	router.POST("/users", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+	router.GET("/dir/", simpleHandler)
+	router.Host("api.example.com").GET("/hosted", simpleHandler)
+
+	results := router.MatchAll([]string{
+		"/users/abc?tab=posts",
+		"/users",
+		"/files/a%2Fb/c",
+		"/dir",
+		"/missing",
+		"/hosted",
+		"users",
+		"",
+	}, "GET")
+
+	expected := []struct {
+		code   int
+		route  string
+		params map[string]string
+	}{
+		{http.StatusOK, "/users/:id", map[string]string{"id": "abc"}},
+		{http.StatusMethodNotAllowed, "", nil},
+		{http.StatusOK, "/files/*path", map[string]string{"path": "a/b/c"}},
+		{http.StatusMovedPermanently, "", nil},
+		{http.StatusNotFound, "", nil},
+		{http.StatusNotFound, "", nil},
+		{http.StatusBadRequest, "", nil},
+		{http.StatusBadRequest, "", nil},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, saw %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.StatusCode != expected[i].code || result.Route != expected[i].route {
+			t.Errorf("Result %d: expected %d %s, saw %d %s", i, expected[i].code, expected[i].route,
+				result.StatusCode, result.Route)
+		}
+		if fmt.Sprint(result.Params) != fmt.Sprint(expected[i].params) {
+			t.Errorf("Result %d: expected params %v, saw %v", i, expected[i].params, result.Params)
+		}
+	}
+}
+
+func BenchmarkMatchAll(b *testing.B) {
+	router := New()
+	router.GET("/", simpleHandler)
+	router.GET("/user/:name", simpleHandler)
+	router.GET("/static/*path", simpleHandler)
+
+	paths := make([]string, 1000)
+	for i := range paths {
+		switch i % 3 {
+		case 0:
+			paths[i] = fmt.Sprintf("/user/%d", i)
+		case 1:
+			paths[i] = fmt.Sprintf("/static/js/%d.js", i)
+		default:
+			paths[i] = fmt.Sprintf("/missing/%d", i)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.MatchAll(paths, "GET")
+	}
+}
+
+func TestLookupBytes(t *testing.T) {
+	router := New()
+	router.GET("/users/:id/posts/:post", simpleHandler)
+	router.GET("/users", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+	router.GET("/dir/", simpleHandler)
+	router.With(WithMetadata("meta")).GET("/meta", simpleHandler)
+	router.With(MatchHeader("X-Beta", "yes")).GET("/beta", simpleHandler)
+
+	arena := make(Params, 0, 4)
+	for _, test := range []struct {
+		method, path string
+		found        bool
+		route        string
+		params       Params
+	}{
+		{"GET", "/users/abc/posts/7", true, "/users/:id/posts/:post", Params{{"id", "abc"}, {"post", "7"}}},
+		{"GET", "/users", true, "/users", Params{}},
+		{"HEAD", "/users", true, "/users", Params{}},
+		{"GET", "/files/a%2Fb/c", true, "/files/*path", Params{{"path", "a/b/c"}}},
+		{"GET", "/dir/", true, "/dir/", Params{}},
+		{"GET", "/dir", false, "", Params{}},
+		{"POST", "/users", false, "", Params{}},
+		{"GET", "/missing", false, "", Params{}},
+		{"GET", "/beta", false, "", Params{}},
+		{"GET", "users", false, "", Params{}},
+		{"GET", "", false, "", Params{}},
+	} {
+		match, found := router.LookupBytes(test.method, []byte(test.path), arena)
+		if found != test.found || match.Route != test.route {
+			t.Errorf("%s %s: expected %v %q, saw %v %q", test.method, test.path, test.found, test.route,
+				found, match.Route)
+		}
+		if found != (match.Handler != nil) {
+			t.Errorf("%s %s: expected a handler only when found", test.method, test.path)
+		}
+		if fmt.Sprint(match.Params) != fmt.Sprint(test.params) {
+			t.Errorf("%s %s: expected params %v, saw %v", test.method, test.path, test.params, match.Params)
+		}
+		if cap(match.Params) != cap(arena) {
+			t.Errorf("%s %s: expected the params to be written into the arena", test.method, test.path)
+		}
+	}
+
+	if match, _ := router.LookupBytes("GET", []byte("/meta"), nil); match.Metadata != "meta" {
+		t.Errorf("Expected the route's metadata, saw %v", match.Metadata)
+	}
+
+	path := []byte("/users")
+	allocs := testing.AllocsPerRun(100, func() {
+		router.LookupBytes("GET", path, arena)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations for a static route, saw %v", allocs)
+	}
+}
+
+func BenchmarkLookupBytes(b *testing.B) {
+	router := New()
+	router.GET("/user/:name", simpleHandler)
+	path := []byte("/user/gordon")
+	arena := make(Params, 0, 4)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.LookupBytes("GET", path, arena)
+	}
+}
@@ -0,0 +1,20 @@
+package httptreemux
+
+// MatchStats counts the work done to match a request path against the routing tree. It is
+// recorded in LookupResult.MatchStats when TreeMux.Debug or TreeMux.RecordMatchStats is true,
+// so that the instrumentation hooks can find the URLs which are disproportionately expensive
+// to match, because of the shape of the route table.
+type MatchStats struct {
+	// NodesVisited is the number of tree nodes which the search entered.
+	NodesVisited int
+	// Depth is the deepest level of the tree that the search reached, where the children of
+	// the root are at level 1.
+	Depth int
+	// Fallbacks is the number of times the search tried a wildcard or catch-all node, because
+	// no static node had matched the rest of the path.
+	Fallbacks int
+	// Backtracks is the number of times the search found no handler below a node and went
+	// back to try another. A route continuing after a catch-all is tried at each slash of the
+	// rest of the path, so paths with many segments can cause many backtracks.
+	Backtracks int
+}
@@ -0,0 +1,55 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchStats(t *testing.T) {
+	router := New()
+	router.GET("/users/:id/posts", simpleHandler)
+	router.GET("/users/new", simpleHandler)
+	router.GET("/files/*path/meta", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+
+	r, _ := newRequest("GET", "/users/new", nil)
+	if lr, _ := router.Lookup(httptest.NewRecorder(), r); lr.MatchStats != nil {
+		t.Errorf("Expected no stats by default, saw %+v", *lr.MatchStats)
+	}
+
+	router.RecordMatchStats = true
+	for _, test := range []struct {
+		path     string
+		code     int
+		expected MatchStats
+	}{
+		{"/users/new", http.StatusOK, MatchStats{NodesVisited: 4, Depth: 3}},
+		// The static node for "new" is searched before falling back to the wildcard.
+		{"/users/newer/posts", http.StatusOK, MatchStats{NodesVisited: 7, Depth: 5, Fallbacks: 1, Backtracks: 1}},
+		{"/users/1/posts", http.StatusOK, MatchStats{NodesVisited: 6, Depth: 5, Fallbacks: 1}},
+		{"/files/a/b/c/meta", http.StatusOK, MatchStats{NodesVisited: 6, Depth: 5, Fallbacks: 1}},
+		// The route after the catch-all is tried at each slash before the catch-all route.
+		{"/files/a/b/c/d", http.StatusOK, MatchStats{NodesVisited: 10, Depth: 4, Fallbacks: 4, Backtracks: 3}},
+	} {
+		r, _ := newRequest("GET", test.path, nil)
+		lr, _ := router.Lookup(httptest.NewRecorder(), r)
+		if lr.StatusCode != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, lr.StatusCode)
+		}
+		if lr.MatchStats == nil || *lr.MatchStats != test.expected {
+			t.Errorf("%s: expected stats %+v, saw %+v", test.path, test.expected, lr.MatchStats)
+		}
+	}
+
+	var served *MatchStats
+	router.OnRouteServed = func(r *http.Request, lr LookupResult, elapsed time.Duration) {
+		served = lr.MatchStats
+	}
+	r, _ = newRequest("GET", "/users/new", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if served == nil || served.NodesVisited != 4 {
+		t.Errorf("Expected OnRouteServed to receive the stats, saw %+v", served)
+	}
+}
@@ -0,0 +1,83 @@
+package httptreemux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MethodPolicy sets how the router treats methods which are not valid tokens as defined by
+// RFC 9110, such as the garbage that scanners send, and methods in lowercase.
+type MethodPolicy int
+
+const (
+	// PassMethods matches every method as it is, and allows routes with any method. Go's
+	// HTTP server already rejects requests whose method is not a token, but requests built in
+	// other ways, or received by other servers, may have any method.
+	PassMethods MethodPolicy = iota
+	// RejectInvalidMethods answers requests whose method is not a token with 501 Not
+	// Implemented, without matching them against the routes, and refuses to add routes whose
+	// method is not a token.
+	RejectInvalidMethods
+	// NormalizeMethodCase is like RejectInvalidMethods, but also converts the methods of
+	// routes and requests to uppercase, so that a request for "get" is served by the GET
+	// route and its handler sees a method of "GET".
+	NormalizeMethodCase
+)
+
+// isTokenChar returns true if c is a tchar in the token grammar of RFC 9110, section 5.6.2.
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+// validMethod returns true if the method is a token, which includes MethodAny.
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		if !isTokenChar(method[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// routeMethod returns the method to register a route with under the MethodPolicy, or an error
+// if it is not allowed.
+func (t *TreeMux) routeMethod(method string) (string, error) {
+	if t.MethodPolicy == PassMethods {
+		return method, nil
+	}
+	if !validMethod(method) {
+		return "", fmt.Errorf("method %q is not a valid token", method)
+	}
+	if t.MethodPolicy == NormalizeMethodCase {
+		method = strings.ToUpper(method)
+	}
+	return method, nil
+}
+
+// normalizeMethod returns the request with its method in uppercase if the MethodPolicy is
+// NormalizeMethodCase and the method is a token. Otherwise it returns the request unchanged.
+func (t *TreeMux) normalizeMethod(r *http.Request) *http.Request {
+	if t.MethodPolicy != NormalizeMethodCase || !validMethod(r.Method) {
+		return r
+	}
+	upper := strings.ToUpper(r.Method)
+	if upper == r.Method {
+		return r
+	}
+	normalized := new(http.Request)
+	*normalized = *r
+	normalized.Method = upper
+	return normalized
+}
+
+func notImplementedHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+}
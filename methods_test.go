@@ -0,0 +1,86 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodPolicy(t *testing.T) {
+	var served string
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		served = r.Method
+	}
+
+	for _, test := range []struct {
+		policy MethodPolicy
+		method string
+		code   int
+		served string
+	}{
+		{PassMethods, "GET", http.StatusOK, "GET"},
+		{PassMethods, "get", http.StatusMethodNotAllowed, ""},
+		{PassMethods, "GE T", http.StatusMethodNotAllowed, ""},
+		{RejectInvalidMethods, "GET", http.StatusOK, "GET"},
+		{RejectInvalidMethods, "get", http.StatusMethodNotAllowed, ""},
+		{RejectInvalidMethods, "GE T", http.StatusNotImplemented, ""},
+		{RejectInvalidMethods, "", http.StatusNotImplemented, ""},
+		{RejectInvalidMethods, "G\x00T", http.StatusNotImplemented, ""},
+		{RejectInvalidMethods, "PROPFIND", http.StatusMethodNotAllowed, ""},
+		{NormalizeMethodCase, "get", http.StatusOK, "GET"},
+		{NormalizeMethodCase, "Get", http.StatusOK, "GET"},
+		{NormalizeMethodCase, "GE(T", http.StatusNotImplemented, ""},
+	} {
+		router := New()
+		router.MethodPolicy = test.policy
+		router.GET("/items", handler)
+
+		served = ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", "/items", nil)
+		r.Method = test.method
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("policy %d, method %q: expected code %d, saw %d", test.policy, test.method, test.code, w.Code)
+		}
+		if served != test.served {
+			t.Errorf("policy %d, method %q: expected the handler to see %q, saw %q",
+				test.policy, test.method, test.served, served)
+		}
+
+		lr, found := router.Lookup(nil, r)
+		if found != (test.code == http.StatusOK) || lr.StatusCode != test.code {
+			t.Errorf("policy %d, method %q: Lookup returned %d, %v", test.policy, test.method, lr.StatusCode, found)
+		}
+		if r.Method != test.method {
+			t.Errorf("policy %d: the request's method was changed to %q", test.policy, r.Method)
+		}
+	}
+}
+
+func TestMethodPolicyRegistration(t *testing.T) {
+	router := New()
+	router.MethodPolicy = RejectInvalidMethods
+	if err := router.TryHandle("GE T", "/items", simpleHandler); err == nil {
+		t.Error("Expected an error for a method which is not a token")
+	}
+	if err := router.TryHandle(MethodAny, "/any", simpleHandler); err != nil {
+		t.Errorf("Unexpected error for MethodAny: %v", err)
+	}
+	if routes := router.Routes(); len(routes) != 1 {
+		t.Errorf("Expected only the MethodAny route, saw %v", routes)
+	}
+
+	router = New()
+	router.MethodPolicy = NormalizeMethodCase
+	router.Handle("post", "/items", simpleHandler)
+	if _, found := router.LookupBytes("POST", []byte("/items"), nil); !found {
+		t.Error("Expected the route to be registered as POST")
+	}
+	if _, found := router.LookupBytes("post", []byte("/items"), nil); !found {
+		t.Error("Expected LookupBytes to normalize the method")
+	}
+	if !router.Remove("Post", "/items") {
+		t.Error("Expected Remove to normalize the method")
+	}
+}
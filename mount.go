@@ -0,0 +1,154 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mount forwards all requests under path to handler, with path removed from the request URL.
+// The handler can be any http.Handler, such as a handler from a third-party package or another
+// TreeMux. The prefix is removed from URL.Path, URL.RawPath and RequestURI, so a TreeMux mounted
+// this way matches routes relative to the mount point using either PathSource.
+//
+// A request for the mount point itself is forwarded with a path of "/". As with other patterns
+// ending in a slash, when RedirectTrailingSlash is true, requests for the mount point without the
+// trailing slash are redirected to the version with it.
+//
+// Since the handler is registered for MethodAny, requests under the mount point with any
+// method, including WebDAV and other extension methods, never reach this router's
+// NotFoundHandler or MethodNotAllowedHandler; the mounted handler is responsible for those
+// responses. Routes added to this router under the mount point take precedence over the
+// mounted handler, following the usual priority rules.
+//
+// The mount point can be in a group whose path has wildcards, such as "/tenants/:tenant", in
+// which case as many path segments are removed as the prefix has. It can not contain a
+// catch-all.
+//
+//	admin := httptreemux.New()
+//	admin.GET("/users", listUsers)
+//
+//	router := httptreemux.New()
+//	router.Mount("/admin", admin) // GET /admin/users calls listUsers
+//
+// The handler can find the prefix that it was mounted at from the request's context with
+// ContextMount, or ContextRouteData.Mount in a ContextMux.
+func (g *Group) Mount(path string, handler http.Handler) {
+	g.MountWithMetadata(path, handler, nil)
+}
+
+// MountPoint describes where a handler added with Mount or MountWithMetadata was mounted.
+type MountPoint struct {
+	// Prefix is the full path of the mount point, including the prefix of its group, without
+	// a trailing slash. Wildcards in the group's path appear as they were given, like
+	// "/tenants/:tenant/files".
+	Prefix string
+	// Metadata is the value given to MountWithMetadata, or nil.
+	Metadata interface{}
+}
+
+// MountWithMetadata is like Mount, but also attaches a value to the mount point, which the
+// handler finds in the MountPoint of its requests. This lets the same handler be mounted at
+// several prefixes and adapt its behavior to each, such as a router serving two versions of
+// an API:
+//
+//	api := httptreemux.NewContextMux()
+//	api.GET("/users", func(w http.ResponseWriter, r *http.Request) {
+//	    version := httptreemux.ContextData(r.Context()).Mount().Metadata.(string)
+//	    ...
+//	})
+//
+//	router.MountWithMetadata("/v1", api, "v1")
+//	router.MountWithMetadata("/v2", api, "v2")
+//
+// When handlers are mounted within each other, the requests have the innermost mount point.
+func (g *Group) MountWithMetadata(path string, handler http.Handler, metadata interface{}) {
+	checkPath(path)
+	path = strings.TrimRight(path, "/")
+	prefix := g.path + path
+	escapedPrefix := (&url.URL{Path: prefix}).EscapedPath()
+	mount := &MountPoint{Prefix: prefix, Metadata: metadata}
+
+	// The number of segments to remove from the path, for a prefix with wildcards.
+	segments := -1
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "" {
+			continue
+		}
+		switch segment[0] {
+		case '*':
+			panic("Mount point " + prefix + " can not contain a catch-all")
+		case ':':
+			segments = strings.Count(prefix, "/")
+		}
+	}
+
+	mounted := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		handler.ServeHTTP(w, requestWithMount(stripMountPrefix(r, prefix, escapedPrefix, segments), mount))
+	}
+
+	g.Handle(MethodAny, path+"/*", mounted)
+	g.Handle(MethodAny, path+"/", mounted)
+	if !g.mux.RedirectTrailingSlash && prefix != "" {
+		g.Handle(MethodAny, path, mounted)
+	}
+}
+
+// stripMountPrefix returns a copy of the request with the prefix removed from its path. If
+// segments is not negative, the prefix has wildcards, and that many segments are removed
+// instead.
+func stripMountPrefix(r *http.Request, prefix, escapedPrefix string, segments int) *http.Request {
+	stripped := new(http.Request)
+	*stripped = *r
+
+	u := *r.URL
+	if segments >= 0 {
+		// The values of the wildcards may contain escaped slashes, so the segments are
+		// removed from the escaped path.
+		rest := trimMountSegments(u.EscapedPath(), segments)
+		u.Path = rest
+		if unescaped, err := unescape(rest); err == nil {
+			u.Path = unescaped
+		}
+		if u.RawPath != "" {
+			u.RawPath = rest
+		}
+	} else {
+		u.Path = trimMountPrefix(u.Path, prefix)
+		if u.RawPath != "" {
+			u.RawPath = trimMountPrefix(u.RawPath, escapedPrefix)
+		}
+	}
+	stripped.URL = &u
+	stripped.RequestURI = u.RequestURI()
+
+	return stripped
+}
+
+func trimMountPrefix(path, prefix string) string {
+	// The prefix is compared case-insensitively since it may have been matched
+	// by a router with CaseInsensitive set.
+	if len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+		path = path[len(prefix):]
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// trimMountSegments removes the first segments of a path.
+func trimMountSegments(path string, segments int) string {
+	for ; segments > 0 && path != ""; segments-- {
+		next := strings.IndexByte(path[1:], '/')
+		if next == -1 {
+			path = ""
+			break
+		}
+		path = path[next+1:]
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,141 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	for _, scenario := range scenarios {
+		t.Log(scenario.description)
+		testMount(t, scenario.RequestCreator)
+	}
+}
+
+func testMount(t *testing.T, newRequest RequestCreator) {
+	var matched, matchedParam string
+	admin := New()
+	admin.GET("/", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		matched = "admin index"
+	})
+	admin.GET("/users/:name", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		matched = "admin user"
+		matchedParam = params["name"]
+	})
+
+	var seenPath string
+	router := New()
+	router.GET("/admin/override", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		matched = "override"
+	})
+	router.Mount("/admin", admin)
+	router.NewGroup("/api").Mount("/raw/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matched = "raw " + r.Method
+		seenPath = r.URL.Path
+	}))
+
+	for _, test := range []struct {
+		method, path string
+		expectedCode int
+		expected     string
+	}{
+		{"GET", "/admin/", 200, "admin index"},
+		{"GET", "/admin", 301, ""},
+		{"GET", "/admin/users/dimfeld", 200, "admin user"},
+		{"GET", "/admin/override", 200, "override"},
+		{"GET", "/admin/missing", 404, ""},
+		{"POST", "/admin/users/dimfeld", 405, ""},
+		{"GET", "/api/raw/a/b", 200, "raw GET"},
+		{"DELETE", "/api/raw/", 200, "raw DELETE"},
+		{"PROPFIND", "/api/raw/a", 200, "raw PROPFIND"},
+		{"PROPFIND", "/admin/users/dimfeld", 405, ""},
+	} {
+		matched = ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.expectedCode || matched != test.expected {
+			t.Errorf("%s %s: expected %d %q, saw %d %q", test.method, test.path,
+				test.expectedCode, test.expected, w.Code, matched)
+		}
+	}
+
+	r, _ := newRequest("GET", "/api/raw/a/b", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if seenPath != "/a/b" {
+		t.Errorf("Expected mounted handler to see /a/b, saw %s", seenPath)
+	}
+
+	// Escaped slashes survive the prefix removal when using RequestURI.
+	if r, _ := newRequest("GET", "/admin/users/abc%2Fdef", nil); r.RequestURI != "" {
+		matchedParam = ""
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if matchedParam != "abc/def" {
+			t.Errorf("Expected escaped slash in parameter, saw %q", matchedParam)
+		}
+	}
+}
+
+func TestMountWithoutTrailingSlashRedirect(t *testing.T) {
+	var seenPath string
+	router := New()
+	router.RedirectTrailingSlash = false
+	router.Mount("/static", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenPath = r.URL.Path
+	}))
+
+	for _, path := range []string{"/static", "/static/"} {
+		seenPath = ""
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || seenPath != "/" {
+			t.Errorf("%s: expected 200 with path /, saw %d %q", path, w.Code, seenPath)
+		}
+	}
+}
+
+func TestMountInWildcardGroup(t *testing.T) {
+	for _, scenario := range scenarios {
+		t.Log(scenario.description)
+
+		var seenPath, tenant string
+		router := New()
+		tenants := router.NewGroup("/tenants/:tenant")
+		tenants.Use(func(next HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				tenant = params["tenant"]
+				next(w, r, params)
+			}
+		})
+		tenants.Mount("/files", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenPath = r.URL.Path
+		}))
+
+		for path, expected := range map[string]string{
+			"/tenants/acme/files/a/b": "/a/b",
+			"/tenants/acme/files/":    "/",
+			"/tenants/a%2Fb/files/c":  "/c",
+		} {
+			r, _ := scenario.RequestCreator("GET", path, nil)
+			if strings.Contains(path, "%2F") && r.RequestURI == "" {
+				// Escaped slashes can only be told apart when matching on RequestURI.
+				continue
+			}
+			seenPath, tenant = "", ""
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			if seenPath != expected || tenant == "" {
+				t.Errorf("%s: expected the mounted handler to see %s, saw %q for tenant %q", path, expected, seenPath, tenant)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a mount point with a catch-all")
+		}
+	}()
+	New().NewGroup("/files/*path").Mount("/raw", http.NotFoundHandler())
+}
@@ -0,0 +1,73 @@
+package httptreemux
+
+import (
+	"sync"
+	"time"
+)
+
+// MutationStats describes how changes to the routes of a running router, such as Handle,
+// Remove and RegisterProvider calls, contend with the requests it serves. When
+// TreeMux.SafeAddRoutesWhileRunning is set, lookups hold a read lock on the router and each
+// change takes the write lock, so a change waits for the lookups in progress, and new lookups
+// wait while it holds the lock. Lookups never retry; they are only delayed. Operators can
+// compare these numbers with request latency to check that dynamic registration does not
+// degrade serving. Times are measured with TreeMux.Clock.
+type MutationStats struct {
+	// Writes is the number of times the write lock was taken.
+	Writes uint64
+	// WriterWait is the total time that changes waited for the write lock, for lookups in
+	// progress and other changes to finish, and MaxWriterWait is the longest single wait.
+	WriterWait    time.Duration
+	MaxWriterWait time.Duration
+	// LockHeld is the total time that changes held the write lock, during which lookups were
+	// blocked, and MaxLockHeld is the longest that a single change held it.
+	LockHeld    time.Duration
+	MaxLockHeld time.Duration
+}
+
+// routerMutex is the RWMutex of a router, which counts the use of its write lock.
+type routerMutex struct {
+	sync.RWMutex
+	// now is the router's clock. The stats are only changed while the write lock is held.
+	now      func() time.Time
+	acquired time.Time
+	stats    MutationStats
+}
+
+func (m *routerMutex) Lock() {
+	now := m.now
+	if now == nil {
+		now = time.Now
+	}
+	start := now()
+	m.RWMutex.Lock()
+	m.acquired = now()
+
+	wait := m.acquired.Sub(start)
+	m.stats.Writes++
+	m.stats.WriterWait += wait
+	if wait > m.stats.MaxWriterWait {
+		m.stats.MaxWriterWait = wait
+	}
+}
+
+func (m *routerMutex) Unlock() {
+	now := m.now
+	if now == nil {
+		now = time.Now
+	}
+	held := now().Sub(m.acquired)
+	m.stats.LockHeld += held
+	if held > m.stats.MaxLockHeld {
+		m.stats.MaxLockHeld = held
+	}
+	m.RWMutex.Unlock()
+}
+
+// MutationStats returns the statistics of the changes made to the router's routes since it
+// was created.
+func (t *TreeMux) MutationStats() MutationStats {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.mutex.stats
+}
@@ -0,0 +1,42 @@
+package httptreemux
+
+// Normalization records how the router transformed the path of a request before matching it
+// against the routing tree. It is only recorded when TreeMux.Debug is true, and can be
+// retrieved with ContextNormalization, or with ContextData(r.Context()).Normalization() in
+// context handlers. It is also available to NotFoundHandler and MethodNotAllowedHandler, so
+// that confusing 404 and 405 responses can be logged with exactly what the router saw.
+type Normalization struct {
+	// Original is the path taken from the request according to PathSource, before any
+	// transformations.
+	Original string
+	// Unescaped is the request's URL.Path.
+	Unescaped string
+	// Cleaned is the result of Clean on the path, if the path was not found and
+	// RedirectCleanPath made the router search for the cleaned version. It is empty otherwise.
+	Cleaned string
+	// Searched is the last path that was searched for in the routing tree.
+	Searched string
+	// Transformations lists the transformations which changed the path, in the order they
+	// were applied. The possible values are the Transform constants.
+	Transformations []string
+	// Candidates lists the patterns of every route for the request's method which matches
+	// Searched, in order of precedence, so the first is the route that was matched. More than
+	// one means that overlapping wildcard or catch-all routes could have matched the request,
+	// which is useful to audit route tables that depend on the routing priority.
+	Candidates []string
+}
+
+// The transformations recorded in Normalization.Transformations.
+const (
+	TransformStripFragment      = "strip-fragment"       // A raw fragment was removed, see FragmentBehavior
+	TransformLowercase          = "lowercase"            // The path was lowercased, see CaseInsensitive
+	TransformStripTrailingSlash = "strip-trailing-slash" // A trailing slash was removed, see RedirectTrailingSlash
+	TransformCleanPath          = "clean-path"           // The path was cleaned, see RedirectCleanPath
+	TransformStripLocale        = "strip-locale"         // A locale segment was removed, see Locales
+)
+
+func (n *Normalization) add(transformation string) {
+	if n != nil {
+		n.Transformations = append(n.Transformations, transformation)
+	}
+}
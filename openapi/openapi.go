@@ -0,0 +1,259 @@
+// Package openapi generates an OpenAPI 3 document describing the routes registered with a
+// httptreemux.TreeMux, so that the document can be served from the live router without
+// maintaining a separate list of routes.
+//
+//	router := httptreemux.NewContextMux()
+//	router.With(httptreemux.WithMetadata(openapi.Operation{
+//	    Summary: "Get a user",
+//	})).GET("/users/:id", getUser)
+//	router.Handler("GET", "/openapi.json", openapi.Handler(router.TreeMux, openapi.Info{
+//	    Title:   "Users",
+//	    Version: "1.0.0",
+//	}))
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+// Version is the version of the OpenAPI specification that generated documents follow.
+const Version = "3.0.3"
+
+// Document is the root of an OpenAPI document.
+type Document struct {
+	OpenAPI string              `json:"openapi"`
+	Info    Info                `json:"info"`
+	Servers []Server            `json:"servers,omitempty"`
+	Paths   map[string]PathItem `json:"paths"`
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server is a URL at which the API is served.
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path, keyed by lowercase method name.
+type PathItem map[string]*Operation
+
+// Operation describes a single route. Attaching an Operation or *Operation to a route with
+// httptreemux.WithMetadata adds its details to the generated document.
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter describes a parameter of an operation.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the body of a request.
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType describes the content of a request or response body of a particular type.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Schema is a subset of the OpenAPI schema object.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+// operationMethods are the methods that an OpenAPI path item can describe.
+var operationMethods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true,
+	"OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// New returns a document describing the routes registered with the router.
+//
+// Patterns are converted to OpenAPI path templates, so that both /users/:id and
+// /files/*path become path parameters, named id and path. Note that OpenAPI path parameters
+// can not contain slashes, so a catch-all can not be described exactly. Every path
+// parameter is documented as a required string unless the route's metadata declares it.
+//
+// Routes added to host-specific groups are omitted, as are routes for methods which
+// OpenAPI can not describe. HEAD routes that were added implicitly for GET routes are
+// also omitted. When routes with match options share a path and method, only the first
+// is described, which is the route without match options if there is one.
+func New(router *httptreemux.TreeMux, info Info) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+	}
+
+	for _, route := range router.Routes() {
+		if route.Host != "" || !operationMethods[route.Method] {
+			continue
+		}
+
+		// Path parameters are always required in OpenAPI, so a pattern with optional
+		// parameters is listed once for each number of them.
+		for _, pattern := range expandOptional(route.Pattern) {
+			path, params := convertPattern(pattern)
+			item := doc.Paths[path]
+			if item == nil {
+				item = PathItem{}
+				doc.Paths[path] = item
+			}
+			method := strings.ToLower(route.Method)
+			if _, ok := item[method]; !ok {
+				item[method] = newOperation(route.Metadata, params)
+			}
+		}
+	}
+
+	return doc
+}
+
+// Handler returns a handler which serves the document for the router as JSON. The
+// document is generated on each request, so it includes routes added after the handler.
+func Handler(router *httptreemux.TreeMux, info Info) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(New(router, info))
+	})
+}
+
+// newOperation creates the operation for a route, starting from the Operation in the
+// route's metadata if there is one.
+func newOperation(metadata interface{}, params []string) *Operation {
+	op := &Operation{}
+	switch m := metadata.(type) {
+	case Operation:
+		*op = m
+	case *Operation:
+		if m != nil {
+			*op = *m
+		}
+	}
+
+	// Copy the slices and maps from the metadata before adding to them, since the
+	// same Operation may be attached to more than one route.
+	declared := map[string]bool{}
+	op.Parameters = append([]Parameter(nil), op.Parameters...)
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			declared[p.Name] = true
+		}
+	}
+	for _, name := range params {
+		if !declared[name] {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+	if len(op.Parameters) == 0 {
+		op.Parameters = nil
+	}
+
+	if len(op.Responses) == 0 {
+		// The specification requires at least one response.
+		op.Responses = map[string]Response{
+			"default": {Description: "Default response"},
+		}
+	}
+
+	return op
+}
+
+// expandOptional returns the patterns that a pattern with optional trailing parameters, such
+// as /articles/:year/:month?, matches, from the shortest to the longest.
+func expandOptional(pattern string) []string {
+	segments := strings.Split(pattern, "/")
+	first := len(segments)
+	for first > 0 && isOptional(segments[first-1]) {
+		first--
+	}
+	if first == len(segments) {
+		return []string{pattern}
+	}
+
+	var patterns []string
+	for end := first; end <= len(segments); end++ {
+		for i := first; i < end; i++ {
+			segments[i] = strings.TrimSuffix(segments[i], "?")
+		}
+		p := strings.Join(segments[:end], "/")
+		if p == "" {
+			p = "/"
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+func isOptional(segment string) bool {
+	return len(segment) > 2 && segment[0] == ':' && segment[len(segment)-1] == '?'
+}
+
+// convertPattern converts a httptreemux pattern to an OpenAPI path template, and returns
+// the names of its parameters.
+func convertPattern(pattern string) (string, []string) {
+	segments := strings.Split(pattern, "/")
+	var params []string
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+
+		switch segment[0] {
+		case ':', '*':
+			name := segment[1:]
+			if bracket := strings.IndexByte(name, '['); segment[0] == '*' && bracket != -1 {
+				// Drop the extension set of a catch-all.
+				name = name[:bracket]
+			}
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		case '\\':
+			// An escaped : or * at the start of a segment is a literal character.
+			segments[i] = segment[1:]
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
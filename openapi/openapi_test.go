@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+func handler(w http.ResponseWriter, r *http.Request, params map[string]string) {}
+
+func TestNew(t *testing.T) {
+	router := httptreemux.New()
+	router.GET("/users", handler)
+	router.POST("/users", handler)
+	router.With(httptreemux.WithMetadata(Operation{
+		Summary:    "Get a user",
+		Parameters: []Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}}},
+		Responses:  map[string]Response{"200": {Description: "The user"}},
+	})).GET("/users/:id/posts/:post", handler)
+	router.GET("/files/*path", handler)
+	router.GET(`/\:literal`, handler)
+	router.GET("/archive/:year/:month?", handler)
+	router.Handle("PROPFIND", "/dav", handler)
+	router.Host("api.example.com").GET("/hosted", handler)
+
+	doc := New(router, Info{Title: "Test", Version: "1.0"})
+
+	if doc.OpenAPI != Version || doc.Info.Title != "Test" {
+		t.Errorf("Unexpected document header %s %+v", doc.OpenAPI, doc.Info)
+	}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	expectedPaths := map[string][]string{
+		"/users":                   {"get", "post"},
+		"/users/{id}/posts/{post}": {"get"},
+		"/files/{path}":            {"get"},
+		"/:literal":                {"get"},
+		"/archive/{year}":          {"get"},
+		"/archive/{year}/{month}":  {"get"},
+	}
+	if len(doc.Paths) != len(expectedPaths) {
+		t.Errorf("Expected paths %v, saw %v", expectedPaths, paths)
+	}
+	for path, methods := range expectedPaths {
+		item := doc.Paths[path]
+		if len(item) != len(methods) {
+			t.Errorf("%s: expected methods %v, saw %v", path, methods, item)
+		}
+		for _, method := range methods {
+			if item[method] == nil {
+				t.Errorf("%s: missing method %s", path, method)
+			}
+		}
+	}
+
+	op := doc.Paths["/users/{id}/posts/{post}"]["get"]
+	if op.Summary != "Get a user" {
+		t.Errorf("Expected summary from metadata, saw %q", op.Summary)
+	}
+	expectedParams := []Parameter{
+		{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}},
+		{Name: "post", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+	}
+	if !reflect.DeepEqual(op.Parameters, expectedParams) {
+		t.Errorf("Expected parameters %+v, saw %+v", expectedParams, op.Parameters)
+	}
+	if _, ok := op.Responses["200"]; !ok || len(op.Responses) != 1 {
+		t.Errorf("Expected responses from metadata, saw %v", op.Responses)
+	}
+
+	op = doc.Paths["/users"]["get"]
+	if op.Parameters != nil {
+		t.Errorf("Expected no parameters, saw %+v", op.Parameters)
+	}
+	if _, ok := op.Responses["default"]; !ok {
+		t.Errorf("Expected a default response, saw %v", op.Responses)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	router := httptreemux.NewContextMux()
+	router.Handler("GET", "/openapi.json", Handler(router.TreeMux, Info{Title: "Test", Version: "1.0"}))
+	// Added after the handler, so it is only included because the document is
+	// generated for each request.
+	router.GET("/late/:id", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, saw %s", ct)
+	}
+
+	var doc struct {
+		OpenAPI string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Decoding document: %s", err)
+	}
+	if doc.OpenAPI != Version {
+		t.Errorf("Expected openapi %s, saw %s", Version, doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/late/{id}"]["get"]; !ok {
+		t.Errorf("Expected /late/{id} in the document, saw %v", doc.Paths)
+	}
+	if _, ok := doc.Paths["/openapi.json"]["head"]; ok {
+		t.Error("Expected implicit HEAD routes to be omitted")
+	}
+}
@@ -0,0 +1,105 @@
+package httptreemux
+
+import "net/http"
+
+// RouteOption configures a route as it is registered. Options are attached to a group
+// with Group.With, and apply to every route registered through the returned group.
+type RouteOption func(*routeInfo)
+
+// WithMetadata attaches an arbitrary value to a route. The value is reported in the
+// Metadata member of the LookupResult for the route, including the one passed to
+// route-aware middleware, and by ContextData(r.Context()).Metadata() in context handlers.
+// This lets middleware and handlers look up things like the route's required permissions
+// or rate limit class without maintaining a separate table keyed by pattern.
+//
+// If WithMetadata is given more than once for a route, the last value is used.
+func WithMetadata(metadata interface{}) RouteOption {
+	return func(info *routeInfo) {
+		info.metadata = metadata
+	}
+}
+
+// WithRedirectBehavior overrides TreeMux.RedirectBehavior, TreeMux.RedirectMethodBehavior and
+// TreeMux.RedirectPolicy for requests which are redirected to a route because of
+// RedirectTrailingSlash or RedirectCleanPath.
+func WithRedirectBehavior(behavior RedirectBehavior) RouteOption {
+	return func(info *routeInfo) {
+		info.redirectBehavior = &behavior
+	}
+}
+
+// WithoutRedirects makes a route match only its exact path. Requests which would otherwise be
+// redirected to the route, because their trailing slash differs from the pattern or because
+// their path is not clean, are not found instead.
+//
+//	api := router.NewGroup("/api").With(httptreemux.WithoutRedirects())
+//	api.GET("/users", listUsers) // GET /api/users/ is not found
+func WithoutRedirects() RouteOption {
+	return func(info *routeInfo) {
+		info.noRedirects = true
+	}
+}
+
+// WithPatternEscaping overrides TreeMux.EscapeAddedRoutes for a route. With escape true,
+// the route is also added under the escaped version of its pattern, as with
+// EscapeAddedRoutes. With escape false, the pattern is treated as already escaped and added
+// only as it is given. This lets route manifests imported from systems with different
+// conventions be registered on the same router.
+//
+//	imported.With(httptreemux.WithPatternEscaping(false)).GET("/caf%C3%A9", cafe)
+func WithPatternEscaping(escape bool) RouteOption {
+	return func(info *routeInfo) {
+		info.escapePattern = &escape
+	}
+}
+
+// WithAliases registers a route under additional paths, which are relative to the group
+// like the route's own pattern. Requests to any of the paths are served by the route's
+// handler, and the route is reported under its own pattern, in the LookupResult and
+// ContextData for the request and by Routes, so that the alias paths can be treated as
+// the same route by middleware and analytics. The pattern and its aliases are added
+// atomically: if any of them conflicts with an existing route, none of them are added.
+//
+//	router.With(httptreemux.WithAliases("/ueber-uns", "/a-propos")).GET("/about", about)
+//
+// Aliases are removed along with the route when Remove is called with its pattern.
+func WithAliases(paths ...string) RouteOption {
+	return func(info *routeInfo) {
+		info.aliases = append(info.aliases, paths...)
+	}
+}
+
+// With returns a group with the same path and middleware as g, which applies the given
+// options to every route registered through it. The options are added to any the group
+// already has, and are inherited by groups created from it with NewGroup.
+//
+//	router.With(httptreemux.WithMetadata(Permission("admin"))).GET("/users/:id", getUser)
+func (g *Group) With(opts ...RouteOption) *Group {
+	return &Group{
+		path:    g.path,
+		mux:     g.mux,
+		host:    g.host,
+		stack:   g.stack[:len(g.stack):len(g.stack)],
+		options: append(g.options[:len(g.options):len(g.options)], opts...),
+	}
+}
+
+// newRouteInfo returns the registration details for a route added to the group, with
+// the group's options applied.
+func (g *Group) newRouteInfo(path string) *routeInfo {
+	info := &routeInfo{pattern: g.path + path}
+	for _, opt := range g.options {
+		opt(info)
+	}
+	return info
+}
+
+// lookupResult returns the LookupResult passed to route-aware middleware for the route.
+func (info *routeInfo) lookupResult() LookupResult {
+	return LookupResult{
+		StatusCode: http.StatusOK,
+		Route:      info.pattern,
+		Metadata:   info.metadata,
+		labels:     info.labels,
+	}
+}
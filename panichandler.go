@@ -10,6 +10,26 @@ import (
 	"strings"
 )
 
+// RoutePanicHandler handles a panic in the handler for a route added with WithPanicHandler.
+// The LookupResult identifies the route, with its pattern, parameters and metadata.
+type RoutePanicHandler func(w http.ResponseWriter, r *http.Request, lr LookupResult, err interface{})
+
+// WithPanicHandler recovers panics in the handlers of the routes it is given for, including
+// their middleware, and passes them to handler instead of TreeMux.PanicHandler. This lets
+// groups handle panics differently, for example to never show a stack trace to the clients
+// of some routes while internal tools show one.
+//
+//	payments := router.NewGroup("/payments").With(httptreemux.WithPanicHandler(
+//	    func(w http.ResponseWriter, r *http.Request, lr httptreemux.LookupResult, err interface{}) {
+//	        log.Printf("panic in %s %v: %v", lr.Route, lr.Params, err)
+//	        w.WriteHeader(http.StatusInternalServerError)
+//	    }))
+func WithPanicHandler(handler RoutePanicHandler) RouteOption {
+	return func(info *routeInfo) {
+		info.panicHandler = handler
+	}
+}
+
 // SimplePanicHandler just returns error 500.
 func SimplePanicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
 	w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,175 @@
+// Package paramgen generates typed accessor functions for the parameters of routes, so that
+// handlers read them through generated functions instead of looking up parameter names as
+// strings. It is meant to be called from a small program run by go generate, which lists the
+// routes of an application by name.
+//
+//	//go:generate go run ./cmd/genroutes
+//
+//	err := paramgen.Generate(file, "routes", []paramgen.Route{
+//	    paramgen.ForPattern("UserShow", "/users/:id"),
+//	    paramgen.ForPattern("OrgRepo", "/orgs/:org/repos/*path"),
+//	})
+//
+// generates functions such as
+//
+//	func UserShowParams(ctx context.Context) (id string)
+//	func OrgRepoParams(ctx context.Context) (org string, path string)
+//
+// which read the parameters from the httptreemux.ContextData of a request's context.
+package paramgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Route is a route to generate an accessor for.
+type Route struct {
+	// Name is the name of the route. The accessor is named Name followed by "Params", so
+	// Name should start with an upper case letter for the accessor to be exported.
+	Name string
+	// Params are the names of the route's parameters, in the order the accessor returns them.
+	Params []string
+}
+
+// ForPattern returns the Route with the given name and the parameters of a httptreemux route
+// pattern, such as "/users/:id" or "/files/*path".
+func ForPattern(name, pattern string) Route {
+	return Route{Name: name, Params: PatternParams(pattern)}
+}
+
+// PatternParams returns the names of the wildcards and catch-all of a route pattern, in order.
+func PatternParams(pattern string) []string {
+	var params []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:], "?")
+		if bracket := strings.IndexByte(name, '['); segment[0] == '*' && bracket != -1 {
+			name = name[:bracket]
+		}
+		params = append(params, name)
+	}
+	return params
+}
+
+// Generate writes the source of a Go file in package pkg, with an accessor function for each
+// of the routes which have parameters. It returns an error if a route's name is not a valid identifier, two routes
+// have the same name, or a route has the same parameter twice.
+func Generate(w io.Writer, pkg string, routes []Route) error {
+	if !isIdentifier(pkg) {
+		return fmt.Errorf("paramgen: invalid package name %q", pkg)
+	}
+
+	var accessors bytes.Buffer
+	names := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if !isIdentifier(route.Name) {
+			return fmt.Errorf("paramgen: invalid route name %q", route.Name)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("paramgen: duplicate route name %q", route.Name)
+		}
+		names[route.Name] = true
+
+		if err := writeAccessor(&accessors, route); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by httptreemux/paramgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+	if accessors.Len() != 0 {
+		buf.WriteString("\nimport (\n\t\"context\"\n\n\t\"github.com/dimfeld/httptreemux/v5\"\n)\n")
+		accessors.WriteTo(&buf)
+	}
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("paramgen: formatting generated code: %v", err)
+	}
+	_, err = w.Write(source)
+	return err
+}
+
+func writeAccessor(buf *bytes.Buffer, route Route) error {
+	vars := make([]string, len(route.Params))
+	used := make(map[string]bool, len(route.Params))
+	for i, param := range route.Params {
+		v := variableName(param)
+		if used[v] {
+			return fmt.Errorf("paramgen: route %s has parameters which are both named %s", route.Name, v)
+		}
+		used[v] = true
+		vars[i] = v
+	}
+
+	if len(vars) == 0 {
+		return nil
+	}
+
+	fn := route.Name + "Params"
+	fmt.Fprintf(buf, "\n// %s returns the parameters of the %s route from the request context.\n", fn, route.Name)
+
+	results := make([]string, len(vars))
+	for i, v := range vars {
+		results[i] = v + " string"
+	}
+	fmt.Fprintf(buf, "func %s(ctx context.Context) (%s) {\n", fn, strings.Join(results, ", "))
+	buf.WriteString("\tif cd := httptreemux.ContextData(ctx); cd != nil {\n")
+	for i, param := range route.Params {
+		fmt.Fprintf(buf, "\t\t%s = cd.Param(%q)\n", vars[i], param)
+	}
+	buf.WriteString("\t}\n\treturn\n}\n")
+	return nil
+}
+
+// variableName converts a parameter name to a Go identifier in lower camel case, which does not
+// clash with keywords or the names used by the generated code.
+func variableName(param string) string {
+	var b bytes.Buffer
+	upper := false
+	for _, r := range param {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_':
+			upper = b.Len() != 0
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "p" + name
+	}
+	switch name {
+	case "ctx", "cd", "context", "httptreemux":
+		return name + "Param"
+	}
+	if token.Lookup(name).IsKeyword() {
+		return name + "_"
+	}
+	return name
+}
+
+func isIdentifier(name string) bool {
+	if name == "" || token.Lookup(name).IsKeyword() {
+		return false
+	}
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
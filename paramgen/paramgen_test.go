@@ -0,0 +1,98 @@
+package paramgen
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	var buf bytes.Buffer
+	err := Generate(&buf, "routes", []Route{
+		ForPattern("UserShow", "/users/:id"),
+		ForPattern("Users", "/users"),
+		ForPattern("OrgRepo", "/orgs/:org/repos/*path"),
+		{Name: "Odd", Params: []string{"user-id", "type", "ctx", "2fa"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `// Code generated by httptreemux/paramgen. DO NOT EDIT.
+
+package routes
+
+import (
+	"context"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+// UserShowParams returns the parameters of the UserShow route from the request context.
+func UserShowParams(ctx context.Context) (id string) {
+	if cd := httptreemux.ContextData(ctx); cd != nil {
+		id = cd.Param("id")
+	}
+	return
+}
+
+// OrgRepoParams returns the parameters of the OrgRepo route from the request context.
+func OrgRepoParams(ctx context.Context) (org string, path string) {
+	if cd := httptreemux.ContextData(ctx); cd != nil {
+		org = cd.Param("org")
+		path = cd.Param("path")
+	}
+	return
+}
+
+// OddParams returns the parameters of the Odd route from the request context.
+func OddParams(ctx context.Context) (userId string, type_ string, ctxParam string, p2fa string) {
+	if cd := httptreemux.ContextData(ctx); cd != nil {
+		userId = cd.Param("user-id")
+		type_ = cd.Param("type")
+		ctxParam = cd.Param("ctx")
+		p2fa = cd.Param("2fa")
+	}
+	return
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nSaw:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := Generate(&buf, "routes", []Route{ForPattern("Home", "/")}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "// Code generated by httptreemux/paramgen. DO NOT EDIT.\n\npackage routes\n"; buf.String() != expected {
+		t.Errorf("Expected no imports without parameters, saw:\n%s", buf.String())
+	}
+
+	for _, routes := range [][]Route{
+		{{Name: "user-show", Params: []string{"id"}}},
+		{{Name: "A", Params: []string{"id"}}, {Name: "A", Params: []string{"id"}}},
+		{{Name: "A", Params: []string{"a-b", "a_b", "aB"}}},
+	} {
+		if err := Generate(&buf, "routes", routes); err == nil {
+			t.Errorf("Expected an error for %v", routes)
+		}
+	}
+	if err := Generate(&buf, "func", nil); err == nil {
+		t.Error("Expected an error for an invalid package name")
+	}
+}
+
+func TestPatternParams(t *testing.T) {
+	for pattern, expected := range map[string][]string{
+		"/":                       nil,
+		"/users/:id":              {"id"},
+		"/articles/:year/:month?": {"year", "month"},
+		"/orgs/:org/files/*path":  {"org", "path"},
+		"/static/*file[.js,.css]": {"file"},
+		`/\:literal`:              nil,
+	} {
+		if params := PatternParams(pattern); !reflect.DeepEqual(params, expected) {
+			t.Errorf("%s: expected %v, saw %v", pattern, expected, params)
+		}
+	}
+}
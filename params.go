@@ -0,0 +1,207 @@
+package httptreemux
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Param is a single path parameter, consisting of the name of a wildcard or catch-all in
+// the route's pattern and the value matched from the request path.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params is a list of path parameters, in the order in which they appear in the pattern.
+// Looking up a parameter by name scans the list, which is faster than a map lookup for
+// the small number of parameters in a typical route.
+type Params []Param
+
+// ByName returns the value of the parameter with the given name, or an empty string if
+// there is no such parameter.
+func (ps Params) ByName(name string) string {
+	value, _ := ps.lookup(name)
+	return value
+}
+
+func (ps Params) lookup(name string) (string, bool) {
+	for i := range ps {
+		if ps[i].Key == name {
+			return ps[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// ByNameOr returns the value of the parameter with the given name, or def if there is no
+// such parameter.
+func (ps Params) ByNameOr(name, def string) string {
+	if value, ok := ps.lookup(name); ok {
+		return value
+	}
+	return def
+}
+
+// ParamOr returns the value of a parameter from the map passed to a HandlerFunc, or def if
+// the map has no such parameter, such as when it is an optional parameter that was not
+// given. Reading a missing key from the map directly gives an empty string.
+func ParamOr(params map[string]string, name, def string) string {
+	if value, ok := params[name]; ok {
+		return value
+	}
+	return def
+}
+
+// Map returns the parameters as a newly allocated map, as passed to a HandlerFunc.
+func (ps Params) Map() map[string]string {
+	if len(ps) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(ps))
+	for _, p := range ps {
+		m[p.Key] = p.Value
+	}
+	return m
+}
+
+// ParamsHandlerFunc is a handler which receives the path parameters as Params instead of a map.
+// Like the parameters of context routes with TreeMux.PooledParams, the Params are reused once
+// the handler returns, so they must not be retained; use Map or copy them instead.
+type ParamsHandlerFunc func(http.ResponseWriter, *http.Request, Params)
+
+var paramsPool = sync.Pool{
+	New: func() interface{} {
+		ps := make(Params, 0, 4)
+		return &ps
+	},
+}
+
+// pooledParams fills a Params from the pool with the parameters returned by search,
+// which are in reverse order.
+func pooledParams(names []string, values []string) *Params {
+	ps := paramsPool.Get().(*Params)
+	for i := range names {
+		*ps = append(*ps, Param{Key: names[i], Value: values[len(values)-i-1]})
+	}
+	return ps
+}
+
+// newParams returns the parameters returned by search, which are in reverse order, as Params.
+func newParams(names []string, values []string) Params {
+	ps := make(Params, len(names))
+	for i := range names {
+		ps[i] = Param{Key: names[i], Value: values[len(values)-i-1]}
+	}
+	return ps
+}
+
+func releaseParams(ps *Params) {
+	for i := range *ps {
+		(*ps)[i] = Param{}
+	}
+	*ps = (*ps)[:0]
+	paramsPool.Put(ps)
+}
+
+// patternParamNames returns the names of the wildcards and catch-all in a pattern, in order.
+func patternParamNames(pattern string) []string {
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			names = append(names, segmentParamName(segment))
+		}
+	}
+	return names
+}
+
+// segmentParamName returns the name of the wildcard or catch-all in a pattern segment,
+// without the ? of an optional parameter or the extension set of a catch-all.
+func segmentParamName(segment string) string {
+	name := strings.TrimSuffix(segment[1:], "?")
+	if bracket := strings.IndexByte(name, '['); segment[0] == '*' && bracket != -1 {
+		name = name[:bracket]
+	}
+	return name
+}
+
+// HandleP is like Handle, but adds a handler which receives the path parameters as Params,
+// in the order in which they appear in the pattern. When the group has no middleware, the
+// parameters are passed to the handler without allocating a map. Otherwise the middleware
+// receives them as a map as usual, and the handler gets them converted back into Params.
+func (g *Group) HandleP(method, path string, handler ParamsHandlerFunc) {
+	if err := g.TryHandleP(method, path, handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryHandleP is like HandleP, but returns an error instead of panicking if the route can not
+// be added. See TryHandle for details.
+func (g *Group) TryHandleP(method, path string, handler ParamsHandlerFunc) error {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	path, err := g.mux.translatePattern(path)
+	if err != nil {
+		return err
+	}
+
+	info := g.newRouteInfo(path)
+	names := patternParamNames(info.pattern)
+	mapHandler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		var ps Params
+		if len(params) != 0 {
+			ps = make(Params, 0, len(names))
+			for _, name := range names {
+				if value, ok := params[name]; ok {
+					ps = append(ps, Param{Key: name, Value: value})
+				}
+			}
+		}
+		handler(w, r, ps)
+	}
+
+	info.handler = mapHandler
+	info.wrap = g.middlewareWrapper(info)
+	if len(g.stack) == 0 {
+		info.paramsHandler = handler
+		info.paramsRoute = true
+	}
+
+	return g.addFullStackHandler(method, path, info.wrap(mapHandler), info)
+}
+
+// Syntactic sugar for HandleP("GET", path, handler)
+func (g *Group) GETP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("GET", path, handler)
+}
+
+// Syntactic sugar for HandleP("POST", path, handler)
+func (g *Group) POSTP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("POST", path, handler)
+}
+
+// Syntactic sugar for HandleP("PUT", path, handler)
+func (g *Group) PUTP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("PUT", path, handler)
+}
+
+// Syntactic sugar for HandleP("DELETE", path, handler)
+func (g *Group) DELETEP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("DELETE", path, handler)
+}
+
+// Syntactic sugar for HandleP("PATCH", path, handler)
+func (g *Group) PATCHP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("PATCH", path, handler)
+}
+
+// Syntactic sugar for HandleP("HEAD", path, handler)
+func (g *Group) HEADP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("HEAD", path, handler)
+}
+
+// Syntactic sugar for HandleP("OPTIONS", path, handler)
+func (g *Group) OPTIONSP(path string, handler ParamsHandlerFunc) {
+	g.HandleP("OPTIONS", path, handler)
+}
@@ -0,0 +1,187 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParamsByName(t *testing.T) {
+	ps := Params{{"year", "2024"}, {"slug", "hello"}}
+	if v := ps.ByName("slug"); v != "hello" {
+		t.Errorf("Expected hello, saw %q", v)
+	}
+	if v := ps.ByName("missing"); v != "" {
+		t.Errorf("Expected empty string for a missing parameter, saw %q", v)
+	}
+	if v := ps.ByNameOr("missing", "default"); v != "default" {
+		t.Errorf("Expected the default for a missing parameter, saw %q", v)
+	}
+	if v := ps.ByNameOr("year", "default"); v != "2024" {
+		t.Errorf("Expected 2024, saw %q", v)
+	}
+	if v := ParamOr(map[string]string{"year": "2024"}, "month", "01"); v != "01" {
+		t.Errorf("Expected the default for a missing map parameter, saw %q", v)
+	}
+	if v := ParamOr(nil, "month", "01"); v != "01" {
+		t.Errorf("Expected the default for a nil map, saw %q", v)
+	}
+
+	expected := map[string]string{"year": "2024", "slug": "hello"}
+	if m := ps.Map(); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected map %v, saw %v", expected, m)
+	}
+	if m := (Params{}).Map(); m != nil {
+		t.Errorf("Expected nil map for no parameters, saw %v", m)
+	}
+}
+
+func TestPooledParams(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		router := NewContextMux()
+		router.PooledParams = pooled
+
+		var middlewareParams map[string]string
+		router.Use(func(next HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				middlewareParams = params
+				next(w, r, params)
+			}
+		})
+
+		var ordered Params
+		var params map[string]string
+		router.GET("/posts/:year/:month/*slug", func(w http.ResponseWriter, r *http.Request) {
+			data := ContextData(r.Context())
+			// Copy the slice, since it is reused after the handler returns.
+			ordered = append(Params(nil), data.OrderedParams()...)
+			params = data.Params()
+		})
+
+		var treeParams map[string]string
+		router.TreeMux.GET("/tree/:id", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			treeParams = params
+		})
+
+		r, _ := http.NewRequest("GET", "/posts/2024/05/a/b", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		expectedOrdered := Params{{"year", "2024"}, {"month", "05"}, {"slug", "a/b"}}
+		if !reflect.DeepEqual(ordered, expectedOrdered) {
+			t.Errorf("Pooled %v: expected ordered params %v, saw %v", pooled, expectedOrdered, ordered)
+		}
+		expectedMap := map[string]string{"year": "2024", "month": "05", "slug": "a/b"}
+		if !reflect.DeepEqual(params, expectedMap) {
+			t.Errorf("Pooled %v: expected params %v, saw %v", pooled, expectedMap, params)
+		}
+		if pooled && middlewareParams != nil {
+			t.Errorf("Expected middleware to receive a nil map with PooledParams, saw %v", middlewareParams)
+		} else if !pooled && !reflect.DeepEqual(middlewareParams, expectedMap) {
+			t.Errorf("Expected middleware to receive params %v, saw %v", expectedMap, middlewareParams)
+		}
+
+		result, _ := router.Lookup(nil, r)
+		if !reflect.DeepEqual(result.Params, expectedMap) {
+			t.Errorf("Pooled %v: expected Lookup params %v, saw %v", pooled, expectedMap, result.Params)
+		}
+
+		r, _ = http.NewRequest("GET", "/tree/1", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if treeParams["id"] != "1" {
+			t.Errorf("Pooled %v: expected HandlerFunc to receive a map, saw %v", pooled, treeParams)
+		}
+
+		ordered = nil
+		router.GET("/assets/*path[.js,.css]", func(w http.ResponseWriter, r *http.Request) {
+			ordered = append(Params(nil), ContextData(r.Context()).OrderedParams()...)
+		})
+		r, _ = http.NewRequest("GET", "/assets/x/y.js", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if expected := (Params{{"path", "x/y.js"}}); !reflect.DeepEqual(ordered, expected) {
+			t.Errorf("Pooled %v: expected ordered params %v for an extension catch-all, saw %v", pooled, expected, ordered)
+		}
+	}
+}
+
+func BenchmarkContextParams(b *testing.B) {
+	benchmark := func(b *testing.B, pooled bool) {
+		router := NewContextMux()
+		router.PooledParams = pooled
+		router.GET("/user/:name/:id", func(w http.ResponseWriter, r *http.Request) {
+			ContextData(r.Context()).OrderedParams().ByName("id")
+		})
+
+		r, _ := newRequest("GET", "/user/gordon/1234", nil)
+		benchRequest(b, router, r)
+	}
+
+	b.Run("map", func(b *testing.B) { benchmark(b, false) })
+	b.Run("pooled", func(b *testing.B) { benchmark(b, true) })
+}
+
+func TestHandleP(t *testing.T) {
+	router := New()
+	var ordered Params
+	handler := func(w http.ResponseWriter, r *http.Request, ps Params) {
+		ordered = append(Params(nil), ps...)
+	}
+	router.GETP("/posts/:year/:month/*slug", handler)
+	router.GETP("/assets/:version/*path[.js,.css]", handler)
+	router.POSTP("/static", handler)
+
+	var middlewareParams map[string]string
+	group := router.NewGroup("/api")
+	group.Use(func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			middlewareParams = params
+			next(w, r, params)
+		}
+	})
+	group.GETP("/users/:name/:id", handler)
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		expected Params
+	}{
+		{"GET", "/posts/2024/05/a/b", Params{{"year", "2024"}, {"month", "05"}, {"slug", "a/b"}}},
+		{"HEAD", "/posts/2024/05/a", Params{{"year", "2024"}, {"month", "05"}, {"slug", "a"}}},
+		{"GET", "/assets/2/x/y.js", Params{{"version", "2"}, {"path", "x/y.js"}}},
+		{"POST", "/static", nil},
+		{"GET", "/api/users/gordon/1234", Params{{"name", "gordon"}, {"id", "1234"}}},
+	} {
+		ordered = nil
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if !reflect.DeepEqual(ordered, test.expected) {
+			t.Errorf("%s %s: expected params %v, saw %v", test.method, test.path, test.expected, ordered)
+		}
+
+		// Results from Lookup carry a map, which is converted for the handler.
+		ordered = nil
+		result, _ := router.Lookup(nil, r)
+		router.ServeLookupResult(httptest.NewRecorder(), r, result)
+		if !reflect.DeepEqual(ordered, test.expected) {
+			t.Errorf("%s %s: expected params %v from Lookup, saw %v", test.method, test.path, test.expected, ordered)
+		}
+	}
+
+	expectedMap := map[string]string{"name": "gordon", "id": "1234"}
+	if !reflect.DeepEqual(middlewareParams, expectedMap) {
+		t.Errorf("Expected middleware to receive params %v, saw %v", expectedMap, middlewareParams)
+	}
+}
+
+func BenchmarkHandleP(b *testing.B) {
+	router := New()
+	router.GETP("/user/:name/:id", func(w http.ResponseWriter, r *http.Request, ps Params) {
+		ps.ByName("id")
+	})
+
+	r, _ := newRequest("GET", "/user/gordon/1234", nil)
+	benchRequest(b, router, r)
+}
@@ -0,0 +1,169 @@
+package httptreemux
+
+import (
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingParam is the error in a ParamError for a parameter which the route does not
+// have, or an optional parameter which was not given.
+var ErrMissingParam = errors.New("missing parameter")
+
+// ParamError is returned by the typed parameter accessors of ContextRouteData when a
+// parameter is missing or its value can not be converted.
+type ParamError struct {
+	// Name is the name of the parameter.
+	Name string
+	// Value is the value of the parameter in the request.
+	Value string
+	// Err is ErrMissingParam or the error from converting the value.
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	if e.Err == ErrMissingParam {
+		return "parameter " + e.Name + ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("parameter %s: invalid value %q: %v", e.Name, e.Value, e.Err)
+}
+
+// paramLookup returns the value of a parameter, and whether the request has it.
+type paramLookup func(name string) (string, bool)
+
+func (lookup paramLookup) int64(name string, bitSize int) (int64, error) {
+	value, ok := lookup(name)
+	if !ok {
+		return 0, &ParamError{Name: name, Err: ErrMissingParam}
+	}
+	n, err := strconv.ParseInt(value, 10, bitSize)
+	if err != nil {
+		return 0, &ParamError{Name: name, Value: value, Err: err.(*strconv.NumError).Err}
+	}
+	return n, nil
+}
+
+func (lookup paramLookup) bool(name string) (bool, error) {
+	value, ok := lookup(name)
+	if !ok {
+		return false, &ParamError{Name: name, Err: ErrMissingParam}
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ParamError{Name: name, Value: value, Err: err.(*strconv.NumError).Err}
+	}
+	return b, nil
+}
+
+func (lookup paramLookup) uuid(name string) ([16]byte, error) {
+	value, ok := lookup(name)
+	if !ok {
+		return [16]byte{}, &ParamError{Name: name, Err: ErrMissingParam}
+	}
+	uuid, err := parseUUID(value)
+	if err != nil {
+		return uuid, &ParamError{Name: name, Value: value, Err: err}
+	}
+	return uuid, nil
+}
+
+// parseUUID parses a UUID in the standard hyphenated form, such as
+// "123e4567-e89b-12d3-a456-426614174000", in either case.
+func parseUUID(s string) ([16]byte, error) {
+	var uuid [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return uuid, errors.New("invalid UUID format")
+	}
+
+	digits := strings.Replace(s, "-", "", -1)
+	if len(digits) != 32 {
+		return uuid, errors.New("invalid UUID format")
+	}
+	if _, err := hex.Decode(uuid[:], []byte(digits)); err != nil {
+		return [16]byte{}, errors.New("invalid UUID format")
+	}
+	return uuid, nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	uuidType            = reflect.TypeOf([16]byte{})
+)
+
+// decode sets the fields of the struct that dst points to from the parameters, using the
+// parameter names given in the fields' param tags.
+func (lookup paramLookup) decode(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("httptreemux: Decode requires a non-nil pointer to a struct")
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("param")
+		if name == "" || name == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return &ParamError{Name: name, Value: value, Err: err}
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if reflect.PtrTo(field.Type()).Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	if field.Type().ConvertibleTo(uuidType) && field.Kind() == reflect.Array {
+		uuid, err := parseUUID(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(uuid).Convert(field.Type()))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,718 @@
+package pathtree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind is the kind of a node, which decides how it matches a segment of a path.
+type Kind uint8
+
+const (
+	Static   Kind = iota // Matches its path exactly
+	Wildcard             // Matches one non-empty segment
+	CatchAll             // Matches one or more segments, or the rest of the path
+)
+
+// Node is a node of a routing tree. The tree is made of the nodes for the paths added with
+// Add, and of the nodes on the way to them, so each node only stores a part of a path.
+// Nodes are searched in order of precedence: static children first, in order of priority,
+// then the wildcard child, then the catch-all child.
+//
+// A Node is not safe for concurrent use while paths are added or removed.
+type Node struct {
+	path string
+
+	priority int
+
+	// The list of static children to check.
+	staticIndices []byte
+	staticChild   []*Node
+	// For nodes with many static children, the position in staticChild of the child
+	// starting with each byte, plus one, or zero if there is none. This saves scanning
+	// staticIndices in wide trees. It is nil for nodes with fewer children.
+	staticLookup *[256]uint16
+
+	// If none of the above match, check the wildcard children
+	wildcardChild *Node
+
+	// If none of the above match, then we use the catch-all, if applicable.
+	catchAllChild *Node
+
+	kind Kind
+	// For a catch-all declared with an extension set, such as *filepath[.js,.css], the
+	// extensions that the last segment of its value must have.
+	extensions []string
+
+	// The names of the parameters to apply.
+	wildcardNames []string
+
+	// Value is the data of the path which ends at the node, such as the handlers of a route,
+	// or nil if no path ends at it. Search only returns nodes with a value, and Prune removes
+	// the nodes which have none and no descendants with one.
+	Value interface{}
+}
+
+// MatchFunc decides whether a search for a key ends at a node with the value. If it returns
+// false, the search goes on to look for a better match.
+type MatchFunc func(value interface{}, key string) bool
+
+// ConflictError is returned by Add for a path which conflicts with one that was added before,
+// because the names of its parameters or of a catch-all at the same place differ.
+type ConflictError struct {
+	// Node is the node of the existing path, or its catch-all node.
+	Node   *Node
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// NewRoot returns the root node of an empty tree.
+func NewRoot() *Node {
+	return &Node{path: "/"}
+}
+
+// Path returns the part of the path stored at the node. For a catch-all node, this is the
+// name of the parameter with its extension set, if any, and without the *.
+func (n *Node) Path() string {
+	return n.path
+}
+
+// Priority returns the number of paths that have been added through the node, which orders
+// it among its static siblings.
+func (n *Node) Priority() int {
+	return n.priority
+}
+
+// Kind returns the kind of the node.
+func (n *Node) Kind() Kind {
+	return n.kind
+}
+
+// WildcardNames returns the names of the parameters of the path which ends at the node, in
+// the order they appear in it.
+func (n *Node) WildcardNames() []string {
+	return n.wildcardNames
+}
+
+// HasChildren returns whether any paths continue after the node.
+func (n *Node) HasChildren() bool {
+	return len(n.staticChild) != 0 || n.wildcardChild != nil || n.catchAllChild != nil
+}
+
+// Children returns the children of the node, in the order they are searched.
+func (n *Node) Children() []*Node {
+	children := make([]*Node, 0, len(n.staticChild)+2)
+	children = append(children, n.staticChild...)
+	if n.wildcardChild != nil {
+		children = append(children, n.wildcardChild)
+	}
+	if n.catchAllChild != nil {
+		children = append(children, n.catchAllChild)
+	}
+	return children
+}
+
+// Clear removes the value of the node, and the names of its parameters, so that the node
+// can be reused by a path with different ones.
+func (n *Node) Clear() {
+	n.Value = nil
+	n.wildcardNames = nil
+}
+
+func (n *Node) sortStaticChild(i int, changes *Changes) {
+	for i > 0 && n.staticChild[i].priority > n.staticChild[i-1].priority {
+		changes.reorder()
+		n.staticChild[i], n.staticChild[i-1] = n.staticChild[i-1], n.staticChild[i]
+		n.staticIndices[i], n.staticIndices[i-1] = n.staticIndices[i-1], n.staticIndices[i]
+		if n.staticLookup != nil {
+			n.staticLookup[n.staticIndices[i]] = uint16(i + 1)
+			n.staticLookup[n.staticIndices[i-1]] = uint16(i)
+		}
+		i -= 1
+	}
+}
+
+// Add returns the node for a path, adding it to the tree if necessary. The path does not
+// start with a slash; it is relative to n, which is usually the root. A segment starting
+// with : is a parameter, and one starting with * is a catch-all, which may be followed by an
+// extension set in brackets, such as *file[.js,.css], and by more segments. A backslash
+// before a : or * at the start of a segment makes it static. If changes is not nil, the
+// changes made to the tree are counted in it.
+func (n *Node) Add(path string, changes *Changes) (*Node, error) {
+	return n.add(path, nil, false, changes)
+}
+
+func (n *Node) add(path string, wildcards []string, inStaticToken bool, changes *Changes) (*Node, error) {
+	leaf := len(path) == 0
+	if leaf {
+		if wildcards != nil {
+			// Make sure the current wildcards are the same as the old ones.
+			// If not then we have an ambiguous path.
+			if n.wildcardNames != nil {
+				if len(n.wildcardNames) != len(wildcards) {
+					// This should never happen.
+					return nil, errors.New("Reached leaf node with differing wildcard array length. Please report this as a bug.")
+				}
+
+				for i := 0; i < len(wildcards); i++ {
+					if n.wildcardNames[i] != wildcards[i] {
+						return nil, &ConflictError{
+							Node: n,
+							Reason: fmt.Sprintf("Wildcards %v are ambiguous with wildcards %v",
+								n.wildcardNames, wildcards),
+						}
+					}
+				}
+			} else {
+				// No wildcards yet, so just add the existing set.
+				n.wildcardNames = wildcards
+			}
+		}
+
+		return n, nil
+	}
+
+	c := path[0]
+	nextSlash := strings.Index(path, "/")
+	var thisToken string
+	var tokenEnd int
+
+	if c == '/' {
+		// Done processing the previous token, so reset inStaticToken to false.
+		thisToken = "/"
+		tokenEnd = 1
+	} else if nextSlash == -1 {
+		thisToken = path
+		tokenEnd = len(path)
+	} else {
+		thisToken = path[0:nextSlash]
+		tokenEnd = nextSlash
+	}
+	remainingPath := path[tokenEnd:]
+
+	if c == '*' && !inStaticToken {
+		// Token starts with a *, so it's a catch-all. If more of the path follows it,
+		// that is added below the catch-all node, and matched against the end of the
+		// request path.
+		thisToken = thisToken[1:]
+		name, extensions, err := ParseCatchAll(thisToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if n.catchAllChild == nil {
+			n.catchAllChild = &Node{path: thisToken, kind: CatchAll, extensions: extensions}
+			changes.addNode()
+		}
+
+		if thisToken != n.catchAllChild.path {
+			return nil, &ConflictError{
+				Node: n.catchAllChild,
+				Reason: fmt.Sprintf("Catch-all name in %s doesn't match %s. You probably tried to define overlapping catchalls",
+					path, n.catchAllChild.path),
+			}
+		}
+
+		if wildcards == nil {
+			wildcards = []string{name}
+		} else {
+			wildcards = append(wildcards, name)
+		}
+
+		// An empty remaining path checks the wildcards against those of other paths which
+		// end with the catch-all.
+		return n.catchAllChild.add(remainingPath, wildcards, false, changes)
+	} else if c == ':' && !inStaticToken {
+		// Token starts with a :
+		thisToken = thisToken[1:]
+
+		if wildcards == nil {
+			wildcards = []string{thisToken}
+		} else {
+			wildcards = append(wildcards, thisToken)
+		}
+
+		if n.wildcardChild == nil {
+			n.wildcardChild = &Node{path: "wildcard", kind: Wildcard}
+			changes.addNode()
+		}
+
+		return n.wildcardChild.add(remainingPath, wildcards, false, changes)
+
+	} else {
+		// if strings.ContainsAny(thisToken, ":*") {
+		// 	panic("* or : in middle of path component " + path)
+		// }
+
+		unescaped := false
+		if len(thisToken) >= 2 && !inStaticToken {
+			if thisToken[0] == '\\' && (thisToken[1] == '*' || thisToken[1] == ':' || thisToken[1] == '\\') {
+				// The token starts with a character escaped by a backslash. Drop the backslash.
+				c = thisToken[1]
+				thisToken = thisToken[1:]
+				unescaped = true
+			}
+		}
+
+		// Set inStaticToken to ensure that the rest of this token is not mistaken
+		// for a wildcard if a prefix split occurs at a '*' or ':'.
+		inStaticToken = (c != '/')
+
+		// Do we have an existing node that starts with the same letter?
+		if i := n.staticChildIndex(c); i != -1 {
+			// Yes. Split it based on the common prefix of the existing
+			// node and the new one.
+			child, prefixSplit := n.splitCommonPrefix(i, thisToken, changes)
+
+			child.priority++
+			n.sortStaticChild(i, changes)
+			if unescaped {
+				// Account for the removed backslash.
+				prefixSplit++
+			}
+			return child.add(path[prefixSplit:], wildcards, inStaticToken, changes)
+		}
+
+		// No existing node starting with this letter, so create it.
+		child := &Node{path: thisToken}
+
+		if n.staticIndices == nil {
+			n.staticIndices = []byte{c}
+			n.staticChild = []*Node{child}
+		} else {
+			n.staticIndices = append(n.staticIndices, c)
+			n.staticChild = append(n.staticChild, child)
+		}
+		n.indexStaticChildren()
+		changes.addNode()
+		return child.add(remainingPath, wildcards, inStaticToken, changes)
+	}
+}
+
+// Find returns the node for a path which was previously added with Add, or nil if there is
+// none. It follows the same tokenization rules as Add.
+func (n *Node) Find(path string) *Node {
+	return n.find(path, false)
+}
+
+func (n *Node) find(path string, inStaticToken bool) *Node {
+	if len(path) == 0 {
+		return n
+	}
+
+	c := path[0]
+	nextSlash := strings.Index(path, "/")
+	var thisToken string
+	var remainingPath string
+
+	if c == '/' {
+		thisToken = "/"
+		remainingPath = path[1:]
+	} else if nextSlash == -1 {
+		thisToken = path
+	} else {
+		thisToken = path[0:nextSlash]
+		remainingPath = path[nextSlash:]
+	}
+
+	if c == '*' && !inStaticToken {
+		if n.catchAllChild == nil || thisToken[1:] != n.catchAllChild.path {
+			return nil
+		}
+		return n.catchAllChild.find(remainingPath, false)
+	} else if c == ':' && !inStaticToken {
+		if n.wildcardChild == nil {
+			return nil
+		}
+		return n.wildcardChild.find(remainingPath, false)
+	}
+
+	if len(thisToken) >= 2 && !inStaticToken {
+		if thisToken[0] == '\\' && (thisToken[1] == '*' || thisToken[1] == ':' || thisToken[1] == '\\') {
+			// Skip the escaping backslash, which Add does not store.
+			c = thisToken[1]
+			path = path[1:]
+		}
+	}
+	inStaticToken = (c != '/')
+
+	if i := n.staticChildIndex(c); i != -1 {
+		child := n.staticChild[i]
+		if !strings.HasPrefix(path, child.path) {
+			return nil
+		}
+		return child.find(path[len(child.path):], inStaticToken)
+	}
+
+	return nil
+}
+
+// staticIndexThreshold is the number of static children at which a node indexes them by
+// their first byte. Scanning staticIndices is faster for fewer children.
+const staticIndexThreshold = 8
+
+// staticChildIndex returns the position in staticChild of the child which starts with c,
+// or -1 if there is none.
+func (n *Node) staticChildIndex(c byte) int {
+	if n.staticLookup != nil {
+		return int(n.staticLookup[c]) - 1
+	}
+	for i, index := range n.staticIndices {
+		if index == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexStaticChildren updates staticLookup after a static child is added.
+func (n *Node) indexStaticChildren() {
+	if len(n.staticIndices) < staticIndexThreshold {
+		return
+	}
+	if n.staticLookup == nil {
+		n.staticLookup = new([256]uint16)
+	}
+	for i, c := range n.staticIndices {
+		n.staticLookup[c] = uint16(i + 1)
+	}
+}
+
+func (n *Node) splitCommonPrefix(existingNodeIndex int, path string, changes *Changes) (*Node, int) {
+	childNode := n.staticChild[existingNodeIndex]
+
+	if strings.HasPrefix(path, childNode.path) {
+		// No split needs to be done. Rather, the new path shares the entire
+		// prefix with the existing node, so the new node is just a child of
+		// the existing one. Or the new path is the same as the existing path,
+		// which means that we just move on to the next token. Either way,
+		// this return accomplishes that
+		return childNode, len(childNode.path)
+	}
+
+	var i int
+	// Find the length of the common prefix of the child node and the new path.
+	for i = range childNode.path {
+		if i == len(path) {
+			break
+		}
+		if path[i] != childNode.path[i] {
+			break
+		}
+	}
+
+	commonPrefix := path[0:i]
+	childNode.path = childNode.path[i:]
+
+	// Create a new intermediary node in the place of the existing node, with
+	// the existing node as a child.
+	newNode := &Node{
+		path:     commonPrefix,
+		priority: childNode.priority,
+		// Index is the first letter of the non-common part of the path.
+		staticIndices: []byte{childNode.path[0]},
+		staticChild:   []*Node{childNode},
+	}
+	n.staticChild[existingNodeIndex] = newNode
+	changes.split()
+
+	return newNode, i
+}
+
+// Search finds the node for a path, without its leading slash. It returns the first node in
+// order of precedence whose value match accepts for the key, and true. Otherwise, it returns
+// a node with a value which matches the path, if there is one, and false, so that the caller
+// can tell a path which matches with another key from one which does not match at all. The
+// parameter values are returned in reverse order, as they appear in the path, without being
+// unescaped. If stats is not nil, the work done is counted in it.
+func (n *Node) Search(key, path string, match MatchFunc, stats *Stats) (found *Node, ok bool, params []string) {
+	return n.search(key, path, match, stats, 0)
+}
+
+// search is Search for a node at the given depth of the tree, where the root is 0.
+func (n *Node) search(key, path string, match MatchFunc, stats *Stats, depth int) (found *Node, ok bool, params []string) {
+	stats.visit(depth)
+	// Whether a branch below n has already been searched, so trying another is a backtrack.
+	searched := false
+
+	pathLen := len(path)
+	if pathLen == 0 {
+		if n.Value == nil {
+			return nil, false, nil
+		} else {
+			return n, match(n.Value, key), nil
+		}
+	}
+
+	// First see if this matches a static token.
+	if i := n.staticChildIndex(path[0]); i != -1 {
+		child := n.staticChild[i]
+		childPathLen := len(child.path)
+		if pathLen >= childPathLen && child.path == path[:childPathLen] {
+			nextPath := path[childPathLen:]
+			found, ok, params = child.search(key, nextPath, match, stats, depth+1)
+			searched = true
+		}
+	}
+
+	// If we found a node and it matched, then return here. Otherwise
+	// let's remember that we found this one, but look for a better match.
+	if ok {
+		return
+	}
+
+	if n.wildcardChild != nil {
+		// Didn't find a static token, so check for a wildcard.
+		nextSlash := strings.IndexByte(path, '/')
+		if nextSlash < 0 {
+			nextSlash = pathLen
+		}
+
+		thisToken := path[0:nextSlash]
+		nextToken := path[nextSlash:]
+
+		if len(thisToken) > 0 { // Don't match on empty tokens.
+			stats.fallBack(searched)
+			searched = true
+			wcNode, wcOK, wcParams := n.wildcardChild.search(key, nextToken, match, stats, depth+1)
+			if wcOK || (found == nil && wcNode != nil) {
+				if wcParams == nil {
+					wcParams = []string{thisToken}
+				} else {
+					wcParams = append(wcParams, thisToken)
+				}
+
+				if wcOK {
+					return wcNode, true, wcParams
+				}
+
+				// Didn't actually find a match here, so remember that we
+				// found a node but also see if we can fall through to the
+				// catchall.
+				found = wcNode
+				params = wcParams
+			}
+		}
+	}
+
+	catchAllChild := n.catchAllChild
+	if catchAllChild != nil && catchAllChild.HasChildren() {
+		// Paths which continue after the catch-all. Try to match their remainder at
+		// each slash, starting from the end, so that the catch-all takes as much of the
+		// path as it can.
+		for end := strings.LastIndexByte(path, '/'); end > 0; end = strings.LastIndexByte(path[:end], '/') {
+			if !catchAllChild.acceptsValue(path[:end]) {
+				continue
+			}
+			stats.fallBack(searched)
+			searched = true
+			suffixNode, suffixOK, suffixParams := catchAllChild.search(key, path[end:], match, stats, depth+1)
+			if suffixNode == nil || (!suffixOK && found != nil) {
+				continue
+			}
+
+			suffixParams = append(suffixParams, path[:end])
+
+			if suffixOK {
+				return suffixNode, true, suffixParams
+			}
+			// Remember the node, unless a better match is found.
+			found = suffixNode
+			params = suffixParams
+		}
+	}
+
+	if catchAllChild != nil && catchAllChild.Value != nil && catchAllChild.acceptsValue(path) {
+		// Hit the catchall, so just assign the whole remaining path if it
+		// has a matching value.
+		stats.fallBack(searched)
+		stats.visit(depth + 1)
+		ok = match(catchAllChild.Value, key)
+		// Found a match, or we found a catchall node without one.
+		// Either way, return it since there's nothing left to check after this.
+		if ok || found == nil {
+			return catchAllChild, ok, []string{path}
+		}
+
+	}
+
+	return found, ok, params
+}
+
+// ParseCatchAll splits the token of a catch-all, without the *, into the parameter name and
+// the extension set given in brackets after it, if any: "filepath[.js,.css]" gives "filepath"
+// and [".js", ".css"].
+func ParseCatchAll(token string) (string, []string, error) {
+	open := strings.IndexByte(token, '[')
+	if open == -1 {
+		return token, nil, nil
+	}
+	if token[len(token)-1] != ']' || open == 0 {
+		return "", nil, fmt.Errorf("Invalid extension set in catch-all *%s", token)
+	}
+
+	extensions := strings.Split(token[open+1:len(token)-1], ",")
+	for i, ext := range extensions {
+		ext = strings.TrimSpace(ext)
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], "./[]") {
+			return "", nil, fmt.Errorf("Invalid extension %q in catch-all *%s", ext, token)
+		}
+		extensions[i] = ext
+	}
+	return token[:open], extensions, nil
+}
+
+// HasExtension returns true if a catch-all with the extension set can take the value,
+// because the set is empty or the last segment of the value ends with one of the extensions.
+func HasExtension(extensions []string, value string) bool {
+	if extensions == nil {
+		return true
+	}
+	segment := value[strings.LastIndexByte(value, '/')+1:]
+	for _, ext := range extensions {
+		if len(segment) > len(ext) && strings.EqualFold(segment[len(segment)-len(ext):], ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) acceptsValue(value string) bool {
+	return HasExtension(n.extensions, value)
+}
+
+// Matching returns every node with a value which matches the path, in the order of
+// precedence that Search uses, unlike Search which stops at the first match.
+func (n *Node) Matching(path string) []*Node {
+	return n.matchingNodes(path, nil)
+}
+
+// matchingNodes appends every node with a value that matches the path to found.
+func (n *Node) matchingNodes(path string, found []*Node) []*Node {
+	pathLen := len(path)
+	if pathLen == 0 {
+		if n.Value != nil {
+			found = append(found, n)
+		}
+		return found
+	}
+
+	if i := n.staticChildIndex(path[0]); i != -1 {
+		child := n.staticChild[i]
+		childPathLen := len(child.path)
+		if pathLen >= childPathLen && child.path == path[:childPathLen] {
+			found = child.matchingNodes(path[childPathLen:], found)
+		}
+	}
+
+	if n.wildcardChild != nil {
+		nextSlash := strings.IndexByte(path, '/')
+		if nextSlash < 0 {
+			nextSlash = pathLen
+		}
+		if nextSlash > 0 {
+			found = n.wildcardChild.matchingNodes(path[nextSlash:], found)
+		}
+	}
+
+	if n.catchAllChild != nil && n.catchAllChild.HasChildren() {
+		for end := strings.LastIndexByte(path, '/'); end > 0; end = strings.LastIndexByte(path[:end], '/') {
+			if n.catchAllChild.acceptsValue(path[:end]) {
+				found = n.catchAllChild.matchingNodes(path[end:], found)
+			}
+		}
+	}
+	if n.catchAllChild != nil && n.catchAllChild.Value != nil && n.catchAllChild.acceptsValue(path) {
+		found = append(found, n.catchAllChild)
+	}
+
+	return found
+}
+
+// Prune removes the descendants of the node which have no value and no descendants with
+// one, such as those left behind by a path which was removed, or by a registration which
+// failed part way through. It returns true if the node itself has neither.
+func (n *Node) Prune() bool {
+	if len(n.staticChild) != 0 {
+		indices, children := n.staticIndices[:0], n.staticChild[:0]
+		for i, child := range n.staticChild {
+			if !child.Prune() {
+				indices = append(indices, n.staticIndices[i])
+				children = append(children, child)
+			}
+		}
+		for i := len(children); i < len(n.staticChild); i++ {
+			n.staticChild[i] = nil
+		}
+		n.staticIndices, n.staticChild = indices, children
+		if len(children) == 0 {
+			n.staticIndices, n.staticChild = nil, nil
+		}
+		n.staticLookup = nil
+		n.indexStaticChildren()
+	}
+	if n.wildcardChild != nil && n.wildcardChild.Prune() {
+		n.wildcardChild = nil
+	}
+	if n.catchAllChild != nil && n.catchAllChild.Prune() {
+		n.catchAllChild = nil
+	}
+	return n.Value == nil && !n.HasChildren()
+}
+
+// RemoveCatchAll detaches the catch-all child of the node if it has no value and no
+// children, so that a catch-all with a different name can be added in its place. It returns
+// true if it did so.
+func (n *Node) RemoveCatchAll() bool {
+	if n.catchAllChild == nil || n.catchAllChild.Value != nil || n.catchAllChild.HasChildren() {
+		return false
+	}
+	n.catchAllChild = nil
+	return true
+}
+
+// Walk calls fn for the node and each of its descendants.
+func (n *Node) Walk(fn func(n *Node)) {
+	fn(n)
+	for _, child := range n.staticChild {
+		child.Walk(fn)
+	}
+	if n.wildcardChild != nil {
+		n.wildcardChild.Walk(fn)
+	}
+	if n.catchAllChild != nil {
+		n.catchAllChild.Walk(fn)
+	}
+}
+
+// Clone returns a copy of the node and its descendants which can be changed without
+// affecting them. The value of each copied node with a value is set by copyValue, which is
+// given the original value and the copy.
+func (n *Node) Clone(copyValue func(value interface{}, clone *Node) interface{}) *Node {
+	c := *n
+	c.staticIndices = append([]byte(nil), n.staticIndices...)
+	c.staticChild = make([]*Node, len(n.staticChild))
+	for i, child := range n.staticChild {
+		c.staticChild[i] = child.Clone(copyValue)
+	}
+	if n.staticLookup != nil {
+		lookup := *n.staticLookup
+		c.staticLookup = &lookup
+	}
+	if n.wildcardChild != nil {
+		c.wildcardChild = n.wildcardChild.Clone(copyValue)
+	}
+	if n.catchAllChild != nil {
+		c.catchAllChild = n.catchAllChild.Clone(copyValue)
+	}
+	c.extensions = append([]string(nil), n.extensions...)
+	c.wildcardNames = append([]string(nil), n.wildcardNames...)
+	if n.Value != nil {
+		c.Value = copyValue(n.Value, &c)
+	}
+	return &c
+}
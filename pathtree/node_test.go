@@ -0,0 +1,498 @@
+package pathtree
+
+import (
+	"fmt"
+	"testing"
+)
+
+// dumpTree returns a text representation of the node and its descendants.
+func dumpTree(n *Node, prefix, nodeType string) string {
+	line := fmt.Sprintf("%s %02d %s%s [%d] %v wildcards %v\n", prefix, n.priority, nodeType, n.path,
+		len(n.staticChild), n.Value, n.wildcardNames)
+	prefix += "  "
+	for _, child := range n.staticChild {
+		line += dumpTree(child, prefix, "")
+	}
+	if n.wildcardChild != nil {
+		line += dumpTree(n.wildcardChild, prefix, ":")
+	}
+	if n.catchAllChild != nil {
+		line += dumpTree(n.catchAllChild, prefix, "*")
+	}
+	return line
+}
+
+// matchAny is the MatchFunc of the tests, whose values are the paths that were added.
+func matchAny(value interface{}, key string) bool {
+	return true
+}
+
+func addPath(t *testing.T, tree *Node, path string) {
+	t.Logf("Adding path %s", path)
+	n, err := tree.Add(path[1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Value = path
+}
+
+var test *testing.T
+
+func testPath(t *testing.T, tree *Node, path string, expectPath string, expectedParams map[string]string) {
+	if t.Failed() {
+		t.Log(dumpTree(tree, "", " "))
+		t.FailNow()
+	}
+
+	t.Log("Testing", path)
+	n, ok, paramList := tree.Search("GET", path[1:], matchAny, nil)
+	for i, value := range paramList {
+		if unescaped, err := unescape(value); err == nil {
+			paramList[i] = unescaped
+		}
+	}
+	if expectPath != "" && n == nil {
+		t.Errorf("No match for %s, expected %s", path, expectPath)
+		return
+	} else if expectPath == "" && n != nil {
+		t.Errorf("Expected no match for %s but got %v with params %v", path, n, expectedParams)
+		t.Error("Node and subtree was\n" + dumpTree(n, "", " "))
+		return
+	}
+
+	if n == nil {
+		return
+	}
+
+	if !ok {
+		t.Errorf("Path %s returned valid node but ok was false", path)
+		t.Error("Node and subtree was\n" + dumpTree(n, "", " "))
+		return
+	}
+
+	matchedPath, _ := n.Value.(string)
+	if matchedPath != expectPath {
+		t.Errorf("Path %s matched %s, expected %s", path, matchedPath, expectPath)
+		t.Error("Node and subtree was\n" + dumpTree(n, "", " "))
+	}
+
+	if expectedParams == nil {
+		if len(paramList) != 0 {
+			t.Errorf("Path %s expected no parameters, saw %v", path, paramList)
+		}
+	} else {
+		if len(paramList) != len(n.wildcardNames) {
+			t.Errorf("Got %d params back but node specifies %d",
+				len(paramList), len(n.wildcardNames))
+		}
+
+		params := map[string]string{}
+		for i := 0; i < len(paramList); i++ {
+			params[n.wildcardNames[len(paramList)-i-1]] = paramList[i]
+		}
+		t.Log("\tGot params", params)
+
+		for key, val := range expectedParams {
+			sawVal, ok := params[key]
+			if !ok {
+				t.Errorf("Path %s matched without key %s", path, key)
+			} else if sawVal != val {
+				t.Errorf("Path %s expected param %s to be %s, saw %s", path, key, val, sawVal)
+			}
+
+			delete(params, key)
+		}
+
+		for key, val := range params {
+			t.Errorf("Path %s returned unexpected param %s=%s", path, key, val)
+		}
+	}
+
+}
+
+func checkHandlerNodes(t *testing.T, n *Node) {
+	hasHandlers := n.Value != nil
+	hasWildcards := len(n.wildcardNames) != 0
+
+	if hasWildcards && !hasHandlers {
+		t.Errorf("Node %s has wildcards without handlers", n.path)
+	}
+}
+
+func TestNodeSearch(t *testing.T) {
+	test = t
+	tree := NewRoot()
+
+	addPath(t, tree, "/")
+	addPath(t, tree, "/i")
+	addPath(t, tree, "/i/:aaa")
+	addPath(t, tree, "/images")
+	addPath(t, tree, "/images/abc.jpg")
+	addPath(t, tree, "/images/:imgname")
+	addPath(t, tree, "/images/\\*path")
+	addPath(t, tree, "/images/\\*patch")
+	addPath(t, tree, "/images/*path")
+	addPath(t, tree, "/ima")
+	addPath(t, tree, "/ima/:par")
+	addPath(t, tree, "/images1")
+	addPath(t, tree, "/images2")
+	addPath(t, tree, "/apples")
+	addPath(t, tree, "/app/les")
+	addPath(t, tree, "/apples1")
+	addPath(t, tree, "/appeasement")
+	addPath(t, tree, "/appealing")
+	addPath(t, tree, "/date/\\:year/\\:month")
+	addPath(t, tree, "/date/:year/:month")
+	addPath(t, tree, "/date/:year/month")
+	addPath(t, tree, "/date/:year/:month/abc")
+	addPath(t, tree, "/date/:year/:month/:post")
+	addPath(t, tree, "/date/:year/:month/*post")
+	addPath(t, tree, "/:page")
+	addPath(t, tree, "/:page/:index")
+	addPath(t, tree, "/post/:post/page/:page")
+	addPath(t, tree, "/plaster")
+	addPath(t, tree, "/users/:pk/:related")
+	addPath(t, tree, "/users/:id/updatePassword")
+	addPath(t, tree, "/:something/abc")
+	addPath(t, tree, "/:something/def")
+	addPath(t, tree, "/apples/ab:cde/:fg/*hi")
+	addPath(t, tree, "/apples/ab*cde/:fg/*hi")
+	addPath(t, tree, "/apples/ab\\*cde/:fg/*hi")
+	addPath(t, tree, "/apples/ab*dde")
+
+	testPath(t, tree, "/users/abc/updatePassword", "/users/:id/updatePassword",
+		map[string]string{"id": "abc"})
+	testPath(t, tree, "/users/all/something", "/users/:pk/:related",
+		map[string]string{"pk": "all", "related": "something"})
+
+	testPath(t, tree, "/aaa/abc", "/:something/abc",
+		map[string]string{"something": "aaa"})
+	testPath(t, tree, "/aaa/def", "/:something/def",
+		map[string]string{"something": "aaa"})
+
+	testPath(t, tree, "/paper", "/:page",
+		map[string]string{"page": "paper"})
+
+	testPath(t, tree, "/", "/", nil)
+	testPath(t, tree, "/i", "/i", nil)
+	testPath(t, tree, "/images", "/images", nil)
+	testPath(t, tree, "/images/abc.jpg", "/images/abc.jpg", nil)
+	testPath(t, tree, "/images/something", "/images/:imgname",
+		map[string]string{"imgname": "something"})
+	testPath(t, tree, "/images/long/path", "/images/*path",
+		map[string]string{"path": "long/path"})
+	testPath(t, tree, "/images/even/longer/path", "/images/*path",
+		map[string]string{"path": "even/longer/path"})
+	testPath(t, tree, "/ima", "/ima", nil)
+	testPath(t, tree, "/apples", "/apples", nil)
+	testPath(t, tree, "/app/les", "/app/les", nil)
+	testPath(t, tree, "/abc", "/:page",
+		map[string]string{"page": "abc"})
+	testPath(t, tree, "/abc/100", "/:page/:index",
+		map[string]string{"page": "abc", "index": "100"})
+	testPath(t, tree, "/post/a/page/2", "/post/:post/page/:page",
+		map[string]string{"post": "a", "page": "2"})
+	testPath(t, tree, "/date/2014/5", "/date/:year/:month",
+		map[string]string{"year": "2014", "month": "5"})
+	testPath(t, tree, "/date/2014/month", "/date/:year/month",
+		map[string]string{"year": "2014"})
+	testPath(t, tree, "/date/2014/5/abc", "/date/:year/:month/abc",
+		map[string]string{"year": "2014", "month": "5"})
+	testPath(t, tree, "/date/2014/5/def", "/date/:year/:month/:post",
+		map[string]string{"year": "2014", "month": "5", "post": "def"})
+	testPath(t, tree, "/date/2014/5/def/hij", "/date/:year/:month/*post",
+		map[string]string{"year": "2014", "month": "5", "post": "def/hij"})
+	testPath(t, tree, "/date/2014/5/def/hij/", "/date/:year/:month/*post",
+		map[string]string{"year": "2014", "month": "5", "post": "def/hij/"})
+
+	testPath(t, tree, "/date/2014/ab%2f", "/date/:year/:month",
+		map[string]string{"year": "2014", "month": "ab/"})
+	testPath(t, tree, "/post/ab%2fdef/page/2%2f", "/post/:post/page/:page",
+		map[string]string{"post": "ab/def", "page": "2/"})
+
+	// Test paths with escaped wildcard characters.
+	testPath(t, tree, "/images/*path", "/images/\\*path", nil)
+	testPath(t, tree, "/images/*patch", "/images/\\*patch", nil)
+	testPath(t, tree, "/date/:year/:month", "/date/\\:year/\\:month", nil)
+	testPath(t, tree, "/apples/ab*cde/lala/baba/dada", "/apples/ab*cde/:fg/*hi",
+		map[string]string{"fg": "lala", "hi": "baba/dada"})
+	testPath(t, tree, "/apples/ab\\*cde/lala/baba/dada", "/apples/ab\\*cde/:fg/*hi",
+		map[string]string{"fg": "lala", "hi": "baba/dada"})
+	testPath(t, tree, "/apples/ab:cde/:fg/*hi", "/apples/ab:cde/:fg/*hi",
+		map[string]string{"fg": ":fg", "hi": "*hi"})
+	testPath(t, tree, "/apples/ab*cde/:fg/*hi", "/apples/ab*cde/:fg/*hi",
+		map[string]string{"fg": ":fg", "hi": "*hi"})
+	testPath(t, tree, "/apples/ab*cde/one/two/three", "/apples/ab*cde/:fg/*hi",
+		map[string]string{"fg": "one", "hi": "two/three"})
+	testPath(t, tree, "/apples/ab*dde", "/apples/ab*dde", nil)
+
+	testPath(t, tree, "/ima/bcd/fgh", "", nil)
+	testPath(t, tree, "/date/2014//month", "", nil)
+	testPath(t, tree, "/date/2014/05/", "", nil) // Empty catchall should not match
+	testPath(t, tree, "/post//abc/page/2", "", nil)
+	testPath(t, tree, "/post/abc//page/2", "", nil)
+	testPath(t, tree, "/post/abc/page//2", "", nil)
+	testPath(t, tree, "//post/abc/page/2", "", nil)
+	testPath(t, tree, "//post//abc//page//2", "", nil)
+
+	t.Log("Test retrieval of duplicate paths")
+	p := "date/:year/:month/abc"
+	n, err := tree.Add(p, nil)
+	if n == nil {
+		t.Errorf("Duplicate add of %s didn't return a node: %v", p, err)
+	} else {
+		matchPath, _ := n.Value.(string)
+		if len(matchPath) < 2 || matchPath[1:] != p {
+			t.Errorf("Duplicate add of %s returned node for %s\n%s", p, matchPath,
+				dumpTree(n, "", " "))
+
+		}
+	}
+
+	tree.Walk(func(n *Node) {
+		checkHandlerNodes(t, n)
+	})
+
+	t.Log(dumpTree(tree, "", " "))
+	test = nil
+}
+
+func TestCatchAllTrailingSlash(t *testing.T) {
+	tree := NewRoot()
+	addPath(t, tree, "/abc/*path/")
+	addPath(t, tree, "/def/*path/ghi/")
+
+	testPath(t, tree, "/abc/x/", "/abc/*path/", map[string]string{"path": "x"})
+	testPath(t, tree, "/abc/x/y/", "/abc/*path/", map[string]string{"path": "x/y"})
+	testPath(t, tree, "/abc/x/y", "", nil)
+	testPath(t, tree, "/abc/", "", nil)
+	testPath(t, tree, "/def/x/y/ghi/", "/def/*path/ghi/", map[string]string{"path": "x/y"})
+	testPath(t, tree, "/def/x/ghi", "", nil)
+}
+
+func TestConflicts(t *testing.T) {
+	addPaths := func(p ...string) error {
+		tree := NewRoot()
+		for _, path := range p {
+			if _, err := tree.Add(path, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := addPaths("abc/*path/"); err != nil {
+		t.Errorf("Unexpected error with slash after catch-all: %v", err)
+	}
+
+	if err := addPaths("abc/*path/def"); err != nil {
+		t.Errorf("Unexpected error with path segment after catch-all: %v", err)
+	}
+
+	if err := addPaths("abc/*path/def", "abc/*rest/ghi"); err == nil {
+		t.Error("Expected an error when adding conflicting catch-alls with path segments after them")
+	}
+
+	if _, ok := addPaths("abc/*path", "abc/*paths").(*ConflictError); !ok {
+		t.Error("Expected a conflict when adding conflicting catch-alls")
+	}
+
+	if err := addPaths("abc/*path[.js", "abc/*path"); err == nil {
+		t.Error("Expected an error for an invalid extension set")
+	}
+
+	twoPathConflict := func(first, second string) {
+		if _, ok := addPaths(first, second).(*ConflictError); !ok {
+			t.Errorf("Expected a conflict with ambiguous wildcards on paths %s and %s", first, second)
+		}
+	}
+
+	twoPathConflict("abc/:ab/def/:cd", "abc/:ad/def/:cd")
+	twoPathConflict("abc/:ab/def/:cd", "abc/:ab/def/:ef")
+	twoPathConflict(":abc", ":def")
+	twoPathConflict(":abc/ggg", ":def/ggg")
+	twoPathConflict("abc/:ab/*path", "abc/:cd/*path")
+}
+
+func TestPrune(t *testing.T) {
+	tree := NewRoot()
+	addPath(t, tree, "/abc/def")
+	addPath(t, tree, "/abc/:id/ghi")
+	addPath(t, tree, "/files/*path/raw")
+
+	for _, path := range []string{"abc/:id/ghi", "files/*path/raw"} {
+		tree.Find(path).Clear()
+	}
+	if tree.Prune() {
+		t.Error("Expected the root to keep a child")
+	}
+	tree.Walk(func(n *Node) {
+		if n.Value == nil && !n.HasChildren() {
+			t.Errorf("Expected node %q to be pruned", n.path)
+		}
+	})
+	if tree.Find("abc/:id") != nil || tree.Find("files/*path") != nil {
+		t.Errorf("Expected the cleared paths to be removed\n%s", dumpTree(tree, "", " "))
+	}
+	testPath(t, tree, "/abc/def", "/abc/def", nil)
+
+	// The wildcards can now be added with other names.
+	addPath(t, tree, "/abc/:name/ghi")
+	addPath(t, tree, "/files/*file/raw")
+	testPath(t, tree, "/abc/x/ghi", "/abc/:name/ghi", map[string]string{"name": "x"})
+	testPath(t, tree, "/files/a/b/raw", "/files/*file/raw", map[string]string{"file": "a/b"})
+}
+
+func TestClone(t *testing.T) {
+	tree := NewRoot()
+	addPath(t, tree, "/abc/:id")
+	clone := tree.Clone(func(value interface{}, c *Node) interface{} {
+		return value.(string) + " (copy)"
+	})
+	addPath(t, clone, "/abc/def")
+
+	testPath(t, clone, "/abc/x", "/abc/:id (copy)", map[string]string{"id": "x"})
+	testPath(t, clone, "/abc/def", "/abc/def", nil)
+	testPath(t, tree, "/abc/x", "/abc/:id", map[string]string{"id": "x"})
+	testPath(t, tree, "/abc/def", "/abc/:id", map[string]string{"id": "def"})
+}
+
+func TestSearchKey(t *testing.T) {
+	tree := NewRoot()
+	for _, path := range []string{"/users/new", "/users/:id", "/users/*rest"} {
+		n, _ := tree.Add(path[1:], nil)
+		n.Value = map[string]string{"GET": path}
+	}
+	tree.Find("users/new").Value = map[string]string{"POST": "/users/new"}
+	byKey := func(value interface{}, key string) bool {
+		_, ok := value.(map[string]string)[key]
+		return ok
+	}
+
+	// A node which matches with another key is returned when nothing better matches.
+	if n, ok, _ := tree.Search("GET", "users/new", byKey, nil); !ok || n != tree.Find("users/:id") {
+		t.Errorf("Expected GET users/new to fall back to the wildcard, saw %v %v", ok, n)
+	}
+	if n, ok, _ := tree.Search("PUT", "users/new", byKey, nil); ok || n != tree.Find("users/new") {
+		t.Errorf("Expected PUT users/new to return the static node without a match, saw %v %v", ok, n)
+	}
+	stats := &Stats{}
+	if n, ok, params := tree.Search("GET", "users/a/b", byKey, stats); !ok || n != tree.Find("users/*rest") ||
+		len(params) != 1 || params[0] != "a/b" {
+		t.Errorf("Expected GET users/a/b to match the catch-all, saw %v %v %v", ok, n, params)
+	}
+	if stats.NodesVisited == 0 || stats.Fallbacks == 0 {
+		t.Errorf("Expected the search to be counted, saw %+v", stats)
+	}
+}
+
+func TestTreeWideStatic(t *testing.T) {
+	tree := NewRoot()
+	paths := wideStaticPaths()
+	// Add some paths more than once, so that the children are reordered by priority.
+	for i, path := range append(paths, paths[len(paths)-100:]...) {
+		n, _ := tree.Add(path, nil)
+		if i < len(paths) {
+			n.Value = path
+		}
+	}
+	if tree.staticLookup == nil {
+		t.Fatal("Expected the root node to index its static children")
+	}
+
+	for _, path := range paths {
+		n, ok, _ := tree.Search("GET", path, matchAny, nil)
+		if n == nil || !ok {
+			t.Fatalf("Expected to find %s", path)
+		}
+		if found := tree.Find(path); found != n {
+			t.Fatalf("Expected Find to return the node for %s", path)
+		}
+	}
+	for _, path := range []string{"~/ax", "a/~x", "a/a"} {
+		if _, ok, _ := tree.Search("GET", path, matchAny, nil); ok {
+			t.Errorf("Expected no match for %s", path)
+		}
+	}
+
+	for i, c := range tree.staticIndices {
+		if int(tree.staticLookup[c]) != i+1 {
+			t.Fatalf("Expected the index of %c to be %d, saw %d", c, i+1, tree.staticLookup[c])
+		}
+	}
+}
+
+func BenchmarkTreeNullRequest(b *testing.B) {
+	b.ReportAllocs()
+	tree := NewRoot()
+	tree.Value = "/"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search("GET", "", matchAny, nil)
+	}
+}
+
+func BenchmarkTreeOneStatic(b *testing.B) {
+	b.ReportAllocs()
+	tree := NewRoot()
+	tree.Value = "/"
+	tree.Add("abc", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search("GET", "abc", matchAny, nil)
+	}
+}
+
+func BenchmarkTreeOneParam(b *testing.B) {
+	tree := NewRoot()
+	tree.Value = "/"
+	b.ReportAllocs()
+	tree.Add(":abc", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search("GET", "abc", matchAny, nil)
+	}
+}
+
+func BenchmarkTreeLongParams(b *testing.B) {
+	tree := NewRoot()
+	tree.Value = "/"
+	b.ReportAllocs()
+	tree.Add(":abc/:def/:ghi", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search("GET", "abcdefghijklmnop/aaaabbbbccccddddeeeeffffgggg/hijkl", matchAny, nil)
+	}
+}
+
+// wideStaticPaths returns paths for a tree whose nodes have many static children, like the
+// routes of a large generated API.
+func wideStaticPaths() []string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+	var paths []string
+	for i := 0; i < len(chars); i++ {
+		for j := 0; j < len(chars); j++ {
+			paths = append(paths, chars[i:i+1]+"/"+chars[j:j+1]+"x")
+		}
+	}
+	return paths
+}
+
+func BenchmarkTreeWideStatic(b *testing.B) {
+	tree := NewRoot()
+	paths := wideStaticPaths()
+	for _, path := range paths {
+		n, _ := tree.Add(path, nil)
+		n.Value = path
+	}
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Search("GET", paths[i%len(paths)], matchAny, nil)
+	}
+}
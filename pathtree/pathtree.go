@@ -0,0 +1,165 @@
+// Package pathtree implements the routing tree of httptreemux, which matches paths against
+// patterns with parameters, for the router and for systems which are not HTTP servers, such
+// as message routers dispatching on topic paths or file watchers dispatching on file names.
+// It does not depend on net/http.
+//
+// A Tree maps patterns to arbitrary values, and finds the value and parameters for a path:
+//
+//	tree := pathtree.New()
+//	tree.Insert("/sensors/:id/temperature", onTemperature)
+//	tree.Insert("/logs/*file[.log]", onLog)
+//
+//	if m, ok := tree.Match("/sensors/42/temperature"); ok {
+//	    m.Value.(func(string))(m.Params.ByName("id"))
+//	}
+//
+// Patterns have the same priority rules as the router's routes: static segments before
+// wildcards, and wildcards before catch-alls. Only exact matches are found: paths are not
+// cleaned, a trailing slash is part of the path, and case matters. Node gives access to the
+// tree itself, for a router which stores its own data at each node.
+package pathtree
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Param is a parameter of a matched pattern.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params holds the parameters of a matched pattern, in the order they appear in it.
+type Params []Param
+
+// ByName returns the value of the first parameter with the name, or an empty string if
+// there is none.
+func (ps Params) ByName(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// Match is the result of matching a path.
+type Match struct {
+	// Pattern is the pattern which matched, as it was inserted.
+	Pattern string
+	// Value is the value inserted with the pattern.
+	Value interface{}
+	// Params holds the pattern's parameters, in the order they appear in the pattern.
+	Params Params
+}
+
+// Tree maps patterns to values. It is safe for concurrent use, including inserting and
+// removing patterns while paths are matched.
+type Tree struct {
+	mutex sync.RWMutex
+	root  *Node
+}
+
+// entry is the Value of a node of a Tree.
+type entry struct {
+	pattern string
+	value   interface{}
+}
+
+// New returns an empty Tree.
+func New() *Tree {
+	return &Tree{root: NewRoot()}
+}
+
+// Insert adds a pattern with a value. Patterns start with a slash and use the router's syntax:
+// :name matches one segment, and *name matches the rest of the path, or one or more segments
+// when more follow it. It returns an error if the pattern is invalid or conflicts with one that
+// was already inserted, as a *ConflictError in the latter case.
+func (t *Tree) Insert(pattern string, value interface{}) error {
+	if len(pattern) == 0 || pattern[0] != '/' {
+		return fmt.Errorf("Pattern %q must start with a slash", pattern)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n, err := t.root.Add(pattern[1:], nil)
+	if err == nil && n.Value != nil {
+		err = &ConflictError{Node: n, Reason: pattern + " conflicts with " + n.Value.(*entry).pattern}
+	}
+	if err != nil {
+		t.root.Prune()
+		return err
+	}
+	n.Value = &entry{pattern: pattern, value: value}
+	return nil
+}
+
+// Remove removes a pattern, and returns false if it was not inserted.
+func (t *Tree) Remove(pattern string) bool {
+	if len(pattern) == 0 || pattern[0] != '/' {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n := t.root.Find(pattern[1:])
+	if n == nil || n.Value == nil || n.Value.(*entry).pattern != pattern {
+		return false
+	}
+	n.Clear()
+	t.root.Prune()
+	return true
+}
+
+// Match finds the pattern which matches the path, which starts with a slash. The parameter
+// values are unescaped, as in the router, so a %2F in a path segment becomes a slash in the
+// value without splitting the segment.
+func (t *Tree) Match(path string) (Match, bool) {
+	return t.MatchBytes([]byte(path), nil)
+}
+
+// MatchBytes is like Match for a path held in a byte slice. The parameters are appended to
+// params[:0], so that the same slice can be passed for every path to reuse its storage.
+func (t *Tree) MatchBytes(path []byte, params Params) (Match, bool) {
+	params = params[:0]
+	if len(path) == 0 || path[0] != '/' {
+		return Match{Params: params}, false
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	n, ok, values := t.root.Search("", string(path[1:]), anyValue, nil)
+	if !ok {
+		return Match{Params: params}, false
+	}
+	e := n.Value.(*entry)
+	for i, name := range n.WildcardNames()[:len(values)] {
+		value := values[len(values)-i-1]
+		if unescaped, err := unescape(value); err == nil {
+			value = unescaped
+		}
+		params = append(params, Param{Key: name, Value: value})
+	}
+	return Match{Pattern: e.pattern, Value: e.value, Params: params}, true
+}
+
+// anyValue is the MatchFunc of a Tree, which has one value for each pattern.
+func anyValue(value interface{}, key string) bool {
+	return true
+}
+
+// Patterns returns the inserted patterns, sorted.
+func (t *Tree) Patterns() []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	var patterns []string
+	t.root.Walk(func(n *Node) {
+		if n.Value != nil {
+			patterns = append(patterns, n.Value.(*entry).pattern)
+		}
+	})
+	sort.Strings(patterns)
+	return patterns
+}
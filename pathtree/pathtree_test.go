@@ -0,0 +1,65 @@
+package pathtree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	tree := New()
+	for i, pattern := range []string{
+		"/sensors/:id/temperature",
+		"/sensors/all/temperature",
+		"/logs/*file[.log]",
+		"/topics/*path/status",
+		"/dirs/",
+	} {
+		if err := tree.Insert(pattern, i); err != nil {
+			t.Fatalf("Insert(%q): %v", pattern, err)
+		}
+	}
+	if err := tree.Insert("/sensors/:name/temperature", 9); err == nil {
+		t.Error("Expected an error for a conflicting pattern")
+	}
+
+	for _, test := range []struct {
+		path    string
+		pattern string
+		value   interface{}
+		params  Params
+	}{
+		{"/sensors/42/temperature", "/sensors/:id/temperature", 0, Params{{Key: "id", Value: "42"}}},
+		{"/sensors/all/temperature", "/sensors/all/temperature", 1, nil},
+		{"/sensors/a%2Fb/temperature", "/sensors/:id/temperature", 0, Params{{Key: "id", Value: "a/b"}}},
+		{"/logs/2024/app.log", "/logs/*file[.log]", 2, Params{{Key: "file", Value: "2024/app.log"}}},
+		{"/logs/app.txt", "", nil, nil},
+		{"/topics/a/b/status", "/topics/*path/status", 3, Params{{Key: "path", Value: "a/b"}}},
+		{"/dirs/", "/dirs/", 4, nil},
+		{"/dirs", "", nil, nil},
+		{"/sensors/42/temperature/", "", nil, nil},
+		{"sensors/42/temperature", "", nil, nil},
+	} {
+		m, ok := tree.Match(test.path)
+		if ok != (test.pattern != "") {
+			t.Errorf("%s: expected found %v, saw %v", test.path, test.pattern != "", ok)
+			continue
+		}
+		if m.Pattern != test.pattern || m.Value != test.value || len(m.Params) != len(test.params) ||
+			(len(m.Params) != 0 && !reflect.DeepEqual(m.Params, test.params)) {
+			t.Errorf("%s: expected %s %v %v, saw %s %v %v",
+				test.path, test.pattern, test.value, test.params, m.Pattern, m.Value, m.Params)
+		}
+	}
+
+	if !tree.Remove("/sensors/all/temperature") || tree.Remove("/sensors/all/temperature") {
+		t.Error("Expected the pattern to be removed once")
+	}
+	if m, _ := tree.Match("/sensors/all/temperature"); m.Pattern != "/sensors/:id/temperature" {
+		t.Errorf("Expected the wildcard to match after the removal, saw %q", m.Pattern)
+	}
+
+	expected := []string{"/dirs/", "/logs/*file[.log]", "/sensors/:id/temperature", "/topics/*path/status"}
+	if patterns := tree.Patterns(); !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("Expected patterns %v, saw %v", expected, patterns)
+	}
+}
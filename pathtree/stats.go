@@ -0,0 +1,71 @@
+package pathtree
+
+// Stats counts the work done by Search.
+type Stats struct {
+	// NodesVisited is the number of nodes which the search entered.
+	NodesVisited int
+	// Depth is the deepest level of the tree that the search reached, where the children of
+	// the root are at level 1.
+	Depth int
+	// Fallbacks is the number of times the search tried a wildcard or catch-all node, because
+	// no static node had matched the rest of the path.
+	Fallbacks int
+	// Backtracks is the number of times the search found no match below a node and went back
+	// to try another.
+	Backtracks int
+}
+
+// visit counts a node entered at the given depth. It does nothing if s is nil.
+func (s *Stats) visit(depth int) {
+	if s == nil {
+		return
+	}
+	s.NodesVisited++
+	if depth > s.Depth {
+		s.Depth = depth
+	}
+}
+
+// fallBack counts a wildcard or catch-all node being tried, which is a backtrack if another
+// branch was already searched. It does nothing if s is nil.
+func (s *Stats) fallBack(backtrack bool) {
+	if s == nil {
+		return
+	}
+	s.Fallbacks++
+	if backtrack {
+		s.Backtracks++
+	}
+}
+
+// Changes counts the changes made to the tree by Add. Its methods do nothing on a nil
+// pointer, which is passed when nobody is interested in them.
+type Changes struct {
+	// Nodes is the number of nodes added, including those added by splits.
+	Nodes int
+	// Splits is the number of existing nodes which were split in two, because the path
+	// shares only the start of their path.
+	Splits int
+	// Reorders is the number of times a node moved ahead of a sibling, because adding the
+	// path raised its priority above the sibling's.
+	Reorders int
+}
+
+func (c *Changes) addNode() {
+	if c != nil {
+		c.Nodes++
+	}
+}
+
+func (c *Changes) split() {
+	if c != nil {
+		c.Nodes++
+		c.Splits++
+	}
+}
+
+func (c *Changes) reorder() {
+	if c != nil {
+		c.Reorders++
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !go1.8
+// +build !go1.8
+
+package pathtree
+
+import "net/url"
+
+func unescape(path string) (string, error) {
+	return url.QueryUnescape(path)
+}
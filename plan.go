@@ -0,0 +1,282 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
+)
+
+// PlanAction is what adding a route of a batch passed to Plan would do.
+type PlanAction int
+
+const (
+	PlanAdd      PlanAction = iota // The route would be added
+	PlanReplace                    // A route with the same method and pattern would be replaced
+	PlanConflict                   // The route can not be added; see PlannedRoute.Err
+)
+
+func (a PlanAction) String() string {
+	switch a {
+	case PlanAdd:
+		return "add"
+	case PlanReplace:
+		return "replace"
+	case PlanConflict:
+		return "conflict"
+	}
+	return fmt.Sprintf("PlanAction(%d)", int(a))
+}
+
+// PlannedRoute describes the effect of adding one route of a batch.
+type PlannedRoute struct {
+	// Method and Pattern are the method and full pattern of the route, including the path of
+	// the group.
+	Method  string
+	Pattern string
+	Action  PlanAction
+	// Err is the *RouteConflictError that adding the route would return, for PlanConflict.
+	Err error
+	// Shadows lists the patterns of other routes for the method which match some of the same
+	// paths as this route, and which would no longer serve them because this route takes
+	// priority.
+	Shadows []string
+	// ShadowedBy lists the patterns of other routes for the method which would take priority
+	// over this route for some of the paths that it matches.
+	ShadowedBy []string
+}
+
+// Plan describes what adding a batch of routes would change, as returned by Group.Plan.
+type Plan struct {
+	// Routes has an entry for each route of the batch, in the same order.
+	Routes []PlannedRoute
+}
+
+// Plan works out what adding the routes of a batch to the group would do, without changing
+// the router, so that a control plane can show operators a dry run of a change to its dynamic
+// routes before it is applied. The routes are tried, in order, on a copy of the group's tree:
+// each is added, replaces a route with the same method and pattern, which would have to be
+// removed with Remove first, or conflicts with an existing route or an earlier one of the
+// batch. Routes which would be added are also compared with the other routes for their
+// method, to find those which would take over some of each other's paths. The handlers of
+// the routes may be nil.
+//
+//	plan, err := router.Plan(defs)
+//	for _, r := range plan.Routes {
+//	    fmt.Println(r.Action, r.Method, r.Pattern, r.Shadows, r.ShadowedBy)
+//	}
+//
+// It returns an error if one of the routes is invalid for reasons other than a conflict, such
+// as a malformed pattern. The router's hooks, such as OnRouteRegistered, are not called.
+func (g *Group) Plan(batch []RouteDef) (Plan, error) {
+	trial := New()
+	trial.HeadCanUseGet = g.mux.HeadCanUseGet
+	trial.RedirectTrailingSlash = g.mux.RedirectTrailingSlash
+	trial.CaseInsensitive = g.mux.CaseInsensitive
+	trial.EscapeAddedRoutes = g.mux.EscapeAddedRoutes
+	trial.PatternSyntax = g.mux.PatternSyntax
+	trial.MethodPolicy = g.mux.MethodPolicy
+
+	g.mux.mutex.RLock()
+	trial.root = g.tree().Clone(func(value interface{}, clone *pathtree.Node) interface{} {
+		return value.(*node).clone(clone)
+	})
+	g.mux.mutex.RUnlock()
+	group := &Group{path: g.path, mux: trial, options: g.options}
+
+	plan := Plan{Routes: make([]PlannedRoute, len(batch))}
+	for i, def := range batch {
+		handler := def.Handler
+		if handler == nil {
+			handler = func(w http.ResponseWriter, r *http.Request, params map[string]string) {}
+		}
+		path, err := trial.translatePattern(def.Path)
+		if err != nil {
+			return Plan{}, fmt.Errorf("route %d (%s %s): %v", i, def.Method, def.Path, err)
+		}
+		method, err := trial.routeMethod(def.Method)
+		if err != nil {
+			return Plan{}, fmt.Errorf("route %d (%s %s): %v", i, def.Method, def.Path, err)
+		}
+
+		planned := PlannedRoute{Method: method, Pattern: g.path + path, Action: PlanAdd}
+		if group.registered(method, path) {
+			planned.Action = PlanReplace
+			group.Remove(method, path)
+		}
+		if err := group.With(def.Options...).TryHandle(method, path, handler); err != nil {
+			if _, ok := err.(*RouteConflictError); !ok {
+				return Plan{}, fmt.Errorf("route %d (%s %s): %v", i, def.Method, def.Path, err)
+			}
+			planned.Action = PlanConflict
+			planned.Err = err
+		}
+		plan.Routes[i] = planned
+	}
+
+	for i := range plan.Routes {
+		if plan.Routes[i].Action != PlanConflict {
+			trial.findShadows(&plan.Routes[i])
+		}
+	}
+	return plan, nil
+}
+
+// registered returns true if a route with the method and the pattern, relative to the group,
+// has been added to the group's tree.
+func (g *Group) registered(method, path string) bool {
+	paths, _, err := g.treePaths(path, false)
+	if err != nil {
+		return false
+	}
+	for _, thePath := range paths {
+		n := findNode(g.tree(), thePath[1:])
+		if n == nil {
+			continue
+		}
+		if info := n.leafRoute[method]; info != nil && info.pattern == g.path+path &&
+			(method != "HEAD" || !n.implicitHead) {
+			return true
+		}
+	}
+	return false
+}
+
+// findShadows fills in the Shadows and ShadowedBy members of a planned route. For each other
+// route which serves its method, it builds paths which might match both routes, and checks
+// which of them the tree prefers for the paths that do.
+func (t *TreeMux) findShadows(planned *PlannedRoute) {
+	var others []string
+	walkRoutes(t.root, func(n *node) {
+		for _, method := range []string{planned.Method, MethodAny} {
+			if info := n.leafRoute[method]; info != nil && info.pattern != planned.Pattern &&
+				!containsString(others, info.pattern) {
+				others = append(others, info.pattern)
+			}
+		}
+	})
+
+	for _, other := range others {
+		var probes []string
+		for _, pattern := range t.probePatterns(planned.Pattern) {
+			for _, otherPattern := range t.probePatterns(other) {
+				probes = append(probes, probePath(pattern, otherPattern), probePath(otherPattern, pattern))
+			}
+		}
+
+		for _, probe := range probes {
+			matches := candidates(t.root, planned.Method, probe[1:])
+			own, theirs := indexOf(matches, planned.Pattern), indexOf(matches, other)
+			if own == -1 || theirs == -1 {
+				continue
+			}
+			if own < theirs && !containsString(planned.Shadows, other) {
+				planned.Shadows = append(planned.Shadows, other)
+			} else if theirs < own && !containsString(planned.ShadowedBy, other) {
+				planned.ShadowedBy = append(planned.ShadowedBy, other)
+			}
+		}
+	}
+}
+
+// probePatterns returns the patterns, as they are stored in the tree, which a route's
+// pattern stands for.
+func (t *TreeMux) probePatterns(pattern string) []string {
+	if len(pattern) > 1 && pattern[len(pattern)-1] == '/' && t.RedirectTrailingSlash {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if t.CaseInsensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	expansions, err := optionalExpansions(pattern)
+	if err != nil {
+		return nil
+	}
+	return expansions
+}
+
+// probePath returns a path which matches the pattern, using the static segments of the other
+// pattern as the values of the wildcards at the same positions where it can, so that the path
+// is likely to match both if any path does.
+func probePath(pattern, other string) string {
+	segments := strings.Split(pattern[1:], "/")
+	otherSegments := strings.Split(other[1:], "/")
+	path := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			value := "x"
+			if i < len(otherSegments) && isStaticSegment(otherSegments[i]) {
+				value = unescapeSegment(otherSegments[i])
+			}
+			path = append(path, value)
+		case strings.HasPrefix(segment, "*"):
+			// The catch-all takes the segments of the other pattern that the rest of this
+			// pattern leaves over, or a single segment.
+			var values []string
+			if end := len(otherSegments) - (len(segments) - i - 1); end > i {
+				for _, s := range otherSegments[i:end] {
+					if isStaticSegment(s) {
+						values = append(values, unescapeSegment(s))
+					} else {
+						values = append(values, "x")
+					}
+				}
+			}
+			if len(values) == 0 {
+				values = []string{"x"}
+			}
+			_, extensions, _ := pathtree.ParseCatchAll(segment[1:])
+			if !pathtree.HasExtension(extensions, strings.Join(values, "/")) {
+				values[len(values)-1] += extensions[0]
+			}
+			path = append(path, values...)
+		default:
+			path = append(path, unescapeSegment(segment))
+		}
+	}
+	return "/" + strings.Join(path, "/")
+}
+
+// clone returns a copy of the routes for the copy of their node in a cloned tree, which can
+// be changed without affecting them. The routeInfo of each route is shared.
+func (n *node) clone(tn *pathtree.Node) *node {
+	c := *n
+	c.Node = tn
+	if n.leafHandler != nil {
+		c.leafHandler = make(map[string]HandlerFunc, len(n.leafHandler))
+		for method, handler := range n.leafHandler {
+			c.leafHandler[method] = handler
+		}
+	}
+	if n.leafRoute != nil {
+		c.leafRoute = make(map[string]*routeInfo, len(n.leafRoute))
+		for method, info := range n.leafRoute {
+			c.leafRoute[method] = info
+		}
+	}
+	if n.leafVariants != nil {
+		c.leafVariants = make(map[string][]routeVariant, len(n.leafVariants))
+		for method, variants := range n.leafVariants {
+			c.leafVariants[method] = append([]routeVariant(nil), variants...)
+		}
+	}
+	return &c
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsString(list []string, s string) bool {
+	return indexOf(list, s) != -1
+}
@@ -0,0 +1,88 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	var served string
+	router := New()
+	router.GET("/users/:id", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+	router.GET("/status", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		served = "old status"
+	})
+	api := router.NewGroup("/api")
+	api.GET("/items/:id", simpleHandler)
+
+	plan, err := router.Plan([]RouteDef{
+		{Method: "GET", Path: "/users/me"},
+		{Method: "GET", Path: "/status"},
+		{Method: "GET", Path: "/users/:name"},
+		{Method: "POST", Path: "/users/:id"},
+		{Method: "GET", Path: "/files/:name/raw"},
+		{Method: "GET", Path: "/docs/*page"},
+		{Method: "GET", Path: "/docs/*page"},
+		{Method: "GET", Path: "/docs/:lang"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PlannedRoute{
+		{Method: "GET", Pattern: "/users/me", Action: PlanAdd, Shadows: []string{"/users/:id"}},
+		{Method: "GET", Pattern: "/status", Action: PlanReplace},
+		{Method: "GET", Pattern: "/users/:name", Action: PlanConflict},
+		{Method: "POST", Pattern: "/users/:id", Action: PlanAdd},
+		{Method: "GET", Pattern: "/files/:name/raw", Action: PlanAdd, Shadows: []string{"/files/*path"}},
+		{Method: "GET", Pattern: "/docs/*page", Action: PlanAdd, ShadowedBy: []string{"/docs/:lang"}},
+		{Method: "GET", Pattern: "/docs/*page", Action: PlanReplace, ShadowedBy: []string{"/docs/:lang"}},
+		{Method: "GET", Pattern: "/docs/:lang", Action: PlanAdd, Shadows: []string{"/docs/*page"}},
+	}
+	if len(plan.Routes) != len(expected) {
+		t.Fatalf("Expected %d planned routes, saw %d", len(expected), len(plan.Routes))
+	}
+	for i, planned := range plan.Routes {
+		if planned.Action == PlanConflict {
+			if _, ok := planned.Err.(*RouteConflictError); !ok {
+				t.Errorf("Route %d: expected a *RouteConflictError, saw %v", i, planned.Err)
+			}
+			planned.Err = nil
+		}
+		if !reflect.DeepEqual(planned, expected[i]) {
+			t.Errorf("Route %d: expected %+v, saw %+v", i, expected[i], planned)
+		}
+	}
+
+	// The router is not changed.
+	if routes := router.Routes(); len(routes) != 4 {
+		t.Errorf("Expected the router to keep its 4 routes, saw %v", routes)
+	}
+	r, _ := newRequest("GET", "/status", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if served != "old status" {
+		t.Errorf("Expected the old handler to serve /status, saw %q", served)
+	}
+	r, _ = newRequest("GET", "/users/me", nil)
+	if lr, _ := router.Lookup(nil, r); lr.Route != "/users/:id" {
+		t.Errorf("Expected /users/me to still be served by /users/:id, saw %q", lr.Route)
+	}
+
+	// Paths are relative to the group.
+	plan, err = api.Plan([]RouteDef{{Method: "GET", Path: "/items/new"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := plan.Routes[0]; p.Pattern != "/api/items/new" || !reflect.DeepEqual(p.Shadows, []string{"/api/items/:id"}) {
+		t.Errorf("Expected /api/items/new to shadow /api/items/:id, saw %+v", p)
+	}
+
+	if _, err := router.Plan([]RouteDef{{Method: "GET", Path: "/bad/*[.js"}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,256 @@
+package httptreemux
+
+import (
+	"net/http"
+	"strings"
+)
+
+// predicate is a condition on requests that a route added with a match option only serves.
+type predicate struct {
+	description string
+	match       func(r *http.Request) bool
+}
+
+// routeVariant is a route which was added with match predicates.
+type routeVariant struct {
+	handler HandlerFunc
+	info    *routeInfo
+}
+
+// MatchHeader makes a route serve only requests with the given value in a header. Several
+// routes can then be added for the same path and method, and each request is served by the
+// first one, in the order they were added, whose match options all accept it. A route added
+// without match options serves the requests that none of the others accept; if there is
+// none, those requests are not found.
+//
+// The header matches if one of its comma-separated elements, without any parameters after a
+// semicolon, is equal to the value, ignoring case. So "application/vnd.api+json" matches an
+// Accept header of "application/vnd.api+json; charset=utf-8, text/html;q=0.9".
+//
+//	api.With(httptreemux.MatchHeader("Accept", "application/vnd.v2+json")).GET("/users", listUsersV2)
+//	api.GET("/users", listUsers)
+func MatchHeader(name, value string) RouteOption {
+	return matchOption("header "+http.CanonicalHeaderKey(name)+"="+value, func(r *http.Request) bool {
+		for _, header := range r.Header[http.CanonicalHeaderKey(name)] {
+			for _, element := range strings.Split(header, ",") {
+				if semicolon := strings.IndexByte(element, ';'); semicolon != -1 {
+					element = element[:semicolon]
+				}
+				if strings.EqualFold(strings.TrimSpace(element), value) {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// MatchQuery makes a route serve only requests with the given value for a query parameter.
+// See MatchHeader for how routes with match options are chosen.
+func MatchQuery(name, value string) RouteOption {
+	return matchOption("query "+name+"="+value, func(r *http.Request) bool {
+		for _, v := range r.URL.Query()[name] {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchCookie makes a route serve only requests with a cookie of the given name and value.
+// See MatchHeader for how routes with match options are chosen.
+func MatchCookie(name, value string) RouteOption {
+	return matchOption("cookie "+name+"="+value, func(r *http.Request) bool {
+		for _, cookie := range r.Cookies() {
+			if cookie.Name == name && cookie.Value == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchAny makes a route serve the requests which any of the given match options accept,
+// rather than only those which all of them accept, as when the options are given separately.
+// Options other than match options are ignored.
+//
+//	router.With(httptreemux.MatchAny(
+//	    httptreemux.MatchHeader("X-Beta", "true"),
+//	    httptreemux.MatchCookie("beta", "1"),
+//	)).GET("/search", betaSearch)
+func MatchAny(options ...RouteOption) RouteOption {
+	return matchOption(anyPredicate(options))
+}
+
+// anyPredicate returns the description and condition of a predicate which accepts the
+// requests that any of the match options accept.
+func anyPredicate(options []RouteOption) (string, func(r *http.Request) bool) {
+	alternatives := make([]*routeInfo, 0, len(options))
+	descriptions := make([]string, 0, len(options))
+	for _, option := range options {
+		info := &routeInfo{}
+		option(info)
+		if len(info.predicates) == 0 {
+			continue
+		}
+		alternatives = append(alternatives, info)
+		descriptions = append(descriptions, strings.Join(info.predicateDescriptions(), " and "))
+	}
+
+	return strings.Join(descriptions, " or "), func(r *http.Request) bool {
+		for _, info := range alternatives {
+			if info.matches(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchFunc makes a route serve only requests for which match returns true. The description
+// identifies the condition in the Predicates of the route returned by Routes. See MatchHeader
+// for how routes with match options are chosen.
+func MatchFunc(description string, match func(r *http.Request) bool) RouteOption {
+	return matchOption(description, match)
+}
+
+func matchOption(description string, match func(r *http.Request) bool) RouteOption {
+	return func(info *routeInfo) {
+		info.predicates = append(info.predicates[:len(info.predicates):len(info.predicates)],
+			predicate{description: description, match: match})
+	}
+}
+
+// matches returns true if the request satisfies all of the route's predicates.
+func (info *routeInfo) matches(r *http.Request) bool {
+	for _, p := range info.predicates {
+		if !p.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// predicateDescriptions returns the descriptions of the route's predicates, or nil.
+func (info *routeInfo) predicateDescriptions() []string {
+	if len(info.predicates) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(info.predicates))
+	for i, p := range info.predicates {
+		descriptions[i] = p.description
+	}
+	return descriptions
+}
+
+// addRoute sets the handler for a method on the node. A route with predicates is added to the
+// variants for the method, and only becomes the node's handler if there is no other. A route
+// without predicates takes the place of such a handler.
+func (n *node) addRoute(method string, handler HandlerFunc, info *routeInfo) error {
+	current := n.leafRoute[method]
+	conditional := n.leafHandler[method] != nil && current != nil && len(current.predicates) != 0 &&
+		(method != "HEAD" || !n.implicitHead)
+
+	if len(info.predicates) == 0 {
+		if conditional {
+			n.leafHandler[method] = handler
+			n.leafRoute[method] = info
+			return nil
+		}
+		if err := n.trySetHandler(method, handler, false); err != nil {
+			return err
+		}
+		n.setRouteInfo(method, info)
+		return nil
+	}
+
+	if info.experiment != nil {
+		if err := checkExperiment(n.leafVariants[method], info.experiment); err != nil {
+			return err
+		}
+	}
+
+	if n.leafHandler[method] == nil || (method == "HEAD" && n.implicitHead) {
+		if err := n.trySetHandler(method, handler, false); err != nil {
+			return err
+		}
+		n.setRouteInfo(method, info)
+	}
+	if n.leafVariants == nil {
+		n.leafVariants = make(map[string][]routeVariant)
+	}
+	n.leafVariants[method] = append(n.leafVariants[method], routeVariant{handler: handler, info: info})
+	return nil
+}
+
+// removeRoute undoes addRoute for one route.
+func (n *node) removeRoute(method string, info *routeInfo, headCanUseGet bool) {
+	variants := n.leafVariants[method]
+	for i, v := range variants {
+		if v.info == info {
+			variants = append(variants[:i:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(n.leafVariants, method)
+	} else {
+		n.leafVariants[method] = variants
+	}
+
+	if n.leafRoute[method] != info {
+		return
+	}
+	if len(variants) == 0 {
+		n.removeHandler(method, info.pattern, headCanUseGet)
+		return
+	}
+	n.leafHandler[method] = variants[0].handler
+	n.leafRoute[method] = variants[0].info
+}
+
+// selectRoute returns the handler and route which serve the request, when the node has routes
+// with predicates. It returns false if none of the routes for the method accept the request.
+// If the request takes part in an experiment, random is called once to choose its variant,
+// and the assignment is returned.
+func (n *node) selectRoute(method string, r *http.Request, random func() float64) (HandlerFunc, *routeInfo, *Assignment, bool) {
+	if n.leafHandler[method] == nil {
+		method = MethodAny
+	} else if method == "HEAD" && n.implicitHead {
+		method = "GET"
+	}
+
+	handler, info := n.leafHandler[method], n.leafRoute[method]
+	variants := n.leafVariants[method]
+	if len(variants) == 0 {
+		return handler, info, nil, true
+	}
+
+	var split *experiment
+	draw, offset := 0.0, 0.0
+	for _, v := range variants {
+		if !v.info.matches(r) {
+			continue
+		}
+		if v.info.experiment != nil {
+			if split == nil {
+				split = v.info.experiment
+				draw = random()
+			}
+			offset += v.info.experiment.weight
+			if draw >= offset {
+				continue
+			}
+			return v.handler, v.info, &v.info.experiment.Assignment, true
+		}
+		return v.handler, v.info, nil, true
+	}
+	if info != nil && len(info.predicates) == 0 {
+		if split != nil {
+			return handler, info, &Assignment{Experiment: split.Experiment, Variant: ControlVariant}, true
+		}
+		return handler, info, nil, true
+	}
+	return nil, nil, nil, false
+}
@@ -0,0 +1,106 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPredicates(t *testing.T) {
+	router := New()
+	served := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Write([]byte(name))
+		}
+	}
+
+	router.With(MatchHeader("Accept", "application/vnd.v2+json")).GET("/users", served("v2"))
+	router.With(MatchQuery("version", "3")).GET("/users", served("v3"))
+	router.GET("/users", served("default"))
+	router.With(MatchHeader("X-Beta", "yes")).GET("/beta", served("beta"))
+	router.With(MatchHeader("X-Beta", "yes")).POST("/users", served("post-beta"))
+
+	for _, test := range []struct {
+		method, path string
+		header       http.Header
+		code         int
+		body         string
+	}{
+		{"GET", "/users", nil, http.StatusOK, "default"},
+		{"GET", "/users", http.Header{"Accept": {"application/vnd.v2+json; charset=utf-8, text/html;q=0.9"}}, http.StatusOK, "v2"},
+		{"GET", "/users?version=3", nil, http.StatusOK, "v3"},
+		{"GET", "/users?version=3", http.Header{"Accept": {"application/vnd.v2+json"}}, http.StatusOK, "v2"},
+		{"HEAD", "/users?version=3", nil, http.StatusOK, "v3"},
+		{"GET", "/beta", http.Header{"X-Beta": {"yes"}}, http.StatusOK, "beta"},
+		{"GET", "/beta", nil, http.StatusNotFound, ""},
+		{"POST", "/users", http.Header{"X-Beta": {"yes"}}, http.StatusOK, "post-beta"},
+		{"POST", "/users", nil, http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s %v: expected code %d, saw %d", test.method, test.path, test.header, test.code, w.Code)
+		}
+		if test.code == http.StatusOK && w.Body.String() != test.body {
+			t.Errorf("%s %s %v: expected %q, saw %q", test.method, test.path, test.header, test.body, w.Body.String())
+		}
+	}
+
+	expected := []Route{
+		{Method: "GET", Pattern: "/beta", Predicates: []string{"header X-Beta=yes"}},
+		{Method: "GET", Pattern: "/users"},
+		{Method: "GET", Pattern: "/users", Predicates: []string{"header Accept=application/vnd.v2+json"}},
+		{Method: "GET", Pattern: "/users", Predicates: []string{"query version=3"}},
+		{Method: "POST", Pattern: "/users", Predicates: []string{"header X-Beta=yes"}},
+	}
+	if routes := router.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes %v, saw %v", expected, routes)
+	}
+
+	// A second unconditional route still conflicts.
+	if err := router.TryHandle("GET", "/users", served("other")); err == nil {
+		t.Error("Expected a conflict for a second route without match options")
+	}
+
+	if !router.Remove("GET", "/users") {
+		t.Fatal("Expected the routes to be removed")
+	}
+	r, _ := newRequest("GET", "/users?version=3", nil)
+	if _, found := router.Lookup(nil, r); found {
+		t.Error("Expected all of the routes for the method to be removed")
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	router := New()
+	router.With(MatchAny(
+		MatchHeader("X-Beta", "true"),
+		MatchQuery("beta", "1"),
+	), MatchHeader("Accept", "application/json")).GET("/search", simpleHandler)
+
+	for _, test := range []struct {
+		path   string
+		header http.Header
+		code   int
+	}{
+		{"/search", http.Header{"X-Beta": {"true"}, "Accept": {"application/json"}}, http.StatusOK},
+		{"/search?beta=1", http.Header{"Accept": {"application/json"}}, http.StatusOK},
+		{"/search?beta=1", nil, http.StatusNotFound},
+		{"/search", http.Header{"Accept": {"application/json"}}, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %v: expected code %d, saw %d", test.path, test.header, test.code, w.Code)
+		}
+	}
+}
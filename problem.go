@@ -0,0 +1,473 @@
+package httptreemux
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
+)
+
+// Problem is the body of the responses written by the problem handlers, in the
+// application/problem+json format described by RFC 9457, or in another format chosen
+// with SetErrorSerializer.
+type Problem struct {
+	XMLName  xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type     string   `json:"type" xml:"type"`
+	Title    string   `json:"title" xml:"title"`
+	Status   int      `json:"status" xml:"status"`
+	Detail   string   `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance string   `json:"instance,omitempty" xml:"instance,omitempty"`
+	// Method is the method of the request.
+	Method string `json:"method" xml:"method"`
+	// Allowed lists the methods which the matched pattern does handle. It is only set
+	// on 405 responses.
+	Allowed []string `json:"allowed,omitempty" xml:"allowed>i,omitempty"`
+	// Suggestions lists registered routes which are similar to the requested path. It
+	// is only set on 404 responses when TreeMux.Debug is true.
+	Suggestions []string `json:"suggestions,omitempty" xml:"suggestions>i,omitempty"`
+	// Route is the pattern of the route which timed out, and Budget the time it was given.
+	// They are only set on timeout responses.
+	Route  string `json:"route,omitempty" xml:"route,omitempty"`
+	Budget string `json:"budget,omitempty" xml:"budget,omitempty"`
+}
+
+// maxSuggestions is the maximum number of routes listed in Problem.Suggestions.
+const maxSuggestions = 5
+
+// errorScope overrides the router's error handlers, and adds middleware to the responses
+// generated by the router, for requests under a group's path.
+type errorScope struct {
+	// The root of the tree the group adds routes to.
+	root   *pathtree.Node
+	prefix string
+
+	notFound         func(w http.ResponseWriter, r *http.Request)
+	methodNotAllowed func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc)
+	panicHandler     PanicHandler
+	// Middleware added with UseAlways.
+	middleware []MiddlewareFunc
+}
+
+// UseProblemResponses makes the router respond to requests under the group's path that do not
+// match a route, or do not match the method of a route, with an RFC 9457 application/problem+json
+// document instead of calling TreeMux.NotFoundHandler or TreeMux.MethodNotAllowedHandler. The
+// document includes the requested path and method, the allowed methods for a 405 response, and,
+// when TreeMux.Debug is true, suggestions of similarly-named routes for a 404 response.
+//
+// If more than one group uses custom error responses, the group with the most segments in its
+// path which contains the requested path is used. Calling UseProblemResponses on the TreeMux
+// itself applies it to all requests that are not handled by a more specific group.
+//
+//	router := httptreemux.New()
+//	api := router.NewGroup("/api")
+//	api.UseProblemResponses()
+func (g *Group) UseProblemResponses() {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	scope := g.errorScope()
+	scope.notFound = g.mux.problemNotFound
+	scope.methodNotAllowed = g.mux.problemMethodNotAllowed
+}
+
+// SetNotFoundHandler sets the handler called for requests under the group's path which do not
+// match a route, in place of TreeMux.NotFoundHandler. If more than one group sets a handler,
+// the group with the most segments in its path which contains the requested path is used,
+// preferring static segments over wildcards as routes do. Wildcards in the group's path match
+// any value, so NewGroup("/users/:id") covers /users/1/missing. Passing nil removes the group's
+// handler, so that it is inherited from the parent groups again.
+//
+//	router := httptreemux.New()
+//	router.NotFoundHandler = htmlNotFound
+//	api := router.NewGroup("/api")
+//	api.SetNotFoundHandler(jsonNotFound) // Used for /api/missing, but not /missing
+func (g *Group) SetNotFoundHandler(handler func(w http.ResponseWriter, r *http.Request)) {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	g.errorScope().notFound = handler
+}
+
+// SetMethodNotAllowedHandler sets the handler called for requests under the group's path which
+// match a pattern without a handler for the requested method, in place of
+// TreeMux.MethodNotAllowedHandler. The most specific group's handler is used, as for
+// SetNotFoundHandler.
+func (g *Group) SetMethodNotAllowedHandler(handler func(w http.ResponseWriter, r *http.Request,
+	methods map[string]HandlerFunc)) {
+
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	g.errorScope().methodNotAllowed = handler
+}
+
+// SetPanicHandler sets the handler called for panics in the handlers of requests under the
+// group's path, in place of TreeMux.PanicHandler, so that part of an application can report
+// its crashes separately. The most specific group's handler is used, as for
+// SetNotFoundHandler, and TreeMux.PanicHandler is used for requests outside of all such
+// groups. A handler given for a route with WithPanicHandler takes precedence over both.
+// Passing nil removes the group's handler.
+//
+//	router.PanicHandler = httptreemux.SimplePanicHandler
+//	plugins := router.NewGroup("/plugins")
+//	plugins.SetPanicHandler(reportPluginCrash)
+func (g *Group) SetPanicHandler(handler PanicHandler) {
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	g.errorScope().panicHandler = handler
+	if handler != nil {
+		g.mux.scopedPanicHandlers = true
+	}
+}
+
+// errorScope returns the error scope for the group's path, creating it if necessary.
+// The caller must hold the write lock.
+func (g *Group) errorScope() *errorScope {
+	root := g.tree()
+	prefix := g.path
+	if g.mux.CaseInsensitive {
+		prefix = strings.ToLower(prefix)
+	}
+
+	for _, scope := range g.mux.errorScopes {
+		if scope.root == root && scope.prefix == prefix {
+			return scope
+		}
+	}
+
+	scope := &errorScope{root: root, prefix: prefix}
+	g.mux.errorScopes = append(g.mux.errorScopes, scope)
+	return scope
+}
+
+// errorHandlers returns the handlers to call for a request which did not match a route or
+// did not match its method, taking any group overrides into account.
+func (t *TreeMux) errorHandlers(r *http.Request) (
+	notFound func(w http.ResponseWriter, r *http.Request),
+	methodNotAllowed func(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc)) {
+
+	notFound = t.NotFoundHandler
+	methodNotAllowed = t.MethodNotAllowedHandler
+	if len(t.errorScopes) == 0 {
+		return
+	}
+
+	path := r.URL.Path
+	if t.CaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	root := t.rootForHost(r.Host)
+
+	// Find the most specific handler of each type independently, so that a group which
+	// only overrides one of them inherits the other from its parents.
+	var notFoundScope, methodNotAllowedScope *errorScope
+	for _, scope := range t.errorScopes {
+		if scope.root != root || !pathHasPrefix(path, scope.prefix) {
+			continue
+		}
+
+		if scope.notFound != nil && scope.moreSpecific(notFoundScope) {
+			notFound = scope.notFound
+			notFoundScope = scope
+		}
+
+		if scope.methodNotAllowed != nil && scope.moreSpecific(methodNotAllowedScope) {
+			methodNotAllowed = scope.methodNotAllowed
+			methodNotAllowedScope = scope
+		}
+	}
+
+	return
+}
+
+// panicHandler returns the handler to call for a panic while serving the request, taking any
+// group overrides into account. The caller must hold the read lock if necessary.
+func (t *TreeMux) panicHandler(r *http.Request) PanicHandler {
+	handler := t.PanicHandler
+	if !t.scopedPanicHandlers {
+		return handler
+	}
+
+	path := r.URL.Path
+	if t.CaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	root := t.rootForHost(r.Host)
+
+	var handlerScope *errorScope
+	for _, scope := range t.errorScopes {
+		if scope.panicHandler != nil && scope.moreSpecific(handlerScope) && scope.root == root &&
+			pathHasPrefix(path, scope.prefix) {
+			handler = scope.panicHandler
+			handlerScope = scope
+		}
+	}
+	return handler
+}
+
+// routerResponseHandler wraps a router-generated response with the middleware added by
+// UseAlways for the request's path. The caller must hold the read lock if necessary.
+func (t *TreeMux) routerResponseHandler(r *http.Request, handler HandlerFunc) HandlerFunc {
+	if len(t.errorScopes) == 0 {
+		return handler
+	}
+
+	path := r.URL.Path
+	if t.CaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	root := t.rootForHost(r.Host)
+
+	var scopes []*errorScope
+	for _, scope := range t.errorScopes {
+		if len(scope.middleware) != 0 && scope.root == root && pathHasPrefix(path, scope.prefix) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// Apply the innermost middleware first, so that the outermost runs first.
+	sort.Stable(innermostFirst(scopes))
+	for _, scope := range scopes {
+		for i := len(scope.middleware) - 1; i >= 0; i-- {
+			handler = scope.middleware[i](handler)
+		}
+	}
+	return handler
+}
+
+// innermostFirst sorts error scopes from the most specific to the least.
+type innermostFirst []*errorScope
+
+func (s innermostFirst) Len() int           { return len(s) }
+func (s innermostFirst) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s innermostFirst) Less(i, j int) bool { return s[i].moreSpecific(s[j]) }
+
+// moreSpecific returns true if the scope should be preferred over another one, which may be
+// nil, when both contain a path. A scope with more segments in its prefix is more specific,
+// and of two prefixes with the same number of segments, the one with a static segment where
+// the other has a wildcard wins, as it does in the tree.
+func (scope *errorScope) moreSpecific(other *errorScope) bool {
+	if other == nil {
+		return true
+	}
+	a, b := scope.prefix, other.prefix
+	if na, nb := strings.Count(a, "/"), strings.Count(b, "/"); na != nb {
+		return na > nb
+	}
+	for a != "" && b != "" {
+		var segA, segB string
+		segA, a = nextSegment(a[1:])
+		segB, b = nextSegment(b[1:])
+		if staticA, staticB := isStaticSegment(segA), isStaticSegment(segB); staticA != staticB {
+			return staticA
+		}
+		if segA != "" && segB != "" && segA[0] != segB[0] && !isStaticSegment(segA) {
+			// A wildcard is preferred over a catch-all.
+			return segA[0] == ':'
+		}
+	}
+	return false
+}
+
+// pathHasPrefix reports whether the path is equal to the prefix or under it, respecting
+// segment boundaries, so that "/apiv2" is not under "/api". The prefix is a group's path, so a
+// :name segment in it matches any non-empty segment of the path, and a *name segment matches
+// one or more of them.
+func pathHasPrefix(path, prefix string) bool {
+	if !strings.ContainsAny(prefix, ":*\\") {
+		if !strings.HasPrefix(path, prefix) {
+			return false
+		}
+		return len(path) == len(prefix) || prefix == "" || path[len(prefix)] == '/'
+	}
+
+	for prefix != "" {
+		if path == "" || path[0] != '/' {
+			return false
+		}
+		var segment, value string
+		segment, prefix = nextSegment(prefix[1:])
+		value, path = nextSegment(path[1:])
+		switch {
+		case segment == "":
+			if value != "" {
+				return false
+			}
+		case segment[0] == ':':
+			if value == "" {
+				return false
+			}
+		case segment[0] == '*':
+			// The catch-all takes one or more segments, and the rest of the prefix has to
+			// match what follows them.
+			if value == "" {
+				return false
+			}
+			if prefix == "" {
+				return true
+			}
+			for ; path != ""; path = path[1:] {
+				if path[0] == '/' && pathHasPrefix(path, prefix) {
+					return true
+				}
+			}
+			return false
+		default:
+			if unescapeSegment(segment) != value {
+				return false
+			}
+		}
+	}
+	return path == "" || path[0] == '/'
+}
+
+// nextSegment splits a path, without its leading slash, into its first segment and the rest,
+// which starts with a slash unless it is empty.
+func nextSegment(path string) (segment, rest string) {
+	if i := strings.IndexByte(path, '/'); i != -1 {
+		return path[:i], path[i:]
+	}
+	return path, ""
+}
+
+// unescapeSegment removes the backslash from a static segment starting with an escaped : or *.
+func unescapeSegment(segment string) string {
+	if len(segment) >= 2 && segment[0] == '\\' {
+		return segment[1:]
+	}
+	return segment
+}
+
+func isStaticSegment(segment string) bool {
+	return segment != "" && segment[0] != ':' && segment[0] != '*'
+}
+
+func (t *TreeMux) problemNotFound(w http.ResponseWriter, r *http.Request) {
+	problem := newProblem(r, http.StatusNotFound)
+	problem.Detail = "No route matches " + r.Method + " " + r.URL.Path
+	if t.Debug {
+		problem.Suggestions = t.suggestRoutes(r)
+	}
+	t.writeProblem(w, r, problem)
+}
+
+func (t *TreeMux) problemMethodNotAllowed(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+	problem := newProblem(r, http.StatusMethodNotAllowed)
+	problem.Allowed = make([]string, 0, len(methods))
+	for m := range methods {
+		problem.Allowed = append(problem.Allowed, m)
+	}
+	sort.Strings(problem.Allowed)
+	problem.Detail = r.URL.Path + " does not support method " + r.Method
+
+	w.Header().Set("Allow", strings.Join(problem.Allowed, ", "))
+	t.writeProblem(w, r, problem)
+}
+
+func newProblem(r *http.Request, status int) *Problem {
+	return &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+		Method:   r.Method,
+	}
+}
+
+// suggestion is a route suggested for a path which was not found, with the number of
+// segments by which it differs from the path.
+type suggestion struct {
+	pattern  string
+	distance int
+}
+
+// byDistance sorts suggestions by distance, and then by pattern.
+type byDistance []suggestion
+
+func (s byDistance) Len() int      { return len(s) }
+func (s byDistance) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byDistance) Less(i, j int) bool {
+	if s[i].distance != s[j].distance {
+		return s[i].distance < s[j].distance
+	}
+	return s[i].pattern < s[j].pattern
+}
+
+// suggestRoutes returns the registered patterns which differ from the request path by at
+// most one path segment. Segments are compared case-insensitively, and wildcards and
+// catch-alls match any segment.
+func (t *TreeMux) suggestRoutes(r *http.Request) []string {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	requested := splitSegments(r.URL.Path)
+	var suggestions []suggestion
+	seen := map[string]bool{}
+
+	walkRoutes(t.rootForHost(r.Host), func(n *node) {
+		for _, info := range n.leafRoute {
+			if seen[info.pattern] {
+				continue
+			}
+			seen[info.pattern] = true
+
+			distance := segmentDistance(requested, splitSegments(info.pattern))
+			if distance <= 1 {
+				suggestions = append(suggestions, suggestion{info.pattern, distance})
+			}
+		}
+	})
+
+	sort.Sort(byDistance(suggestions))
+
+	var patterns []string
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		patterns = append(patterns, suggestions[i].pattern)
+	}
+	return patterns
+}
+
+func splitSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// segmentDistance is the edit distance between a request path and a pattern, counting
+// path segments rather than characters.
+func segmentDistance(requested, pattern []string) int {
+	prev := make([]int, len(pattern)+1)
+	cur := make([]int, len(pattern)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(requested); i++ {
+		cur[0] = i
+		for j := 1; j <= len(pattern); j++ {
+			cost := 1
+			p := pattern[j-1]
+			if (len(p) > 0 && (p[0] == ':' || p[0] == '*')) || strings.EqualFold(p, requested[i-1]) {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(pattern)]
+}
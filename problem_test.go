@@ -0,0 +1,100 @@
+package httptreemux
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProblemResponses(t *testing.T) {
+	router := New()
+	router.GET("/about", simpleHandler)
+	api := router.NewGroup("/api")
+	api.GET("/users/:id", simpleHandler)
+	api.POST("/users/:id", simpleHandler)
+	api.GET("/orders", simpleHandler)
+	api.UseProblemResponses()
+
+	serve := func(method, path string) (*httptest.ResponseRecorder, *Problem) {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(method, path, nil)
+		router.ServeHTTP(w, r)
+		if w.Header().Get("Content-Type") != "application/problem+json" {
+			return w, nil
+		}
+
+		problem := &Problem{}
+		if err := json.Unmarshal(w.Body.Bytes(), problem); err != nil {
+			t.Fatalf("%s %s: invalid problem body %q: %v", method, path, w.Body.String(), err)
+		}
+		return w, problem
+	}
+
+	// Requests outside the group use the default handlers.
+	if w, problem := serve("GET", "/missing"); w.Code != 404 || problem != nil {
+		t.Errorf("Expected plain 404 outside the group, saw %d %q", w.Code, w.Body.String())
+	}
+	if w, problem := serve("GET", "/apiv2"); w.Code != 404 || problem != nil {
+		t.Errorf("Expected plain 404 for a path sharing the group's prefix, saw %d %q", w.Code, w.Body.String())
+	}
+
+	w, problem := serve("GET", "/api/user/5")
+	if w.Code != 404 || problem == nil {
+		t.Fatalf("Expected problem 404 in the group, saw %d %q", w.Code, w.Body.String())
+	}
+	if problem.Status != 404 || problem.Title != "Not Found" || problem.Instance != "/api/user/5" || problem.Method != "GET" {
+		t.Errorf("Unexpected problem %+v", problem)
+	}
+	if problem.Suggestions != nil {
+		t.Errorf("Expected no suggestions without Debug, saw %v", problem.Suggestions)
+	}
+
+	w, problem = serve("DELETE", "/api/users/5")
+	if w.Code != 405 || problem == nil {
+		t.Fatalf("Expected problem 405 in the group, saw %d %q", w.Code, w.Body.String())
+	}
+	if expected := []string{"GET", "HEAD", "POST"}; !reflect.DeepEqual(problem.Allowed, expected) {
+		t.Errorf("Expected allowed methods %v, saw %v", expected, problem.Allowed)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Errorf("Unexpected Allow header %q", allow)
+	}
+
+	router.Debug = true
+	_, problem = serve("GET", "/api/user/5")
+	if expected := []string{"/api/users/:id"}; !reflect.DeepEqual(problem.Suggestions, expected) {
+		t.Errorf("Expected suggestions %v, saw %v", expected, problem.Suggestions)
+	}
+
+	_, problem = serve("GET", "/api/ORDERS/x")
+	if expected := []string{"/api/orders", "/api/users/:id"}; !reflect.DeepEqual(problem.Suggestions, expected) {
+		t.Errorf("Expected suggestions %v, saw %v", expected, problem.Suggestions)
+	}
+
+	// Applying it to the whole router covers everything else.
+	router.UseProblemResponses()
+	if w, problem := serve("GET", "/missing"); w.Code != 404 || problem == nil {
+		t.Errorf("Expected problem 404 at the root, saw %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestSegmentDistance(t *testing.T) {
+	for _, test := range []struct {
+		requested, pattern string
+		expected           int
+	}{
+		{"/a/b", "/a/b", 0},
+		{"/a/B", "/a/b", 0},
+		{"/a/5", "/a/:id", 0},
+		{"/a/x/y", "/a/*path", 1},
+		{"/a", "/a/b", 1},
+		{"/x/y", "/a/b", 2},
+		{"/", "/", 0},
+	} {
+		distance := segmentDistance(splitSegments(test.requested), splitSegments(test.pattern))
+		if distance != test.expected {
+			t.Errorf("segmentDistance(%s, %s) = %d, expected %d", test.requested, test.pattern, distance, test.expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"context"
+	"errors"
+)
+
+// RouteDef describes a route supplied by a RouteProvider.
+type RouteDef struct {
+	Method  string
+	Path    string
+	Handler HandlerFunc
+	// Options are applied to the route in addition to those of the group that the
+	// provider is registered with.
+	Options []RouteOption
+}
+
+// RouteProvider is a module which supplies a set of routes, and needs to be started before
+// they can be served and stopped once they are removed. Providers are added to a group with
+// RegisterProvider.
+type RouteProvider interface {
+	// Routes returns the routes to add. The paths are relative to the group that the
+	// provider is registered with.
+	Routes() []RouteDef
+	// Start is called before the provider's routes are added.
+	Start(ctx context.Context) error
+	// Stop is called after the provider's routes have been removed.
+	Stop(ctx context.Context) error
+}
+
+// ErrProviderRegistered is returned by RegisterProvider if the provider is already registered,
+// and ErrProviderNotRegistered by StopProvider if it is not.
+var (
+	ErrProviderRegistered    = errors.New("httptreemux: route provider is already registered")
+	ErrProviderNotRegistered = errors.New("httptreemux: route provider is not registered")
+)
+
+// providerRegistration records a registered provider and the routes that it added.
+type providerRegistration struct {
+	provider RouteProvider
+	group    *Group
+	routes   []RouteDef
+}
+
+// RegisterProvider starts the provider and adds its routes to the group. If Start returns an
+// error, no routes are added. If a route can not be added, such as because it conflicts with
+// an existing route, the routes added so far are removed, the provider is stopped, and the error
+// from adding the route is returned.
+//
+// Providers can be registered while the router is serving requests, as long as
+// TreeMux.SafeAddRoutesWhileRunning is true.
+func (g *Group) RegisterProvider(ctx context.Context, p RouteProvider) error {
+	t := g.mux
+	reg := &providerRegistration{provider: p, group: g}
+
+	// Record the provider first, so that it can not be registered twice at once.
+	t.mutex.Lock()
+	if t.providerIndex(p) != -1 {
+		t.mutex.Unlock()
+		return ErrProviderRegistered
+	}
+	t.providers = append(t.providers, reg)
+	t.mutex.Unlock()
+
+	if err := p.Start(ctx); err != nil {
+		t.removeProvider(p)
+		return err
+	}
+
+	for _, route := range p.Routes() {
+		if err := g.With(route.Options...).TryHandle(route.Method, route.Path, route.Handler); err != nil {
+			t.removeProvider(p)
+			reg.removeRoutes()
+			p.Stop(ctx)
+			return err
+		}
+		reg.routes = append(reg.routes, route)
+	}
+
+	return nil
+}
+
+// StopProvider removes the routes added by a provider, and then stops it. The error returned
+// by the provider's Stop method is returned.
+func (t *TreeMux) StopProvider(ctx context.Context, p RouteProvider) error {
+	reg := t.removeProvider(p)
+	if reg == nil {
+		return ErrProviderNotRegistered
+	}
+
+	reg.removeRoutes()
+	return p.Stop(ctx)
+}
+
+// StopProviders stops all registered providers, in the reverse of the order in which they were
+// registered, as for StopProvider. It returns the first error returned by a provider, but
+// stops the others regardless.
+func (t *TreeMux) StopProviders(ctx context.Context) error {
+	t.mutex.RLock()
+	providers := append([]*providerRegistration(nil), t.providers...)
+	t.mutex.RUnlock()
+
+	var firstErr error
+	for i := len(providers) - 1; i >= 0; i-- {
+		err := t.StopProvider(ctx, providers[i].provider)
+		if err != nil && err != ErrProviderNotRegistered && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// providerIndex returns the index of the provider in t.providers, or -1.
+// The caller must hold the lock.
+func (t *TreeMux) providerIndex(p RouteProvider) int {
+	for i, reg := range t.providers {
+		if reg.provider == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeProvider removes the provider from t.providers and returns its registration,
+// or nil if it was not registered.
+func (t *TreeMux) removeProvider(p RouteProvider) *providerRegistration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	i := t.providerIndex(p)
+	if i == -1 {
+		return nil
+	}
+	reg := t.providers[i]
+	t.providers = append(t.providers[:i:i], t.providers[i+1:]...)
+	return reg
+}
+
+func (reg *providerRegistration) removeRoutes() {
+	for _, route := range reg.routes {
+		reg.group.Remove(route.Method, route.Path)
+	}
+}
@@ -0,0 +1,156 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testProvider struct {
+	name     string
+	routes   []RouteDef
+	startErr error
+	stopErr  error
+	started  bool
+	stopped  bool
+	stops    *[]string
+}
+
+func (p *testProvider) Routes() []RouteDef { return p.routes }
+
+func (p *testProvider) Start(ctx context.Context) error {
+	p.started = true
+	return p.startErr
+}
+
+func (p *testProvider) Stop(ctx context.Context) error {
+	p.stopped = true
+	if p.stops != nil {
+		*p.stops = append(*p.stops, p.name)
+	}
+	return p.stopErr
+}
+
+func TestRouteProvider(t *testing.T) {
+	ctx := context.Background()
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte(params["id"]))
+	}
+
+	serve := func(router *TreeMux, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	router := New()
+	p := &testProvider{routes: []RouteDef{
+		{Method: "GET", Path: "/users/:id", Handler: handler},
+		{Method: "GET", Path: "/users", Handler: handler, Options: []RouteOption{WithMetadata("list")}},
+	}}
+
+	if err := router.NewGroup("/api").RegisterProvider(ctx, p); err != nil {
+		t.Fatalf("RegisterProvider returned %v", err)
+	}
+	if !p.started {
+		t.Error("provider was not started")
+	}
+	if w := serve(router, "/api/users/5"); w.Code != http.StatusOK || w.Body.String() != "5" {
+		t.Errorf("expected provider route to be served, got %d %q", w.Code, w.Body.String())
+	}
+	if lr, _ := router.Lookup(nil, httptest.NewRequest("GET", "/api/users", nil)); lr.Metadata != "list" {
+		t.Errorf("expected route options to be applied, got metadata %v", lr.Metadata)
+	}
+
+	if err := router.RegisterProvider(ctx, p); err != ErrProviderRegistered {
+		t.Errorf("expected ErrProviderRegistered, got %v", err)
+	}
+
+	if err := router.StopProvider(ctx, p); err != nil {
+		t.Errorf("StopProvider returned %v", err)
+	}
+	if !p.stopped {
+		t.Error("provider was not stopped")
+	}
+	if w := serve(router, "/api/users/5"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after stopping provider, got %d", w.Code)
+	}
+	if err := router.StopProvider(ctx, p); err != ErrProviderNotRegistered {
+		t.Errorf("expected ErrProviderNotRegistered, got %v", err)
+	}
+
+	// The provider can be registered again once stopped.
+	p.stopped = false
+	if err := router.NewGroup("/api").RegisterProvider(ctx, p); err != nil {
+		t.Errorf("re-registering provider returned %v", err)
+	}
+	if w := serve(router, "/api/users/6"); w.Code != http.StatusOK {
+		t.Errorf("expected re-registered route to be served, got %d", w.Code)
+	}
+}
+
+func TestRouteProviderFailure(t *testing.T) {
+	ctx := context.Background()
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {}
+
+	router := New()
+	startErr := errors.New("start failed")
+	p := &testProvider{startErr: startErr, routes: []RouteDef{{Method: "GET", Path: "/a", Handler: handler}}}
+	if err := router.RegisterProvider(ctx, p); err != startErr {
+		t.Errorf("expected Start error, got %v", err)
+	}
+	if lr, found := router.Lookup(nil, httptest.NewRequest("GET", "/a", nil)); found {
+		t.Errorf("expected no routes after Start failure, got %v", lr.StatusCode)
+	}
+
+	router.GET("/taken", handler)
+	p = &testProvider{routes: []RouteDef{
+		{Method: "GET", Path: "/b", Handler: handler},
+		{Method: "GET", Path: "/taken", Handler: handler},
+	}}
+	if err := router.RegisterProvider(ctx, p); err == nil {
+		t.Error("expected conflicting route to return an error")
+	}
+	if !p.stopped {
+		t.Error("expected provider to be stopped after failing to add routes")
+	}
+	if _, found := router.Lookup(nil, httptest.NewRequest("GET", "/b", nil)); found {
+		t.Error("expected routes added before the conflict to be removed")
+	}
+	if _, found := router.Lookup(nil, httptest.NewRequest("GET", "/taken", nil)); !found {
+		t.Error("expected the existing route to remain")
+	}
+	if err := router.StopProvider(ctx, p); err != ErrProviderNotRegistered {
+		t.Errorf("expected failed provider not to be registered, got %v", err)
+	}
+}
+
+func TestStopProviders(t *testing.T) {
+	ctx := context.Background()
+	var stops []string
+	stopErr := errors.New("stop failed")
+
+	router := New()
+	for _, name := range []string{"a", "b", "c"} {
+		p := &testProvider{name: name, stops: &stops}
+		if name == "b" {
+			p.stopErr = stopErr
+		}
+		if err := router.RegisterProvider(ctx, p); err != nil {
+			t.Fatalf("RegisterProvider returned %v", err)
+		}
+	}
+
+	if err := router.StopProviders(ctx); err != stopErr {
+		t.Errorf("expected first Stop error, got %v", err)
+	}
+	if len(stops) != 3 || stops[0] != "c" || stops[1] != "b" || stops[2] != "a" {
+		t.Errorf("expected providers to be stopped in reverse order, got %v", stops)
+	}
+}
@@ -0,0 +1,12 @@
+// Package refmatch is a reference implementation of httptreemux's route matching, for
+// property-based tests of the router. The Matcher compares a path against every route, one
+// segment at a time, and ranks the matches by the router's priority rules, without any of the
+// optimizations of the tree. A Checker registers the same routes with both and reports the
+// paths for which they disagree, so that randomly generated route tables and paths can catch
+// changes to the tree which alter which route matches.
+//
+// Only exact matching is covered: the router used by a Checker has RedirectTrailingSlash off,
+// so a trailing slash is part of the pattern, and paths are not cleaned or lowercased.
+//
+// The package requires Go 1.8 or later.
+package refmatch
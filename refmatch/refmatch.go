@@ -0,0 +1,333 @@
+//go:build go1.8
+// +build go1.8
+
+package refmatch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+// The kinds of pattern segments, in the order of their priority.
+const (
+	static = iota
+	wildcard
+	catchAllContinuing
+	catchAll
+)
+
+type segment struct {
+	kind int
+	// The text of a static segment, or the name of a wildcard or catch-all.
+	value string
+	// The extensions of a catch-all, if it was given an extension set.
+	extensions []string
+}
+
+type route struct {
+	method  string
+	pattern string
+	// The pattern without wildcard names, which identifies the routes that share a node of
+	// the tree and so choose their handler by method together.
+	shape    string
+	segments []segment
+}
+
+// Match is a route matched by the Matcher.
+type Match struct {
+	// Method is the method of the route, which may differ from the method that was matched.
+	// It is empty in the Router match of a Mismatch.
+	Method  string
+	Pattern string
+	Params  httptreemux.Params
+}
+
+// Matcher matches paths against a list of routes. The zero value is ready to use.
+type Matcher struct {
+	routes []route
+}
+
+// Add adds a route in the syntax of the routing tree: static segments, :name wildcards, and
+// *name catch-alls, optionally with an extension set such as *file[.js,.css], and followed by
+// more segments. A backslash escapes a : or * at the start of a segment. Optional wildcards
+// and the brace syntax are not supported. If the same method and pattern are added twice, the
+// first route is used.
+func (m *Matcher) Add(method, pattern string) error {
+	if pattern == "" || pattern[0] != '/' {
+		return fmt.Errorf("pattern %q must start with /", pattern)
+	}
+	if strings.ContainsAny(pattern, "{}") {
+		return fmt.Errorf("pattern %q: the brace syntax is not supported", pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	r := route{method: method, pattern: pattern, segments: make([]segment, len(parts))}
+	shape := make([]string, len(parts))
+	for i, part := range parts {
+		s := segment{kind: static, value: part}
+		shape[i] = part
+		switch {
+		case strings.HasPrefix(part, ":"):
+			if strings.HasSuffix(part, "?") {
+				return fmt.Errorf("pattern %q: optional wildcards are not supported", pattern)
+			}
+			s = segment{kind: wildcard, value: part[1:]}
+			shape[i] = ":"
+		case strings.HasPrefix(part, "*"):
+			s.kind = catchAll
+			if i != len(parts)-1 {
+				s.kind = catchAllContinuing
+			}
+			s.value = part[1:]
+			if open := strings.IndexByte(s.value, '['); open != -1 {
+				if !strings.HasSuffix(s.value, "]") || open == len(s.value)-1 {
+					return fmt.Errorf("pattern %q: invalid extension set", pattern)
+				}
+				s.extensions = strings.Split(s.value[open+1:len(s.value)-1], ",")
+				for j := range s.extensions {
+					s.extensions[j] = strings.TrimSpace(s.extensions[j])
+				}
+				s.value = s.value[:open]
+			}
+		case len(part) >= 2 && part[0] == '\\' && (part[1] == ':' || part[1] == '*' || part[1] == '\\'):
+			s.value = part[1:]
+			shape[i] = part[1:]
+		}
+		r.segments[i] = s
+	}
+	r.shape = "/" + strings.Join(shape, "/")
+	m.routes = append(m.routes, r)
+	return nil
+}
+
+// candidate is one way that a route matches a path.
+type candidate struct {
+	route  *route
+	params []string
+	// The priority of each segment of the route as it was matched, compared in order.
+	rank []int
+}
+
+// Match returns the route which the router would choose to serve the method and path, with
+// its parameters in the order of the pattern, unescaped. It returns false if no route for
+// the method matches. As with the router, a GET route also serves HEAD requests when there is
+// no HEAD route for its pattern, and a route for httptreemux.MethodAny serves every method
+// which has no route of its own.
+func (m *Matcher) Match(method, path string) (Match, bool) {
+	if path == "" || path[0] != '/' {
+		return Match{}, false
+	}
+	segments := strings.Split(path[1:], "/")
+
+	var candidates []candidate
+	for i := range m.routes {
+		r := &m.routes[i]
+		if m.serving(method, r.shape) != r {
+			continue
+		}
+		r.match(r.segments, segments, nil, nil, &candidates)
+	}
+	if len(candidates) == 0 {
+		return Match{}, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return lessRank(candidates[i].rank, candidates[j].rank)
+	})
+	best := candidates[0]
+	match := Match{Method: best.route.method, Pattern: best.route.pattern}
+	names := best.route.paramNames()
+	for i, value := range best.params {
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		match.Params = append(match.Params, httptreemux.Param{Key: names[i], Value: value})
+	}
+	return match, true
+}
+
+// serving returns the route which serves the method for the routes with the given shape, or
+// nil if there is none.
+func (m *Matcher) serving(method, shape string) *route {
+	methods := []string{method, httptreemux.MethodAny}
+	if method == "HEAD" {
+		methods = []string{"HEAD", "GET", httptreemux.MethodAny}
+	}
+	for _, try := range methods {
+		for i := range m.routes {
+			if m.routes[i].shape == shape && m.routes[i].method == try {
+				return &m.routes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// match finds every way that the pattern segments match the path segments, and adds them to
+// the candidates.
+func (r *route) match(pattern []segment, path []string, params []string, rank []int, candidates *[]candidate) {
+	if len(pattern) == 0 {
+		if len(path) == 0 {
+			*candidates = append(*candidates, candidate{route: r, params: copyStrings(params), rank: copyInts(rank)})
+		}
+		return
+	}
+	if len(path) == 0 {
+		return
+	}
+
+	s := pattern[0]
+	switch s.kind {
+	case static:
+		if path[0] == s.value {
+			r.match(pattern[1:], path[1:], params, append(rank, static), candidates)
+		}
+	case wildcard:
+		if path[0] != "" {
+			r.match(pattern[1:], path[1:], append(params, path[0]), append(rank, wildcard), candidates)
+		}
+	case catchAllContinuing:
+		// The catch-all takes one or more segments, and leaves at least one for the rest of
+		// the pattern. Longer values take priority.
+		for n := 1; n < len(path); n++ {
+			value := strings.Join(path[:n], "/")
+			if value != "" && s.accepts(value) {
+				r.match(pattern[1:], path[n:], append(params, value),
+					append(rank, catchAllContinuing, len(path)-n), candidates)
+			}
+		}
+	case catchAll:
+		value := strings.Join(path, "/")
+		if value != "" && s.accepts(value) {
+			r.match(pattern[1:], nil, append(params, value), append(rank, catchAll), candidates)
+		}
+	}
+}
+
+// accepts returns true if the value has one of the extensions of the catch-all, or it has
+// none.
+func (s segment) accepts(value string) bool {
+	if s.extensions == nil {
+		return true
+	}
+	last := value[strings.LastIndexByte(value, '/')+1:]
+	for _, ext := range s.extensions {
+		if len(last) > len(ext) && strings.EqualFold(last[len(last)-len(ext):], ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// paramNames returns the names of the route's wildcards and catch-alls, in order.
+func (r *route) paramNames() []string {
+	var names []string
+	for _, s := range r.segments {
+		if s.kind != static {
+			names = append(names, s.value)
+		}
+	}
+	return names
+}
+
+// lessRank returns true if a match with rank a takes priority over one with rank b.
+func lessRank(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func copyStrings(s []string) []string {
+	return append([]string(nil), s...)
+}
+
+func copyInts(s []int) []int {
+	return append([]int(nil), s...)
+}
+
+// Mismatch is the error returned by Checker.Check when the router and the Matcher disagree.
+type Mismatch struct {
+	Method, Path string
+	// Router and Reference are the matches of each, with ok false if it found no route.
+	Router, Reference     Match
+	RouterOK, ReferenceOK bool
+}
+
+func (e *Mismatch) Error() string {
+	describe := func(m Match, ok bool) string {
+		if !ok {
+			return "no match"
+		}
+		return fmt.Sprintf("%s %v", m.Pattern, m.Params)
+	}
+	return fmt.Sprintf("%s %s: router found %s, reference found %s", e.Method, e.Path,
+		describe(e.Router, e.RouterOK), describe(e.Reference, e.ReferenceOK))
+}
+
+// Route is a route given to NewChecker.
+type Route struct {
+	Method, Pattern string
+}
+
+// Checker cross-checks the router against the Matcher for the same routes.
+type Checker struct {
+	Router    *httptreemux.TreeMux
+	Reference *Matcher
+	// Skipped are the routes which the router or the Matcher rejected, because they conflict
+	// with an earlier route or use syntax that the Matcher does not support. Neither has them.
+	Skipped []Route
+}
+
+// NewChecker returns a Checker with the routes added, in order, to a new router and Matcher.
+func NewChecker(routes []Route) *Checker {
+	c := &Checker{Router: httptreemux.New(), Reference: &Matcher{}}
+	c.Router.RedirectTrailingSlash = false
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {}
+	for _, r := range routes {
+		probe := &Matcher{}
+		if err := probe.Add(r.Method, r.Pattern); err != nil {
+			c.Skipped = append(c.Skipped, r)
+			continue
+		}
+		if err := c.Router.TryHandle(r.Method, r.Pattern, handler); err != nil {
+			c.Skipped = append(c.Skipped, r)
+			continue
+		}
+		c.Reference.Add(r.Method, r.Pattern)
+	}
+	return c
+}
+
+// Check matches the method and path with the router's LookupBytes and with the Matcher, and
+// returns a *Mismatch if they disagree on the route or its parameters.
+func (c *Checker) Check(method, path string) error {
+	routerMatch, routerOK := c.Router.LookupBytes(method, []byte(path), nil)
+	reference, referenceOK := c.Reference.Match(method, path)
+	got := Match{Pattern: routerMatch.Route, Params: routerMatch.Params}
+	if routerOK != referenceOK || (routerOK && !sameMatch(got, reference)) {
+		return &Mismatch{Method: method, Path: path, Router: got, Reference: reference,
+			RouterOK: routerOK, ReferenceOK: referenceOK}
+	}
+	return nil
+}
+
+// sameMatch returns true if the matches have the same pattern and parameters.
+func sameMatch(a, b Match) bool {
+	if a.Pattern != b.Pattern || len(a.Params) != len(b.Params) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	return true
+}
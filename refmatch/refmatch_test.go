@@ -0,0 +1,95 @@
+//go:build go1.8
+// +build go1.8
+
+package refmatch
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+func TestMatcher(t *testing.T) {
+	m := &Matcher{}
+	for _, r := range []Route{
+		{"GET", "/users/new"},
+		{"GET", "/users/:id"},
+		{"GET", "/users/:id/posts"},
+		{"GET", "/files/*path/meta"},
+		{"GET", "/files/*path"},
+		{"GET", "/assets/*file[.js,.css]"},
+		{"GET", `/\:literal`},
+		{httptreemux.MethodAny, "/any"},
+	} {
+		if err := m.Add(r.Method, r.Pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		method, path string
+		pattern      string
+		params       httptreemux.Params
+	}{
+		{"GET", "/users/new", "/users/new", nil},
+		{"GET", "/users/old", "/users/:id", httptreemux.Params{{Key: "id", Value: "old"}}},
+		{"HEAD", "/users/new", "/users/new", nil},
+		{"GET", "/users/a%20b/posts", "/users/:id/posts", httptreemux.Params{{Key: "id", Value: "a b"}}},
+		{"GET", "/files/a/meta/b/meta", "/files/*path/meta", httptreemux.Params{{Key: "path", Value: "a/meta/b"}}},
+		{"GET", "/files/a/b", "/files/*path", httptreemux.Params{{Key: "path", Value: "a/b"}}},
+		{"GET", "/assets/app.JS", "/assets/*file[.js,.css]", httptreemux.Params{{Key: "file", Value: "app.JS"}}},
+		{"GET", "/assets/index.html", "", nil},
+		{"GET", "/:literal", `/\:literal`, nil},
+		{"DELETE", "/any", "/any", nil},
+		{"POST", "/users/new", "", nil},
+		{"GET", "/users/", "", nil},
+	} {
+		match, ok := m.Match(test.method, test.path)
+		if ok != (test.pattern != "") || match.Pattern != test.pattern || !reflect.DeepEqual(match.Params, test.params) {
+			t.Errorf("%s %s: expected %q %v, saw %q %v", test.method, test.path, test.pattern, test.params,
+				match.Pattern, match.Params)
+		}
+	}
+
+	for _, pattern := range []string{"users", "/users/:id?", "/users/{id}", "/files/*path[.js"} {
+		if err := m.Add("GET", pattern); err == nil {
+			t.Errorf("%s: expected an error", pattern)
+		}
+	}
+}
+
+// TestRandomRoutes cross-checks the router with the Matcher on random route tables.
+func TestRandomRoutes(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	patternSegments := []string{"a", "b", "ab", "", ":x", ":y", "*c", "*c[.js]", `\:a`}
+	pathSegments := []string{"a", "b", "ab", "abc", "", "x.js", "a%2Fb", ":a"}
+	methods := []string{"GET", "POST", "HEAD", httptreemux.MethodAny}
+
+	join := func(choices []string, n int) string {
+		segments := make([]string, n)
+		for i := range segments {
+			segments[i] = choices[random.Intn(len(choices))]
+		}
+		return "/" + strings.Join(segments, "/")
+	}
+
+	for table := 0; table < 300; table++ {
+		routes := make([]Route, 1+random.Intn(12))
+		for i := range routes {
+			routes[i] = Route{methods[random.Intn(len(methods))], join(patternSegments, 1+random.Intn(4))}
+		}
+		c := NewChecker(routes)
+
+		for i := 0; i < 200; i++ {
+			path := join(pathSegments, 1+random.Intn(5))
+			for _, method := range []string{"GET", "HEAD", "POST", "PUT"} {
+				if err := c.Check(method, path); err != nil {
+					t.Fatalf("Routes %v: %v", routes, err)
+				}
+			}
+		}
+	}
+}
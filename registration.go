@@ -0,0 +1,22 @@
+package httptreemux
+
+import "time"
+
+// RegistrationStats describes the changes made to the routing tree to add a route, which are
+// reported to TreeMux.OnRouteRegistered. Routes which share long prefixes with many others,
+// or which are added in an order that keeps raising the priority of later siblings, cost more
+// to add, which matters to programs that generate thousands of routes at startup.
+type RegistrationStats struct {
+	Method  string
+	Pattern string
+	// NodesAdded is the number of nodes added to the tree, including those added by splits.
+	NodesAdded int
+	// Splits is the number of existing nodes which were split in two, because the path of
+	// the route shares only the start of their path.
+	Splits int
+	// Reorders is the number of times a node moved ahead of a sibling, because adding the
+	// route raised its priority above the sibling's.
+	Reorders int
+	// Duration is the time it took to add the route, according to Clock.
+	Duration time.Duration
+}
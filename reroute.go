@@ -0,0 +1,73 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// MaxReRoutes is the number of times a single request may be passed to ReRoute
+// before ReRoute returns ErrReRouteLoop.
+const MaxReRoutes = 10
+
+// ErrReRouteLoop is returned by ReRoute when a request has already been re-routed
+// MaxReRoutes times, which usually indicates routes which re-route to each other.
+var ErrReRouteLoop = errors.New("httptreemux: too many internal re-routes")
+
+// ReRoute dispatches the request internally to the route for the given method and path, as if
+// the client had requested it directly, without sending a redirect to the client. The path may
+// include a query string, which replaces the query string of the original request; otherwise the
+// original query string is kept.
+//
+// The re-routed request is handled exactly like a new request: redirects, NotFoundHandler and
+// MethodNotAllowedHandler all apply, and the ContextData of the request is updated to describe the
+// new route. The original request is not modified.
+//
+// To protect against routes which re-route to each other, ReRoute returns ErrReRouteLoop
+// without writing anything to w once a request has been re-routed MaxReRoutes times.
+//
+//	router.GET("/", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+//	    router.ReRoute(w, r, "GET", "/index.html")
+//	})
+func (t *TreeMux) ReRoute(w http.ResponseWriter, r *http.Request, method, path string) error {
+	depth, _ := r.Context().Value(reRouteDepthKey).(int)
+	if depth >= MaxReRoutes {
+		return ErrReRouteLoop
+	}
+
+	target, err := url.ParseRequestURI(path)
+	if err != nil {
+		return err
+	}
+
+	u := *r.URL
+	u.Path = target.Path
+	u.RawPath = target.RawPath
+	if target.RawQuery != "" || target.ForceQuery {
+		u.RawQuery = target.RawQuery
+	}
+
+	ctx := context.WithValue(r.Context(), reRouteDepthKey, depth+1)
+	rerouted := r.WithContext(ctx)
+	rerouted.Method = method
+	rerouted.URL = &u
+	rerouted.RequestURI = u.RequestURI()
+
+	result, _ := t.Lookup(w, rerouted)
+	if result.StatusCode == http.StatusOK {
+		rerouted = rerouted.WithContext(AddRouteDataToContext(ctx, &contextData{
+			route:    result.Route,
+			params:   result.Params,
+			metadata: result.Metadata,
+			canary:   result.Canary,
+			mount:    ContextMount(ctx),
+		}))
+	}
+
+	t.ServeLookupResult(w, rerouted, result)
+	return nil
+}
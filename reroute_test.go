@@ -0,0 +1,95 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestReRoute(t *testing.T) {
+	router := NewContextMux()
+
+	var seenRoute string
+	var seenParams map[string]string
+	var seenQuery string
+	router.GET("/articles/:id", func(w http.ResponseWriter, r *http.Request) {
+		data := ContextData(r.Context())
+		seenRoute = data.Route()
+		seenParams = data.Params()
+		seenQuery = r.URL.RawQuery
+		w.Write([]byte("article"))
+	})
+
+	router.GET("/legacy/:id", func(w http.ResponseWriter, r *http.Request) {
+		id := ContextParams(r.Context())["id"]
+		if err := router.ReRoute(w, r, "GET", "/articles/"+id); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+		if r.URL.Path != "/legacy/"+id {
+			t.Errorf("ReRoute modified the original request path to %s", r.URL.Path)
+		}
+	})
+
+	router.GET("/query", func(w http.ResponseWriter, r *http.Request) {
+		router.ReRoute(w, r, "GET", "/articles/5?page=2")
+	})
+
+	router.GET("/missing", func(w http.ResponseWriter, r *http.Request) {
+		router.ReRoute(w, r, "GET", "/does/not/exist")
+	})
+
+	var loopErr error
+	router.GET("/loop", func(w http.ResponseWriter, r *http.Request) {
+		if err := router.ReRoute(w, r, "GET", "/loop"); err != nil {
+			loopErr = err
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		seenRoute, seenParams, seenQuery = "", nil, ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/legacy/10?x=y")
+	if w.Code != http.StatusOK || w.Body.String() != "article" {
+		t.Errorf("Expected re-routed article response, saw %d %q", w.Code, w.Body.String())
+	}
+	if seenRoute != "/articles/:id" {
+		t.Errorf("Expected ContextData route /articles/:id, saw %s", seenRoute)
+	}
+	if !reflect.DeepEqual(seenParams, map[string]string{"id": "10"}) {
+		t.Errorf("Unexpected params %v", seenParams)
+	}
+	if seenQuery != "x=y" {
+		t.Errorf("Expected original query string to be kept, saw %q", seenQuery)
+	}
+
+	serve("/query?x=y")
+	if seenQuery != "page=2" {
+		t.Errorf("Expected query string from the re-route path, saw %q", seenQuery)
+	}
+
+	if w := serve("/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected re-route to a missing path to return 404, saw %d", w.Code)
+	}
+
+	for _, defaultContext := range []context.Context{nil, context.Background()} {
+		router.DefaultContext = defaultContext
+		loopErr = nil
+		if w := serve("/loop"); w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected re-route loop to return 500, saw %d", w.Code)
+		}
+		if loopErr != ErrReRouteLoop {
+			t.Errorf("Expected ErrReRouteLoop, saw %v", loopErr)
+		}
+	}
+}
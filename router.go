@@ -5,10 +5,16 @@
 package httptreemux
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
 )
 
 // The params argument contains the parameters parsed from wildcards and catch-alls in the URL.
@@ -46,6 +52,45 @@ const (
 	URLPath                      // Use r.URL.Path
 )
 
+// RedirectPreserveMethod returns 301 Moved Permanently for GET, HEAD, OPTIONS and TRACE requests,
+// and 308 Permanent Redirect for all other methods, so that clients and proxies which change
+// the method of a request to GET after a 301 redirect resubmit it unchanged.
+const RedirectPreserveMethod RedirectBehavior = UseHandler + 1
+
+// RefuseUnsafeRedirect returns 301 Moved Permanently for GET, HEAD, OPTIONS and TRACE requests,
+// but does not redirect requests with other methods, since clients which don't resend their body
+// after a redirect would lose it. Those requests are answered by TreeMux.RedirectRefusedHandler,
+// which by default explains which URL to use instead.
+const RefuseUnsafeRedirect RedirectBehavior = RedirectPreserveMethod + 1
+
+// FragmentBehavior sets how the router treats a raw '#' in the path of a request.
+//
+// Clients never send the fragment of a URL to the server, and a '#' within a path should
+// be escaped as %23. If a client sends one anyway, Go's HTTP server leaves it in both
+// RequestURI and URL.Path, whereas a request built with http.NewRequest has the fragment
+// moved into URL.Fragment. To make matching independent of how the request was built,
+// the router always removes the fragment before matching, or rejects the request.
+// An escaped %23 is not affected, and matches as part of the path.
+type FragmentBehavior int
+
+const (
+	StripFragment  FragmentBehavior = iota // Ignore the fragment when matching
+	RejectFragment                         // Respond with 400 Bad Request
+)
+
+// PathMismatchBehavior sets how the router treats a request whose raw path, taken from its
+// RequestURI, does not unescape to its URL.Path. This happens when a proxy or load balancer
+// forwards a malformed request, or when middleware changes URL.Path without RequestURI, as
+// http.StripPrefix does.
+type PathMismatchBehavior int
+
+const (
+	UsePathSource      PathMismatchBehavior = iota // Match the path chosen by PathSource, as usual
+	PreferRawPath                                  // Match the raw path from RequestURI
+	PreferURLPath                                  // Match URL.Path
+	RejectPathMismatch                             // Respond with 400 Bad Request
+)
+
 // LookupResult contains information about a route lookup, which is returned from Lookup and
 // can be passed to ServeLookupResult if the request should be served.
 type LookupResult struct {
@@ -56,26 +101,164 @@ type LookupResult struct {
 	StatusCode int
 	handler    HandlerFunc
 	// Params represents the key value pairs of the path parameters.
-	Params      map[string]string
-	leafHandler map[string]HandlerFunc // Only has a value when StatusCode is MethodNotAllowed.
+	Params map[string]string
+	// Route is the pattern of the matched route, as it was registered, without expanded
+	// wildcards. It is empty unless StatusCode is http.StatusOK.
+	Route string
+	// Metadata is the value attached to the matched route with WithMetadata, if any.
+	Metadata interface{}
+	// ImplicitTrailingSlash is true when the request matched the route only after a trailing
+	// slash was added to or removed from its path, and is served without being redirected
+	// because the redirect behavior for the route is UseHandler. Middleware can use it to
+	// log clients which request non-canonical URLs.
+	ImplicitTrailingSlash bool
+	// Assignment is the experiment variant chosen for the request, when its route takes part
+	// in an experiment added with WithExperiment.
+	Assignment *Assignment
+	// Canary is the name of the release serving the request, when its route was added with
+	// WithCanary.
+	Canary string
+	// MatchStats counts the work done to match the request path against the routing tree.
+	// It is only recorded when TreeMux.Debug or TreeMux.RecordMatchStats is true.
+	MatchStats *MatchStats
+	// AllowedMethods are the methods which have handlers for the matched path, sorted.
+	// It only has a value when StatusCode is http.StatusMethodNotAllowed.
+	AllowedMethods []string
+	leafHandler    map[string]HandlerFunc // Only has a value when StatusCode is MethodNotAllowed.
+	// Only has a value when TreeMux.Debug is true.
+	normalization *Normalization
+	// True when the handler was generated by the router rather than registered.
+	generated bool
+	// The fully canonical path, when the request is redirected.
+	canonical string
+	// The labels given for the route with WithLabels.
+	labels *labelSet
+	// The handler given for the route with WithPanicHandler.
+	panicHandler RoutePanicHandler
+	// The filter for the instrumentation hooks given with WithHookFilter.
+	hookFilter func(r *http.Request) bool
+	// The budget and response given for the route with WithTimeout.
+	timeout        time.Duration
+	timeoutHandler TimeoutHandler
+	// The response cache of the route given with WithResponseCache.
+	cache *responseCache
+	// The changes to the request's headers given for the route with WithoutRequestHeaders,
+	// WithRequestHeader and WithRouteHeader.
+	headerRules *headerRules
+	// The escaped values of the parameters, when unescaping changed any of them.
+	rawParams Params
+	// Only have values when the route was matched with pooled parameters.
+	paramsHandler ParamsHandlerFunc
+	pooledParams  *Params
+	// The node of the matched path, for Methods.
+	node *node
+}
+
+// Handler returns the handler which ServeLookupResult calls for the result: the handler of
+// the matched route, with the middleware of its group, or the handler generated by the router
+// for a redirect or an automatic OPTIONS response. It is nil when the lookup found no handler.
+// The handler must be called with the result's Params.
+func (lr LookupResult) Handler() HandlerFunc {
+	return lr.handler
 }
 
-// Dump returns a text representation of the routing tree.
+// RawParams returns the values of the path parameters as they appeared in the request,
+// before they were unescaped, so that a proxy can forward a segment containing an escaped
+// slash (%2F) or other escaped characters exactly as it was sent. A value is only escaped if
+// TreeMux.PathSource is RequestURI, which is the default.
+func (lr LookupResult) RawParams() map[string]string {
+	if lr.rawParams != nil {
+		return lr.rawParams.Map()
+	}
+	if lr.pooledParams != nil {
+		return lr.pooledParams.Map()
+	}
+	if lr.Params == nil {
+		return nil
+	}
+	raw := make(map[string]string, len(lr.Params))
+	for name, value := range lr.Params {
+		raw[name] = value
+	}
+	return raw
+}
+
+// Dump returns a text representation of the routing tree, followed by the tree
+// for each host added with Host.
 func (t *TreeMux) Dump() string {
-	return t.root.dumpTree("", "")
+	dump := dumpTree(t.root, "", "")
+	for _, h := range t.hosts {
+		dump += "host " + h.pattern + "\n" + dumpTree(h.root, "", "")
+	}
+	return dump
+}
+
+// DumpTree writes a readable description of the routing tree to w, followed by the tree for
+// each host added with Host, to help diagnose why one route takes precedence over another.
+// Each node is written on its own line, indented below its parent, with its kind (static,
+// param or catch-all), its path segment, if any, and its priority. Children are listed in
+// the order in which they are searched. Nodes which end a route also list the names of
+// their parameters and, for each method, the pattern of the route that handles it.
+//
+//	/ priority=0
+//	  static "users" priority=2 GET=/users HEAD=(implicit)
+//	    static "/" priority=1
+//	      static "new" priority=0 POST=/users/new
+//	      param priority=0 params=[id] GET=/users/:id HEAD=(implicit)
+func (t *TreeMux) DumpTree(w io.Writer) error {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	var buf bytes.Buffer
+	writeTree(&buf, t.root, "", "")
+	for _, h := range t.hosts {
+		buf.WriteString("host " + h.pattern + "\n")
+		writeTree(&buf, h.root, "", "")
+	}
+	_, err := buf.WriteTo(w)
+	return err
 }
 
 func (t *TreeMux) serveHTTPPanic(w http.ResponseWriter, r *http.Request) {
 	if err := recover(); err != nil {
-		t.PanicHandler(w, r, err)
+		if t.SafeAddRoutesWhileRunning {
+			t.mutex.RLock()
+		}
+		panicHandler := t.panicHandler(r)
+		if t.SafeAddRoutesWhileRunning {
+			t.mutex.RUnlock()
+		}
+
+		if panicHandler == nil {
+			// Only groups other than the request's have panic handlers.
+			panic(err)
+		}
+
+		handler := t.lockedRouterResponseHandler(r, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			panicHandler(w, r, err)
+		})
+		handler(w, r, nil)
 	}
 }
 
-func (t *TreeMux) redirectStatusCode(method string) (int, bool) {
-	var behavior RedirectBehavior
-	var ok bool
-	if behavior, ok = t.RedirectMethodBehavior[method]; !ok {
-		behavior = t.RedirectBehavior
+// lockedRouterResponseHandler is like routerResponseHandler, but takes the read lock if necessary.
+func (t *TreeMux) lockedRouterResponseHandler(r *http.Request, handler HandlerFunc) HandlerFunc {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+	return t.routerResponseHandler(r, handler)
+}
+
+// redirectStatusCode returns the status code for redirecting a request to the target path of a
+// route, or false if the route's handler should be called instead. The info may be nil if the
+// route is not known.
+func (t *TreeMux) redirectStatusCode(r *http.Request, info *routeInfo, target string) (int, bool) {
+	behavior, ok := t.redirectBehavior(r, info)
+	if !ok {
+		return t.RedirectPolicy(r, target)
 	}
 	switch behavior {
 	case Redirect301:
@@ -88,11 +271,55 @@ func (t *TreeMux) redirectStatusCode(method string) (int, bool) {
 		return 308, true
 	case UseHandler:
 		return 0, false
+	case RedirectPreserveMethod:
+		if isSafeMethod(r.Method) {
+			return http.StatusMovedPermanently, true
+		}
+		return 308, true
 	default:
 		return http.StatusMovedPermanently, true
 	}
 }
 
+// redirectBehavior returns the redirect behavior for a request to a route, or false if
+// RedirectPolicy decides instead. The info may be nil if the route is not known.
+func (t *TreeMux) redirectBehavior(r *http.Request, info *routeInfo) (RedirectBehavior, bool) {
+	if info != nil && info.redirectBehavior != nil {
+		return *info.redirectBehavior, true
+	} else if t.RedirectPolicy != nil {
+		return 0, false
+	}
+	if behavior, ok := t.RedirectMethodBehavior[r.Method]; ok {
+		return behavior, true
+	}
+	return t.RedirectBehavior, true
+}
+
+// refusesRedirect returns true if the request is not redirected because of the
+// RefuseUnsafeRedirect behavior.
+func (t *TreeMux) refusesRedirect(r *http.Request, info *routeInfo) bool {
+	behavior, ok := t.redirectBehavior(r, info)
+	return ok && behavior == RefuseUnsafeRedirect && !isSafeMethod(r.Method)
+}
+
+// redirectRefusedHandler returns the handler for a request which is not redirected to the
+// target path because of the RefuseUnsafeRedirect behavior.
+func (t *TreeMux) redirectRefusedHandler(target string) HandlerFunc {
+	refused := t.RedirectRefusedHandler
+	if refused == nil {
+		refused = t.redirectRefused
+	}
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		refused(w, r, redirectURL(r, target))
+	}
+}
+
+// isSafeMethod returns true for the methods which RFC 9110 defines as safe, whose requests a
+// client can repeat as a GET after a 301 redirect without losing anything.
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "TRACE"
+}
+
 func redirectHandler(newPath string, statusCode int) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 		redirect(w, r, newPath, statusCode)
@@ -100,35 +327,180 @@ func redirectHandler(newPath string, statusCode int) HandlerFunc {
 }
 
 func redirect(w http.ResponseWriter, r *http.Request, newPath string, statusCode int) {
+	http.Redirect(w, r, redirectURL(r, newPath), statusCode)
+}
+
+// redirectURL returns the URL for redirecting a request to a new path, keeping its query.
+func redirectURL(r *http.Request, newPath string) string {
 	newURL := url.URL{
 		Path:     newPath,
 		RawQuery: r.URL.RawQuery,
 		Fragment: r.URL.Fragment,
 	}
-	http.Redirect(w, r, newURL.String(), statusCode)
+	return newURL.String()
 }
 
-func (t *TreeMux) lookup(w http.ResponseWriter, r *http.Request) (result LookupResult, found bool) {
-	result.StatusCode = http.StatusNotFound
+// requestPath returns the path of the request which is matched against the tree, according
+// to the PathSource and PathMismatchBehavior settings, along with where it was taken from. It
+// returns false if the request must be rejected because its paths disagree.
+func (t *TreeMux) requestPath(r *http.Request) (string, PathSource, bool) {
 	path := r.RequestURI
-	unescapedPath := r.URL.Path
 	pathLen := len(path)
-	if pathLen > 0 && t.PathSource == RequestURI {
-		rawQueryLen := len(r.URL.RawQuery)
-
-		if rawQueryLen != 0 || path[pathLen-1] == '?' {
-			// Remove any query string and the ?.
-			path = path[:pathLen-rawQueryLen-1]
-			pathLen = len(path)
-		}
-	} else {
+	if pathLen == 0 {
 		// In testing with http.NewRequest,
 		// RequestURI is not set so just grab URL.Path instead.
-		path = r.URL.Path
-		pathLen = len(path)
+		return r.URL.Path, URLPath, true
+	}
+
+	rawQueryLen := len(r.URL.RawQuery)
+	if rawQueryLen != 0 || path[pathLen-1] == '?' {
+		// Remove any query string and the ?.
+		path = path[:pathLen-rawQueryLen-1]
+	}
+
+	source := t.PathSource
+	if t.PathMismatchBehavior != UsePathSource && pathsDisagree(path, r.URL.Path) {
+		switch t.PathMismatchBehavior {
+		case PreferRawPath:
+			source = RequestURI
+		case PreferURLPath:
+			source = URLPath
+		case RejectPathMismatch:
+			return "", source, false
+		}
+	}
+
+	if source != RequestURI {
+		return r.URL.Path, URLPath, true
+	}
+	return path, RequestURI, true
+}
+
+// pathsDisagree returns true if the raw path from a request's RequestURI does not unescape to
+// its URL.Path. A RequestURI in absolute form, as sent to proxies, is not compared.
+func pathsDisagree(raw, path string) bool {
+	if raw == "" || raw[0] != '/' {
+		return false
+	}
+	unescaped, err := unescape(raw)
+	return err != nil || unescaped != path
+}
+
+// rawFragment returns the part of the request's path that starts with an unescaped '#',
+// or an empty string if there is none.
+func rawFragment(r *http.Request) string {
+	target := r.RequestURI
+	if query := strings.IndexByte(target, '?'); query != -1 {
+		target = target[:query]
+	}
+	if hash := strings.IndexByte(target, '#'); hash != -1 {
+		return target[hash:]
+	}
+	return ""
+}
+
+func badRequestHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+// lookup finds the handler for a request. If pooled is true and the matched route takes its
+// parameters as Params, because it was added with HandleP or because TreeMux.PooledParams is
+// set, they are captured into a Params from the pool instead of a map, and the caller must
+// release them after serving the request.
+func (t *TreeMux) lookup(w http.ResponseWriter, r *http.Request, pooled bool) (result LookupResult, found bool) {
+	if t.MethodPolicy != PassMethods && !validMethod(r.Method) {
+		return LookupResult{StatusCode: http.StatusNotImplemented, handler: notImplementedHandler}, false
+	}
+	r = t.normalizeMethod(r)
+
+	result.StatusCode = http.StatusNotFound
+	path, source, ok := t.requestPath(r)
+	if !ok {
+		return LookupResult{StatusCode: http.StatusBadRequest, handler: badRequestHandler}, false
+	}
+	unescapedPath := r.URL.Path
+	if source == RequestURI && t.PathMismatchBehavior == PreferRawPath {
+		// The raw path may not match URL.Path, so unescape it instead.
+		if unescaped, err := unescape(path); err == nil {
+			unescapedPath = unescaped
+		}
+	}
+
+	var norm *Normalization
+	if t.Debug {
+		norm = &Normalization{Original: path, Unescaped: unescapedPath}
+		defer func() {
+			if norm.Searched != "" {
+				norm.Candidates = candidates(t.rootForHost(r.Host), r.Method, norm.Searched[1:])
+			}
+			result.normalization = norm
+		}()
+	}
+
+	if fragment := rawFragment(r); fragment != "" {
+		if t.FragmentBehavior == RejectFragment {
+			return LookupResult{StatusCode: http.StatusBadRequest, handler: badRequestHandler}, false
+		}
+
+		unescapedFragment := fragment
+		if unescaped, err := unescape(fragment); err == nil {
+			unescapedFragment = unescaped
+		}
+		if source == RequestURI {
+			path = strings.TrimSuffix(path, fragment)
+		} else {
+			path = strings.TrimSuffix(path, unescapedFragment)
+		}
+		unescapedPath = strings.TrimSuffix(unescapedPath, unescapedFragment)
+		if path == "" {
+			path = "/"
+		}
+		if unescapedPath == "" {
+			unescapedPath = "/"
+		}
+		norm.add(TransformStripFragment)
+	}
+
+	if path == "" {
+		// Only possible for requests which were not received by a server.
+		path = "/"
+	}
+	if unescapedPath == "" {
+		unescapedPath = "/"
+	}
+
+	var locale, localePrefix string
+	if len(t.Locales) != 0 {
+		if locale = t.pathLocale(path); locale != "" {
+			localePrefix = "/" + locale
+			path = stripLocale(path, locale)
+			unescapedPath = stripLocale(unescapedPath, locale)
+			norm.add(TransformStripLocale)
+		}
+	}
+	requested := unescapedPath
+
+	cleaned := false
+	if t.CleanPath {
+		if cleanPath := Clean(decodeDots(path)); cleanPath != path {
+			path = cleanPath
+			unescapedPath = Clean(unescapedPath)
+			cleaned = true
+			if norm != nil {
+				norm.Cleaned = unescapedPath
+				norm.add(TransformCleanPath)
+			}
+		}
 	}
+
+	pathLen := len(path)
+	casedPath := unescapedPath
 	if t.CaseInsensitive {
-		path = strings.ToLower(path)
+		lowerPath := strings.ToLower(path)
+		if lowerPath != path {
+			norm.add(TransformLowercase)
+		}
+		path = lowerPath
 		unescapedPath = strings.ToLower(unescapedPath)
 	}
 
@@ -136,22 +508,49 @@ func (t *TreeMux) lookup(w http.ResponseWriter, r *http.Request) (result LookupR
 	if trailingSlash && t.RedirectTrailingSlash {
 		path = path[:pathLen-1]
 		unescapedPath = unescapedPath[:len(unescapedPath)-1]
+		norm.add(TransformStripTrailingSlash)
+	}
+
+	if norm != nil {
+		norm.Searched = path
+	}
+
+	var stats *pathtree.Stats
+	if t.Debug || t.RecordMatchStats {
+		stats = &pathtree.Stats{}
+		defer func() {
+			matchStats := MatchStats(*stats)
+			result.MatchStats = &matchStats
+		}()
 	}
 
-	n, handler, params := t.root.search(r.Method, path[1:])
+	root := t.rootForHost(r.Host)
+	n, handler, params := search(root, r.Method, path[1:], stats)
 	if n == nil {
 		if t.RedirectCleanPath {
 			// Path was not found. Try cleaning it up and search again.
 			// TODO Test this
 			cleanPath := Clean(unescapedPath)
-			n, handler, params = t.root.search(r.Method, cleanPath[1:])
+			if norm != nil {
+				norm.Cleaned = cleanPath
+				norm.Searched = cleanPath
+				if cleanPath != unescapedPath {
+					norm.add(TransformCleanPath)
+				}
+			}
+			n, handler, params = search(root, r.Method, cleanPath[1:], stats)
 			if n == nil {
 				// Still nothing found.
 				return
 			}
-			if statusCode, ok := t.redirectStatusCode(r.Method); ok {
+			info := n.route(r.Method)
+			if info != nil && info.noRedirects {
+				// The route only matches its exact path.
+				return
+			}
+			if result, ok := t.redirectResult(r, n, info, localePrefix, requested, cleanPath); ok {
 				// Redirect to the actual path
-				return LookupResult{statusCode, redirectHandler(cleanPath, statusCode), nil, nil}, true
+				return result, true
 			}
 		} else {
 			// Not found.
@@ -159,60 +558,319 @@ func (t *TreeMux) lookup(w http.ResponseWriter, r *http.Request) (result LookupR
 		}
 	}
 
+	generated := false
 	if handler == nil {
 		if r.Method == "OPTIONS" && t.OptionsHandler != nil {
 			handler = t.OptionsHandler
+		} else if r.Method == "OPTIONS" && t.AutomaticOptions && len(n.leafHandler) != 0 {
+			handler = t.automaticOptionsHandler(n.leafHandler)
+			generated = true
 		}
 
 		if handler == nil {
 			result.leafHandler = n.leafHandler
+			result.node = n
+			result.AllowedMethods = make([]string, 0, len(n.leafHandler))
+			for m := range n.leafHandler {
+				result.AllowedMethods = append(result.AllowedMethods, m)
+			}
+			sort.Strings(result.AllowedMethods)
+			result.hookFilter = methodNotAllowedHookFilter(n.leafRoute)
 			result.StatusCode = http.StatusMethodNotAllowed
 			return
 		}
 	}
 
-	if !n.isCatchAll || t.RemoveCatchAllTrailingSlash {
+	if cleaned && t.RedirectCleanPath {
+		info := n.route(r.Method)
+		if info != nil && info.noRedirects {
+			return LookupResult{StatusCode: http.StatusNotFound}, false
+		}
+		target := unescapedPath
+		if trailingSlash && t.RedirectTrailingSlash {
+			// Keep the slash that was removed above. If the route does not want it,
+			// the next request is redirected again.
+			target += "/"
+		}
+		if result, ok := t.redirectResult(r, n, info, localePrefix, requested, target); ok {
+			return result, true
+		}
+	}
+
+	implicitSlash := false
+	if n.Kind() != pathtree.CatchAll || t.RemoveCatchAllTrailingSlash {
 		if trailingSlash != n.addSlash && t.RedirectTrailingSlash {
-			if statusCode, ok := t.redirectStatusCode(r.Method); ok {
-				var h HandlerFunc
-				if n.addSlash {
-					// Need to add a slash.
-					h = redirectHandler(unescapedPath+"/", statusCode)
-				} else if path != "/" {
-					// We need to remove the slash. This was already done at the
-					// beginning of the function.
-					h = redirectHandler(unescapedPath, statusCode)
+			implicitSlash = true
+			info := n.route(r.Method)
+			if info != nil && info.noRedirects {
+				// The route only matches its exact path.
+				return LookupResult{StatusCode: http.StatusNotFound}, false
+			}
+			if n.addSlash {
+				// Need to add a slash.
+				if result, ok := t.redirectResult(r, n, info, localePrefix, requested, unescapedPath+"/"); ok {
+					return result, true
 				}
-
-				if h != nil {
-					return LookupResult{statusCode, h, nil, nil}, true
+			} else if path != "/" {
+				// We need to remove the slash. This was already done at the
+				// beginning of the function.
+				if result, ok := t.redirectResult(r, n, info, localePrefix, requested, unescapedPath); ok {
+					return result, true
 				}
 			}
 		}
 	}
 
-	var paramMap map[string]string
-	if len(params) != 0 {
-		if len(params) != len(n.leafWildcardNames) {
-			// Need better behavior here. Should this be a panic?
-			panic(fmt.Sprintf("httptreemux parameter list length mismatch: %v, %v",
-				params, n.leafWildcardNames))
+	if t.CaseInsensitive && t.RedirectCanonicalCase && !generated {
+		info := n.route(r.Method)
+		if canonical, ok := canonicalCasePath(info, casedPath); ok && canonical != casedPath && !info.noRedirects {
+			if result, ok := t.redirectResult(r, n, info, localePrefix, requested, canonical); ok {
+				return result, true
+			}
 		}
+	}
+
+	if len(params) != 0 && len(params) != len(n.WildcardNames()) {
+		// Need better behavior here. Should this be a panic?
+		panic(fmt.Sprintf("httptreemux parameter list length mismatch: %v, %v",
+			params, n.WildcardNames()))
+	}
+
+	info := n.route(r.Method)
+	var assignment *Assignment
+	if n.leafVariants != nil && !generated {
+		var ok bool
+		if handler, info, assignment, ok = n.selectRoute(r.Method, r, t.random); !ok {
+			// None of the routes' match options accept the request.
+			return LookupResult{StatusCode: http.StatusNotFound}, false
+		}
+	}
+
+	if !generated && t.rejectsEarlyData(r, info) {
+		return LookupResult{StatusCode: StatusTooEarly, handler: tooEarlyHandler}, false
+	}
+
+	if !generated && t.rejectsSource(r, info) {
+		return LookupResult{StatusCode: http.StatusForbidden, handler: t.forbiddenHandler()}, false
+	}
+
+	params, rawParams := unescapeParams(params)
+
+	result = LookupResult{StatusCode: http.StatusOK, handler: handler, generated: generated,
+		ImplicitTrailingSlash: implicitSlash, Assignment: assignment, node: n}
+	if rawParams != nil {
+		result.rawParams = newParams(n.WildcardNames()[:len(rawParams)], rawParams)
+	}
+	if info != nil {
+		result.Route = info.pattern
+		result.Metadata = info.metadata
+		result.labels = info.labels
+		result.panicHandler = info.panicHandler
+		result.hookFilter = info.hookFilter
+		result.timeout = info.timeout
+		result.timeoutHandler = info.timeoutHandler
+		result.cache = info.cache
+		result.Canary = info.canary
+		result.headerRules = info.headerRules
+	}
 
-		paramMap = make(map[string]string)
+	// A handler which times out, or refreshes a cached response, may still be running when the
+	// pooled parameters are released.
+	if pooled && info != nil && info.paramsHandler != nil && (t.PooledParams || info.paramsRoute) &&
+		info.timeout == 0 && info.cache == nil {
+		result.paramsHandler = info.paramsHandler
+		result.pooledParams = pooledParams(n.WildcardNames()[:len(params)], params)
+		if locale != "" {
+			*result.pooledParams = append(*result.pooledParams, Param{Key: LocaleParam, Value: locale})
+		}
+	} else if len(params) != 0 || locale != "" {
+		paramMap := make(map[string]string)
 		numParams := len(params)
 		for index := 0; index < numParams; index++ {
-			paramMap[n.leafWildcardNames[numParams-index-1]] = params[index]
+			paramMap[n.WildcardNames()[numParams-index-1]] = params[index]
+		}
+		if locale != "" {
+			paramMap[LocaleParam] = locale
 		}
+		result.Params = paramMap
 	}
+	if locale != "" && result.rawParams != nil {
+		result.rawParams = append(result.rawParams, Param{Key: LocaleParam, Value: locale})
+	}
+	return result, true
+}
 
-	return LookupResult{http.StatusOK, handler, paramMap, nil}, true
+// unescapeParams unescapes the parameter values returned by search. If any of them changed,
+// it returns the original values as well, and otherwise nil.
+func unescapeParams(params []string) (unescaped []string, raw []string) {
+	for i, value := range params {
+		if strings.IndexByte(value, '%') == -1 {
+			continue
+		}
+		u, err := unescape(value)
+		if err != nil || u == value {
+			continue
+		}
+		if raw == nil {
+			raw = params
+			unescaped = make([]string, len(params))
+			copy(unescaped, params)
+		}
+		unescaped[i] = u
+	}
+	if raw == nil {
+		return params, nil
+	}
+	return unescaped, raw
+}
+
+// redirectResult returns the result for a request for the requested path which is redirected
+// to target, the path with the first correction made. If MaxCanonicalizationPasses allows,
+// the request is redirected to the path with more corrections made instead. The prefix is the
+// locale segment which was removed from the paths, if any. It returns false if the request
+// should be served by the route's handler instead of being redirected.
+func (t *TreeMux) redirectResult(r *http.Request, n *node, info *routeInfo, prefix, requested, target string) (LookupResult, bool) {
+	if t.MaxCanonicalizationPasses > 1 {
+		target = t.canonicalPath(n, info, requested, t.MaxCanonicalizationPasses)
+	}
+	target = addPathPrefix(prefix, target)
+	canonical := addPathPrefix(prefix, t.canonicalPath(n, info, requested, -1))
+	if t.refusesRedirect(r, info) {
+		return LookupResult{StatusCode: http.StatusNotFound, handler: t.redirectRefusedHandler(target),
+			canonical: canonical}, true
+	}
+	statusCode, ok := t.redirectStatusCode(r, info, target)
+	if !ok {
+		return LookupResult{}, false
+	}
+	return LookupResult{StatusCode: statusCode, handler: redirectHandler(target, statusCode), canonical: canonical}, true
+}
+
+// canonicalPath applies the router's corrections to the unescaped path of a request which
+// matched n, in the order that successive redirects would make them: cleaning the path, adding
+// or removing the trailing slash, and changing the case. It stops after the given number of
+// corrections have changed the path, or makes all of them if passes is negative.
+func (t *TreeMux) canonicalPath(n *node, info *routeInfo, path string, passes int) string {
+	if t.RedirectCleanPath || t.CleanPath {
+		if cleaned := Clean(path); cleaned != path {
+			path = cleaned
+			if t.RedirectCleanPath {
+				if passes--; passes == 0 {
+					return path
+				}
+			}
+		}
+	}
+
+	if t.RedirectTrailingSlash && (n.Kind() != pathtree.CatchAll || t.RemoveCatchAllTrailingSlash) && path != "/" {
+		hasSlash := path[len(path)-1] == '/'
+		if hasSlash != n.addSlash {
+			if n.addSlash {
+				path += "/"
+			} else {
+				path = path[:len(path)-1]
+			}
+			if passes--; passes == 0 {
+				return path
+			}
+		}
+	}
+
+	if t.CaseInsensitive && t.RedirectCanonicalCase {
+		if canonical, ok := canonicalCasePath(info, path); ok {
+			path = canonical
+		}
+	}
+	return path
+}
+
+// decodeDots decodes percent-encoded dots in a raw path, so that encoded dot segments
+// are removed by Clean.
+func decodeDots(path string) string {
+	if !strings.Contains(path, "%2") {
+		return path
+	}
+	path = strings.Replace(path, "%2e", ".", -1)
+	return strings.Replace(path, "%2E", ".", -1)
+}
+
+// canonicalCasePath returns the path of a request that was matched case-insensitively, with the
+// static segments changed to the casing of the route's pattern or of the alias it matched.
+func canonicalCasePath(info *routeInfo, path string) (string, bool) {
+	if info == nil || strings.Contains(path, "//") {
+		return "", false
+	}
+
+	if canonical, ok := patternCasePath(info.pattern, path); ok {
+		return canonical, true
+	}
+	for _, alias := range info.aliases {
+		if canonical, ok := patternCasePath(alias, path); ok {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// patternCasePath returns the path with the case of its static segments changed to
+// match the pattern, if the path corresponds to the pattern segment by segment.
+func patternCasePath(pattern, path string) (string, bool) {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	for len(patternSegments) > len(pathSegments) && isOptionalSegment(patternSegments[len(patternSegments)-1]) {
+		// The optional parameters which were not given.
+		patternSegments = patternSegments[:len(patternSegments)-1]
+	}
+	for i, segment := range patternSegments {
+		if i >= len(pathSegments) {
+			return "", false
+		}
+
+		if segment != "" && segment[0] == '*' {
+			// The catch-all matches the path up to the segments which follow it in the pattern.
+			end := len(pathSegments) - (len(patternSegments) - i - 1)
+			if end <= i {
+				return "", false
+			}
+			rest := strings.Join(pathSegments[i:end], "/")
+			pathSegments = append(pathSegments[:i], append([]string{rest}, pathSegments[end:]...)...)
+			continue
+		} else if segment != "" && segment[0] == ':' {
+			continue
+		}
+
+		if segment != "" && segment[0] == '\\' {
+			segment = segment[1:]
+		}
+		if !strings.EqualFold(segment, pathSegments[i]) {
+			// The path does not correspond to the pattern segment by segment, for example
+			// because a wildcard value contained an escaped slash.
+			return "", false
+		}
+		pathSegments[i] = segment
+	}
+
+	if len(pathSegments) != len(patternSegments) {
+		return "", false
+	}
+	return strings.Join(pathSegments, "/"), true
+}
+
+// Canonicalize returns the path that the request would be redirected to, with every correction
+// that the router makes to paths applied at once, and true. This is the target that a redirect
+// has when MaxCanonicalizationPasses is large enough, regardless of its actual value. If the
+// request would not be redirected, it returns an empty string and false.
+func (t *TreeMux) Canonicalize(r *http.Request) (string, bool) {
+	result, _ := t.Lookup(nil, r)
+	return result.canonical, result.canonical != ""
 }
 
 // Lookup performs a lookup without actually serving the request or mutating the request or response.
 // The return values are a LookupResult and a boolean. The boolean will be true when a handler
 // was found or the lookup resulted in a redirect which will point to a real handler. It is false
-// for requests which would result in a `StatusNotFound` or `StatusMethodNotAllowed`.
+// for requests which would result in a `StatusNotFound` or `StatusMethodNotAllowed`, or a
+// `StatusBadRequest` when FragmentBehavior is RejectFragment, or a `StatusNotImplemented` for
+// an invalid method when MethodPolicy rejects them.
 //
 // Regardless of the returned boolean's value, the LookupResult may be passed to ServeLookupResult
 // to be served appropriately.
@@ -223,7 +881,7 @@ func (t *TreeMux) Lookup(w http.ResponseWriter, r *http.Request) (LookupResult,
 		t.mutex.RLock()
 	}
 
-	result, found := t.lookup(w, r)
+	result, found := t.lookup(w, r, false)
 
 	if t.SafeAddRoutesWhileRunning {
 		t.mutex.RUnlock()
@@ -234,28 +892,58 @@ func (t *TreeMux) Lookup(w http.ResponseWriter, r *http.Request) (LookupResult,
 
 // ServeLookupResult serves a request, given a lookup result from the Lookup function.
 func (t *TreeMux) ServeLookupResult(w http.ResponseWriter, r *http.Request, lr LookupResult) {
-	if lr.handler == nil {
+	if lr.handler == nil && t.Fallback != nil {
+		t.Fallback.ServeHTTP(w, r)
+	} else if lr.handler == nil {
+		r = requestWithNormalization(r, lr.normalization)
 		if lr.StatusCode == http.StatusMethodNotAllowed && lr.leafHandler != nil {
-			if t.SafeAddRoutesWhileRunning {
-				t.mutex.RLock()
+			if t.OnMethodNotAllowed != nil && lr.callHooks(r) {
+				t.OnMethodNotAllowed(r, lr)
 			}
+		} else if t.OnNotFound != nil {
+			t.OnNotFound(r)
+		}
 
-			t.MethodNotAllowedHandler(w, r, lr.leafHandler)
+		if t.SafeAddRoutesWhileRunning {
+			t.mutex.RLock()
+		}
 
+		notFound, methodNotAllowed := t.errorHandlers(r)
+		if lr.StatusCode == http.StatusMethodNotAllowed && lr.leafHandler != nil {
+			handler := t.routerResponseHandler(r, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				methodNotAllowed(w, r, lr.leafHandler)
+			})
 			if t.SafeAddRoutesWhileRunning {
-				t.mutex.RUnlock()
+				// The lock is held while the handler reads the methods map.
+				defer t.mutex.RUnlock()
 			}
+			handler(w, r, nil)
 		} else {
-			t.NotFoundHandler(w, r)
+			handler := t.routerResponseHandler(r, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				notFound(w, r)
+			})
+			if t.SafeAddRoutesWhileRunning {
+				t.mutex.RUnlock()
+			}
+
+			handler(w, r, nil)
 		}
+	} else if lr.StatusCode != http.StatusOK || lr.generated {
+		// A redirect or other response generated by the router.
+		r = t.setDefaultRequestContext(r)
+		r = requestWithNormalization(r, lr.normalization)
+		t.lockedRouterResponseHandler(r, lr.handler)(w, r, lr.Params)
 	} else {
 		r = t.setDefaultRequestContext(r)
-		lr.handler(w, r, lr.Params)
+		r = requestWithNormalization(r, lr.normalization)
+		r = requestWithRawParams(r, lr.rawParams)
+		r = requestWithAssignment(r, lr.Assignment)
+		t.serveMatched(w, r, lr)
 	}
 }
 
 func (t *TreeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if t.PanicHandler != nil {
+	if t.PanicHandler != nil || t.scopedPanicHandlers {
 		defer t.serveHTTPPanic(w, r)
 	}
 
@@ -265,13 +953,37 @@ func (t *TreeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		t.mutex.RLock()
 	}
 
-	result, _ := t.lookup(w, r)
+	r = t.normalizeMethod(r)
+	result, _ := t.lookup(w, r, true)
 
 	if t.SafeAddRoutesWhileRunning {
 		t.mutex.RUnlock()
 	}
 
 	t.ServeLookupResult(w, r, result)
+	if result.pooledParams != nil {
+		releaseParams(result.pooledParams)
+	}
+}
+
+// automaticOptionsHandler returns the handler for an OPTIONS request to a path with the
+// given handlers, for use when AutomaticOptions is set.
+func (t *TreeMux) automaticOptionsHandler(methods map[string]HandlerFunc) HandlerFunc {
+	allowed := make([]string, 0, len(methods)+1)
+	allowed = append(allowed, "OPTIONS")
+	for m := range methods {
+		allowed = append(allowed, m)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		if t.GlobalOPTIONS != nil {
+			t.GlobalOPTIONS(w, r, allowed)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
 }
 
 // MethodNotAllowedHandler is the default handler for TreeMux.MethodNotAllowedHandler,
@@ -290,17 +1002,18 @@ func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request,
 
 func New() *TreeMux {
 	tm := &TreeMux{
-		root:                    &node{path: "/"},
-		NotFoundHandler:         http.NotFound,
-		MethodNotAllowedHandler: MethodNotAllowedHandler,
-		HeadCanUseGet:           true,
-		RedirectTrailingSlash:   true,
-		RedirectCleanPath:       true,
-		RedirectBehavior:        Redirect301,
-		RedirectMethodBehavior:  make(map[string]RedirectBehavior),
-		PathSource:              RequestURI,
-		EscapeAddedRoutes:       false,
+		root:                   pathtree.NewRoot(),
+		HeadCanUseGet:          true,
+		RedirectTrailingSlash:  true,
+		RedirectCleanPath:      true,
+		RedirectBehavior:       Redirect301,
+		RedirectMethodBehavior: make(map[string]RedirectBehavior),
+		PathSource:             RequestURI,
+		EscapeAddedRoutes:      false,
 	}
 	tm.Group.mux = tm
+	tm.mutex.now = tm.now
+	tm.NotFoundHandler = tm.notFound
+	tm.MethodNotAllowedHandler = tm.methodNotAllowed
 	return tm
 }
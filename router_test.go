@@ -1,6 +1,8 @@
 package httptreemux
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"math/rand"
@@ -249,6 +251,68 @@ func TestMethodNotAllowedHandler(t *testing.T) {
 	}
 }
 
+func TestAutomaticOptions(t *testing.T) {
+	router := New()
+	router.AutomaticOptions = true
+	router.GET("/user/:id", simpleHandler)
+	router.PUT("/user/:id", simpleHandler)
+	router.OPTIONS("/custom", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("OPTIONS", "/user/abc", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected code 204, saw %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("Expected Allow header GET, HEAD, OPTIONS, PUT, saw %q", allow)
+	}
+
+	var allowed []string
+	router.GlobalOPTIONS = func(w http.ResponseWriter, r *http.Request, methods []string) {
+		allowed = methods
+		w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+		w.WriteHeader(http.StatusOK)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("GlobalOPTIONS was not called, code %d, headers %v", w.Code, w.Header())
+	}
+	if !reflect.DeepEqual(allowed, []string{"GET", "HEAD", "OPTIONS", "PUT"}) {
+		t.Errorf("Unexpected allowed methods %v", allowed)
+	}
+
+	// A route's own OPTIONS handler takes precedence.
+	w = httptest.NewRecorder()
+	r, _ = newRequest("OPTIONS", "/custom", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Expected the route's OPTIONS handler to be called, saw code %d", w.Code)
+	}
+
+	// So does OptionsHandler.
+	router.OptionsHandler = func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	w = httptest.NewRecorder()
+	r, _ = newRequest("OPTIONS", "/user/abc", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected OptionsHandler to be called, saw code %d", w.Code)
+	}
+	router.OptionsHandler = nil
+
+	w = httptest.NewRecorder()
+	r, _ = newRequest("OPTIONS", "/missing", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected code 404 for a path without routes, saw %d", w.Code)
+	}
+}
+
 func TestOptionsHandler(t *testing.T) {
 	optionsHandler := func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -359,6 +423,90 @@ func TestPanic(t *testing.T) {
 	}
 }
 
+func TestRoutePanicHandler(t *testing.T) {
+	router := New()
+	sawGlobal := false
+	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}) {
+		sawGlobal = true
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	var sawResult LookupResult
+	var sawErr interface{}
+	payments := router.NewGroup("/payments").With(WithPanicHandler(
+		func(w http.ResponseWriter, r *http.Request, lr LookupResult, err interface{}) {
+			sawResult, sawErr = lr, err
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	payments.UseHandler(func(next http.Handler) http.Handler { return next })
+	payments.GET("/:id", panicHandler)
+	router.GET("/tools", panicHandler)
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/payments/42", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable || sawGlobal {
+		t.Errorf("Expected the route's panic handler to be used, saw %d", w.Code)
+	}
+	if sawResult.Route != "/payments/:id" || sawResult.Params["id"] != "42" || sawErr != "test panic" {
+		t.Errorf("Expected the route and params of the panic, saw %q %v %v", sawResult.Route, sawResult.Params, sawErr)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = newRequest("GET", "/tools", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || !sawGlobal {
+		t.Errorf("Expected the global panic handler to be used, saw %d", w.Code)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	legacy := New()
+	legacy.GET("/old/:page", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("legacy " + params["page"]))
+	})
+	legacy.POST("/users", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("legacy post"))
+	})
+
+	router := New()
+	router.GET("/users", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("new"))
+	})
+	router.GET("/dir/", simpleHandler)
+	sawNotFound := false
+	router.OnNotFound = func(r *http.Request) {
+		sawNotFound = true
+	}
+	router.Fallback = legacy
+
+	for _, test := range []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/users", http.StatusOK, "new"},
+		{"POST", "/users", http.StatusOK, "legacy post"},
+		{"GET", "/old/about", http.StatusOK, "legacy about"},
+		{"GET", "/dir", http.StatusMovedPermanently, ""},
+		{"GET", "/missing", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: expected body %q, saw %q", test.method, test.path, test.body, w.Body.String())
+		}
+	}
+
+	if sawNotFound {
+		t.Error("Expected OnNotFound not to be called when the fallback serves the request")
+	}
+}
+
 func TestRedirect(t *testing.T) {
 	for _, scenario := range scenarios {
 		t.Log(scenario.description)
@@ -637,7 +785,7 @@ func TestWildcardAtSplitNode(t *testing.T) {
 	router.GET("/:slug", simpleHandler)
 	router.GET("/:slug/abc", simpleHandler)
 
-	t.Log(router.root.dumpTree("", " "))
+	t.Log(dumpTree(router.root, "", " "))
 
 	r, _ := newRequest("GET", "/patch", nil)
 	w := httptest.NewRecorder()
@@ -701,6 +849,118 @@ func TestSlash(t *testing.T) {
 	}
 }
 
+func TestLookupRawParams(t *testing.T) {
+	router := New()
+	router.GET("/proxy/:bucket/*key", simpleHandler)
+
+	r, _ := newRequest("GET", "/proxy/a%2Fb/c%20d/e+f", nil)
+	lr, found := router.Lookup(nil, r)
+	if !found {
+		t.Fatal("Expected the route to be found")
+	}
+	expected := map[string]string{"bucket": "a/b", "key": "c d/e+f"}
+	if !reflect.DeepEqual(lr.Params, expected) {
+		t.Errorf("Expected params %v, saw %v", expected, lr.Params)
+	}
+	expected = map[string]string{"bucket": "a%2Fb", "key": "c%20d/e+f"}
+	if raw := lr.RawParams(); !reflect.DeepEqual(raw, expected) {
+		t.Errorf("Expected raw params %v, saw %v", expected, raw)
+	}
+
+	// Without any escaped characters, the raw values are the same.
+	r, _ = newRequest("GET", "/proxy/a/b", nil)
+	lr, _ = router.Lookup(nil, r)
+	expected = map[string]string{"bucket": "a", "key": "b"}
+	if raw := lr.RawParams(); !reflect.DeepEqual(raw, expected) {
+		t.Errorf("Expected raw params %v, saw %v", expected, raw)
+	}
+}
+
+func TestPathMismatchBehavior(t *testing.T) {
+	served := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Write([]byte(name + " " + params["id"]))
+		}
+	}
+	router := New()
+	router.GET("/api/users/:id", served("raw"))
+	router.GET("/users/:id", served("path"))
+
+	for _, test := range []struct {
+		behavior   PathMismatchBehavior
+		requestURI string
+		path       string
+		code       int
+		body       string
+	}{
+		// The paths disagree, as after http.StripPrefix.
+		{UsePathSource, "/api/users/a%2Fb", "/users/a/b", http.StatusOK, "raw a/b"},
+		{PreferRawPath, "/api/users/a%2Fb", "/users/a/b", http.StatusOK, "raw a/b"},
+		{PreferURLPath, "/api/users/a%2Fb", "/users/a/b", http.StatusNotFound, ""},
+		{PreferURLPath, "/api/users/1?x=1", "/users/1", http.StatusOK, "path 1"},
+		{RejectPathMismatch, "/api/users/1", "/users/1", http.StatusBadRequest, ""},
+		// The paths agree.
+		{RejectPathMismatch, "/users/a%2Fb?x=1", "/users/a/b", http.StatusOK, "path a/b"},
+		{PreferURLPath, "/users/a%2Fb", "/users/a/b", http.StatusOK, "path a/b"},
+		// A malformed escape never agrees.
+		{RejectPathMismatch, "/users/%zz", "/users/%zz", http.StatusBadRequest, ""},
+	} {
+		router.PathMismatchBehavior = test.behavior
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", "/", nil)
+		r.URL.Path = test.path
+		r.RequestURI = test.requestURI
+		if query := strings.IndexByte(test.requestURI, '?'); query != -1 {
+			r.URL.RawQuery = test.requestURI[query+1:]
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("Behavior %d, %s: expected code %d, saw %d", test.behavior, test.requestURI, test.code, w.Code)
+		} else if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("Behavior %d, %s: expected %q, saw %q", test.behavior, test.requestURI, test.body, w.Body.String())
+		}
+	}
+}
+
+func TestLocales(t *testing.T) {
+	router := New()
+	router.Locales = []string{"en", "de", "pt-br"}
+	router.GET("/", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte("home " + params[LocaleParam]))
+	})
+	router.GET("/products/:id", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Write([]byte(params["id"] + " " + params[LocaleParam]))
+	})
+
+	for _, test := range []struct {
+		path     string
+		code     int
+		body     string
+		location string
+	}{
+		{"/products/5", http.StatusOK, "5 ", ""},
+		{"/de/products/5", http.StatusOK, "5 de", ""},
+		{"/PT-BR/products/5", http.StatusOK, "5 pt-br", ""},
+		{"/en", http.StatusOK, "home en", ""},
+		{"/en/", http.StatusOK, "home en", ""},
+		{"/fr/products/5", http.StatusNotFound, "", ""},
+		{"/english/products/5", http.StatusNotFound, "", ""},
+		{"/de/products/5/?q=1", http.StatusMovedPermanently, "", "/de/products/5?q=1"},
+		{"/DE/products//5", http.StatusMovedPermanently, "", "/de/products/5"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		} else if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: expected %q, saw %q", test.path, test.body, w.Body.String())
+		} else if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%s: expected a redirect to %q, saw %q", test.path, test.location, location)
+		}
+	}
+}
+
 func TestQueryString(t *testing.T) {
 	for _, scenario := range scenarios {
 		t.Log(scenario.description)
@@ -872,6 +1132,49 @@ func TestEscapedRoutes(t *testing.T) {
 	}
 }
 
+func TestPatternEscaping(t *testing.T) {
+	for _, escape := range []bool{false, true} {
+		router := New()
+		router.EscapeAddedRoutes = escape
+		// Otherwise an escaped path which is not found is redirected to the unescaped one.
+		router.RedirectCleanPath = false
+		router.With(WithPatternEscaping(true)).GET("/café", simpleHandler)
+		router.With(WithPatternEscaping(false)).GET("/naïve", simpleHandler)
+		router.GET("/résumé", simpleHandler)
+
+		for _, test := range []struct {
+			path string
+			code int
+		}{
+			{"/café", http.StatusOK},
+			{"/caf%C3%A9", http.StatusOK},
+			{"/naïve", http.StatusOK},
+			{"/na%C3%AFve", http.StatusNotFound},
+			{"/résumé", http.StatusOK},
+			{"/r%C3%A9sum%C3%A9", map[bool]int{false: http.StatusNotFound, true: http.StatusOK}[escape]},
+		} {
+			w := httptest.NewRecorder()
+			r, _ := newRequest("GET", test.path, nil)
+			router.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Errorf("EscapeAddedRoutes %v, %s: expected code %d, saw %d", escape, test.path, test.code, w.Code)
+			}
+		}
+
+		if !router.Remove("GET", "/café") {
+			t.Errorf("EscapeAddedRoutes %v: expected /café to be removed", escape)
+		}
+		for _, path := range []string{"/café", "/caf%C3%A9"} {
+			w := httptest.NewRecorder()
+			r, _ := newRequest("GET", path, nil)
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("EscapeAddedRoutes %v, %s: expected 404 after removal, saw %d", escape, path, w.Code)
+			}
+		}
+	}
+}
+
 // Create a bunch of paths for testing.
 func createRoutes(numRoutes int) []string {
 	letters := "abcdefghijhklmnopqrstuvwxyz"
@@ -1020,6 +1323,128 @@ func TestReadWriteConcurrency(t *testing.T) {
 	handleRequests(0, "DELETE", concurrentRoutes, true)
 }
 
+func TestRemove(t *testing.T) {
+	var matched string
+	makeHandler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			matched = name
+		}
+	}
+
+	router := New()
+	router.GET("/user/:name", makeHandler("GET user"))
+	router.POST("/user/:name", makeHandler("POST user"))
+	router.GET("/posts/", makeHandler("GET posts"))
+	router.GET("/files/*path", makeHandler("GET files"))
+	router.GET("/\\*star", makeHandler("GET star"))
+	router.GET("/head", makeHandler("GET head"))
+	router.HEAD("/head", makeHandler("HEAD head"))
+	api := router.NewGroup("/api")
+	api.GET("/items", makeHandler("GET items"))
+
+	check := func(method, path string, expectedCode int, expected string) {
+		t.Helper()
+		matched = ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest(method, path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != expectedCode || matched != expected {
+			t.Errorf("%s %s: expected %d %q, saw %d %q", method, path, expectedCode, expected, w.Code, matched)
+		}
+	}
+
+	if router.Remove("GET", "/user/:other") {
+		t.Error("Remove succeeded with mismatched wildcard names")
+	}
+	if router.Remove("PUT", "/user/:name") {
+		t.Error("Remove succeeded for a method without a handler")
+	}
+	if router.Remove("GET", "/nothing/here") {
+		t.Error("Remove succeeded for a missing path")
+	}
+
+	if !router.Remove("GET", "/user/:name") {
+		t.Error("Remove of GET /user/:name failed")
+	}
+	check("GET", "/user/abc", 405, "")
+	check("HEAD", "/user/abc", 405, "")
+	check("POST", "/user/abc", 200, "POST user")
+
+	router.Remove("POST", "/user/:name")
+	check("POST", "/user/abc", 404, "")
+
+	// The node can be reused with different wildcard names.
+	router.GET("/user/:id", makeHandler("GET user id"))
+	check("GET", "/user/abc", 200, "GET user id")
+
+	if !router.Remove("GET", "/posts/") {
+		t.Error("Remove of GET /posts/ failed")
+	}
+	check("GET", "/posts/", 404, "")
+	check("GET", "/posts", 404, "")
+
+	router.Remove("GET", "/files/*path")
+	check("GET", "/files/a/b", 404, "")
+	router.GET("/files/*rest", makeHandler("GET files rest"))
+	check("GET", "/files/a/b", 200, "GET files rest")
+
+	router.Remove("GET", "/\\*star")
+	check("GET", "/*star", 404, "")
+
+	// Removing an explicit HEAD handler falls back to GET.
+	router.Remove("HEAD", "/head")
+	check("HEAD", "/head", 200, "GET head")
+	if router.Remove("HEAD", "/head") {
+		t.Error("Remove succeeded for an implicit HEAD handler")
+	}
+
+	if api.Remove("GET", "/api/items") {
+		t.Error("Remove on a group should be relative to the group path")
+	}
+	if !api.Remove("GET", "/items") {
+		t.Error("Remove of GET /api/items failed")
+	}
+	check("GET", "/api/items", 404, "")
+}
+
+// TestRemoveConcurrency ensures that when SafeAddRoutesWhileRunning is enabled,
+// routes can be removed while serving traffic.
+func TestRemoveConcurrency(t *testing.T) {
+	router := New()
+	router.SafeAddRoutesWhileRunning = true
+
+	routes := createRoutes(1000)
+	for _, route := range routes {
+		router.GET(route, simpleHandler)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		for _, route := range routes {
+			router.Remove("GET", route)
+		}
+		wg.Done()
+	}()
+	go func() {
+		for _, route := range routes {
+			r, _ := newRequest("GET", route, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		wg.Done()
+	}()
+	wg.Wait()
+
+	for _, route := range routes {
+		r, _ := newRequest("GET", route, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s expected 404 after removal, saw %d", route, w.Code)
+		}
+	}
+}
+
 func TestLookup(t *testing.T) {
 	router := New()
 	router.GET("/", simpleHandler)
@@ -1060,6 +1485,173 @@ func TestLookup(t *testing.T) {
 	tryLookup("POST", "/user/dimfeld/", true, http.StatusTemporaryRedirect)
 }
 
+func TestLookupRoute(t *testing.T) {
+	router := New()
+	router.GET("/user/:name", simpleHandler)
+	router.NewGroup("/files").GET("/*path", simpleHandler)
+
+	for _, test := range []struct {
+		method, path, expected string
+	}{
+		{"GET", "/user/dimfeld", "/user/:name"},
+		{"HEAD", "/user/dimfeld", "/user/:name"},
+		{"GET", "/files/a/b", "/files/*path"},
+		{"POST", "/user/dimfeld", ""},
+		{"GET", "/user/dimfeld/", ""},
+	} {
+		r, _ := newRequest(test.method, test.path, nil)
+		lr, _ := router.Lookup(&mockResponseWriter{}, r)
+		if lr.Route != test.expected {
+			t.Errorf("%s %s expected route %q, saw %q", test.method, test.path, test.expected, lr.Route)
+		}
+	}
+}
+
+func TestLookupHandlerAndAllowedMethods(t *testing.T) {
+	router := New()
+	var called string
+	router.GET("/user/:name", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		called = params["name"]
+	})
+	router.POST("/user/:name", simpleHandler)
+	router.DELETE("/user/:name", simpleHandler)
+
+	r, _ := newRequest("GET", "/user/dimfeld", nil)
+	lr, found := router.Lookup(&mockResponseWriter{}, r)
+	if !found || lr.Handler() == nil {
+		t.Fatal("Expected a handler for GET /user/dimfeld")
+	}
+	if lr.Route != "/user/:name" || lr.Params["name"] != "dimfeld" {
+		t.Errorf("Expected route /user/:name with name dimfeld, saw %q %v", lr.Route, lr.Params)
+	}
+	if lr.AllowedMethods != nil {
+		t.Errorf("Expected no allowed methods for a match, saw %v", lr.AllowedMethods)
+	}
+	lr.Handler()(&mockResponseWriter{}, r, lr.Params)
+	if called != "dimfeld" {
+		t.Errorf("Expected the matched handler to be called, saw %q", called)
+	}
+
+	r, _ = newRequest("PUT", "/user/dimfeld", nil)
+	lr, found = router.Lookup(&mockResponseWriter{}, r)
+	if found || lr.StatusCode != http.StatusMethodNotAllowed || lr.Handler() != nil {
+		t.Fatalf("Expected a 405 result without a handler, saw %d", lr.StatusCode)
+	}
+	expected := []string{"DELETE", "GET", "HEAD", "POST"}
+	if !reflect.DeepEqual(lr.AllowedMethods, expected) {
+		t.Errorf("Expected allowed methods %v, saw %v", expected, lr.AllowedMethods)
+	}
+}
+
+func TestLookupMethods(t *testing.T) {
+	router := New()
+	router.With(WithMetadata("read")).GET("/user/:name", simpleHandler)
+	router.POST("/user/:name", simpleHandler)
+	router.GET("/other", simpleHandler)
+
+	for _, method := range []string{"GET", "PUT"} {
+		r, _ := newRequest(method, "/user/dimfeld", nil)
+		lr, _ := router.Lookup(&mockResponseWriter{}, r)
+		methods := lr.Methods()
+		if len(methods) != 3 {
+			t.Fatalf("%s: expected 3 methods, saw %v", method, methods)
+		}
+		for i, expected := range []MethodRoute{
+			{Method: "GET", Pattern: "/user/:name", Metadata: "read"},
+			{Method: "HEAD", Pattern: "/user/:name", Metadata: "read", Implicit: true},
+			{Method: "POST", Pattern: "/user/:name"},
+		} {
+			m := methods[i]
+			if m.Method != expected.Method || m.Pattern != expected.Pattern || m.Metadata != expected.Metadata ||
+				m.Implicit != expected.Implicit || m.Handler == nil {
+				t.Errorf("%s: expected %+v, saw %+v", method, expected, m)
+			}
+		}
+	}
+
+	r, _ := newRequest("GET", "/missing", nil)
+	if lr, _ := router.Lookup(&mockResponseWriter{}, r); lr.Methods() != nil {
+		t.Errorf("Expected no methods for a path which was not found, saw %v", lr.Methods())
+	}
+}
+
+func TestHeadOnlyRoute(t *testing.T) {
+	for _, headCanUseGet := range []bool{true, false} {
+		router := New()
+		router.HeadCanUseGet = headCanUseGet
+		router.AutomaticOptions = true
+		router.HEAD("/ping", simpleHandler)
+
+		for _, test := range []struct {
+			method string
+			code   int
+			allow  string
+		}{
+			{"HEAD", http.StatusOK, ""},
+			{"GET", http.StatusMethodNotAllowed, "HEAD"},
+			{"POST", http.StatusMethodNotAllowed, "HEAD"},
+			{"OPTIONS", http.StatusNoContent, "HEAD, OPTIONS"},
+		} {
+			w := httptest.NewRecorder()
+			r, _ := newRequest(test.method, "/ping", nil)
+			router.ServeHTTP(w, r)
+			if w.Code != test.code || w.Header().Get("Allow") != test.allow {
+				t.Errorf("HeadCanUseGet %v, %s: expected %d with Allow %q, saw %d with %q", headCanUseGet,
+					test.method, test.code, test.allow, w.Code, w.Header().Get("Allow"))
+			}
+		}
+
+		// A GET handler added later does not replace the HEAD handler.
+		var served string
+		router.GET("/ping", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			served = "GET"
+		})
+		r, _ := newRequest("HEAD", "/ping", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if served != "" {
+			t.Errorf("HeadCanUseGet %v: expected the HEAD handler to serve HEAD, saw %s", headCanUseGet, served)
+		}
+
+		// Without the HEAD handler, HEAD falls back to GET only when HeadCanUseGet is set.
+		router.Remove("HEAD", "/ping")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if headCanUseGet && served != "GET" {
+			t.Errorf("Expected HEAD to fall back to the GET handler")
+		} else if !headCanUseGet && w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405 for HEAD without HeadCanUseGet, saw %d", w.Code)
+		}
+	}
+}
+
+func TestLookupImplicitTrailingSlash(t *testing.T) {
+	router := New()
+	router.RedirectBehavior = UseHandler
+	router.GET("/page", simpleHandler)
+	router.GET("/dir/", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+
+	for _, test := range []struct {
+		path     string
+		implicit bool
+	}{
+		{"/page", false},
+		{"/page/", true},
+		{"/dir/", false},
+		{"/dir", true},
+		{"/files/a/", false},
+	} {
+		r, _ := newRequest("GET", test.path, nil)
+		lr, found := router.Lookup(&mockResponseWriter{}, r)
+		if !found || lr.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected a match, saw %d", test.path, lr.StatusCode)
+		}
+		if lr.ImplicitTrailingSlash != test.implicit {
+			t.Errorf("%s: expected ImplicitTrailingSlash %v, saw %v", test.path, test.implicit, lr.ImplicitTrailingSlash)
+		}
+	}
+}
+
 func TestRedirectEscapedPath(t *testing.T) {
 	router := New()
 
@@ -1301,6 +1893,23 @@ func BenchmarkRouterRootWithoutPanicHandler(b *testing.B) {
 	benchRequest(b, router, r)
 }
 
+func BenchmarkRouterWideStatic(b *testing.B) {
+	router := New()
+	var requests []*http.Request
+	for _, path := range wideStaticPaths() {
+		router.GET("/"+path, simpleHandler)
+		r, _ := newRequest("GET", "/"+path, nil)
+		requests = append(requests, r)
+	}
+	w := new(mockResponseWriter)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, requests[i%len(requests)])
+	}
+}
+
 func BenchmarkRouterParam(b *testing.B) {
 	router := New()
 
@@ -1322,3 +1931,960 @@ func BenchmarkRouterLongParams(b *testing.B) {
 
 	benchRequest(b, router, r)
 }
+
+func TestFragment(t *testing.T) {
+	// readRequest parses the request the same way as the HTTP server, which leaves
+	// a raw '#' in the path.
+	readRequest := func(target string) *http.Request {
+		r, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+		if err != nil {
+			t.Fatalf("Reading request for %s: %s", target, err)
+		}
+		return r
+	}
+
+	for _, pathSource := range []PathSource{RequestURI, URLPath} {
+		router := New()
+		router.PathSource = pathSource
+		var id string
+		router.GET("/user/:id", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			id = params["id"]
+		})
+
+		for _, test := range []struct {
+			target   string
+			code     int
+			expected string
+		}{
+			{"/user/abc#frag", http.StatusOK, "abc"},
+			{"/user/abc#frag?q=1", http.StatusOK, "abc"},
+			{"/user/abc#a/b", http.StatusOK, "abc"},
+			{"/user/abc/#frag", http.StatusMovedPermanently, ""},
+			{"/user/a%23b", http.StatusOK, "a#b"},
+			{"/user/#frag", http.StatusNotFound, ""},
+		} {
+			id = ""
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, readRequest(test.target))
+			if w.Code != test.code {
+				t.Errorf("PathSource %d, %s: expected code %d, saw %d", pathSource, test.target, test.code, w.Code)
+			}
+			if id != test.expected {
+				t.Errorf("PathSource %d, %s: expected id %q, saw %q", pathSource, test.target, test.expected, id)
+			}
+			if test.code == http.StatusMovedPermanently && w.Header().Get("Location") != "/user/abc" {
+				t.Errorf("PathSource %d, %s: expected redirect to /user/abc, saw %s", pathSource, test.target, w.Header().Get("Location"))
+			}
+		}
+
+		// A request built by http.NewRequest has its fragment parsed already.
+		id = ""
+		r, _ := http.NewRequest("GET", "/user/abc#frag", nil)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if id != "abc" {
+			t.Errorf("PathSource %d: expected id abc from NewRequest, saw %q", pathSource, id)
+		}
+
+		router.FragmentBehavior = RejectFragment
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, readRequest("/user/abc#frag"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("PathSource %d: expected RejectFragment to return 400, saw %d", pathSource, w.Code)
+		}
+		if _, found := router.Lookup(nil, readRequest("/user/abc#frag")); found {
+			t.Errorf("PathSource %d: expected Lookup to report a rejected fragment as not found", pathSource)
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, readRequest("/user/a%23b"))
+		if w.Code != http.StatusOK {
+			t.Errorf("PathSource %d: expected RejectFragment to allow an escaped %%23, saw %d", pathSource, w.Code)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	router := New()
+	router.EscapeAddedRoutes = true
+	router.GET("/users/:id", simpleHandler)
+	router.DELETE("/users/:id", simpleHandler)
+	router.With(WithMetadata("files")).GET("/files/*path", simpleHandler)
+	router.HEAD("/explicit", simpleHandler)
+	router.GET("/with space", simpleHandler)
+	router.Host("api.example.com").POST("/hosted", simpleHandler)
+
+	expected := []Route{
+		{Method: "HEAD", Pattern: "/explicit"},
+		{Method: "GET", Pattern: "/files/*path", Metadata: "files"},
+		{Method: "DELETE", Pattern: "/users/:id"},
+		{Method: "GET", Pattern: "/users/:id"},
+		{Method: "GET", Pattern: "/with space"},
+		{Method: "POST", Pattern: "/hosted", Host: "api.example.com"},
+	}
+	if routes := router.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes\n%+v\nsaw\n%+v", expected, routes)
+	}
+}
+
+func TestRouteRedirectOverrides(t *testing.T) {
+	router := New()
+	router.GET("/legacy/page", simpleHandler)
+	router.GET("/legacy/dir/", simpleHandler)
+
+	api := router.NewGroup("/api").With(WithoutRedirects())
+	api.GET("/users", simpleHandler)
+	api.GET("/dir/", simpleHandler)
+
+	router.With(WithRedirectBehavior(Redirect308)).POST("/submit", simpleHandler)
+	router.With(WithRedirectBehavior(UseHandler)).GET("/handled", simpleHandler)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/legacy/page/", http.StatusMovedPermanently},
+		{"GET", "/legacy/dir", http.StatusMovedPermanently},
+		{"GET", "/legacy//page", http.StatusMovedPermanently},
+		{"GET", "/api/users", http.StatusOK},
+		{"GET", "/api/users/", http.StatusNotFound},
+		{"GET", "/api/dir/", http.StatusOK},
+		{"GET", "/api/dir", http.StatusNotFound},
+		{"GET", "/api//users", http.StatusNotFound},
+		{"POST", "/submit/", 308},
+		{"POST", "/other/../submit", 308},
+		{"GET", "/handled/", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+		}
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	router := New()
+	router.RedirectBehavior = RedirectPreserveMethod
+	router.GET("/page", simpleHandler)
+	router.POST("/submit", simpleHandler)
+
+	for _, test := range []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{"GET", "/page/?a=1", http.StatusMovedPermanently, "/page?a=1"},
+		{"HEAD", "/page/", http.StatusMovedPermanently, "/page"},
+		{"POST", "/submit/?a=1&b=2", 308, "/submit?a=1&b=2"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: expected %d to %s, saw %d to %s", test.method, test.path,
+				test.code, test.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	var targets []string
+	router.RedirectPolicy = func(r *http.Request, target string) (int, bool) {
+		targets = append(targets, target)
+		if r.URL.Path == target+"/" {
+			// Serve requests with an extra trailing slash without redirecting.
+			return 0, false
+		}
+		return http.StatusTemporaryRedirect, true
+	}
+	router.With(WithRedirectBehavior(Redirect301)).GET("/legacy", simpleHandler)
+
+	for _, test := range []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{"POST", "/submit/", http.StatusOK, ""},
+		{"POST", "/x/../submit?a=1", http.StatusTemporaryRedirect, "/submit?a=1"},
+		{"GET", "/legacy/", http.StatusMovedPermanently, "/legacy"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("Policy %s %s: expected %d to %q, saw %d to %q", test.method, test.path,
+				test.code, test.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+	if expected := []string{"/submit", "/submit"}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected the policy to be called for %v, saw %v", expected, targets)
+	}
+}
+
+func TestRefuseUnsafeRedirect(t *testing.T) {
+	router := New()
+	router.RedirectBehavior = RefuseUnsafeRedirect
+	router.GET("/page", simpleHandler)
+	router.POST("/submit", simpleHandler)
+	router.With(WithRedirectBehavior(Redirect308)).POST("/upload", simpleHandler)
+
+	for _, test := range []struct {
+		method, path string
+		code         int
+		location     string
+		body         string
+	}{
+		{"GET", "/page/?a=1", http.StatusMovedPermanently, "/page?a=1", ""},
+		{"POST", "/submit/?a=1", http.StatusNotFound, "",
+			"POST requests are not redirected, since the request body could be lost. Send the request to /submit?a=1 instead.\n"},
+		{"POST", "/upload/", 308, "/upload", ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: expected %d to %s, saw %d to %s", test.method, test.path,
+				test.code, test.location, w.Code, w.Header().Get("Location"))
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: expected body %q, saw %q", test.method, test.path, test.body, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("POST", "/submit/", nil)
+	router.ServeHTTP(w, r)
+	if link := w.Header().Get("Link"); link != `</submit>; rel="canonical"` {
+		t.Errorf("Expected a canonical link to /submit, saw %q", link)
+	}
+
+	router.SetErrorSerializer("application/json", JSONErrorSerializer)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Expected a JSON problem, saw %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var refused string
+	router.RedirectRefusedHandler = func(w http.ResponseWriter, r *http.Request, target string) {
+		refused = target
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w = httptest.NewRecorder()
+	r, _ = newRequest("DELETE", "/submit/?id=2", nil)
+	router.DELETE("/submit", simpleHandler)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || refused != "/submit?id=2" {
+		t.Errorf("Expected the custom handler with target /submit?id=2, saw %d with %q", w.Code, refused)
+	}
+}
+
+func TestRedirectCanonicalCase(t *testing.T) {
+	router := New()
+	router.CaseInsensitive = true
+	router.RedirectCanonicalCase = true
+	router.GET("/Users/:Name/Posts", simpleHandler)
+	router.GET("/Files/*path", simpleHandler)
+	router.GET("/Dir/", simpleHandler)
+	router.With(WithoutRedirects()).GET("/Strict", simpleHandler)
+
+	for _, test := range []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/Users/Bob/Posts", http.StatusOK, ""},
+		{"/users/Bob/posts", http.StatusMovedPermanently, "/Users/Bob/Posts"},
+		{"/USERS/bob/POSTS?x=1", http.StatusMovedPermanently, "/Users/bob/Posts?x=1"},
+		{"/files/A/b.TXT", http.StatusMovedPermanently, "/Files/A/b.TXT"},
+		{"/Files/A/b.TXT", http.StatusOK, ""},
+		{"/dir/", http.StatusMovedPermanently, "/Dir/"},
+		{"/strict", http.StatusOK, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%s: expected location %q, saw %q", test.path, test.location, location)
+		}
+	}
+
+	router.RedirectCanonicalCase = false
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/users/bob/posts", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected no redirect without RedirectCanonicalCase, saw %d", w.Code)
+	}
+}
+
+func TestMaxCanonicalizationPasses(t *testing.T) {
+	router := New()
+	router.CaseInsensitive = true
+	router.RedirectCanonicalCase = true
+	router.GET("/Users/:id/", simpleHandler)
+	router.GET("/Plain", simpleHandler)
+	router.With(WithRedirectBehavior(UseHandler)).GET("/Direct/", simpleHandler)
+
+	for _, test := range []struct {
+		passes    int
+		path      string
+		location  string
+		canonical string
+	}{
+		{0, "/users//5", "/users/5", "/Users/5/"},
+		{1, "/users//5", "/users/5", "/Users/5/"},
+		{2, "/users//5", "/users/5/", "/Users/5/"},
+		{3, "/users//5", "/Users/5/", "/Users/5/"},
+		{3, "/users/5", "/Users/5/", "/Users/5/"},
+		{0, "/Users/5", "/users/5/", "/Users/5/"},
+		{0, "/a/../plain/", "/plain", "/Plain"},
+		{3, "/a/../plain/", "/Plain", "/Plain"},
+		{3, "/Plain/", "/Plain", "/Plain"},
+	} {
+		router.MaxCanonicalizationPasses = test.passes
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%d passes, %s: expected code 301, saw %d", test.passes, test.path, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%d passes, %s: expected location %q, saw %q", test.passes, test.path, test.location, location)
+		}
+
+		canonical, ok := router.Canonicalize(r)
+		if !ok || canonical != test.canonical {
+			t.Errorf("%s: expected Canonicalize to return %q, saw %q, %v", test.path, test.canonical, canonical, ok)
+		}
+	}
+
+	for _, path := range []string{"/Users/5/", "/direct", "/missing/"} {
+		r, _ := newRequest("GET", path, nil)
+		if canonical, ok := router.Canonicalize(r); ok {
+			t.Errorf("%s: expected no canonical path, saw %q", path, canonical)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	var matched string
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			matched = name
+		}
+	}
+
+	router := New()
+	router.GET("/images/*path", handler("images"))
+	router.GET("/cgi/foo.js", handler("cgi"))
+	router.GET("/dir/", handler("dir"))
+
+	// Without CleanPath, dot segments are matched literally by the catch-all.
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/images/../cgi/foo.js", nil)
+	router.ServeHTTP(w, r)
+	if matched != "images" {
+		t.Errorf("Expected the catch-all to match without CleanPath, saw %q", matched)
+	}
+
+	router.CleanPath = true
+	for _, test := range []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/images/../cgi/foo.js", http.StatusMovedPermanently, "/cgi/foo.js"},
+		{"/images/%2e%2E/cgi/foo.js", http.StatusMovedPermanently, "/cgi/foo.js"},
+		{"//cgi///foo.js", http.StatusMovedPermanently, "/cgi/foo.js"},
+		{"/cgi/./foo.js?q=1", http.StatusMovedPermanently, "/cgi/foo.js?q=1"},
+		{"/images/a/../b.png", http.StatusMovedPermanently, "/images/b.png"},
+		{"/x/../dir/", http.StatusMovedPermanently, "/dir/"},
+		{"/x/../missing", http.StatusNotFound, ""},
+		{"/cgi/foo.js", http.StatusOK, ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%s: expected location %q, saw %q", test.path, test.location, location)
+		}
+	}
+
+	router.RedirectCleanPath = false
+	matched = ""
+	w = httptest.NewRecorder()
+	r, _ = newRequest("GET", "/images/%2e%2e/cgi/foo.js", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || matched != "cgi" {
+		t.Errorf("Expected the cleaned path to be served without a redirect, saw %d %q", w.Code, matched)
+	}
+
+	// A request without a path must not panic.
+	r, _ = http.NewRequest("GET", "http://example.com", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestPatternSyntaxBraces(t *testing.T) {
+	router := New()
+	router.PatternSyntax = SyntaxBraces
+
+	var params map[string]string
+	handler := func(w http.ResponseWriter, r *http.Request, p map[string]string) {
+		params = p
+	}
+	router.GET("/users/{id}", handler)
+	router.GET("/files/{path...}", handler)
+	router.GET("/dir/{$}", handler)
+	router.GET("/old/:name/*rest", handler)
+	router.NewGroup("/orgs/{org}").GET("/repos/{repo}", handler)
+
+	for _, test := range []struct {
+		path   string
+		code   int
+		params map[string]string
+	}{
+		{"/users/5", http.StatusOK, map[string]string{"id": "5"}},
+		{"/files/a/b.txt", http.StatusOK, map[string]string{"path": "a/b.txt"}},
+		{"/dir/", http.StatusOK, nil},
+		{"/dir", http.StatusMovedPermanently, nil},
+		{"/old/x/y/z", http.StatusOK, map[string]string{"name": "x", "rest": "y/z"}},
+		{"/orgs/go/repos/net", http.StatusOK, map[string]string{"org": "go", "repo": "net"}},
+	} {
+		params = nil
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if test.code == http.StatusOK && !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected params %v, saw %v", test.path, test.params, params)
+		}
+	}
+
+	r, _ := newRequest("GET", "/users/5", nil)
+	if lr, _ := router.Lookup(nil, r); lr.Route != "/users/:id" {
+		t.Errorf("expected route to be stored as /users/:id, saw %q", lr.Route)
+	}
+
+	for _, pattern := range []string{"/a{id}", "/{id}b", "/{}", "/{...}", "/{rest...}/more", "/{$}/more", "/{a{b}}"} {
+		if err := router.TryHandle("GET", pattern, handler); err == nil {
+			t.Errorf("expected pattern %s to be rejected", pattern)
+		}
+	}
+
+	if !router.Remove("GET", "/users/{id}") {
+		t.Error("expected Remove to accept the brace syntax")
+	}
+
+	router = New()
+	router.GET("/{id}", handler)
+	r, _ = newRequest("GET", "/{id}", nil)
+	if lr, _ := router.Lookup(nil, r); lr.StatusCode != http.StatusOK || lr.Params != nil {
+		t.Errorf("expected braces to be literal with SyntaxColon, saw %d %v", lr.StatusCode, lr.Params)
+	}
+}
+
+func TestAnyMethod(t *testing.T) {
+	router := New()
+	var served string
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			served = name + " " + params["id"]
+		}
+	}
+	router.Any("/webhook", handler("any"))
+	router.POST("/webhook", handler("post"))
+	router.Any("/items/:id", handler("any"))
+	router.GET("/items/:id", handler("get"))
+	router.Handle("PROPFIND", "/dav/*path", handler("propfind"))
+	router.Handle("MKCOL", "/dav/*path", handler("mkcol"))
+
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+		served string
+	}{
+		{"GET", "/webhook", http.StatusOK, "any "},
+		{"PUT", "/webhook", http.StatusOK, "any "},
+		{"REPORT", "/webhook", http.StatusOK, "any "},
+		{"POST", "/webhook", http.StatusOK, "post "},
+		{"GET", "/items/5", http.StatusOK, "get 5"},
+		{"HEAD", "/items/5", http.StatusOK, "get 5"},
+		{"DELETE", "/items/5", http.StatusOK, "any 5"},
+		{"PROPFIND", "/dav/a/b", http.StatusOK, "propfind "},
+		{"MKCOL", "/dav/a", http.StatusOK, "mkcol "},
+		{"GET", "/dav/a", http.StatusMethodNotAllowed, ""},
+	} {
+		served = ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+		}
+		if served != test.served {
+			t.Errorf("%s %s: expected %q to be served, saw %q", test.method, test.path, test.served, served)
+		}
+		if w.Code == http.StatusMethodNotAllowed {
+			allow := w.Header()["Allow"]
+			sort.Strings(allow)
+			if strings.Join(allow, ", ") != "MKCOL, PROPFIND" {
+				t.Errorf("%s %s: expected Allow header to list custom methods, saw %q", test.method, test.path, allow)
+			}
+		}
+	}
+
+	if !router.Remove(MethodAny, "/webhook") {
+		t.Error("expected Remove to remove the MethodAny handler")
+	}
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/webhook", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 after removing MethodAny handler, saw %d", w.Code)
+	}
+}
+
+func TestNormalizationCandidates(t *testing.T) {
+	router := New()
+	router.Debug = true
+	router.GET("/users/new", simpleHandler)
+	router.GET("/users/:id", simpleHandler)
+	router.GET("/users/*path", simpleHandler)
+	router.POST("/users/:id", simpleHandler)
+	router.GET("/:section/new", simpleHandler)
+
+	for _, test := range []struct {
+		method     string
+		path       string
+		candidates []string
+	}{
+		{"GET", "/users/new", []string{"/users/new", "/users/:id", "/users/*path", "/:section/new"}},
+		{"GET", "/users/5", []string{"/users/:id", "/users/*path"}},
+		{"GET", "/users/5/posts", []string{"/users/*path"}},
+		{"POST", "/users/new", []string{"/users/:id"}},
+		{"GET", "/other", nil},
+	} {
+		r, _ := newRequest(test.method, test.path, nil)
+		result, _ := router.Lookup(nil, r)
+		if result.normalization == nil {
+			t.Fatalf("%s %s: expected normalization to be recorded", test.method, test.path)
+		}
+		if !reflect.DeepEqual(result.normalization.Candidates, test.candidates) {
+			t.Errorf("%s %s: expected candidates %v, saw %v", test.method, test.path, test.candidates, result.normalization.Candidates)
+		}
+	}
+
+	router.Debug = false
+	r, _ := newRequest("GET", "/users/new", nil)
+	if result, _ := router.Lookup(nil, r); result.normalization != nil {
+		t.Error("expected no normalization without Debug")
+	}
+}
+
+func TestMidPathCatchAll(t *testing.T) {
+	router := New()
+	var served string
+	var params map[string]string
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, p map[string]string) {
+			served = name
+			params = p
+		}
+	}
+	router.GET("/repos/*owner_repo/commits/:sha", handler("commit"))
+	router.GET("/files/*path/meta", handler("meta"))
+	router.GET("/files/*path", handler("file"))
+	router.GET("/files/:name/meta", handler("name"))
+	router.GET("/files/special/meta", handler("special"))
+
+	for _, test := range []struct {
+		path   string
+		code   int
+		served string
+		params map[string]string
+	}{
+		{"/repos/org/repo/commits/abc", http.StatusOK, "commit", map[string]string{"owner_repo": "org/repo", "sha": "abc"}},
+		{"/repos/a/commits/b/commits/c", http.StatusOK, "commit", map[string]string{"owner_repo": "a/commits/b", "sha": "c"}},
+		{"/repos/org/repo/commits", http.StatusNotFound, "", nil},
+		{"/repos/commits/abc", http.StatusNotFound, "", nil},
+		{"/files/special/meta", http.StatusOK, "special", nil},
+		{"/files/a/meta", http.StatusOK, "name", map[string]string{"name": "a"}},
+		{"/files/a/b/meta", http.StatusOK, "meta", map[string]string{"path": "a/b"}},
+		{"/files/a/meta/b/meta", http.StatusOK, "meta", map[string]string{"path": "a/meta/b"}},
+		{"/files/a%2Fb/c/meta", http.StatusOK, "meta", map[string]string{"path": "a/b/c"}},
+		{"/files/a/b", http.StatusOK, "file", map[string]string{"path": "a/b"}},
+		{"/files/a/b/meta/", http.StatusMovedPermanently, "", nil},
+	} {
+		served, params = "", nil
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if served != test.served || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %s with %v, saw %s with %v", test.path, test.served, test.params, served, params)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("POST", "/repos/org/repo/commits/abc", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for a method without a handler, saw %d", w.Code)
+	}
+
+	if !router.Remove("GET", "/files/*path/meta") {
+		t.Fatal("Expected route after the catch-all to be removed")
+	}
+	served, params = "", nil
+	r, _ = newRequest("GET", "/files/a/b/meta", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if served != "file" || params["path"] != "a/b/meta" {
+		t.Errorf("Expected the catch-all route to match after removal, saw %s with %v", served, params)
+	}
+
+	// A pattern with a trailing slash after the catch-all. With RedirectTrailingSlash, the
+	// slash is removed from the pattern, and catch-alls match with or without it. Without
+	// it, the slash must be present.
+	for _, redirect := range []bool{true, false} {
+		router = New()
+		router.RedirectTrailingSlash = redirect
+		router.GET("/dirs/*path/", handler("dir"))
+		for _, test := range []struct {
+			path   string
+			served string
+			value  string
+		}{
+			{"/dirs/a/", "dir", "a"},
+			{"/dirs/a/b/", "dir", "a/b"},
+			{"/dirs/a/b", "dir", "a/b"},
+			{"/dirs/", "", ""},
+		} {
+			if !redirect && !strings.HasSuffix(test.path, "/") {
+				test.served, test.value = "", ""
+			}
+			served, params = "", nil
+			r, _ := newRequest("GET", test.path, nil)
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			if served != test.served || params["path"] != test.value {
+				t.Errorf("%s (RedirectTrailingSlash %v): expected %q with path %q, saw %q with %v",
+					test.path, redirect, test.served, test.value, served, params)
+			}
+		}
+	}
+
+	router = New()
+	router.CaseInsensitive = true
+	router.RedirectCanonicalCase = true
+	router.GET("/Repos/*repo/Commits", handler("commits"))
+	w = httptest.NewRecorder()
+	r, _ = newRequest("GET", "/repos/Org/Repo/commits", nil)
+	router.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); location != "/Repos/Org/Repo/Commits" {
+		t.Errorf("Expected redirect to the canonical case, saw %d %q", w.Code, location)
+	}
+}
+
+func TestCatchAllExtensions(t *testing.T) {
+	router := New()
+	var served string
+	var params map[string]string
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, p map[string]string) {
+			served = name
+			params = p
+		}
+	}
+	router.GET("/*file[.js,.css,.map]", handler("asset"))
+	router.GET("/api/users", handler("users"))
+	router.GET("/images/*path[.png]/meta", handler("meta"))
+	router.GET("/images/:name/meta", handler("name"))
+
+	for _, test := range []struct {
+		path   string
+		code   int
+		served string
+		params map[string]string
+	}{
+		{"/app.js", http.StatusOK, "asset", map[string]string{"file": "app.js"}},
+		{"/dist/app.js.map", http.StatusOK, "asset", map[string]string{"file": "dist/app.js.map"}},
+		{"/dist/Site.CSS", http.StatusOK, "asset", map[string]string{"file": "dist/Site.CSS"}},
+		{"/api/users", http.StatusOK, "users", nil},
+		{"/api/usres", http.StatusNotFound, "", nil},
+		{"/index.html", http.StatusNotFound, "", nil},
+		{"/.js", http.StatusNotFound, "", nil},
+		{"/images/a/b.png/meta", http.StatusOK, "meta", map[string]string{"path": "a/b.png"}},
+		{"/images/a.gif/meta", http.StatusOK, "name", map[string]string{"name": "a.gif"}},
+		{"/images/a/b.gif/meta", http.StatusNotFound, "", nil},
+	} {
+		served, params = "", nil
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if served != test.served || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected %s with %v, saw %s with %v", test.path, test.served, test.params, served, params)
+		}
+	}
+
+	if !router.Remove("GET", "/*file[.js,.css,.map]") {
+		t.Error("Expected the catch-all with extensions to be removed")
+	}
+	if err := router.TryHandle("GET", "/*file", handler("file")); err != nil {
+		t.Errorf("Expected a catch-all without extensions after removal, saw %v", err)
+	}
+
+	for _, pattern := range []string{"/*file[.js", "/*file[js]", "/*file[]", "/*[.js]", "/*file[.a.b]"} {
+		if err := New().TryHandle("GET", pattern, handler("bad")); err == nil {
+			t.Errorf("%s: expected an error for a malformed extension set", pattern)
+		}
+	}
+
+	// A malformed catch-all after another leaves no part of the route in the tree.
+	router = New()
+	if err := router.TryHandle("GET", "/c/*p/*q[", handler("bad")); err == nil {
+		t.Error("Expected an error for a malformed extension set after a catch-all")
+	}
+	if err := router.TryHandle("GET", "/c/*other", handler("other")); err != nil {
+		t.Errorf("Expected a different catch-all name to be accepted after the failure, saw %v", err)
+	}
+	if routes := router.Routes(); len(routes) != 1 || routes[0].Pattern != "/c/*other" {
+		t.Errorf("Expected only /c/*other to be registered, saw %v", routes)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	build := func(reverse bool, metadata string) *TreeMux {
+		router := New()
+		add := []func(){
+			func() { router.GET("/users/:id", simpleHandler) },
+			func() { router.With(WithMetadata(metadata)).POST("/users", simpleHandler) },
+			func() { router.Host("api.example.com").GET("/status", simpleHandler) },
+		}
+		if reverse {
+			for i := len(add) - 1; i >= 0; i-- {
+				add[i]()
+			}
+		} else {
+			for _, f := range add {
+				f()
+			}
+		}
+		return router
+	}
+
+	fingerprint := build(false, "admin").Fingerprint()
+	if len(fingerprint) != 64 {
+		t.Errorf("Expected a hex-encoded SHA-256 hash, saw %q", fingerprint)
+	}
+	if other := build(true, "admin").Fingerprint(); other != fingerprint {
+		t.Errorf("Expected the fingerprint not to depend on the order of registration, saw %s and %s", fingerprint, other)
+	}
+	if other := build(false, "user").Fingerprint(); other == fingerprint {
+		t.Error("Expected different metadata to change the fingerprint")
+	}
+
+	router := build(false, "admin")
+	router.DELETE("/users/:id", simpleHandler)
+	if other := router.Fingerprint(); other == fingerprint {
+		t.Error("Expected an added route to change the fingerprint")
+	}
+	router.Remove("DELETE", "/users/:id")
+	if other := router.Fingerprint(); other != fingerprint {
+		t.Error("Expected removing the added route to restore the fingerprint")
+	}
+}
+
+func TestOptionalParams(t *testing.T) {
+	router := New()
+	var params map[string]string
+	handler := func(w http.ResponseWriter, r *http.Request, p map[string]string) {
+		params = p
+	}
+	router.GET("/articles/:year/:month?/:day?", handler)
+	router.NewGroup("/tags").GET("/:tag?", handler)
+
+	for _, test := range []struct {
+		path   string
+		code   int
+		params map[string]string
+	}{
+		{"/articles/2024", http.StatusOK, map[string]string{"year": "2024"}},
+		{"/articles/2024/06", http.StatusOK, map[string]string{"year": "2024", "month": "06"}},
+		{"/articles/2024/06/01", http.StatusOK, map[string]string{"year": "2024", "month": "06", "day": "01"}},
+		{"/articles/2024/06/01/extra", http.StatusNotFound, nil},
+		{"/articles", http.StatusNotFound, nil},
+		{"/tags", http.StatusOK, nil},
+		{"/tags/go", http.StatusOK, map[string]string{"tag": "go"}},
+	} {
+		params = nil
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: expected code %d, saw %d", test.path, test.code, w.Code)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected params %v, saw %v", test.path, test.params, params)
+		}
+		if test.code == http.StatusOK {
+			lr, _ := router.Lookup(w, r)
+			if !strings.HasSuffix(lr.Route, "?") {
+				t.Errorf("%s: expected the registered pattern as the route, saw %q", test.path, lr.Route)
+			}
+		}
+	}
+
+	expected := []Route{
+		{Method: "GET", Pattern: "/articles/:year/:month?/:day?"},
+		{Method: "GET", Pattern: "/tags/:tag?"},
+	}
+	if routes := router.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes %v, saw %v", expected, routes)
+	}
+
+	if err := router.TryHandle("GET", "/bad/:a?/b", handler); err == nil {
+		t.Error("Expected an error for an optional parameter followed by a static segment")
+	}
+
+	// The registration is atomic if one of the expansions conflicts.
+	router.GET("/items/:id", handler)
+	if err := router.TryHandle("GET", "/items/:name?", handler); err == nil {
+		t.Error("Expected a conflict for an expansion with a different wildcard name")
+	}
+	r, _ := newRequest("GET", "/items", nil)
+	if _, found := router.Lookup(nil, r); found {
+		t.Error("Expected the other expansions to be removed after a conflict")
+	}
+
+	if !router.Remove("GET", "/articles/:year/:month?/:day?") {
+		t.Fatal("Expected the route to be removed")
+	}
+	for _, path := range []string{"/articles/2024", "/articles/2024/06", "/articles/2024/06/01"} {
+		r, _ := newRequest("GET", path, nil)
+		if _, found := router.Lookup(nil, r); found {
+			t.Errorf("%s: expected no match after removing the route", path)
+		}
+	}
+}
+
+func TestRouteAliases(t *testing.T) {
+	router := New()
+	router.CaseInsensitive = true
+	router.RedirectCanonicalCase = true
+	var served string
+	handler := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		lr, _ := router.Lookup(w, r)
+		served = lr.Route
+	}
+	router.NewGroup("/site").With(WithAliases("/ueber-uns", "/a-propos/")).GET("/about", handler)
+
+	for _, path := range []string{"/site/about", "/site/ueber-uns", "/site/a-propos/"} {
+		served = ""
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected code 200, saw %d", path, w.Code)
+		}
+		if served != "/site/about" {
+			t.Errorf("%s: expected the canonical route, saw %q", path, served)
+		}
+	}
+
+	// Redirects keep the alias the request was made to.
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/site/Ueber-Uns", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/site/ueber-uns" {
+		t.Errorf("Expected a redirect to /site/ueber-uns, saw %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	expected := []Route{
+		{Method: "GET", Pattern: "/site/about", Aliases: []string{"/site/ueber-uns", "/site/a-propos/"}},
+	}
+	if routes := router.Routes(); !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Expected routes %v, saw %v", expected, routes)
+	}
+
+	// The registration is atomic if one of the aliases conflicts.
+	router.GET("/contact/:id", handler)
+	err := router.With(WithAliases("/contact/:name")).TryHandle("GET", "/kontakt", handler)
+	if err == nil {
+		t.Error("Expected a conflict for an alias with a different wildcard name")
+	}
+	r, _ = newRequest("GET", "/kontakt", nil)
+	if _, found := router.Lookup(nil, r); found {
+		t.Error("Expected the route to be removed after a conflicting alias")
+	}
+
+	// The nodes added for the route are removed as well, so that a route with a different
+	// wildcard name can take their place.
+	router.GET("/existing", handler)
+	err = router.With(WithAliases("/existing")).TryHandle("GET", "/x/*a", handler)
+	if err == nil {
+		t.Error("Expected a conflict for an alias of an existing route")
+	}
+	if err := router.TryHandle("GET", "/x/*c", handler); err != nil {
+		t.Errorf("Expected a different catch-all name to be accepted after the failure, saw %v", err)
+	}
+	err = router.With(WithAliases("/existing")).TryHandle("GET", "/y/:a/z", handler)
+	if err == nil {
+		t.Error("Expected a conflict for an alias of an existing route")
+	}
+	if err := router.TryHandle("GET", "/y/:c/z", handler); err != nil {
+		t.Errorf("Expected a different wildcard name to be accepted after the failure, saw %v", err)
+	}
+
+	if !router.Remove("GET", "/site/about") {
+		t.Fatal("Expected the route to be removed")
+	}
+	for _, path := range []string{"/site/about", "/site/ueber-uns", "/site/a-propos/"} {
+		r, _ := newRequest("GET", path, nil)
+		if _, found := router.Lookup(nil, r); found {
+			t.Errorf("%s: expected the alias to be removed with the route", path)
+		}
+	}
+}
+
+func TestDumpTree(t *testing.T) {
+	router := New()
+	router.GET("/users", simpleHandler)
+	router.GET("/users/:id", simpleHandler)
+	router.POST("/users/new", simpleHandler)
+	router.GET("/files/*path", simpleHandler)
+	router.Host("api.example.com").PUT("/items/", simpleHandler)
+
+	expected := `/ priority=0
+  static "users" priority=2 GET=/users HEAD=(implicit)
+    static "/" priority=1
+      static "new" priority=0 POST=/users/new
+      param priority=0 params=[id] GET=/users/:id HEAD=(implicit)
+  static "files" priority=0
+    static "/" priority=0
+      catch-all "*path" priority=0 params=[path] GET=/files/*path HEAD=(implicit)
+host api.example.com
+/ priority=0
+  static "items" priority=0 slash PUT=/items/
+`
+	var buf bytes.Buffer
+	if err := router.DumpTree(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("Expected tree:\n%s\nsaw:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,199 @@
+package httptreemux
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
+)
+
+// Route describes a route registered with the router, as returned by Routes.
+type Route struct {
+	// Method is the HTTP method of the route.
+	Method string
+	// Pattern is the full pattern of the route as it was registered, including the
+	// path of the group it was added to.
+	Pattern string
+	// Host is the pattern passed to Host for routes added to a host-specific group,
+	// or an empty string for routes that apply to all hosts.
+	Host string
+	// Metadata is the value attached to the route with WithMetadata, if any.
+	Metadata interface{}
+	// Aliases are the full patterns of the additional paths given for the route with
+	// WithAliases, if any.
+	Aliases []string
+	// Predicates describe the conditions given for the route with MatchHeader, MatchQuery
+	// and MatchFunc, if any.
+	Predicates []string
+}
+
+// MethodRoute is a method which the path of a LookupResult has a handler for, as returned by
+// LookupResult.Methods.
+type MethodRoute struct {
+	// Method is the HTTP method, or MethodAny for a route added with Any.
+	Method string
+	// Pattern is the full pattern of the route which handles the method.
+	Pattern string
+	// Metadata is the value attached to that route with WithMetadata, if any.
+	Metadata interface{}
+	// Handler is the route's handler, with the middleware of its group. It is given to
+	// identify the handler, for example by comparing function names, and should not be
+	// called directly.
+	Handler HandlerFunc
+	// Implicit is true for HEAD when it is served by the GET route because
+	// TreeMux.HeadCanUseGet is set.
+	Implicit bool
+}
+
+// Methods returns the methods which the path of a lookup result has handlers for, sorted, with
+// the route which handles each. It is nil unless the lookup matched a path, with a StatusCode of
+// http.StatusOK or http.StatusMethodNotAllowed. Self-describing APIs and custom 405 pages can
+// use it to describe what else the path supports. For methods with routes added with match
+// options, the route which serves the requests that none of the options accept is given.
+func (lr LookupResult) Methods() []MethodRoute {
+	n := lr.node
+	if n == nil || len(n.leafHandler) == 0 {
+		return nil
+	}
+	methods := make([]MethodRoute, 0, len(n.leafHandler))
+	for method, handler := range n.leafHandler {
+		m := MethodRoute{Method: method, Handler: handler}
+		info := n.leafRoute[method]
+		if method == "HEAD" && n.implicitHead {
+			m.Implicit = true
+			info = n.leafRoute["GET"]
+		}
+		if info != nil {
+			m.Pattern = info.pattern
+			m.Metadata = info.metadata
+		}
+		methods = append(methods, m)
+	}
+	sort.Sort(byMethod(methods))
+	return methods
+}
+
+// byMethod sorts the methods of a path by name.
+type byMethod []MethodRoute
+
+func (s byMethod) Len() int           { return len(s) }
+func (s byMethod) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byMethod) Less(i, j int) bool { return s[i].Method < s[j].Method }
+
+// Routes returns the routes registered with the router, sorted by host, pattern and
+// method. HEAD routes that were added implicitly for GET routes, because HeadCanUseGet
+// is set, are not included.
+func (t *TreeMux) Routes() []Route {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	var routes []Route
+	collect := func(host string, root *pathtree.Node) {
+		// With EscapeAddedRoutes or CaseInsensitive, a route can be stored under
+		// more than one path.
+		type registration struct {
+			method string
+			info   *routeInfo
+		}
+		seen := map[registration]bool{}
+		add := func(method string, info *routeInfo) {
+			if seen[registration{method, info}] {
+				return
+			}
+			seen[registration{method, info}] = true
+			routes = append(routes, Route{
+				Method:     method,
+				Pattern:    info.pattern,
+				Host:       host,
+				Metadata:   info.metadata,
+				Aliases:    info.aliases,
+				Predicates: info.predicateDescriptions(),
+			})
+		}
+		walkRoutes(root, func(n *node) {
+			for method, info := range n.leafRoute {
+				if method != "HEAD" || !n.implicitHead {
+					add(method, info)
+				}
+			}
+			for method, variants := range n.leafVariants {
+				for _, v := range variants {
+					add(method, v.info)
+				}
+			}
+		})
+	}
+
+	collect("", t.root)
+	for _, h := range t.hosts {
+		collect(h.pattern, h.root)
+	}
+
+	sortRoutes(routes)
+	return routes
+}
+
+// SkippedRoutes returns the routes which were not registered because they were added to a
+// group returned by When or DevOnly whose condition was false, sorted like Routes. This can
+// be exposed on a debug endpoint to confirm which routes a build leaves out.
+func (t *TreeMux) SkippedRoutes() []Route {
+	if t.SafeAddRoutesWhileRunning {
+		t.mutex.RLock()
+		defer t.mutex.RUnlock()
+	}
+
+	routes := append([]Route(nil), t.skippedRoutes...)
+	sortRoutes(routes)
+	return routes
+}
+
+// Fingerprint returns a hash of the routes returned by Routes, covering their hosts, methods,
+// patterns and metadata. It is the same for routers with the same routes regardless of the
+// order in which they were added, so comparing it across the replicas of a service, for example
+// from a health check endpoint, verifies that they all run the same route table. Metadata is
+// included through its fmt %v formatting, so values whose formatting is not deterministic, such
+// as pointers, make the fingerprint differ between processes.
+func (t *TreeMux) Fingerprint() string {
+	hash := sha256.New()
+	for _, route := range t.Routes() {
+		fmt.Fprintf(hash, "%q %q %q", route.Host, route.Method, route.Pattern)
+		if route.Metadata != nil {
+			fmt.Fprintf(hash, " %q", fmt.Sprintf("%v", route.Metadata))
+		}
+		for _, alias := range route.Aliases {
+			fmt.Fprintf(hash, " alias %q", alias)
+		}
+		for _, p := range route.Predicates {
+			fmt.Fprintf(hash, " match %q", p)
+		}
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func sortRoutes(routes []Route) {
+	sort.Sort(byRoute(routes))
+}
+
+// byRoute sorts routes by host, pattern, method and predicates.
+type byRoute []Route
+
+func (s byRoute) Len() int      { return len(s) }
+func (s byRoute) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRoute) Less(i, j int) bool {
+	if s[i].Host != s[j].Host {
+		return s[i].Host < s[j].Host
+	}
+	if s[i].Pattern != s[j].Pattern {
+		return s[i].Pattern < s[j].Pattern
+	}
+	if s[i].Method != s[j].Method {
+		return s[i].Method < s[j].Method
+	}
+	return strings.Join(s[i].Predicates, " ") < strings.Join(s[j].Predicates, " ")
+}
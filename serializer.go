@@ -0,0 +1,214 @@
+package httptreemux
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorSerializer writes the response for an error generated by the router, described by
+// problem, in one format. It sets the Content-Type header and writes problem.Status. Headers
+// such as Allow are already set.
+type ErrorSerializer func(w http.ResponseWriter, problem *Problem)
+
+type errorSerializer struct {
+	mediaType string
+	serialize ErrorSerializer
+}
+
+// SetErrorSerializer registers the serializer for the responses which the router generates
+// itself when a client accepts the given media type, such as "application/json" or
+// "application/x-protobuf". Once any serializer is registered, the default NotFoundHandler
+// and MethodNotAllowedHandler, ProblemPanicHandler, the responses of UseProblemResponses,
+// and the response for a route added with a nil handler for WithTimeout all describe the
+// error as a Problem and write it with the serializer that best matches the request's
+// Accept header. If none matches, the first serializer registered is used. Passing a nil
+// serializer removes the one for the media type. Serializers can be changed while the
+// router serves requests.
+//
+//	router.SetErrorSerializer("application/json", httptreemux.JSONErrorSerializer)
+//	router.SetErrorSerializer("application/xml", httptreemux.XMLErrorSerializer)
+//	router.SetErrorSerializer("text/plain", httptreemux.TextErrorSerializer)
+func (t *TreeMux) SetErrorSerializer(mediaType string, serializer ErrorSerializer) {
+	t.serializersMutex.Lock()
+	defer t.serializersMutex.Unlock()
+
+	// The requests being served may still use the old slice, so a new one is made.
+	mediaType = strings.ToLower(mediaType)
+	serializers := make([]errorSerializer, 0, len(t.errorSerializers)+1)
+	found := false
+	for _, s := range t.errorSerializers {
+		if s.mediaType == mediaType {
+			found = true
+			if serializer == nil {
+				continue
+			}
+			s.serialize = serializer
+		}
+		serializers = append(serializers, s)
+	}
+	if !found && serializer != nil {
+		serializers = append(serializers, errorSerializer{mediaType, serializer})
+	}
+	t.errorSerializers = serializers
+}
+
+// serializers returns the serializers added with SetErrorSerializer.
+func (t *TreeMux) serializers() []errorSerializer {
+	t.serializersMutex.Lock()
+	defer t.serializersMutex.Unlock()
+	return t.errorSerializers
+}
+
+// JSONErrorSerializer writes the problem as an RFC 9457 application/problem+json document.
+func JSONErrorSerializer(w http.ResponseWriter, problem *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// XMLErrorSerializer writes the problem as an RFC 9457 application/problem+xml document.
+func XMLErrorSerializer(w http.ResponseWriter, problem *Problem) {
+	w.Header().Set("Content-Type", "application/problem+xml")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(problem)
+}
+
+// TextErrorSerializer writes the problem's status, title and detail as plain text.
+func TextErrorSerializer(w http.ResponseWriter, problem *Problem) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	if problem.Detail == "" {
+		fmt.Fprintf(w, "%d %s\n", problem.Status, problem.Title)
+	} else {
+		fmt.Fprintf(w, "%d %s: %s\n", problem.Status, problem.Title, problem.Detail)
+	}
+}
+
+// writeProblem writes the problem with the serializer for the request, or as JSON if there
+// are no serializers.
+func (t *TreeMux) writeProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	serializers := t.serializers()
+	if len(serializers) == 0 {
+		JSONErrorSerializer(w, problem)
+		return
+	}
+	bestErrorSerializer(r, serializers)(w, problem)
+}
+
+// bestErrorSerializer returns the serializer whose media type the request's Accept header
+// prefers, taking the quality values and the specificity of the media ranges into account.
+func bestErrorSerializer(r *http.Request, serializers []errorSerializer) ErrorSerializer {
+	best := serializers[0].serialize
+	bestQuality, bestSpecificity := 0.0, -1
+	for _, header := range r.Header["Accept"] {
+		for _, element := range strings.Split(header, ",") {
+			mediaRange, quality := parseAcceptElement(element)
+			if quality <= 0 {
+				continue
+			}
+			for _, s := range serializers {
+				specificity := mediaRangeSpecificity(mediaRange, s.mediaType)
+				if specificity < 0 {
+					continue
+				}
+				if quality > bestQuality || (quality == bestQuality && specificity > bestSpecificity) {
+					best, bestQuality, bestSpecificity = s.serialize, quality, specificity
+				}
+			}
+		}
+	}
+	return best
+}
+
+// parseAcceptElement returns the media range of one element of an Accept header, in lower
+// case, and its quality value.
+func parseAcceptElement(element string) (string, float64) {
+	parts := strings.Split(element, ";")
+	quality := 1.0
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				quality = q
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), quality
+}
+
+// mediaRangeSpecificity returns how specifically the media range of an Accept header matches
+// the media type: 2 for the type itself, 1 for a range such as text/*, 0 for */*, and -1 if
+// it does not match.
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1]):
+		return 1
+	}
+	return -1
+}
+
+// notFound is the default NotFoundHandler.
+func (t *TreeMux) notFound(w http.ResponseWriter, r *http.Request) {
+	if len(t.serializers()) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	problem := newProblem(r, http.StatusNotFound)
+	problem.Detail = "No route matches " + r.Method + " " + r.URL.Path
+	t.writeProblem(w, r, problem)
+}
+
+// methodNotAllowed is the default MethodNotAllowedHandler.
+func (t *TreeMux) methodNotAllowed(w http.ResponseWriter, r *http.Request, methods map[string]HandlerFunc) {
+	if len(t.serializers()) == 0 {
+		MethodNotAllowedHandler(w, r, methods)
+		return
+	}
+	t.problemMethodNotAllowed(w, r, methods)
+}
+
+// redirectRefused is the default RedirectRefusedHandler.
+func (t *TreeMux) redirectRefused(w http.ResponseWriter, r *http.Request, target string) {
+	w.Header().Set("Link", "<"+target+`>; rel="canonical"`)
+	detail := r.Method + " requests are not redirected, since the request body could be lost. " +
+		"Send the request to " + target + " instead."
+	if len(t.serializers()) == 0 {
+		http.Error(w, detail, http.StatusNotFound)
+		return
+	}
+	problem := newProblem(r, http.StatusNotFound)
+	problem.Detail = detail
+	t.writeProblem(w, r, problem)
+}
+
+// timeoutResponse is the response for a route added with a nil handler for WithTimeout.
+func (t *TreeMux) timeoutResponse(w http.ResponseWriter, r *http.Request, lr LookupResult, budget time.Duration) {
+	if len(t.serializers()) == 0 {
+		defaultTimeoutHandler(w, r, lr, budget)
+		return
+	}
+	problem := timeoutProblem(r, lr, budget)
+	t.writeProblem(w, r, problem)
+}
+
+// ProblemPanicHandler is a PanicHandler which responds with 500 Internal Server Error, written
+// as a Problem with the serializers registered with SetErrorSerializer, or as JSON if there
+// are none. The panic value is not included in the response.
+//
+//	router.PanicHandler = router.ProblemPanicHandler
+func (t *TreeMux) ProblemPanicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
+	t.writeProblem(w, r, newProblem(r, http.StatusInternalServerError))
+}
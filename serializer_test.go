@@ -0,0 +1,116 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorSerializers(t *testing.T) {
+	router := New()
+	router.GET("/users/:id", simpleHandler)
+	router.GET("/panic", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		panic("oops")
+	})
+	router.PanicHandler = router.ProblemPanicHandler
+
+	serve := func(method, path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(method, path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Without serializers, the defaults are unchanged.
+	if w := serve("GET", "/missing", "application/json"); w.Code != http.StatusNotFound ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the plain not found response, saw %d %v", w.Code, w.Header())
+	}
+	if w := serve("POST", "/users/1", ""); w.Code != http.StatusMethodNotAllowed || w.Body.Len() != 0 {
+		t.Errorf("Expected the empty method not allowed response, saw %d %q", w.Code, w.Body.String())
+	}
+
+	router.SetErrorSerializer("application/json", JSONErrorSerializer)
+	router.SetErrorSerializer("application/xml", XMLErrorSerializer)
+	router.SetErrorSerializer("text/plain", TextErrorSerializer)
+	router.SetErrorSerializer("application/x-protobuf", func(w http.ResponseWriter, problem *Problem) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(problem.Status)
+	})
+
+	for _, test := range []struct {
+		method, path, accept string
+		code                 int
+		contentType, body    string
+	}{
+		{"GET", "/missing", "", http.StatusNotFound, "application/problem+json", `"title":"Not Found"`},
+		{"GET", "/missing", "application/xml", http.StatusNotFound, "application/problem+xml",
+			`<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><title>Not Found</title><status>404</status>`},
+		{"GET", "/missing", "text/html, text/*;q=0.8", http.StatusNotFound, "text/plain; charset=utf-8",
+			"404 Not Found: No route matches GET /missing"},
+		{"GET", "/missing", "application/json;q=0.5, application/xml;q=0.9", http.StatusNotFound,
+			"application/problem+xml", "<problem"},
+		{"GET", "/missing", "*/*, application/X-Protobuf", http.StatusNotFound, "application/x-protobuf", ""},
+		{"GET", "/missing", "image/png", http.StatusNotFound, "application/problem+json", `"status":404`},
+		{"POST", "/users/1", "application/xml", http.StatusMethodNotAllowed, "application/problem+xml",
+			"<allowed><i>GET</i><i>HEAD</i></allowed>"},
+		{"GET", "/panic", "text/plain", http.StatusInternalServerError, "text/plain; charset=utf-8",
+			"500 Internal Server Error"},
+	} {
+		w := serve(test.method, test.path, test.accept)
+		if w.Code != test.code || w.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s %s with Accept %q: expected %d %s, saw %d %s", test.method, test.path, test.accept,
+				test.code, test.contentType, w.Code, w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s %s with Accept %q: expected the body to contain %q, saw %q", test.method, test.path,
+				test.accept, test.body, w.Body.String())
+		}
+	}
+
+	if w := serve("POST", "/users/1", ""); w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("Expected the Allow header, saw %q", w.Header().Get("Allow"))
+	}
+
+	router.SetErrorSerializer("application/json", nil)
+	if w := serve("GET", "/missing", "application/json"); w.Header().Get("Content-Type") != "application/problem+xml" {
+		t.Errorf("Expected the first remaining serializer after removing JSON, saw %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestSetErrorSerializerWhileServing(t *testing.T) {
+	router := New()
+	router.SafeAddRoutesWhileRunning = true
+	router.GET("/users/:id", simpleHandler)
+	router.SetErrorSerializer("application/json", JSONErrorSerializer)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			router.SetErrorSerializer("application/xml", XMLErrorSerializer)
+			router.SetErrorSerializer("application/xml", nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/missing", nil)
+		r.Header.Set("Accept", "application/xml")
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("Expected a 404, saw %d", w.Code)
+		}
+		// The 405 is written while the router's lock is held.
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", "/users/1", nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("Expected a 405, saw %d", w.Code)
+		}
+	}
+	<-done
+}
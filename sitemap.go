@@ -0,0 +1,122 @@
+package httptreemux
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParamValues returns the parameter values to fill in to the pattern of a route when
+// listing its paths with SitemapPaths. Each map in the result produces one path, and
+// must have a value for every wildcard and catch-all in the pattern.
+type ParamValues func(route Route) []map[string]string
+
+// SitemapPaths returns the paths of the GET routes which apply to all hosts, for
+// building a sitemap from the routes that are actually registered. The paths are
+// sorted, and each is escaped for use in a URL.
+//
+// Routes without parameters are listed as they are. Routes with parameters are only
+// listed when values is not nil, with one path for each set of values that it returns.
+// A set of values which is missing a parameter of the route is skipped. Optional
+// parameters are only filled in when the set has values for them. The values of
+// catch-all parameters may contain slashes to fill in several segments.
+//
+// Routes are listed under their own pattern, and not under the paths added with
+// WithAliases.
+//
+//	paths := router.SitemapPaths(func(route httptreemux.Route) []map[string]string {
+//	    if route.Pattern == "/products/:slug" {
+//	        return productSlugs()
+//	    }
+//	    return nil
+//	})
+func (t *TreeMux) SitemapPaths(values ParamValues) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, route := range t.Routes() {
+		if route.Method != "GET" || route.Host != "" {
+			continue
+		}
+
+		expansions, err := optionalExpansions(route.Pattern)
+		if err != nil {
+			continue
+		}
+
+		var sets []map[string]string
+		if values != nil {
+			sets = values(route)
+		}
+
+		for _, expansion := range expansions {
+			if len(patternParamNames(expansion)) == 0 {
+				if path, ok := fillPattern(expansion, nil); ok && !seen[path] {
+					seen[path] = true
+					paths = append(paths, path)
+				}
+				continue
+			}
+
+			for _, params := range sets {
+				if path, ok := fillPattern(expansion, params); ok && !seen[path] {
+					seen[path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// fillPattern returns the escaped path for a pattern with the parameters replaced by
+// their values, and false if a value is missing.
+func fillPattern(pattern string, params map[string]string) (string, bool) {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+
+		switch segment[0] {
+		case ':', '*':
+			value, ok := params[segment[1:]]
+			if !ok || value == "" {
+				return "", false
+			}
+
+			if segment[0] == ':' {
+				segments[i] = escapeSegment(value)
+			} else {
+				parts := strings.Split(value, "/")
+				for j, part := range parts {
+					parts[j] = escapeSegment(part)
+				}
+				segments[i] = strings.Join(parts, "/")
+			}
+		case '\\':
+			segments[i] = escapeSegment(segment[1:])
+		default:
+			segments[i] = escapeSegment(segment)
+		}
+	}
+
+	return strings.Join(segments, "/"), true
+}
+
+// escapeSegment escapes a value for use as a single path segment, as url.PathEscape does in
+// Go 1.8 and later.
+func escapeSegment(s string) string {
+	const hex = "0123456789ABCDEF"
+	escaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-_.~$&+:=@", c) != -1 {
+			escaped = append(escaped, c)
+		} else {
+			escaped = append(escaped, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return string(escaped)
+}
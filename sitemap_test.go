@@ -0,0 +1,55 @@
+package httptreemux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSitemapPaths(t *testing.T) {
+	router := New()
+	router.GET("/", simpleHandler)
+	router.GET("/about", simpleHandler)
+	router.With(WithAliases("/ueber-uns")).GET("/company", simpleHandler)
+	router.POST("/contact", simpleHandler)
+	router.GET("/products/:slug", simpleHandler)
+	router.GET("/docs/*page", simpleHandler)
+	router.GET("/blog/:year?", simpleHandler)
+	router.GET("/\\:literal", simpleHandler)
+	router.Host("admin.example.com").GET("/dashboard", simpleHandler)
+
+	expected := []string{"/", "/:literal", "/about", "/blog", "/company"}
+	if paths := router.SitemapPaths(nil); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %v, saw %v", expected, paths)
+	}
+
+	paths := router.SitemapPaths(func(route Route) []map[string]string {
+		switch route.Pattern {
+		case "/products/:slug":
+			return []map[string]string{{"slug": "chair"}, {"slug": "red table"}, {"other": "x"}}
+		case "/docs/*page":
+			return []map[string]string{{"page": "guide/install"}}
+		case "/blog/:year?":
+			return []map[string]string{{"year": "2024"}}
+		}
+		return nil
+	})
+	expected = []string{"/", "/:literal", "/about", "/blog", "/blog/2024", "/company",
+		"/docs/guide/install", "/products/chair", "/products/red%20table"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %v, saw %v", expected, paths)
+	}
+}
+
+func TestEscapeSegment(t *testing.T) {
+	for value, expected := range map[string]string{
+		"plain-name_1.~": "plain-name_1.~",
+		"a b/c?d;e,f":    "a%20b%2Fc%3Fd%3Be%2Cf",
+		"$&+:=@":         "$&+:=@",
+		"café#%":         "caf%C3%A9%23%25",
+		"":               "",
+	} {
+		if escaped := escapeSegment(value); escaped != expected {
+			t.Errorf("escapeSegment(%q): expected %q, saw %q", value, expected, escaped)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package httptreemux
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WithAllowedSources makes a route serve only requests from clients whose IP address is in
+// one of the sources, which are IP addresses or CIDR ranges such as "10.0.0.0/8". Other
+// requests are answered by TreeMux.ForbiddenHandler. The client's address is found as
+// described for TreeMux.ClientIP. Since the check is made by the router, it applies even if
+// the middleware of a group is misconfigured, which makes it suitable for internal-only
+// routes such as admin endpoints.
+//
+// When the option is given more than once, for example on a group and on one of its routes,
+// a request must be from one of the sources given to each. It panics if a source is not a
+// valid address or range.
+//
+//	admin := router.NewGroup("/admin").With(httptreemux.WithAllowedSources("10.0.0.0/8", "::1"))
+func WithAllowedSources(sources ...string) RouteOption {
+	nets := mustParseSources(sources)
+	return func(info *routeInfo) {
+		info.allowedSources = append(info.allowedSources[:len(info.allowedSources):len(info.allowedSources)], nets)
+	}
+}
+
+// WithDeniedSources makes a route answer requests from clients whose IP address is in one of
+// the sources with TreeMux.ForbiddenHandler. It takes precedence over WithAllowedSources. It
+// panics if a source is not a valid address or range.
+func WithDeniedSources(sources ...string) RouteOption {
+	nets := mustParseSources(sources)
+	return func(info *routeInfo) {
+		info.deniedSources = append(info.deniedSources[:len(info.deniedSources):len(info.deniedSources)], nets...)
+	}
+}
+
+// parseSources parses IP addresses and CIDR ranges. A single address is a range which
+// contains only that address.
+func parseSources(sources []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(sources))
+	for _, source := range sources {
+		if strings.IndexByte(source, '/') == -1 {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: source}
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func mustParseSources(sources []string) []*net.IPNet {
+	nets, err := parseSources(sources)
+	if err != nil {
+		panic("httptreemux: invalid source: " + err.Error())
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client which sent the request, or nil if it can not
+// be determined. This is the address the request came from, unless that is one of the
+// TrustedProxies. Then the X-Forwarded-For header is read from right to left, skipping the
+// addresses of trusted proxies, and the first other address is the client's.
+func (t *TreeMux) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || len(t.TrustedProxies) == 0 {
+		return ip
+	}
+
+	var trusted []*net.IPNet
+	for _, proxy := range t.TrustedProxies {
+		// Entries which are not valid are ignored.
+		if nets, err := parseSources([]string{proxy}); err == nil {
+			trusted = append(trusted, nets...)
+		}
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && containsIP(trusted, ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// The rest of the header can't be trusted.
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// rejectsSource returns true if the request must be answered with 403 Forbidden because of the
+// route's allowed or denied sources.
+func (t *TreeMux) rejectsSource(r *http.Request, info *routeInfo) bool {
+	if info == nil || (info.allowedSources == nil && info.deniedSources == nil) {
+		return false
+	}
+	ip := t.ClientIP(r)
+	if ip == nil {
+		return true
+	}
+	if containsIP(info.deniedSources, ip) {
+		return true
+	}
+	for _, allowed := range info.allowedSources {
+		if !containsIP(allowed, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forbiddenHandler returns the handler which answers requests rejected because of their source.
+func (t *TreeMux) forbiddenHandler() HandlerFunc {
+	if t.ForbiddenHandler == nil {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+	}
+	handler := t.ForbiddenHandler
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		handler(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	router := New()
+	router.TrustedProxies = []string{"192.0.2.1", "198.51.100.0/24"}
+	admin := router.NewGroup("/admin").With(WithAllowedSources("10.0.0.0/8", "::1"))
+	admin.GET("/stats", simpleHandler)
+	admin.With(WithDeniedSources("10.0.0.13")).GET("/users", simpleHandler)
+	admin.With(WithAllowedSources("10.1.0.0/16")).GET("/keys", simpleHandler)
+	router.With(WithDeniedSources("203.0.113.0/24")).GET("/public", simpleHandler)
+
+	for _, test := range []struct {
+		path, remote, forwarded string
+		code                    int
+	}{
+		{"/admin/stats", "10.2.3.4:1234", "", http.StatusOK},
+		{"/admin/stats", "[::1]:1234", "", http.StatusOK},
+		{"/admin/stats", "203.0.113.7:1234", "", http.StatusForbidden},
+		{"/admin/users", "10.0.0.12:1234", "", http.StatusOK},
+		{"/admin/users", "10.0.0.13:1234", "", http.StatusForbidden},
+		{"/admin/keys", "10.1.2.3:1234", "", http.StatusOK},
+		{"/admin/keys", "10.2.2.3:1234", "", http.StatusForbidden},
+		// Forwarded by trusted proxies.
+		{"/admin/stats", "192.0.2.1:1234", "10.2.3.4", http.StatusOK},
+		{"/admin/stats", "192.0.2.1:1234", "203.0.113.7, 10.2.3.4, 198.51.100.9", http.StatusOK},
+		{"/admin/stats", "192.0.2.1:1234", "10.2.3.4, 203.0.113.7", http.StatusForbidden},
+		// An untrusted client can not choose its address.
+		{"/admin/stats", "203.0.113.7:1234", "10.2.3.4", http.StatusForbidden},
+		{"/admin/stats", "192.0.2.1:1234", "garbage", http.StatusForbidden},
+		{"/public", "198.18.0.1:1234", "", http.StatusOK},
+		{"/public", "203.0.113.7:1234", "", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", test.path, nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s from %s via %q: expected code %d, saw %d", test.path, test.remote, test.forwarded,
+				test.code, w.Code)
+		}
+	}
+
+	router.ForbiddenHandler = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/admin/stats", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the ForbiddenHandler to answer, saw %d", w.Code)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for an invalid source")
+		}
+	}()
+	WithAllowedSources("10.0.0.0/33")
+}
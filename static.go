@@ -0,0 +1,87 @@
+package httptreemux
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Static adds a route which responds with a precomputed response, for endpoints such as health
+// checks, version information or robots.txt which do not need a handler of their own. The status,
+// headers and body are written as given, along with a Content-Length header. If the header has no
+// Content-Type, it is detected from the body as usual. The group's middleware is applied as for
+// any other route.
+//
+// If the header includes an ETag, GET and HEAD requests whose If-None-Match header matches it
+// receive a 304 Not Modified response without a body. StaticETag computes an ETag from the body.
+//
+//	router.Static("GET", "/robots.txt", http.StatusOK, http.Header{
+//	    "Content-Type": {"text/plain; charset=utf-8"},
+//	    "ETag":         {httptreemux.StaticETag(robots)},
+//	}, robots)
+func (g *Group) Static(method, path string, status int, header http.Header, body []byte) {
+	g.Handle(method, path, staticHandler(status, header, body))
+}
+
+// StaticETag returns a strong ETag for a response body, derived from its SHA-256 hash.
+func StaticETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func staticHandler(status int, header http.Header, body []byte) HandlerFunc {
+	// Copy everything, so that the caller can not change the response later. Each value
+	// slice has no spare capacity, so that it can be shared between responses: adding a
+	// value to the response header copies the slice instead of writing to it.
+	header = cloneHeader(header)
+	header["Content-Length"] = []string{strconv.Itoa(len(body))}
+	body = append([]byte(nil), body...)
+	etag := header.Get("ETag")
+
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		h := w.Header()
+		if etag != "" && (r.Method == "GET" || r.Method == "HEAD") && etagMatches(r.Header.Get("If-None-Match"), etag) {
+			for key, values := range header {
+				if key != "Content-Length" && key != "Content-Type" {
+					h[key] = values
+				}
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		for key, values := range header {
+			h[key] = values
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header)+1)
+	for key, values := range header {
+		copied := make([]string, len(values))
+		copy(copied, values)
+		clone[http.CanonicalHeaderKey(key)] = copied
+	}
+	return clone
+}
+
+// etagMatches reports whether an If-None-Match header matches an ETag, using the weak
+// comparison required by RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
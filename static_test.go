@@ -0,0 +1,82 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	body := []byte("User-agent: *\nDisallow:\n")
+	header := http.Header{
+		"content-type":  {"text/plain; charset=utf-8"},
+		"Cache-Control": {"max-age=3600"},
+		"ETag":          {StaticETag(body)},
+	}
+
+	router := New()
+	router.Static("GET", "/robots.txt", http.StatusOK, header, body)
+	router.Static("GET", "/health", http.StatusNoContent, nil, nil)
+	// Changing the arguments afterward must not change the response.
+	header.Set("Cache-Control", "no-store")
+	body[0] = 'X'
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/robots.txt", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected code 200, saw %d", w.Code)
+	}
+	if w.Body.String() != "User-agent: *\nDisallow:\n" {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Expected Content-Type from header, saw %q", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=3600" {
+		t.Errorf("Expected Cache-Control max-age=3600, saw %q", cc)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "24" {
+		t.Errorf("Expected Content-Length 24, saw %q", cl)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag[0] != '"' {
+		t.Errorf("Expected a strong ETag, saw %q", etag)
+	}
+
+	for _, test := range []struct {
+		method      string
+		ifNoneMatch string
+		code        int
+	}{
+		{"GET", etag, http.StatusNotModified},
+		{"GET", `"other", W/` + etag, http.StatusNotModified},
+		{"GET", "*", http.StatusNotModified},
+		{"HEAD", etag, http.StatusNotModified},
+		{"GET", `"other"`, http.StatusOK},
+	} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(test.method, "/robots.txt", nil)
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s with If-None-Match %s: expected code %d, saw %d", test.method, test.ifNoneMatch, test.code, w.Code)
+		}
+		if test.code == http.StatusNotModified {
+			if w.Body.Len() != 0 {
+				t.Errorf("Expected no body on 304, saw %q", w.Body.String())
+			}
+			if w.Header().Get("ETag") != etag || w.Header().Get("Content-Length") != "" {
+				t.Errorf("Unexpected 304 headers %v", w.Header())
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/health", nil)
+	r.Header.Set("If-None-Match", "*")
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected code 204 without an ETag, saw %d", w.Code)
+	}
+}
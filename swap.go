@@ -0,0 +1,102 @@
+package httptreemux
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Swap replaces the handler of the route registered for the method and pattern, which is
+// relative to the group like the pattern given to Handle, and returns the handler that it
+// replaced. The middleware of the group that the route was added through is applied to the
+// new handler, and the route keeps its options, such as its metadata and aliases. Since the
+// tree is not changed, the route is never missing, as it would be between Remove and Handle,
+// so handlers can be upgraded in place by plugin systems or blue/green deployments within one
+// process. Like Handle, it takes the write lock on the router, so with
+// SafeAddRoutesWhileRunning each request is served entirely by the old or the new handler.
+//
+// It returns an error if there is no such route, or if there are several because they were
+// added with match options such as MatchHeader.
+//
+//	old, err := router.Swap("GET", "/reports/:id", reportsV2)
+func (g *Group) Swap(method, path string, handler HandlerFunc) (HandlerFunc, error) {
+	if handler == nil {
+		return nil, errors.New("Swap needs a handler")
+	}
+
+	g.mux.mutex.Lock()
+	defer g.mux.mutex.Unlock()
+
+	path, err := g.mux.translatePattern(path)
+	if err != nil {
+		return nil, err
+	}
+	if method, err = g.mux.routeMethod(method); err != nil {
+		return nil, err
+	}
+	pattern := g.path + path
+
+	var info *routeInfo
+	ambiguous := false
+	walkRoutes(g.tree(), func(n *node) {
+		candidates := make([]*routeInfo, 0, 1+len(n.leafVariants[method]))
+		if method != "HEAD" || !n.implicitHead {
+			candidates = append(candidates, n.leafRoute[method])
+		}
+		for _, v := range n.leafVariants[method] {
+			candidates = append(candidates, v.info)
+		}
+		for _, c := range candidates {
+			if c == nil || c.pattern != pattern || c == info {
+				continue
+			}
+			if info != nil {
+				ambiguous = true
+			}
+			info = c
+		}
+	})
+	if info == nil || info.wrap == nil {
+		return nil, fmt.Errorf("No route for %s %s", method, pattern)
+	}
+	if ambiguous {
+		return nil, fmt.Errorf("More than one route for %s %s was added with match options", method, pattern)
+	}
+
+	// The wrapper of a context group sets the params handler again.
+	info.paramsHandler = nil
+	info.paramsRoute = false
+	wrapped := info.wrap(handler)
+
+	// The route may be stored under several paths, for its aliases and optional wildcards,
+	// and also serves HEAD requests if it is a GET route.
+	walkRoutes(g.tree(), func(n *node) {
+		for m, routeInfo := range n.leafRoute {
+			if routeInfo == info {
+				n.leafHandler[m] = wrapped
+			}
+		}
+		for _, variants := range n.leafVariants {
+			for i := range variants {
+				if variants[i].info == info {
+					variants[i].handler = wrapped
+				}
+			}
+		}
+	})
+
+	old := info.handler
+	info.handler = handler
+	return old, nil
+}
+
+// middlewareWrapper returns the function which applies the group's middleware to a handler
+// of the route, to make the handler stored in the tree.
+func (g *Group) middlewareWrapper(info *routeInfo) func(HandlerFunc) HandlerFunc {
+	stack := g.stack
+	return func(handler HandlerFunc) HandlerFunc {
+		if len(stack) > 0 {
+			return handlerWithMiddlewares(handler, stack, info.lookupResult())
+		}
+		return handler
+	}
+}
@@ -0,0 +1,108 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	router := New()
+	served := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Write([]byte(name + " " + params["id"]))
+		}
+	}
+	api := router.NewGroup("/api")
+	api.Use(func(next HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Header().Set("X-Middleware", "yes")
+			next(w, r, params)
+		}
+	})
+	api.With(WithAliases("/reports/:id/view")).GET("/reports/:id", served("v1"))
+	router.With(MatchHeader("X-Beta", "yes")).GET("/search", served("beta"))
+	router.GET("/search", served("search"))
+	router.With(MatchHeader("X-Canary", "yes")).GET("/search", served("canary"))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(method, path, nil)
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	old, err := api.Swap("GET", "/reports/:id", served("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/api/reports/1", "/api/reports/1/view"} {
+		w := serve("GET", path)
+		if w.Body.String() != "v2 1" || w.Header().Get("X-Middleware") != "yes" {
+			t.Errorf("%s: expected the new handler with the middleware, saw %q %q", path, w.Body.String(),
+				w.Header().Get("X-Middleware"))
+		}
+	}
+	if w := serve("HEAD", "/api/reports/1"); w.Code != http.StatusOK || w.Header().Get("X-Middleware") != "yes" {
+		t.Errorf("Expected HEAD to use the new GET handler, saw %d", w.Code)
+	}
+
+	if _, err := router.Swap("GET", "/api/reports/:id", old); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve("GET", "/api/reports/2"); w.Body.String() != "v1 2" {
+		t.Errorf("Expected the old handler to be swapped back, saw %q", w.Body.String())
+	}
+
+	for _, test := range []struct {
+		method, path string
+	}{
+		{"POST", "/api/reports/:id"},
+		{"HEAD", "/api/reports/:id"},
+		{"GET", "/api/reports/:other"},
+		{"GET", "/search"},
+	} {
+		if _, err := router.Swap(test.method, test.path, served("x")); err == nil {
+			t.Errorf("%s %s: expected an error", test.method, test.path)
+		}
+	}
+
+	router.GETP("/items/:id", func(w http.ResponseWriter, r *http.Request, ps Params) {
+		w.Write([]byte("params " + ps.ByName("id")))
+	})
+	if _, err := router.Swap("GET", "/items/:id", served("map")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve("GET", "/items/3"); w.Body.String() != "map 3" {
+		t.Errorf("Expected the new handler for a HandleP route, saw %q", w.Body.String())
+	}
+
+	cm := NewContextMux()
+	cm.GET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	})
+	oldContext, err := cm.Swap("GET", "/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new " + ContextData(r.Context()).Param("id")))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/users/5", nil)
+	cm.ServeHTTP(w, r)
+	if w.Body.String() != "new 5" {
+		t.Errorf("Expected the new handler with context data, saw %q", w.Body.String())
+	}
+
+	if _, err := cm.Swap("GET", "/users/:id", oldContext); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	cm.ServeHTTP(w, r)
+	if w.Body.String() != "old" {
+		t.Errorf("Expected the old handler to be swapped back, saw %q", w.Body.String())
+	}
+}
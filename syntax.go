@@ -0,0 +1,63 @@
+package httptreemux
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PatternSyntax selects the syntax accepted for wildcards in route patterns.
+type PatternSyntax int
+
+const (
+	// SyntaxColon accepts only :name wildcards and *name catch-alls.
+	SyntaxColon PatternSyntax = iota
+	// SyntaxBraces additionally accepts the syntax of http.ServeMux in Go 1.22: a {name}
+	// segment is a wildcard, a final {name...} segment is a catch-all, and a final {$}
+	// segment matches only the path ending in the slash before it. Each pair of braces must
+	// make up a whole segment.
+	SyntaxBraces
+)
+
+// translatePattern converts a pattern written in the router's PatternSyntax into the syntax
+// used by the tree.
+func (t *TreeMux) translatePattern(path string) (string, error) {
+	if t.PatternSyntax != SyntaxBraces || !strings.ContainsAny(path, "{}") {
+		return path, nil
+	}
+
+	segments := strings.Split(path, "/")
+	last := len(segments) - 1
+	for i, segment := range segments {
+		if !strings.ContainsAny(segment, "{}") {
+			continue
+		}
+
+		if len(segment) < 2 || segment[0] != '{' || segment[len(segment)-1] != '}' ||
+			strings.ContainsAny(segment[1:len(segment)-1], "{}") {
+			return "", fmt.Errorf("Pattern %s: braces must enclose a whole segment", path)
+		}
+
+		name := segment[1 : len(segment)-1]
+		switch {
+		case name == "$":
+			if i != last {
+				return "", fmt.Errorf("Pattern %s: {$} must be the last segment", path)
+			}
+			segments[i] = ""
+		case strings.HasSuffix(name, "..."):
+			if i != last {
+				return "", fmt.Errorf("Pattern %s: %s must be the last segment", path, segment)
+			}
+			name = strings.TrimSuffix(name, "...")
+			segments[i] = "*" + name
+		default:
+			segments[i] = ":" + name
+		}
+
+		if name == "" {
+			return "", fmt.Errorf("Pattern %s: wildcard %s has no name", path, segment)
+		}
+	}
+
+	return strings.Join(segments, "/"), nil
+}
@@ -0,0 +1,127 @@
+package httptreemux
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutHandler writes the response for a request whose handler did not finish within the
+// budget of its route, given with WithTimeout. It receives the LookupResult of the route, so
+// that the response and logs can identify the endpoint.
+type TimeoutHandler func(w http.ResponseWriter, r *http.Request, lr LookupResult, budget time.Duration)
+
+// WithTimeout limits the time the route's handler has to respond. The handler runs with a
+// request context which is canceled once the budget is spent, and writes into a buffer. If it
+// finishes in time, the buffered response is sent; otherwise handler writes the response
+// instead, and anything the route's handler writes afterwards is discarded. If handler is
+// nil, the response is a plain 503 Service Unavailable, as with http.TimeoutHandler, or a
+// Problem written with the error serializers when any are registered with SetErrorSerializer.
+//
+// The budget is measured with TreeMux.Clock: the context's deadline is its current time plus
+// the budget, and the timeout fires from its timers if it is a TimerClock, or from the
+// system clock's otherwise.
+//
+// Timeouts are reported to TreeMux.OnRouteTimeout instead of OnRouteServed, and requests
+// whose client goes away before the handler finishes are reported to neither. A panic of the
+// handler before the timeout response is written is passed to the panic handlers as usual. A
+// panic after it is passed to the route's or router's panic handler with a ResponseWriter
+// which discards what it writes, so that the panic can still be logged; without a panic
+// handler it is dropped, as with http.TimeoutHandler. Timeouts need the request context, so
+// in Go 1.6 and before the option has no effect.
+//
+//	api.With(httptreemux.WithTimeout(2*time.Second, httptreemux.ProblemTimeout)).GET("/report", buildReport)
+func WithTimeout(budget time.Duration, handler TimeoutHandler) RouteOption {
+	return func(info *routeInfo) {
+		info.timeout = budget
+		info.timeoutHandler = handler
+	}
+}
+
+// ProblemTimeout is a TimeoutHandler which responds with 503 Service Unavailable and an RFC
+// 9457 application/problem+json document, which names the route's pattern and its budget.
+// To respond in the format chosen by the error serializers registered with
+// SetErrorSerializer instead, pass a nil handler to WithTimeout.
+func ProblemTimeout(w http.ResponseWriter, r *http.Request, lr LookupResult, budget time.Duration) {
+	JSONErrorSerializer(w, timeoutProblem(r, lr, budget))
+}
+
+func timeoutProblem(r *http.Request, lr LookupResult, budget time.Duration) *Problem {
+	problem := newProblem(r, http.StatusServiceUnavailable)
+	problem.Detail = lr.Route + " did not respond within " + budget.String()
+	problem.Route = lr.Route
+	problem.Budget = budget.String()
+	return problem
+}
+
+func defaultTimeoutHandler(w http.ResponseWriter, r *http.Request, lr LookupResult, budget time.Duration) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+// handlerOutcome is how the handler of a route finished.
+type handlerOutcome int
+
+const (
+	handlerServed handlerOutcome = iota
+	handlerTimedOut
+	// The client went away before a handler with a timeout finished.
+	handlerAbandoned
+)
+
+// timeoutWriter buffers the response of a handler running with a timeout. Once the timeout
+// fires, its writes fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	mutex    sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.body.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// flush sends the buffered response to w.
+func (tw *timeoutWriter) flush(w http.ResponseWriter) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	dst := w.Header()
+	for name, values := range tw.header {
+		dst[name] = values
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	w.WriteHeader(tw.code)
+	w.Write(tw.body.Bytes())
+}
+
+// timeOut stops the writes of the handler, so that the timeout response can be written.
+func (tw *timeoutWriter) timeOut() {
+	tw.mutex.Lock()
+	tw.timedOut = true
+	tw.mutex.Unlock()
+}
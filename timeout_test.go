@@ -0,0 +1,172 @@
+//go:build go1.7
+// +build go1.7
+
+package httptreemux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	clock := &fakeClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	router := New()
+	router.Clock = clock
+	var served, timedOut []string
+	router.OnRouteServed = func(r *http.Request, lr LookupResult, elapsed time.Duration) {
+		served = append(served, lr.Route)
+	}
+	router.OnRouteTimeout = func(r *http.Request, lr LookupResult, budget time.Duration) {
+		if budget != 20*time.Millisecond {
+			t.Errorf("Expected a budget of 20ms, saw %s", budget)
+		}
+		timedOut = append(timedOut, lr.Route)
+	}
+	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	started := make(chan struct{}, 1)
+	var deadline time.Time
+	slow := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		deadline, _ = r.Context().Deadline()
+		started <- struct{}{}
+		<-r.Context().Done()
+		w.Write([]byte("late"))
+	}
+	fast := router.With(WithTimeout(time.Second, nil))
+	fast.GET("/fast", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		w.Header().Set("X-Handler", "fast")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	fast.GET("/panic", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		panic("oops")
+	})
+	router.With(WithTimeout(20*time.Millisecond, ProblemTimeout)).GET("/reports/:id", slow)
+	router.With(WithTimeout(20*time.Millisecond, nil)).GET("/plain", slow)
+
+	// serveSlow serves a request to a slow route, advancing the clock past its budget once
+	// the handler runs.
+	serveSlow := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := newRequest("GET", path, nil)
+		done := make(chan struct{})
+		go func() {
+			router.ServeHTTP(w, r)
+			close(done)
+		}()
+		<-started
+		clock.advance(20 * time.Millisecond)
+		<-done
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/fast", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated || w.Body.String() != "done" || w.Header().Get("X-Handler") != "fast" {
+		t.Errorf("Expected the buffered response, saw %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	start := clock.Now()
+	w = serveSlow("/reports/1")
+	if expected := start.Add(20 * time.Millisecond); !deadline.Equal(expected) {
+		t.Errorf("Expected the deadline %v from the router's clock, saw %v", expected, deadline)
+	}
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Expected a 503 problem response, saw %d %v", w.Code, w.Header())
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Route != "/reports/:id" || problem.Budget != "20ms" {
+		t.Errorf("Expected the route and budget in the problem, saw %+v", problem)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Error("Expected the handler's late write to be discarded")
+	}
+
+	w = serveSlow("/plain")
+	if w.Code != http.StatusServiceUnavailable || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected a plain 503 response, saw %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = newRequest("GET", "/panic", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the panic to reach the panic handler, saw %d", w.Code)
+	}
+
+	// A request whose client goes away is reported to neither hook.
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ = newRequest("GET", "/plain", nil)
+	r = r.WithContext(ctx)
+	w = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, r)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no response for a canceled request, saw %q", w.Body.String())
+	}
+
+	if len(served) != 1 || served[0] != "/fast" {
+		t.Errorf("Expected OnRouteServed for /fast only, saw %v", served)
+	}
+	if len(timedOut) != 2 || timedOut[0] != "/reports/:id" || timedOut[1] != "/plain" {
+		t.Errorf("Expected OnRouteTimeout for the slow routes, saw %v", timedOut)
+	}
+}
+
+func TestWithTimeoutLatePanic(t *testing.T) {
+	clock := &fakeClock{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	router := New()
+	router.Clock = clock
+
+	started, release := make(chan struct{}), make(chan struct{})
+	recovered := make(chan interface{}, 1)
+	router.With(
+		WithTimeout(time.Second, nil),
+		WithPanicHandler(func(w http.ResponseWriter, r *http.Request, lr LookupResult, err interface{}) {
+			w.WriteHeader(http.StatusInternalServerError)
+			recovered <- err
+		}),
+	).GET("/late", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		close(started)
+		<-release
+		panic("late")
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := newRequest("GET", "/late", nil)
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, r)
+		close(done)
+	}()
+	<-started
+	clock.advance(time.Second)
+	<-done
+	close(release)
+
+	// The panic comes after the timeout response, and reaches the panic handler without
+	// changing the response.
+	if err := <-recovered; err != "late" {
+		t.Errorf("Expected the late panic to reach the panic handler, saw %v", err)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the timeout response, saw %d", w.Code)
+	}
+}
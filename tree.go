@@ -1,340 +1,346 @@
 package httptreemux
 
 import (
+	"bytes"
 	"fmt"
-	"strings"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
 )
 
+// node holds the routes which end at a node of the routing tree. Once a handler is set, it is
+// the Value of the tree's node, which it embeds.
 type node struct {
-	path string
-
-	priority int
-
-	// The list of static children to check.
-	staticIndices []byte
-	staticChild   []*node
-
-	// If none of the above match, check the wildcard children
-	wildcardChild *node
-
-	// If none of the above match, then we use the catch-all, if applicable.
-	catchAllChild *node
+	*pathtree.Node
 
-	// Data for the node is below.
-
-	addSlash   bool
-	isCatchAll bool
+	addSlash bool
 	// If true, the head handler was set implicitly, so let it also be set explicitly.
 	implicitHead bool
 	// If this node is the end of the URL, then call the handler, if applicable.
 	leafHandler map[string]HandlerFunc
+	// Registration details for each handler in leafHandler.
+	leafRoute map[string]*routeInfo
+	// The routes added with match options for each method, in the order they were added.
+	leafVariants map[string][]routeVariant
+}
 
-	// The names of the parameters to apply.
-	leafWildcardNames []string
+// routeInfo holds the details of a route registration which are not needed to
+// find the route, but which are reported once it is matched.
+type routeInfo struct {
+	// The full pattern passed at registration, including the group prefix.
+	pattern string
+	// The value given with WithMetadata.
+	metadata interface{}
+	// Overrides of the router's redirect settings, from WithRedirectBehavior and
+	// WithoutRedirects.
+	redirectBehavior *RedirectBehavior
+	noRedirects      bool
+	// Overrides TreeMux.EscapeAddedRoutes, from WithPatternEscaping.
+	escapePattern *bool
+	// The handler as it was given when the route was added or swapped, and the function which
+	// wraps it with the group's middleware to make the handler stored in the tree.
+	handler HandlerFunc
+	wrap    func(HandlerFunc) HandlerFunc
+	// An alternative to the handler in leafHandler, which is used when
+	// TreeMux.PooledParams is set, or always when paramsRoute is set.
+	paramsHandler ParamsHandlerFunc
+	// True for routes added with HandleP.
+	paramsRoute bool
+	// True for routes added to a group from When(false), which are not registered.
+	skip bool
+	// The paths given with WithAliases. Once the route is registered, these are full
+	// patterns which include the group prefix.
+	aliases []string
+	// The labels given with WithLabels, if any.
+	labels *labelSet
+	// The conditions given with MatchHeader, MatchQuery and MatchFunc.
+	predicates []predicate
+	// The handler given with WithPanicHandler, if any.
+	panicHandler RoutePanicHandler
+	// The filter given with WithHookFilter or WithoutHooks, if any.
+	hookFilter func(r *http.Request) bool
+	// Whether the route accepts requests in TLS early data, if given with WithEarlyData.
+	earlyData *bool
+	// The budget and response given with WithTimeout, if any.
+	timeout        time.Duration
+	timeoutHandler TimeoutHandler
+	// The response cache created for the route by WithResponseCache, if any.
+	cache *responseCache
+	// The ranges given with WithAllowedSources, one list for each time the option was given,
+	// and with WithDeniedSources.
+	allowedSources [][]*net.IPNet
+	deniedSources  []*net.IPNet
+	// The traffic split given with WithExperiment, if any.
+	experiment *experiment
+	// The name of the release given with WithCanary, if any.
+	canary string
+	// The header changes given with WithoutRequestHeaders, WithRequestHeader and
+	// WithRouteHeader, if any.
+	headerRules *headerRules
 }
 
-func (n *node) sortStaticChild(i int) {
-	for i > 0 && n.staticChild[i].priority > n.staticChild[i-1].priority {
-		n.staticChild[i], n.staticChild[i-1] = n.staticChild[i-1], n.staticChild[i]
-		n.staticIndices[i], n.staticIndices[i-1] = n.staticIndices[i-1], n.staticIndices[i]
-		i -= 1
+func (n *node) setHandler(verb string, handler HandlerFunc, implicitHead bool) {
+	if err := n.trySetHandler(verb, handler, implicitHead); err != nil {
+		panic(err.Error())
 	}
 }
 
-func (n *node) setHandler(verb string, handler HandlerFunc, implicitHead bool) {
+func (n *node) trySetHandler(verb string, handler HandlerFunc, implicitHead bool) error {
 	if n.leafHandler == nil {
 		n.leafHandler = make(map[string]HandlerFunc)
 	}
 	_, ok := n.leafHandler[verb]
 	if ok && (verb != "HEAD" || !n.implicitHead) {
-		panic(fmt.Sprintf("%s already handles %s", n.path, verb))
+		err := &RouteConflictError{Reason: fmt.Sprintf("%s already handles %s", n.Path(), verb)}
+		if info := n.leafRoute[verb]; info != nil {
+			err.Existing = info.pattern
+		}
+		return err
 	}
 	n.leafHandler[verb] = handler
+	n.Node.Value = n
 
 	if verb == "HEAD" {
 		n.implicitHead = implicitHead
 	}
+	return nil
 }
 
-func (n *node) addPath(path string, wildcards []string, inStaticToken bool) *node {
-	leaf := len(path) == 0
-	if leaf {
-		if wildcards != nil {
-			// Make sure the current wildcards are the same as the old ones.
-			// If not then we have an ambiguous path.
-			if n.leafWildcardNames != nil {
-				if len(n.leafWildcardNames) != len(wildcards) {
-					// This should never happen.
-					panic("Reached leaf node with differing wildcard array length. Please report this as a bug.")
-				}
-
-				for i := 0; i < len(wildcards); i++ {
-					if n.leafWildcardNames[i] != wildcards[i] {
-						panic(fmt.Sprintf("Wildcards %v are ambiguous with wildcards %v",
-							n.leafWildcardNames, wildcards))
-					}
-				}
-			} else {
-				// No wildcards yet, so just add the existing set.
-				n.leafWildcardNames = wildcards
-			}
+// anyPattern returns the pattern of one of the routes registered on the node,
+// for use in error messages.
+func (n *node) anyPattern() string {
+	pattern := ""
+	method := ""
+	for m, info := range n.leafRoute {
+		if method == "" || m < method {
+			method = m
+			pattern = info.pattern
 		}
-
-		return n
 	}
+	return pattern
+}
 
-	c := path[0]
-	nextSlash := strings.Index(path, "/")
-	var thisToken string
-	var tokenEnd int
-
-	if c == '/' {
-		// Done processing the previous token, so reset inStaticToken to false.
-		thisToken = "/"
-		tokenEnd = 1
-	} else if nextSlash == -1 {
-		thisToken = path
-		tokenEnd = len(path)
-	} else {
-		thisToken = path[0:nextSlash]
-		tokenEnd = nextSlash
+// removeHandler removes the handler for a verb, as long as it was registered
+// with the given pattern, and returns whether it did so.
+func (n *node) removeHandler(verb, pattern string, headCanUseGet bool) bool {
+	info := n.leafRoute[verb]
+	if info == nil || info.pattern != pattern || (verb == "HEAD" && n.implicitHead) {
+		// An implicit HEAD handler is only removed along with its GET handler.
+		return false
 	}
-	remainingPath := path[tokenEnd:]
 
-	if c == '*' && !inStaticToken {
-		// Token starts with a *, so it's a catch-all
-		thisToken = thisToken[1:]
-		if n.catchAllChild == nil {
-			n.catchAllChild = &node{path: thisToken, isCatchAll: true}
-		}
+	delete(n.leafHandler, verb)
+	delete(n.leafRoute, verb)
+	delete(n.leafVariants, verb)
 
-		if path[1:] != n.catchAllChild.path {
-			panic(fmt.Sprintf("Catch-all name in %s doesn't match %s. You probably tried to define overlapping catchalls",
-				path, n.catchAllChild.path))
+	switch verb {
+	case "GET":
+		if n.implicitHead {
+			delete(n.leafHandler, "HEAD")
+			delete(n.leafRoute, "HEAD")
+			n.implicitHead = false
 		}
-
-		if nextSlash != -1 {
-			panic("/ after catch-all found in " + path)
-		}
-
-		if wildcards == nil {
-			wildcards = []string{thisToken}
-		} else {
-			wildcards = append(wildcards, thisToken)
+	case "HEAD":
+		if get := n.leafHandler["GET"]; get != nil && headCanUseGet {
+			// Fall back to the GET handler, as if HEAD had never been set.
+			n.setHandler("HEAD", get, true)
+			n.setRouteInfo("HEAD", n.leafRoute["GET"])
 		}
-		n.catchAllChild.leafWildcardNames = wildcards
+	}
 
-		return n.catchAllChild
-	} else if c == ':' && !inStaticToken {
-		// Token starts with a :
-		thisToken = thisToken[1:]
+	if len(n.leafHandler) == 0 {
+		// Let the node be reused by a route with different wildcards.
+		n.Clear()
+		n.addSlash = false
+	}
 
-		if wildcards == nil {
-			wildcards = []string{thisToken}
-		} else {
-			wildcards = append(wildcards, thisToken)
-		}
+	return true
+}
 
-		if n.wildcardChild == nil {
-			n.wildcardChild = &node{path: "wildcard"}
-		}
+// handler returns the handler for a method, or the handler registered for MethodAny if the
+// method has none of its own.
+func (n *node) handler(method string) HandlerFunc {
+	if handler := n.leafHandler[method]; handler != nil {
+		return handler
+	}
+	return n.leafHandler[MethodAny]
+}
 
-		return n.wildcardChild.addPath(remainingPath, wildcards, false)
+// route returns the routeInfo for the handler that handler returns.
+func (n *node) route(method string) *routeInfo {
+	if n.leafHandler[method] != nil {
+		return n.leafRoute[method]
+	}
+	return n.leafRoute[MethodAny]
+}
 
-	} else {
-		// if strings.ContainsAny(thisToken, ":*") {
-		// 	panic("* or : in middle of path component " + path)
-		// }
+func (n *node) setRouteInfo(verb string, info *routeInfo) {
+	if n.leafRoute == nil {
+		n.leafRoute = make(map[string]*routeInfo)
+	}
+	n.leafRoute[verb] = info
+}
 
-		unescaped := false
-		if len(thisToken) >= 2 && !inStaticToken {
-			if thisToken[0] == '\\' && (thisToken[1] == '*' || thisToken[1] == ':' || thisToken[1] == '\\') {
-				// The token starts with a character escaped by a backslash. Drop the backslash.
-				c = thisToken[1]
-				thisToken = thisToken[1:]
-				unescaped = true
-			}
-		}
+// leafOf returns the routes which end at a node of the tree, or nil if there is no node or
+// no routes end at it.
+func leafOf(tn *pathtree.Node) *node {
+	if tn == nil || tn.Value == nil {
+		return nil
+	}
+	return tn.Value.(*node)
+}
 
-		// Set inStaticToken to ensure that the rest of this token is not mistaken
-		// for a wildcard if a prefix split occurs at a '*' or ':'.
-		inStaticToken = (c != '/')
+// nodeAt returns the routes which end at a node of the tree, which are empty if none do yet.
+func nodeAt(tn *pathtree.Node) *node {
+	if n := leafOf(tn); n != nil {
+		return n
+	}
+	return &node{Node: tn}
+}
 
-		// Do we have an existing node that starts with the same letter?
-		for i, index := range n.staticIndices {
-			if c == index {
-				// Yes. Split it based on the common prefix of the existing
-				// node and the new one.
-				child, prefixSplit := n.splitCommonPrefix(i, thisToken)
+// findNode returns the routes for a path which was previously added to the tree, or nil if
+// no routes end at it.
+func findNode(root *pathtree.Node, path string) *node {
+	return leafOf(root.Find(path))
+}
 
-				child.priority++
-				n.sortStaticChild(i)
-				if unescaped {
-					// Account for the removed backslash.
-					prefixSplit++
-				}
-				return child.addPath(path[prefixSplit:], wildcards, inStaticToken)
+// addPath returns the routes for a path, adding it to the tree if necessary. If changes is
+// not nil, the changes made to the tree are counted in it.
+func addPath(root *pathtree.Node, path string, changes *pathtree.Changes) (*node, error) {
+	tn, err := root.Add(path, changes)
+	if err != nil {
+		if conflict, ok := err.(*pathtree.ConflictError); ok {
+			existing := ""
+			if n := leafOf(conflict.Node); n != nil {
+				existing = n.anyPattern()
 			}
+			err = &RouteConflictError{Existing: existing, Reason: conflict.Reason}
 		}
-
-		// No existing node starting with this letter, so create it.
-		child := &node{path: thisToken}
-
-		if n.staticIndices == nil {
-			n.staticIndices = []byte{c}
-			n.staticChild = []*node{child}
-		} else {
-			n.staticIndices = append(n.staticIndices, c)
-			n.staticChild = append(n.staticChild, child)
-		}
-		return child.addPath(remainingPath, wildcards, inStaticToken)
+		return nil, err
 	}
+	return nodeAt(tn), nil
 }
 
-func (n *node) splitCommonPrefix(existingNodeIndex int, path string) (*node, int) {
-	childNode := n.staticChild[existingNodeIndex]
-
-	if strings.HasPrefix(path, childNode.path) {
-		// No split needs to be done. Rather, the new path shares the entire
-		// prefix with the existing node, so the new node is just a child of
-		// the existing one. Or the new path is the same as the existing path,
-		// which means that we just move on to the next token. Either way,
-		// this return accomplishes that
-		return childNode, len(childNode.path)
+// search finds the node for a path. The parameter values are returned in reverse order, as
+// they appear in the path, without being unescaped. If stats is not nil, the work done is
+// counted in it.
+func search(root *pathtree.Node, method, path string, stats *pathtree.Stats) (found *node, handler HandlerFunc, params []string) {
+	tn, _, params := root.Search(method, path, hasHandler, stats)
+	found = leafOf(tn)
+	if found == nil {
+		return nil, nil, nil
 	}
+	return found, found.handler(method), params
+}
 
-	var i int
-	// Find the length of the common prefix of the child node and the new path.
-	for i = range childNode.path {
-		if i == len(path) {
-			break
+// hasHandler is the pathtree.MatchFunc of the routing tree, which ends a search at a node
+// with a handler for the method.
+func hasHandler(value interface{}, method string) bool {
+	return value.(*node).handler(method) != nil
+}
+
+// candidates returns the patterns of all routes with a handler for the method which match
+// the path, in the order of precedence that search uses, unlike search which stops at the
+// first match.
+func candidates(root *pathtree.Node, method, path string) []string {
+	var patterns []string
+	for _, tn := range root.Matching(path) {
+		match := leafOf(tn)
+		if match.handler(method) == nil {
+			continue
 		}
-		if path[i] != childNode.path[i] {
-			break
+		info := match.route(method)
+		if info == nil {
+			continue
+		}
+		duplicate := false
+		for _, p := range patterns {
+			duplicate = duplicate || p == info.pattern
+		}
+		if !duplicate {
+			patterns = append(patterns, info.pattern)
 		}
 	}
-
-	commonPrefix := path[0:i]
-	childNode.path = childNode.path[i:]
-
-	// Create a new intermediary node in the place of the existing node, with
-	// the existing node as a child.
-	newNode := &node{
-		path:     commonPrefix,
-		priority: childNode.priority,
-		// Index is the first letter of the non-common part of the path.
-		staticIndices: []byte{childNode.path[0]},
-		staticChild:   []*node{childNode},
-	}
-	n.staticChild[existingNodeIndex] = newNode
-
-	return newNode, i
+	return patterns
 }
 
-func (n *node) search(method, path string) (found *node, handler HandlerFunc, params []string) {
-	// if test != nil {
-	// 	test.Logf("Searching for %s in %s", path, n.dumpTree("", ""))
-	// }
-	pathLen := len(path)
-	if pathLen == 0 {
-		if len(n.leafHandler) == 0 {
-			return nil, nil, nil
-		} else {
-			return n, n.leafHandler[method], nil
+// walkRoutes calls fn for the routes at each node of the tree which has any.
+func walkRoutes(root *pathtree.Node, fn func(n *node)) {
+	root.Walk(func(tn *pathtree.Node) {
+		if n := leafOf(tn); n != nil {
+			fn(n)
 		}
-	}
+	})
+}
 
-	// First see if this matches a static token.
-	firstChar := path[0]
-	for i, staticIndex := range n.staticIndices {
-		if staticIndex == firstChar {
-			child := n.staticChild[i]
-			childPathLen := len(child.path)
-			if pathLen >= childPathLen && child.path == path[:childPathLen] {
-				nextPath := path[childPathLen:]
-				found, handler, params = child.search(method, nextPath)
-			}
-			break
+func dumpTree(tn *pathtree.Node, prefix, nodeType string) string {
+	var handlers map[string]HandlerFunc
+	if n := leafOf(tn); n != nil {
+		handlers = n.leafHandler
+	}
+	children := tn.Children()
+	static := 0
+	for _, child := range children {
+		if child.Kind() == pathtree.Static {
+			static++
 		}
 	}
+	line := fmt.Sprintf("%s %02d %s%s [%d] %v wildcards %v\n", prefix, tn.Priority(), nodeType, tn.Path(),
+		static, handlers, tn.WildcardNames())
+	prefix += "  "
+	for _, child := range children {
+		line += dumpTree(child, prefix, [...]string{"", ":", "*"}[child.Kind()])
+	}
+	return line
+}
 
-	// If we found a node and it had a valid handler, then return here. Otherwise
-	// let's remember that we found this one, but look for a better match.
-	if handler != nil {
-		return
+// writeTree writes the readable form of the tree used by TreeMux.DumpTree.
+func writeTree(buf *bytes.Buffer, tn *pathtree.Node, indent, kind string) {
+	switch kind {
+	case "":
+		fmt.Fprintf(buf, "%s%s priority=%d", indent, tn.Path(), tn.Priority())
+	case "param":
+		// The names of the parameter are given by the leaf nodes below it.
+		fmt.Fprintf(buf, "%sparam priority=%d", indent, tn.Priority())
+	case "catch-all":
+		fmt.Fprintf(buf, "%scatch-all %q priority=%d", indent, "*"+tn.Path(), tn.Priority())
+	default:
+		fmt.Fprintf(buf, "%s%s %q priority=%d", indent, kind, tn.Path(), tn.Priority())
 	}
 
-	if n.wildcardChild != nil {
-		// Didn't find a static token, so check for a wildcard.
-		nextSlash := strings.IndexByte(path, '/')
-		if nextSlash < 0 {
-			nextSlash = pathLen
+	if n := leafOf(tn); n != nil {
+		if len(n.WildcardNames()) != 0 {
+			fmt.Fprintf(buf, " params=%v", n.WildcardNames())
 		}
-
-		thisToken := path[0:nextSlash]
-		nextToken := path[nextSlash:]
-
-		if len(thisToken) > 0 { // Don't match on empty tokens.
-			wcNode, wcHandler, wcParams := n.wildcardChild.search(method, nextToken)
-			if wcHandler != nil || (found == nil && wcNode != nil) {
-				unescaped, err := unescape(thisToken)
-				if err != nil {
-					unescaped = thisToken
-				}
-
-				if wcParams == nil {
-					wcParams = []string{unescaped}
-				} else {
-					wcParams = append(wcParams, unescaped)
-				}
-
-				if wcHandler != nil {
-					return wcNode, wcHandler, wcParams
-				}
-
-				// Didn't actually find a handler here, so remember that we
-				// found a node but also see if we can fall through to the
-				// catchall.
-				found = wcNode
-				handler = wcHandler
-				params = wcParams
-			}
+		if n.addSlash {
+			buf.WriteString(" slash")
 		}
-	}
 
-	catchAllChild := n.catchAllChild
-	if catchAllChild != nil {
-		// Hit the catchall, so just assign the whole remaining path if it
-		// has a matching handler.
-		handler = catchAllChild.leafHandler[method]
-		// Found a handler, or we found a catchall node without a handler.
-		// Either way, return it since there's nothing left to check after this.
-		if handler != nil || found == nil {
-			unescaped, err := unescape(path)
-			if err != nil {
-				unescaped = path
+		methods := make([]string, 0, len(n.leafHandler))
+		for method := range n.leafHandler {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			pattern := ""
+			if method == "HEAD" && n.implicitHead {
+				pattern = "(implicit)"
+			} else if info := n.leafRoute[method]; info != nil {
+				pattern = info.pattern
+			}
+			fmt.Fprintf(buf, " %s=%s", method, pattern)
+			if variants := len(n.leafVariants[method]); variants != 0 {
+				fmt.Fprintf(buf, "(%d conditional)", variants)
 			}
-
-			return catchAllChild, handler, []string{unescaped}
 		}
-
 	}
+	buf.WriteByte('\n')
 
-	return found, handler, params
-}
-
-func (n *node) dumpTree(prefix, nodeType string) string {
-	line := fmt.Sprintf("%s %02d %s%s [%d] %v wildcards %v\n", prefix, n.priority, nodeType, n.path,
-		len(n.staticChild), n.leafHandler, n.leafWildcardNames)
-	prefix += "  "
-	for _, node := range n.staticChild {
-		line += node.dumpTree(prefix, "")
-	}
-	if n.wildcardChild != nil {
-		line += n.wildcardChild.dumpTree(prefix, ":")
+	indent += "  "
+	for _, child := range tn.Children() {
+		writeTree(buf, child, indent, [...]string{"static", "param", "catch-all"}[child.Kind()])
 	}
-	if n.catchAllChild != nil {
-		line += n.catchAllChild.dumpTree(prefix, "*")
-	}
-	return line
 }
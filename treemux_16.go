@@ -6,28 +6,95 @@ package httptreemux
 import (
 	"net/http"
 	"sync"
+	"time"
+
+	"github.com/dimfeld/httptreemux/v5/pathtree"
 )
 
 type TreeMux struct {
-	root  *node
-	mutex sync.RWMutex
+	root  *pathtree.Node
+	mutex routerMutex
+	// Routing trees for specific hosts, added with Host.
+	hosts []*hostTree
+	// Group-specific error handlers.
+	errorScopes []*errorScope
+	// True once a group has set a panic handler with SetPanicHandler.
+	scopedPanicHandlers bool
+	// Routes skipped because they were added to a group from When or DevOnly.
+	skippedRoutes []Route
+	// Serializers added with SetErrorSerializer, in the order they were added. The slice is
+	// replaced rather than changed, under serializersMutex.
+	errorSerializers []errorSerializer
+	serializersMutex sync.Mutex
 
 	Group
 
 	// The default PanicHandler just returns a 500 code.
 	PanicHandler PanicHandler
 
-	// The default NotFoundHandler is http.NotFound.
+	// The default NotFoundHandler is http.NotFound, or a Problem written with the error
+	// serializers when any are registered with SetErrorSerializer.
 	NotFoundHandler func(w http.ResponseWriter, r *http.Request)
 
+	// Fallback, if set, serves the requests which do not match a route, in place of the not
+	// found and method not allowed responses, including those of groups. The hooks for those
+	// responses are not called. Setting it to another router, such as a legacy mux or another
+	// TreeMux, tries that router for paths which this one does not have, which helps to move
+	// routes from one router to another gradually. Since the request is passed on unchanged,
+	// the fallback parses its path and applies its own redirects.
+	Fallback http.Handler
+
+	// OnRouteMatched, OnNotFound and OnMethodNotAllowed are called for each request served by
+	// the router, if set, before the handler, NotFoundHandler or MethodNotAllowedHandler runs.
+	// OnRouteMatched receives the LookupResult with the route's pattern, metadata and
+	// parameters, and OnRouteServed receives it again after the handler returns, along with the
+	// time the handler took according to Clock. OnRouteServed is not called if the handler
+	// panics, or if the client goes away before the handler of a route added with WithTimeout
+	// finishes. These let tracing and metrics packages instrument every route in one place. They
+	// are not called for redirects and other responses generated by the router.
+	OnRouteMatched     func(r *http.Request, lr LookupResult)
+	OnRouteServed      func(r *http.Request, lr LookupResult, elapsed time.Duration)
+	OnNotFound         func(r *http.Request)
+	OnMethodNotAllowed func(r *http.Request, lr LookupResult)
+
+	// OnRouteTimeout is called instead of OnRouteServed when the handler of a route added
+	// with WithTimeout does not respond within its budget, after the timeout response is
+	// written.
+	OnRouteTimeout func(r *http.Request, lr LookupResult, budget time.Duration)
+
+	// OnExperimentAssigned is called before the handler for each request whose route takes part
+	// in an experiment added with WithExperiment, with the variant chosen in lr.Assignment, so
+	// that assignment events can be sent to analytics. Unlike the other hooks, it is called
+	// even for routes with WithHookFilter or WithoutHooks, so that no exposure is missed.
+	OnExperimentAssigned func(r *http.Request, lr LookupResult)
+
+	// OnRouteRegistered, if set, is called after each route is added, with the changes that
+	// adding it made to the routing tree and the time it took. Teams which generate thousands of
+	// routes can use it to find the registrations which split many nodes or reorder them. It is
+	// called while the router is locked, so it must not add or remove routes.
+	OnRouteRegistered func(stats RegistrationStats)
+
 	// Any OPTIONS request that matches a path without its own OPTIONS handler will use this handler,
 	// if set, instead of calling MethodNotAllowedHandler.
 	OptionsHandler HandlerFunc
 
+	// AutomaticOptions makes the router answer OPTIONS requests for any path that has handlers
+	// for other methods, if the path has no OPTIONS handler of its own and OptionsHandler is not
+	// set. The response has an Allow header listing the methods of the path. This is false by
+	// default.
+	AutomaticOptions bool
+
+	// GlobalOPTIONS writes the responses to OPTIONS requests answered because of AutomaticOptions,
+	// after the Allow header has been set. The allowed parameter holds the same methods as the
+	// header, sorted. This is the place for CORS middleware to write preflight responses. If it
+	// is nil, the response is 204 No Content.
+	GlobalOPTIONS func(w http.ResponseWriter, r *http.Request, allowed []string)
+
 	// MethodNotAllowedHandler is called when a pattern matches, but that
 	// pattern does not have a handler for the requested method. The default
 	// handler just writes the status code http.StatusMethodNotAllowed and adds
-	// the required Allowed header.
+	// the required Allowed header, or writes a Problem with the error
+	// serializers when any are registered with SetErrorSerializer.
 	// The methods parameter contains the map of each method to the corresponding
 	// handler function.
 	MethodNotAllowedHandler func(w http.ResponseWriter, r *http.Request,
@@ -35,7 +102,10 @@ type TreeMux struct {
 
 	// HeadCanUseGet allows the router to use the GET handler to respond to
 	// HEAD requests if no explicit HEAD handler has been added for the
-	// matching pattern. This is true by default.
+	// matching pattern. This is true by default. It applies to the GET routes
+	// added while it is set. A HEAD handler always takes precedence, whether it
+	// is added before or after the GET handler, and a path with only a HEAD
+	// handler answers GET requests with 405 Method Not Allowed.
 	HeadCanUseGet bool
 
 	// RedirectCleanPath allows the router to try clean the current request path,
@@ -43,11 +113,26 @@ type TreeMux struct {
 	// This is true by default.
 	RedirectCleanPath bool
 
+	// CleanPath makes the router normalize the path of every request before matching it, by
+	// removing . and .. segments, including percent-encoded ones, and duplicate slashes. Without
+	// it, a request such as /images/../cgi/foo.js is matched as is, and only cleaned if it does
+	// not match any route. If RedirectCleanPath is also set, requests whose path changed are
+	// redirected to the cleaned path; otherwise they are served as if the cleaned path had been
+	// requested. This is false by default.
+	CleanPath bool
+
 	// RedirectTrailingSlash enables automatic redirection in case router doesn't find a matching route
 	// for the current request path but a handler for the path with or without the trailing
 	// slash exists. This is true by default.
 	RedirectTrailingSlash bool
 
+	// MaxCanonicalizationPasses is the number of corrections to the path of a request, out of
+	// cleaning it, fixing its trailing slash and fixing its case, that are combined into a single
+	// redirect. With the default of 0 or 1, each redirect makes one correction, so a request which
+	// needs several of them is redirected several times. Set this to 3 to always redirect to the
+	// fully canonical path at once.
+	MaxCanonicalizationPasses int
+
 	// RemoveCatchAllTrailingSlash removes the trailing slash when a catch-all pattern
 	// is matched, if set to true. By default, catch-all paths are never redirected.
 	RemoveCatchAllTrailingSlash bool
@@ -60,6 +145,19 @@ type TreeMux struct {
 	// The key is the method name, and the value is the behavior to use for that method.
 	RedirectMethodBehavior map[string]RedirectBehavior
 
+	// RedirectPolicy, if set, decides how to redirect a request to the path of the route that it
+	// matched once the router's corrections are made, in place of RedirectBehavior and
+	// RedirectMethodBehavior. It returns the status code of the redirect, or false to serve the
+	// request with the route's handler instead. The query string of the request is always kept.
+	// Routes added with WithRedirectBehavior keep their own behavior.
+	RedirectPolicy func(r *http.Request, target string) (int, bool)
+
+	// RedirectRefusedHandler responds to the requests which the RefuseUnsafeRedirect behavior
+	// does not redirect. The target is the URL that the request would have been redirected to,
+	// including the query string. By default, the response is 404 Not Found, with a Link header
+	// and a message naming the target, written as a Problem if SetErrorSerializer was called.
+	RedirectRefusedHandler func(w http.ResponseWriter, r *http.Request, target string)
+
 	// PathSource determines from where the router gets its path to search.
 	// By default it pulls the data from the RequestURI member, but this can
 	// be overridden to use URL.Path instead.
@@ -70,18 +168,136 @@ type TreeMux struct {
 	// library that modify the Request before passing it to the router.
 	PathSource PathSource
 
+	// PathMismatchBehavior determines which path is matched when the raw path in RequestURI
+	// does not unescape to URL.Path, which happens with some malformed proxies. By default
+	// PathSource decides as usual. PreferRawPath and PreferURLPath choose one of the paths for
+	// such requests, and RejectPathMismatch responds to them with 400 Bad Request. The path
+	// which was used is available from ContextRouteData.PathSource.
+	PathMismatchBehavior PathMismatchBehavior
+
 	// EscapeAddedRoutes controls URI escaping behavior when adding a route to the tree.
 	// If set to true, the router will add both the route as originally passed, and
 	// a version passed through URL.EscapedPath. This behavior is disabled by default.
 	EscapeAddedRoutes bool
 
+	// PatternSyntax determines the wildcard syntax accepted in the patterns of routes and
+	// groups. Set it to SyntaxBraces to also accept the {name} and {name...} syntax of
+	// http.ServeMux, which is translated into :name and *name. Patterns are translated when
+	// they are added, so it should be set before adding any routes. The default is SyntaxColon.
+	PatternSyntax PatternSyntax
+
+	// FragmentBehavior determines what happens to requests whose path contains a raw '#',
+	// which some broken clients send instead of escaping it as %23. The fragment is never
+	// matched against routes. By default the '#' and everything after it are ignored while
+	// matching; set this to RejectFragment to respond with 400 Bad Request instead.
+	FragmentBehavior FragmentBehavior
+
+	// MethodPolicy determines how methods which are not valid tokens as defined by RFC 9110 are
+	// treated, at registration and when requests are matched. By default they are passed
+	// through unchecked. With RejectInvalidMethods, routes with such methods can not be added
+	// and requests with them are answered with 501 Not Implemented before they are matched, so
+	// they never reach handlers or hooks. NormalizeMethodCase also converts methods to
+	// uppercase. It should be set before adding any routes.
+	MethodPolicy MethodPolicy
+
+	// Locales lists the locale segments, such as "en", "de" or "pt-br", which may start the path
+	// of a request. A matching segment is removed from the path before it is matched, ignoring
+	// case, and handlers receive the locale, as it is written here, in the LocaleParam
+	// parameter. So with Locales set to []string{"en", "de"}, a request for /de/products is
+	// served by the route for /products, with a locale of "de". Redirects keep the locale.
+	Locales []string
+
+	// RejectEarlyData answers requests which were sent in TLS 1.3 early data (0-RTT), and so
+	// could have been replayed by an attacker, with 425 Too Early when their method is not
+	// idempotent, as recommended by RFC 8470. The client then retries the request once the
+	// handshake is complete. Use WithEarlyData to change this for individual routes. See
+	// IsEarlyData for how such requests are recognized.
+	RejectEarlyData bool
+
+	// TrustedProxies are the IP addresses and CIDR ranges of the proxies in front of the
+	// server, whose X-Forwarded-For headers are believed when finding the client's address
+	// for WithAllowedSources and WithDeniedSources. See ClientIP. Invalid entries are ignored.
+	TrustedProxies []string
+
+	// ForbiddenHandler answers the requests which WithAllowedSources or WithDeniedSources
+	// reject. If it is nil, the response is a plain 403 Forbidden.
+	ForbiddenHandler func(w http.ResponseWriter, r *http.Request)
+
+	// PooledParams reduces allocations by capturing the path parameters of routes added with a
+	// ContextGroup into a Params slice taken from a pool, instead of allocating a map for each
+	// request. ContextData(r.Context()).OrderedParams() returns the slice, and the map returned by
+	// ContextData(r.Context()).Params() is only built if it is called. With this set, middleware on
+	// these routes receives a nil params map, and should use ContextData instead. The Params slice
+	// is reused once the handler returns, so it must not be retained. Routes added with a
+	// HandlerFunc still receive a map, as do the results of Lookup. This is false by default.
+	PooledParams bool
+
 	// SafeAddRoutesWhileRunning tells the router to protect all accesses to the tree with an RWMutex. This is only needed
 	// if you are going to add routes after the router has already begun serving requests. There is a potential
 	// performance penalty at high load.
 	SafeAddRoutesWhileRunning bool
+
+	// Debug adds extra information to some router-generated responses, such as suggestions of
+	// similar routes in the responses of groups using UseProblemResponses. It should not be
+	// enabled in production, since it reveals details of the registered routes. It also
+	// records how each request path was normalized before matching; see Normalization.
+	Debug bool
+
+	// RecordMatchStats counts the work done to match each request path against the routing
+	// tree in LookupResult.MatchStats, as Debug does, without the other effects of Debug. It
+	// adds a small cost to each lookup.
+	RecordMatchStats bool
+
+	// RedirectCanonicalCase redirects requests matched by CaseInsensitive to the casing of the
+	// route's pattern, when the static parts of the path are cased differently. Wildcard values
+	// keep the casing of the request. The status code follows RedirectBehavior.
+	RedirectCanonicalCase bool
+
+	// NormalizeHost normalizes the request host before a host tree is selected for it, for
+	// requests from CDNs and proxies which vary in how they send it: the host is lowercased,
+	// a trailing dot is removed, and the default ports 80 and 443 are removed. Host patterns
+	// are matched regardless of their case, and port 80 or 443 in a pattern is ignored. It
+	// does not change how paths are matched; see CaseInsensitive for that.
+	NormalizeHost bool
+
+	// Clock provides the current time to time-dependent features, and Random provides
+	// random numbers to features which make random choices. They default to the system
+	// clock and the math/rand package, and can be replaced to make tests deterministic.
+	// Route timeouts also use the timers of a Clock which implements TimerClock.
+	Clock  Clock
+	Random RandomSource
 }
 
 func (t *TreeMux) setDefaultRequestContext(r *http.Request) *http.Request {
 	// Nothing to do on Go 1.6 and before
 	return r
 }
+
+func requestWithNormalization(r *http.Request, n *Normalization) *http.Request {
+	// Go 1.6 and before have no request context to store it in.
+	return r
+}
+
+func requestWithRawParams(r *http.Request, raw Params) *http.Request {
+	return r
+}
+
+func requestWithAssignment(r *http.Request, a *Assignment) *http.Request {
+	return r
+}
+
+func requestWithMount(r *http.Request, m *MountPoint) *http.Request {
+	return r
+}
+
+func detachRequest(r *http.Request) *http.Request {
+	detached := new(http.Request)
+	*detached = *r
+	return detached
+}
+
+func (t *TreeMux) serveWithTimeout(w http.ResponseWriter, r *http.Request, lr LookupResult) handlerOutcome {
+	// Without a request context, the handler could not be told to stop.
+	lr.callHandler(w, r)
+	return handlerServed
+}
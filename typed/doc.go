@@ -0,0 +1,16 @@
+// Package typed adds routes with typed handlers to a httptreemux.ContextGroup. A typed handler
+// receives the request bound into a struct, from the JSON body, the query string and the path
+// parameters, and returns a value which is written as JSON, so that simple JSON APIs need
+// neither a framework nor the same decoding and encoding code in every handler.
+//
+//	type getUser struct {
+//	    ID     int  `param:"id"`
+//	    Expand bool `query:"expand"`
+//	}
+//
+//	typed.GET(router.ContextGroup, "/users/:id", func(ctx context.Context, req getUser) (User, error) {
+//	    return loadUser(ctx, req.ID, req.Expand)
+//	})
+//
+// The package requires Go 1.18 or later, for generics.
+package typed
@@ -0,0 +1,193 @@
+//go:build go1.18
+
+package typed
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+// HandlerFunc is a typed handler, which receives the bound request and returns the response to
+// write as JSON, or an error.
+type HandlerFunc[Req, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// Handle adds a route to the group which binds each request into a Req with Bind, calls fn and
+// writes the Resp that it returns as JSON with a 200 status. Errors from binding, which are
+// *BindError values with a status of 400, and errors returned by fn are passed to
+// TreeMux.ErrorHandler, as for routes added with ContextGroup.HandleErr.
+func Handle[Req, Resp any](cg *httptreemux.ContextGroup, method, path string, fn HandlerFunc[Req, Resp]) {
+	cg.HandleErr(method, path, func(w http.ResponseWriter, r *http.Request) error {
+		var req Req
+		if err := Bind(r, &req); err != nil {
+			return err
+		}
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, resp)
+	})
+}
+
+// GET is a shortcut for Handle with the GET method.
+func GET[Req, Resp any](cg *httptreemux.ContextGroup, path string, fn HandlerFunc[Req, Resp]) {
+	Handle(cg, "GET", path, fn)
+}
+
+// POST is a shortcut for Handle with the POST method.
+func POST[Req, Resp any](cg *httptreemux.ContextGroup, path string, fn HandlerFunc[Req, Resp]) {
+	Handle(cg, "POST", path, fn)
+}
+
+// PUT is a shortcut for Handle with the PUT method.
+func PUT[Req, Resp any](cg *httptreemux.ContextGroup, path string, fn HandlerFunc[Req, Resp]) {
+	Handle(cg, "PUT", path, fn)
+}
+
+// PATCH is a shortcut for Handle with the PATCH method.
+func PATCH[Req, Resp any](cg *httptreemux.ContextGroup, path string, fn HandlerFunc[Req, Resp]) {
+	Handle(cg, "PATCH", path, fn)
+}
+
+// DELETE is a shortcut for Handle with the DELETE method.
+func DELETE[Req, Resp any](cg *httptreemux.ContextGroup, path string, fn HandlerFunc[Req, Resp]) {
+	Handle(cg, "DELETE", path, fn)
+}
+
+// BindError is returned by Bind when the request can not be bound. Its status code is 400,
+// so httptreemux.SimpleErrorHandler responds with 400 Bad Request.
+type BindError struct {
+	Err error
+}
+
+func (e *BindError) Error() string {
+	return "binding request: " + e.Err.Error()
+}
+
+// StatusCode returns http.StatusBadRequest.
+func (e *BindError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind fills in the value that dst points to from a request. A JSON body is decoded into it
+// first. If dst points to a struct, the fields with a `query` tag are then set from the query
+// parameters of those names, and the fields with a `param` tag from the path parameters, as
+// by ContextRouteData.Decode. A slice field with a query tag receives every value of a
+// repeated query parameter. Bind returns a *BindError if the body or a parameter is invalid.
+func Bind(r *http.Request, dst interface{}) error {
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(dst); err != nil && err != io.EOF {
+			return &BindError{Err: err}
+		}
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	if err := bindQuery(r.URL.Query(), v.Elem()); err != nil {
+		return &BindError{Err: err}
+	}
+	if data := httptreemux.ContextData(r.Context()); data != nil {
+		if err := data.Decode(dst); err != nil {
+			return &BindError{Err: err}
+		}
+	}
+	return nil
+}
+
+func bindQuery(query url.Values, v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("query")
+		if name == "" || name == "-" || field.PkgPath != "" {
+			continue
+		}
+		values := query[name]
+		if len(values) == 0 {
+			continue
+		}
+
+		f := v.Field(i)
+		if f.Kind() == reflect.Slice && !isTextUnmarshaler(f) {
+			slice := reflect.MakeSlice(f.Type(), len(values), len(values))
+			for j, value := range values {
+				if err := setValue(slice.Index(j), value); err != nil {
+					return fmt.Errorf("query parameter %s: invalid value %q: %v", name, value, err)
+				}
+			}
+			f.Set(slice)
+		} else if err := setValue(f, values[0]); err != nil {
+			return fmt.Errorf("query parameter %s: invalid value %q: %v", name, values[0], err)
+		}
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func isTextUnmarshaler(v reflect.Value) bool {
+	return reflect.PtrTo(v.Type()).Implements(textUnmarshalerType)
+}
+
+func setValue(v reflect.Value, value string) error {
+	if isTextUnmarshaler(v) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err.(*strconv.NumError).Err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, resp interface{}) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
@@ -0,0 +1,90 @@
+//go:build go1.18
+
+package typed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dimfeld/httptreemux/v5"
+)
+
+type updateItem struct {
+	Org    string   `param:"org"`
+	ID     int      `param:"id"`
+	DryRun bool     `query:"dry_run"`
+	Tags   []string `query:"tag"`
+	Name   string   `json:"name"`
+}
+
+type item struct {
+	Org    string   `json:"org"`
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	DryRun bool     `json:"dryRun"`
+	Tags   []string `json:"tags"`
+}
+
+func TestHandle(t *testing.T) {
+	router := httptreemux.NewContextMux()
+	PUT(router.ContextGroup, "/orgs/:org/items/:id", func(ctx context.Context, req updateItem) (item, error) {
+		if req.Name == "" {
+			return item{}, errors.New("missing name")
+		}
+		return item{Org: req.Org, ID: req.ID, Name: req.Name, DryRun: req.DryRun, Tags: req.Tags}, nil
+	})
+	GET(router.ContextGroup, "/items", func(ctx context.Context, req struct{}) ([]string, error) {
+		return []string{"a", "b"}, nil
+	})
+
+	for _, test := range []struct {
+		method, path, body string
+		code               int
+		response           string
+	}{
+		{"PUT", "/orgs/acme/items/5?dry_run=true&tag=x&tag=y", `{"name":"widget"}`, http.StatusOK,
+			`{"org":"acme","id":5,"name":"widget","dryRun":true,"tags":["x","y"]}` + "\n"},
+		{"PUT", "/orgs/acme/items/5", `{"name":"widget","id":7}`, http.StatusOK,
+			`{"org":"acme","id":5,"name":"widget","dryRun":false,"tags":null}` + "\n"},
+		{"PUT", "/orgs/acme/items/five", `{"name":"widget"}`, http.StatusBadRequest, ""},
+		{"PUT", "/orgs/acme/items/5?dry_run=maybe", `{"name":"widget"}`, http.StatusBadRequest, ""},
+		{"PUT", "/orgs/acme/items/5", `{"name":`, http.StatusBadRequest, ""},
+		{"PUT", "/orgs/acme/items/5", `{}`, http.StatusInternalServerError, ""},
+		{"GET", "/items", "", http.StatusOK, `["a","b"]` + "\n"},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+			continue
+		}
+		if test.response != "" {
+			if w.Body.String() != test.response {
+				t.Errorf("%s %s: expected %s, saw %s", test.method, test.path, test.response, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("%s %s: unexpected Content-Type %q", test.method, test.path, ct)
+			}
+		}
+	}
+}
+
+func TestBindError(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/?n=x", nil)
+	var dst struct {
+		N int `query:"n"`
+	}
+	err := Bind(r, &dst)
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) || bindErr.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("Expected a BindError, saw %v", err)
+	}
+	if expected := `binding request: query parameter n: invalid value "x": invalid syntax`; err.Error() != expected {
+		t.Errorf("Expected %q, saw %q", expected, err.Error())
+	}
+}
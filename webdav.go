@@ -0,0 +1,71 @@
+package httptreemux
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// webDAVMethods are the methods which WebDAV (RFC 4918) adds to HTTP.
+var webDAVMethods = []string{"COPY", "LOCK", "MKCOL", "MOVE", "PROPFIND", "PROPPATCH", "UNLOCK"}
+
+// WebDAV adds routes for a WebDAV subtree at path, which serve the methods in handlers for the
+// collection at path, with a trailing slash, and for everything under it. The handlers
+// receive the part of the path after the collection in the "path" parameter. Requests for the
+// path without the trailing slash are redirected according to RedirectTrailingSlash. A handler given for MethodAny serves all of the
+// WebDAV methods (COPY, LOCK, MKCOL, MOVE, PROPFIND, PROPPATCH and UNLOCK), as well as GET,
+// HEAD, PUT and DELETE, except those which have their own handlers in the map.
+//
+// Unless the map has a handler for OPTIONS, OPTIONS requests are answered with an Allow header
+// listing the methods, and the DAV header that WebDAV clients use to discover the server's
+// compliance classes: "1", or "1, 2" if LOCK is served. Requests with other methods receive
+// the router's 405 Method Not Allowed response, with the same Allow header.
+//
+//	dav := &webdav.Handler{Prefix: "/dav", FileSystem: webdav.Dir("/srv/dav"), LockSystem: webdav.NewMemLS()}
+//	router.WebDAV("/dav", map[string]httptreemux.HandlerFunc{
+//	    httptreemux.MethodAny: func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+//	        dav.ServeHTTP(w, r)
+//	    },
+//	})
+func (g *Group) WebDAV(path string, handlers map[string]HandlerFunc) {
+	methods := make(map[string]HandlerFunc, len(handlers))
+	if handler := handlers[MethodAny]; handler != nil {
+		for _, method := range append([]string{"GET", "HEAD", "PUT", "DELETE"}, webDAVMethods...) {
+			methods[method] = handler
+		}
+	}
+	for method, handler := range handlers {
+		if method != MethodAny {
+			methods[method] = handler
+		}
+	}
+
+	if methods["OPTIONS"] == nil {
+		allowed := []string{"OPTIONS"}
+		for method := range methods {
+			allowed = append(allowed, method)
+		}
+		if methods["GET"] != nil && methods["HEAD"] == nil && g.mux.HeadCanUseGet {
+			allowed = append(allowed, "HEAD")
+		}
+		sort.Strings(allowed)
+		allow := strings.Join(allowed, ", ")
+		dav := "1"
+		if methods["LOCK"] != nil {
+			dav = "1, 2"
+		}
+
+		methods["OPTIONS"] = func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Header().Set("Allow", allow)
+			w.Header().Set("DAV", dav)
+			w.Header().Set("MS-Author-Via", "DAV")
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	path = strings.TrimRight(path, "/")
+	for method, handler := range methods {
+		g.Handle(method, path+"/", handler)
+		g.Handle(method, path+"/*path", handler)
+	}
+}
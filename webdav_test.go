@@ -0,0 +1,63 @@
+package httptreemux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWebDAV(t *testing.T) {
+	served := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+			w.Write([]byte(name + " " + r.Method + " " + params["path"]))
+		}
+	}
+
+	router := New()
+	router.WebDAV("/dav/", map[string]HandlerFunc{
+		MethodAny:  served("any"),
+		"PROPFIND": served("propfind"),
+	})
+	router.WebDAV("/readonly", map[string]HandlerFunc{
+		"GET":      served("get"),
+		"PROPFIND": served("propfind"),
+	})
+
+	for _, test := range []struct {
+		method, path string
+		code         int
+		body         string
+		allow, dav   string
+	}{
+		{"PROPFIND", "/dav/", http.StatusOK, "propfind PROPFIND ", "", ""},
+		{"PROPFIND", "/dav/a/b.txt", http.StatusOK, "propfind PROPFIND a/b.txt", "", ""},
+		{"MKCOL", "/dav/new", http.StatusOK, "any MKCOL new", "", ""},
+		{"LOCK", "/dav/a", http.StatusOK, "any LOCK a", "", ""},
+		{"PUT", "/dav/a", http.StatusOK, "any PUT a", "", ""},
+		{"OPTIONS", "/dav/a", http.StatusOK, "",
+			"COPY, DELETE, GET, HEAD, LOCK, MKCOL, MOVE, OPTIONS, PROPFIND, PROPPATCH, PUT, UNLOCK", "1, 2"},
+		{"OPTIONS", "/readonly/", http.StatusOK, "", "GET, HEAD, OPTIONS, PROPFIND", "1"},
+		{"MOVE", "/readonly/a", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS, PROPFIND", ""},
+		{"PROPFIND", "/dav", http.StatusMovedPermanently, "", "", ""},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := newRequest(test.method, test.path, nil)
+		router.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: expected code %d, saw %d", test.method, test.path, test.code, w.Code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s %s: expected %q, saw %q", test.method, test.path, test.body, w.Body.String())
+		}
+		allowed := w.Header()["Allow"]
+		sort.Strings(allowed)
+		if allow := strings.Join(allowed, ", "); allow != test.allow {
+			t.Errorf("%s %s: expected Allow %q, saw %q", test.method, test.path, test.allow, allow)
+		}
+		if dav := w.Header().Get("DAV"); dav != test.dav {
+			t.Errorf("%s %s: expected DAV %q, saw %q", test.method, test.path, test.dav, dav)
+		}
+	}
+}